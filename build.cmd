@@ -16,4 +16,4 @@ set GOARCH=amd64
 
 go mod download
 :: strip debug info during build
-go build -tags "" -ldflags="-s -w -X main.Version=1.0.0 -X main.BuildTime=$BUILDDATE%" -o ads.exe -v cmd/ads/main.go
+go build -tags "" -ldflags="-s -w -X main.Version=1.0.0 -X main.BuildTime=%BUILDDATE%" -o ads.exe -v cmd/ads/main.go
@@ -2,42 +2,117 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
 	//include handlers and configuration
 	"ADS4/internal/app"
+	"ADS4/internal/backup"
 	"ADS4/internal/config"
 	"ADS4/internal/utils"
 )
 
+// build version and time, set with -ldflags "-X main.Version=1.0.0 -X main.BuildTime=20260101"
+var (
+	Version   = "dev"
+	BuildTime = ""
+)
+
 func main() {
 
-	log.Printf("Starting ADS4 service")
+	// ads gencert writes a self-signed certificate for HTTPS and exits
+	if len(os.Args) > 1 && os.Args[1] == "gencert" {
+		generateCertificate(os.Args[2:])
+		return
+	}
 
-	// Load the configuration
-	cfg := config.LoadConfig()
+	// Load the configuration once, from the defaults, the config file, the environment and the flags
+	cfg, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	command := ""
+	if len(args) > 0 {
+		command = args[0]
+	}
 
-	// Initialize the app
-	application := app.NewApp(cfg)
+	// ads config prints the settings in use and where each one came from, without the secrets
+	if command == "config" {
+		fmt.Print(cfg)
+	}
+	var invalid *config.ValidationError
+	if errors.As(err, &invalid) {
+		for _, field := range invalid.Fields {
+			log.Printf("Configuration: %v", field)
+		}
+		log.Fatalf("The configuration is not valid, %d settings to fix", len(invalid.Fields))
+	}
+	if err != nil {
+		log.Fatalf("Error loading the configuration: %v", err)
+	}
+	if command == "config" {
+		return
+	}
 
-	// Get PORT from environment, default to 8080 if not set
-	port := cfg.ADSPORT
-	if port == "" {
-		port = "8080"
+	// ads admin runs an operator command against the database and exits
+	if command == "admin" {
+		if err := app.RunAdmin(cfg, args[1:], os.Stdout); err != nil && !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
 	}
 
-	if len(os.Args) > 1 {
-		s := os.Args[1]
+	// ads restore replaces the database and data folders with a backup and exits
+	if command == "restore" {
+		restoreBackup(cfg, args[1:])
+		return
+	}
+
+	// Structured logging to the console and the rotating log files
+	if _, err := utils.NewLogger(utils.LogOptions{
+		Level:      cfg.LogLevel,
+		Format:     cfg.LogFormat,
+		Dir:        cfg.LogDir,
+		MaxSizeMB:  cfg.LogMaxSizeMB,
+		MaxFiles:   cfg.LogMaxFiles,
+		MaxAgeDays: cfg.LogMaxAgeDays,
+	}); err != nil {
+		log.Fatalf("Error setting up logging: %v", err)
+	}
+	log.Printf("Starting ADS4 service version %s", Version)
+
+	// the lock of the data folder is held while the service runs, a restore refuses to start while it is held
+	lock, err := utils.LockFile(filepath.Join(cfg.DataDir, backup.LockName))
+	if errors.Is(err, utils.ErrLocked) {
+		log.Fatalf("Another ADS4 service or a restore is using %s", cfg.DataDir)
+	}
+	if err != nil {
+		log.Fatalf("Error locking the data folder: %v", err)
+	}
+	defer lock.Close()
+
+	// Initialize the app
+	application := app.NewApp(cfg)
+	application.Version, application.BuildTime = Version, BuildTime
 
-		if _, err := strconv.ParseInt(s, 10, 64); err == nil {
-			log.Printf("Using port %s", s)
-			port = s
+	// ADSPORT, 8080 unless set, or the port given after the flags
+	port := cfg.ADSPORT
+	if command != "" {
+		if _, err := strconv.ParseInt(command, 10, 64); err == nil {
+			log.Printf("Using port %s", command)
+			port = command
 		}
 	}
 
@@ -46,35 +121,142 @@ func main() {
 	if ip == nil {
 		ip = net.ParseIP("0.0.0.0")
 	}
-	log.Printf("Starting HTTP service on http://%s:%s", ip, port)
 	//log.Printf("Shutdown the service http://%s:%s/shutdown (admin only)", ip, port)
 
 	// HTTP listener is in a goroutine as it's blocking
-	go func() {
-		if err := application.Router.Start(":" + port); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Error starting the server: %v", err)
+	var redirect *http.Server
+	if cfg.TLSEnabled() {
+		tlsConfig, err := application.TLSConfig()
+		if err != nil {
+			log.Fatalf("Error loading the TLS certificate: %v", err)
+		}
+		log.Printf("Starting HTTPS service on https://%s:%s", ip, port)
+
+		server := application.Router.TLSServer
+		server.Addr = ":" + port
+		server.TLSConfig = tlsConfig
+		go func() {
+			if err := application.Router.StartServer(server); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Error starting the server: %v", err)
+			}
+		}()
 
+		// optional plain HTTP listener sending browsers to the HTTPS port
+		if cfg.HTTPRedirectPort != "" {
+			redirect = application.HTTPSRedirectServer(port)
+			log.Printf("Redirecting http://%s:%s to HTTPS", ip, cfg.HTTPRedirectPort)
+			go func() {
+				if err := redirect.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+					log.Fatalf("Error starting the HTTP redirect: %v", err)
+				}
+			}()
 		}
+	} else {
+		log.Printf("Starting HTTP service on http://%s:%s", ip, port)
+		go func() {
+			if err := application.Router.Start(":" + port); err != nil && err != http.ErrServerClosed {
+				log.Fatalf("Error starting the server: %v", err)
+
+			}
+		}()
+	}
+
+	// Shut down gracefully on ctrl-c, SIGTERM (service managers, containers) or an admin request
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	var reason string
+	select {
+	case sig := <-signals:
+		reason = "signal " + sig.String()
+	case reason = <-application.ShutdownRequested():
+	}
+
+	// a second signal stops the service straight away
+	go func() {
+		<-signals
+		log.Println("Second signal received, stopping now")
+		utils.CloseLogger()
+		os.Exit(1)
 	}()
 
-	// Setup a ctrl-c trap to ensure a graceful shutdown
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	<-c
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
 	application.Context = ctx
 
-	defer cancel()
+	if redirect != nil {
+		redirect.Shutdown(ctx)
+	}
+	if err := application.Shutdown(ctx, reason); err != nil {
+		log.Printf("Shutdown did not complete cleanly: %v", err)
+	}
+
+	log.Println("Shutdown complete")
+	utils.CloseLogger()
+}
+
+// generateCertificate handles the gencert command, making a self-signed certificate for a LAN exam room
+func generateCertificate(args []string) {
+	flags := flag.NewFlagSet("gencert", flag.ExitOnError)
+	certFile := flags.String("cert", "data/tls/ads4-cert.pem", "certificate file to write")
+	keyFile := flags.String("key", "data/tls/ads4-key.pem", "private key file to write")
+	hosts := flags.String("hosts", strings.Join(utils.CertificateHosts(), ","), "comma separated host names and IP addresses")
+	days := flags.Int("days", 365, "days the certificate is valid for")
+	flags.Parse(args)
+
+	var names []string
+	for _, host := range strings.Split(*hosts, ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			names = append(names, host)
+		}
+	}
+	if len(names) == 0 || *days < 1 {
+		log.Fatalf("gencert needs at least one host and a positive number of days")
+	}
+
+	if err := utils.GenerateSelfSignedCert(*certFile, *keyFile, names, time.Duration(*days)*24*time.Hour); err != nil {
+		log.Fatalf("Error generating the certificate: %v", err)
+	}
+
+	log.Printf("Self-signed certificate for %s written, valid for %d days", strings.Join(names, ", "), *days)
+	fmt.Printf("TLS_CERT=%s\nTLS_KEY=%s\n", *certFile, *keyFile)
+}
 
-	log.Println("closing database connections")
-	application.DB.Close()
+// restoreBackup handles the restore command, the backup is checked against its manifest before any file
+// is replaced and the replaced files are moved back when it fails part way. It refuses to run while the
+// service holds the lock of the data folder
+func restoreBackup(cfg config.Config, args []string) {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	verifyOnly := flags.Bool("verify", false, "only check the backup against its manifest")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: ads restore [-verify] <backup.tar.gz>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	archive := flags.Arg(0)
 
-	// Log the shutdown process
-	log.Println("Shutting HTTP service down")
-	if err := application.Router.Shutdown(ctx); err != nil {
-		log.Fatalf("Server Shutdown Failed: %v", err)
+	if *verifyOnly {
+		manifest, err := backup.Verify(archive)
+		if err != nil {
+			log.Fatalf("The backup %s is not valid: %v", archive, err)
+		}
+		log.Printf("The backup %s made %s is valid, %d files match their checksums", archive, manifest.Created, len(manifest.Files))
+		return
 	}
 
-	log.Println("Shutdown complete")
-	os.Exit(0)
+	if cfg.DBtype != "sqlite" {
+		log.Fatalf("Restoring a backup needs the SQLite database, DB_TYPE is %s", cfg.DBtype)
+	}
+	manifest, kept, err := backup.Restore(archive, cfg.DataDir, cfg.DBName)
+	if err != nil {
+		if kept != "" {
+			log.Fatalf("Restore failed part way: %v - the replaced files are in %s", err, kept)
+		}
+		log.Fatalf("Restore failed, nothing was replaced: %v", err)
+	}
+	log.Printf("Restored the backup made %s, %d files checked", manifest.Created, len(manifest.Files))
+	log.Printf("The replaced database and folders are in %s, remove it once the service is working", kept)
 }
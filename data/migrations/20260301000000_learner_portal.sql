@@ -0,0 +1,35 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Link a Learner system account to the learner record it may view in the portal
+ALTER TABLE "UserT" ADD COLUMN "StudentID" VARCHAR(8) REFERENCES "Learners"("StudentID");
+CREATE UNIQUE INDEX userT_byStudentID ON userT(StudentID);
+
+-- Marker feedback released to the learner along with the grade
+ALTER TABLE "Learnerexams" ADD COLUMN "Feedback" TEXT;
+
+DROP VIEW IF EXISTS "MarkedExams";
+CREATE VIEW MarkedExams AS
+SELECT l.StudentID, s.name, l.ExamID, o.CourseCode,
+       o.Year, o.Semester, l.Grade, l.Feedback
+FROM offerings o, Learnerexams l, Learners s
+WHERE o.ExamID = l.ExamID AND l.StudentID = s.StudentID
+      AND l.status = 'marked';
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP VIEW IF EXISTS "MarkedExams";
+CREATE VIEW MarkedExams AS
+SELECT l.StudentID, s.name, l.ExamID, o.CourseCode,
+       o.Year, o.Semester, l.Grade
+FROM offerings o, Learnerexams l, Learners s
+WHERE o.ExamID = l.ExamID AND l.StudentID = s.StudentID
+      AND l.status = 'marked';
+
+ALTER TABLE "Learnerexams" DROP COLUMN "Feedback";
+DROP INDEX IF EXISTS userT_byStudentID;
+ALTER TABLE "UserT" DROP COLUMN "StudentID";
+-- +goose StatementEnd
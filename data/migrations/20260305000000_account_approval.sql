@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Self-registered accounts wait in a pending queue until an admin approves or rejects them.
+-- Accounts created by an admin or the seed are approved
+ALTER TABLE "UserT" ADD COLUMN "Approval" VARCHAR(8) NOT NULL DEFAULT 'approved' CHECK (Approval IN ('pending','approved','rejected'));
+ALTER TABLE "UserT" ADD COLUMN "RejectReason" VARCHAR(255);
+ALTER TABLE "UserT" ADD COLUMN "RegisteredAt" TIMESTAMP;
+CREATE INDEX userT_byApproval ON userT(Approval);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP INDEX IF EXISTS userT_byApproval;
+ALTER TABLE "UserT" DROP COLUMN "RegisteredAt";
+ALTER TABLE "UserT" DROP COLUMN "RejectReason";
+ALTER TABLE "UserT" DROP COLUMN "Approval";
+-- +goose StatementEnd
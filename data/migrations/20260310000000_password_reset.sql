@@ -0,0 +1,22 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Single use password reset tokens, only the SHA-256 hash of the token is stored
+CREATE TABLE "PasswordResets" (
+    "TokenHash"   VARCHAR(64) NOT NULL,
+    "UserID"      INTEGER NOT NULL,
+    "CreatedAt"   TIMESTAMP NOT NULL,
+    "ExpiresAt"   TIMESTAMP NOT NULL,
+    "UsedAt"      TIMESTAMP,
+    PRIMARY KEY("TokenHash"),
+    FOREIGN KEY("UserID") REFERENCES "UserT"("UserID") ON DELETE CASCADE
+);
+CREATE INDEX passwordresets_byUserID ON PasswordResets(UserID);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS "PasswordResets";
+-- +goose StatementEnd
@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Outgoing email waits here until the mailer has delivered it, failed sends are retried with a backoff
+CREATE TABLE "MailQueue" (
+    "MailID"      INTEGER,
+    "Recipient"   VARCHAR(255) NOT NULL,
+    "Subject"     VARCHAR(255) NOT NULL,
+    "TextBody"    TEXT,
+    "HTMLBody"    TEXT,
+    "Status"      VARCHAR(7) NOT NULL DEFAULT 'pending',
+    "Attempts"    INTEGER NOT NULL DEFAULT 0,
+    "NextAttempt" TIMESTAMP NOT NULL,
+    "LastError"   TEXT,
+    "CreatedAt"   TIMESTAMP NOT NULL,
+    "SentAt"      TIMESTAMP,
+    PRIMARY KEY("MailID" AUTOINCREMENT),
+    CHECK (Status IN ('pending','sent','failed'))
+);
+CREATE INDEX mailqueue_byStatus ON MailQueue(Status, NextAttempt);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS "MailQueue";
+-- +goose StatementEnd
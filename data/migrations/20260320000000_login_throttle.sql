@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Failed login tracking for the lockout policy
+-- Scope: account (staff username), ip (staff login source address), exam (Assessment Tool source address)
+-- LastFailure and LockedUntil are unix times
+CREATE TABLE "LoginFailures" (
+    "Scope"       VARCHAR(8) NOT NULL,
+    "Subject"     VARCHAR(255) NOT NULL,
+    "Failures"    INTEGER NOT NULL DEFAULT 0,
+    "LastFailure" INTEGER NOT NULL DEFAULT 0,
+    "LockedUntil" INTEGER NOT NULL DEFAULT 0,
+    PRIMARY KEY("Scope","Subject"),
+    CHECK (Scope IN ('account','ip','exam'))
+);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS "LoginFailures";
+-- +goose StatementEnd
@@ -0,0 +1,32 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- TOTP second factor for staff accounts
+-- TOTPSecret is set when enrolment starts and TOTPEnabled once the first code has been confirmed
+-- TOTPRequired is set by an admin to make enrolment mandatory at the next login
+-- TOTPLastStep is the last accepted time step so a code cannot be replayed
+ALTER TABLE "UserT" ADD COLUMN "TOTPSecret" VARCHAR(64);
+ALTER TABLE "UserT" ADD COLUMN "TOTPEnabled" BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE "UserT" ADD COLUMN "TOTPRequired" BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE "UserT" ADD COLUMN "TOTPLastStep" INTEGER NOT NULL DEFAULT 0;
+
+-- single use recovery codes, only the SHA-256 hash is stored
+CREATE TABLE "RecoveryCodes" (
+    "CodeHash"  VARCHAR(64) PRIMARY KEY,
+    "UserID"    INTEGER NOT NULL,
+    "UsedAt"    TIMESTAMP,
+    FOREIGN KEY("UserID") REFERENCES "UserT"("UserID") ON DELETE CASCADE
+);
+CREATE INDEX "recoveryCodes_byUser" ON "RecoveryCodes" ("UserID");
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS "RecoveryCodes";
+ALTER TABLE "UserT" DROP COLUMN "TOTPLastStep";
+ALTER TABLE "UserT" DROP COLUMN "TOTPRequired";
+ALTER TABLE "UserT" DROP COLUMN "TOTPEnabled";
+ALTER TABLE "UserT" DROP COLUMN "TOTPSecret";
+-- +goose StatementEnd
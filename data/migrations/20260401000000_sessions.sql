@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Server side login sessions, keyed by the token ID (jti) claim of the login JWT
+-- a token is only accepted while its session row exists, deleting the row revokes the login
+CREATE TABLE "Sessions" (
+    "SessionID"   VARCHAR(64) NOT NULL,
+    "UserID"      INTEGER NOT NULL,
+    "CreatedAt"   TIMESTAMP NOT NULL,
+    "LastSeen"    TIMESTAMP NOT NULL,
+    "ExpiresAt"   TIMESTAMP NOT NULL,
+    "IP"          VARCHAR(64),
+    "UserAgent"   VARCHAR(255),
+    PRIMARY KEY("SessionID"),
+    FOREIGN KEY("UserID") REFERENCES "UserT"("UserID") ON DELETE CASCADE
+);
+CREATE INDEX sessions_byUserID ON Sessions(UserID);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS "Sessions";
+-- +goose StatementEnd
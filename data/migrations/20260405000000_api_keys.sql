@@ -0,0 +1,28 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- API keys for machine clients e.g. the Assessment Tool and Assessment Marking Tool
+-- only the SHA-256 hash of a key is stored, the key itself is shown once when it is created
+-- Scopes is a comma separated list e.g. exam:deliver,marks:write,reports:read
+CREATE TABLE "ApiKeys" (
+    "KeyID"       INTEGER,
+    "Name"        VARCHAR(64) NOT NULL,
+    "Prefix"      VARCHAR(16) NOT NULL,
+    "KeyHash"     VARCHAR(64) NOT NULL UNIQUE,
+    "Scopes"      VARCHAR(255) NOT NULL,
+    "CreatedBy"   INTEGER,
+    "CreatedAt"   TIMESTAMP NOT NULL,
+    "LastUsed"    TIMESTAMP,
+    "LastUsedIP"  VARCHAR(64),
+    "RevokedAt"   TIMESTAMP,
+    PRIMARY KEY("KeyID" AUTOINCREMENT),
+    FOREIGN KEY("CreatedBy") REFERENCES "UserT"("UserID") ON DELETE SET NULL
+);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS "ApiKeys";
+-- +goose StatementEnd
@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- where the account is authenticated - local accounts check the bcrypt password in UserT,
+-- ldap accounts were provisioned from the directory on their first login and have no local password
+ALTER TABLE "UserT" ADD COLUMN "AuthSource" VARCHAR(8) NOT NULL DEFAULT 'local';
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+ALTER TABLE "UserT" DROP COLUMN "AuthSource";
+-- +goose StatementEnd
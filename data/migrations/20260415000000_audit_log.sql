@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Audit trail of the changes made through the staff and admin routes
+-- Before and After hold JSON snapshots of the entity, the request body itself is never stored
+CREATE TABLE "AuditLog" (
+    "AuditID"     INTEGER,
+    "CreatedAt"   TIMESTAMP NOT NULL,
+    "ActorID"     INTEGER NOT NULL,
+    "Actor"       VARCHAR(80) NOT NULL,
+    "Role"        VARCHAR(20) NOT NULL,
+    "Action"      VARCHAR(16) NOT NULL,
+    "Entity"      VARCHAR(32) NOT NULL,
+    "EntityID"    VARCHAR(64),
+    "Detail"      VARCHAR(255),
+    "Before"      TEXT,
+    "After"       TEXT,
+    "Method"      VARCHAR(8) NOT NULL,
+    "Path"        VARCHAR(255) NOT NULL,
+    "Status"      INTEGER NOT NULL,
+    "IP"          VARCHAR(64),
+    PRIMARY KEY("AuditID" AUTOINCREMENT)
+);
+CREATE INDEX auditLog_byCreatedAt ON AuditLog(CreatedAt);
+CREATE INDEX auditLog_byEntity ON AuditLog(Entity, EntityID);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS "AuditLog";
+-- +goose StatementEnd
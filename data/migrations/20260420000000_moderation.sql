@@ -0,0 +1,57 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Second marker of an exam offering, assigned by the course coordinator or an Admin
+CREATE TABLE "Moderators" (
+    "ExamID"      VARCHAR(15) NOT NULL,
+    "ModeratorID" INTEGER NOT NULL,
+    "AssignedBy"  INTEGER NOT NULL,
+    "AssignedAt"  TIMESTAMP NOT NULL,
+    PRIMARY KEY("ExamID"),
+    FOREIGN KEY("ExamID") REFERENCES "Offerings"("ExamID") ON DELETE CASCADE,
+    FOREIGN KEY("ModeratorID") REFERENCES "UserT"("UserID")
+);
+
+-- Per question marks of a learner exam, Stage is first for the first marker and moderated for the
+-- moderator's adjustments. The final mark of a question is the moderated mark when there is one
+CREATE TABLE "Marks" (
+    "StudentID"   VARCHAR NOT NULL,
+    "ExamID"      VARCHAR(15) NOT NULL,
+    "Stage"       VARCHAR(10) NOT NULL,
+    "Question"    VARCHAR(32) NOT NULL,
+    "Mark"        REAL NOT NULL,
+    "OutOf"       REAL NOT NULL,
+    "Comment"     VARCHAR(500),
+    "MarkerID"    INTEGER NOT NULL,
+    "MarkedAt"    TIMESTAMP NOT NULL,
+    PRIMARY KEY("StudentID", "ExamID", "Stage", "Question"),
+    FOREIGN KEY("ExamID") REFERENCES "Offerings"("ExamID") ON DELETE CASCADE,
+    CHECK (Stage IN ('first', 'moderated')),
+    CHECK (Mark >= 0 AND Mark <= OutOf)
+);
+
+-- Every step of the moderation workflow, StudentID is NULL for the offering level steps
+CREATE TABLE "MarkingSteps" (
+    "StepID"      INTEGER,
+    "ExamID"      VARCHAR(15) NOT NULL,
+    "StudentID"   VARCHAR,
+    "Step"        VARCHAR(10) NOT NULL,
+    "ActorID"     INTEGER NOT NULL,
+    "Actor"       VARCHAR(80) NOT NULL,
+    "Comment"     VARCHAR(500),
+    "CreatedAt"   TIMESTAMP NOT NULL,
+    PRIMARY KEY("StepID" AUTOINCREMENT),
+    FOREIGN KEY("ExamID") REFERENCES "Offerings"("ExamID") ON DELETE CASCADE,
+    CHECK (Step IN ('assigned', 'submitted', 'moderated', 'approved'))
+);
+CREATE INDEX markingSteps_byExam ON MarkingSteps(ExamID, StudentID);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS "MarkingSteps";
+DROP TABLE IF EXISTS "Marks";
+DROP TABLE IF EXISTS "Moderators";
+-- +goose StatementEnd
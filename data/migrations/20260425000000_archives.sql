@@ -0,0 +1,55 @@
+-- +goose Up
+-- +goose StatementBegin
+
+-- Year-end archives, a closed year or semester packaged into a signed records archive (the database rows
+-- and exam files) and a signed submissions archive (the learners' uploads). Semester is empty when the
+-- whole year was archived. SubmissionsPurgedAt is set once the retention period has passed and the
+-- submissions archive was removed
+CREATE TABLE "Archives" (
+    "ArchiveID"           INTEGER,
+    "Year"                INTEGER NOT NULL,
+    "Semester"            VARCHAR(2) NOT NULL DEFAULT '',
+    "RecordsFile"         VARCHAR(255) NOT NULL,
+    "RecordsSHA256"       VARCHAR(64) NOT NULL,
+    "SubmissionsFile"     VARCHAR(255) NOT NULL,
+    "SubmissionsSHA256"   VARCHAR(64) NOT NULL,
+    "Offerings"           INTEGER NOT NULL DEFAULT 0,
+    "Results"             INTEGER NOT NULL DEFAULT 0,
+    "SubmissionFiles"     INTEGER NOT NULL DEFAULT 0,
+    "SubmissionBytes"     INTEGER NOT NULL DEFAULT 0,
+    "CreatedBy"           VARCHAR(80) NOT NULL,
+    "CreatedAt"           TIMESTAMP NOT NULL,
+    "SubmissionsPurgedAt" TIMESTAMP,
+    PRIMARY KEY("ArchiveID" AUTOINCREMENT),
+    CHECK ("Semester" IN ('', 'S1', 'S2', 'S3'))
+);
+CREATE INDEX archives_byYear ON Archives(Year);
+
+-- Searchable index of the results that were archived, the learner name is copied from Learners
+CREATE TABLE "ArchivedResults" (
+    "ArchiveID"   INTEGER NOT NULL,
+    "StudentID"   VARCHAR(8) NOT NULL,
+    "Name"        VARCHAR NOT NULL DEFAULT '',
+    "ExamID"      VARCHAR(15) NOT NULL,
+    "Year"        INTEGER NOT NULL,
+    "Semester"    VARCHAR(2) NOT NULL,
+    "CourseCode"  VARCHAR(9) NOT NULL,
+    "Status"      VARCHAR(6) NOT NULL,
+    "Grade"       INTEGER DEFAULT 0,
+    "Feedback"    TEXT,
+    "StartTime"   TIME,
+    "EndTime"     TIME,
+    PRIMARY KEY("ArchiveID", "StudentID", "ExamID"),
+    FOREIGN KEY("ArchiveID") REFERENCES "Archives"("ArchiveID") ON DELETE CASCADE
+);
+CREATE INDEX archivedResults_byStudentID ON ArchivedResults(StudentID);
+CREATE INDEX archivedResults_byCourseCode ON ArchivedResults(CourseCode, Year);
+
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+
+DROP TABLE IF EXISTS "ArchivedResults";
+DROP TABLE IF EXISTS "Archives";
+-- +goose StatementEnd
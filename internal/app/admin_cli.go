@@ -0,0 +1,590 @@
+package app
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"os/user"
+	"strings"
+	"text/tabwriter"
+
+	"ADS4/internal/config"
+	"ADS4/internal/database"
+	"ADS4/internal/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+/* Operator commands
+   - ads admin runs the routine admin work on the server against the configured database, without the web
+     service or a login. The commands use the database methods and checks of the admin pages
+   - the changes are written to the audit trail with the actor cli:<operating system user>, the method CLI
+     and the command as the path. Passwords are never recorded
+   - the flags of a command come before its arguments e.g. ads admin user reset -password ... adminx
+*/
+
+// adminUsage lists the operator commands
+const adminUsage = `usage: ads admin <command>
+  user list [-role Admin|Faculty|Learner]
+  user create -username <name> -email <email> -role <role> [-studentid <id>] [-password <password>] [-active]
+  user reset [-password <password>] <username>        a random password is printed when none is given
+  user deactivate|activate <username>
+  import [-purge] [-overwrite] course|learner|offering|learnerexam <file.csv>
+  export [-o <file.csv>] course|learner|offering|learnerexam|audit
+  offering open|close <examid>
+  attempt list [-examid <examid>]
+  attempt expire|reset <studentid> <examid>
+  migrate status|up`
+
+// errAdminUsage is returned for an unknown command or missing arguments, with the usage
+var errAdminUsage = errors.New(adminUsage)
+
+// adminCLI runs an operator command
+type adminCLI struct {
+	app     *App
+	out     io.Writer
+	actor   string // cli:<operating system user>
+	command string // the command without its flags and arguments, the path of its audit entries
+}
+
+// RunAdmin runs the operator command in args against the database of cfg, the output goes to out
+func RunAdmin(cfg config.Config, args []string, out io.Writer) error {
+	if len(args) < 2 {
+		return errAdminUsage
+	}
+
+	commands := map[string]func(cli *adminCLI, args []string) error{
+		"user list":       (*adminCLI).userList,
+		"user create":     (*adminCLI).userCreate,
+		"user reset":      (*adminCLI).userReset,
+		"user deactivate": func(cli *adminCLI, args []string) error { return cli.userActive(args, false) },
+		"user activate":   func(cli *adminCLI, args []string) error { return cli.userActive(args, true) },
+		"import":          (*adminCLI).importData,
+		"export":          (*adminCLI).exportData,
+		"offering open":   func(cli *adminCLI, args []string) error { return cli.offeringStatus(args, "active") },
+		"offering close":  func(cli *adminCLI, args []string) error { return cli.offeringStatus(args, "closed") },
+		"attempt list":    (*adminCLI).attemptList,
+		"attempt expire":  (*adminCLI).attemptExpire,
+		"attempt reset":   (*adminCLI).attemptReset,
+		"migrate status":  (*adminCLI).migrateStatus,
+		"migrate up":      (*adminCLI).migrateUp,
+	}
+	// import and export are one word, the others are a group and a command
+	name, rest := args[0], args[1:]
+	if _, ok := commands[name]; !ok {
+		name, rest = args[0]+" "+args[1], args[2:]
+	}
+	run, ok := commands[name]
+	if !ok {
+		return errAdminUsage
+	}
+
+	db, err := database.NewDB(cfg)
+	if err != nil {
+		return fmt.Errorf("connecting to the database: %w", err)
+	}
+	defer db.Close()
+
+	cli := &adminCLI{
+		app:     &App{DB: db, Config: cfg, DataDir: cfg.DataDir},
+		out:     out,
+		actor:   "cli:unknown",
+		command: "ads admin " + name,
+	}
+	if current, err := user.Current(); err == nil {
+		cli.actor = "cli:" + current.Username
+	}
+	return run(cli, rest)
+}
+
+// flags returns the flag set of the command, parse errors are returned rather than ending the process
+func (cli *adminCLI) flags() *flag.FlagSet {
+	flags := flag.NewFlagSet(cli.command, flag.ContinueOnError)
+	flags.SetOutput(cli.out)
+	return flags
+}
+
+// parse parses the flags of the command and checks it was given the number of arguments
+func (cli *adminCLI) parse(flags *flag.FlagSet, args []string, count int) ([]string, error) {
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() != count {
+		return nil, errAdminUsage
+	}
+	return flags.Args(), nil
+}
+
+// audit records a change made by the command, as AuditTrail does for the web routes
+func (cli *adminCLI) audit(action, entity, entityID, detail string, before, after any) {
+	entry := &database.AuditEntry{
+		Actor:    cli.actor,
+		Role:     "Operator",
+		Action:   action,
+		Entity:   entity,
+		EntityID: entityID,
+		Detail:   detail,
+		Before:   auditJSON(before),
+		After:    auditJSON(after),
+		Method:   "CLI",
+		Path:     cli.command,
+		Status:   http.StatusOK,
+	}
+	if err := cli.app.DB.CreateAuditEntry(entry); err != nil {
+		fmt.Fprintf(cli.out, "warning: the audit entry was not written: %v\n", err)
+	}
+}
+
+// table writes rows as aligned columns under the headings
+func (cli *adminCLI) table(headings []string, rows [][]string) error {
+	w := tabwriter.NewWriter(cli.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(headings, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+// userList lists the accounts, all of them or those of a role
+func (cli *adminCLI) userList(args []string) error {
+	flags := cli.flags()
+	role := flags.String("role", "", "only the accounts of the role")
+	if _, err := cli.parse(flags, args, 0); err != nil {
+		return err
+	}
+
+	var rows [][]string
+	opts := database.ListOptions{Page: 1, PageSize: database.MaxPageSize, Sort: "username"}
+	for {
+		users, total, err := cli.app.DB.GetAllUsers(*role, opts)
+		if err != nil {
+			return err
+		}
+		for _, u := range users {
+			rows = append(rows, []string{fmt.Sprint(u.UserID), u.Username, u.Email, u.Role, fmt.Sprint(u.Active), u.StudentID, u.AuthSource})
+		}
+		if opts.Page*opts.PageSize >= total {
+			break
+		}
+		opts.Page++
+	}
+	return cli.table([]string{"ID", "USERNAME", "EMAIL", "ROLE", "ACTIVE", "STUDENT ID", "SOURCE"}, rows)
+}
+
+// userCreate adds a local account with the checks of the admin page, the password is printed when it was generated
+func (cli *adminCLI) userCreate(args []string) error {
+	flags := cli.flags()
+	username := flags.String("username", "", "username, at least 6 letters, numbers or underscores")
+	email := flags.String("email", "", "email address")
+	role := flags.String("role", "", "Admin, Faculty or Learner")
+	studentid := flags.String("studentid", "", "the learner record of a Learner account")
+	password := flags.String("password", "", "password, a random one is made when not given")
+	active := flags.Bool("active", false, "activate the account straight away")
+	if _, err := cli.parse(flags, args, 0); err != nil {
+		return err
+	}
+
+	if err := validateUser(*username, *email, *role); err != nil {
+		return err
+	}
+	linked, err := cli.app.validateUserLearner(*role, *studentid, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := cli.app.DB.GetUserByUsername(*username); err == nil {
+		return errors.New("username already exists")
+	}
+	if _, err := cli.app.DB.GetUserByEmail(*email); err == nil {
+		return errors.New("email already exists")
+	}
+
+	hash, generated, err := cli.password(*password)
+	if err != nil {
+		return err
+	}
+	err = cli.app.DB.CreateUser(&models.User{Username: *username, Password: hash, Email: *email, Role: *role, StudentID: linked})
+	if err != nil {
+		return fmt.Errorf("creating the user: %w", err)
+	}
+	created, err := cli.app.DB.GetUserByUsername(*username)
+	if err != nil {
+		return err
+	}
+	if *active {
+		if err := cli.app.DB.UpdateActive(created.UserID, true); err != nil {
+			return err
+		}
+		created.Active = true
+	}
+
+	cli.audit("create", "user", fmt.Sprint(created.UserID), "password set", nil, userAudit(created))
+	fmt.Fprintf(cli.out, "User %s created with ID %d, active %t\n", created.Username, created.UserID, created.Active)
+	if generated != "" {
+		fmt.Fprintf(cli.out, "Password: %s\n", generated)
+	}
+	return nil
+}
+
+// userReset sets a new password for a local account and clears its login lockout, the account's
+// sessions are revoked
+func (cli *adminCLI) userReset(args []string) error {
+	flags := cli.flags()
+	password := flags.String("password", "", "the new password, a random one is made when not given")
+	rest, err := cli.parse(flags, args, 1)
+	if err != nil {
+		return err
+	}
+
+	account, err := cli.user(rest[0])
+	if err != nil {
+		return err
+	}
+	if account.AuthSource == "ldap" {
+		return fmt.Errorf("%s logs in with the directory password, reset it in the directory", account.Username)
+	}
+	hash, generated, err := cli.password(*password)
+	if err != nil {
+		return err
+	}
+	if err := cli.app.DB.UpdatePassword(account.UserID, hash); err != nil {
+		return fmt.Errorf("updating the password: %w", err)
+	}
+	if _, err := cli.app.DB.ClearLoginFailure(ScopeAccount, account.Username); err != nil {
+		return fmt.Errorf("clearing the lockout: %w", err)
+	}
+
+	cli.audit("update", "user", fmt.Sprint(account.UserID), "password reset, sessions revoked", nil, nil)
+	fmt.Fprintf(cli.out, "Password of %s reset, the sessions are revoked and any lockout cleared\n", account.Username)
+	if generated != "" {
+		fmt.Fprintf(cli.out, "Password: %s\n", generated)
+	}
+	return nil
+}
+
+// userActive activates or deactivates an account, a deactivated account is logged out everywhere.
+// The default admin is always active
+func (cli *adminCLI) userActive(args []string, active bool) error {
+	rest, err := cli.parse(cli.flags(), args, 1)
+	if err != nil {
+		return err
+	}
+	account, err := cli.user(rest[0])
+	if err != nil {
+		return err
+	}
+	if account.DefaultAdmin && !active {
+		return errors.New("the default admin cannot be deactivated")
+	}
+
+	before := userAudit(account)
+	if err := cli.app.DB.UpdateActive(account.UserID, active); err != nil {
+		return err
+	}
+	after, _ := cli.app.DB.GetUserByID(account.UserID)
+	cli.audit("update", "user", fmt.Sprint(account.UserID), "", before, userAudit(after))
+
+	state := "activated"
+	if !active {
+		state = "deactivated, its sessions are revoked"
+	}
+	fmt.Fprintf(cli.out, "User %s %s\n", account.Username, state)
+	return nil
+}
+
+// user finds an account by its username
+func (cli *adminCLI) user(username string) (*models.User, error) {
+	account, err := cli.app.DB.GetUserByUsername(username)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("user %s not found", username)
+	}
+	return account, err
+}
+
+// password checks a password against the policy and hashes it, or makes a random one when it is empty.
+// The random password is returned so it can be shown once
+func (cli *adminCLI) password(password string) (hash, generated string, err error) {
+	if password == "" {
+		if password, err = randomPassword(); err != nil {
+			return "", "", err
+		}
+		generated = password
+	}
+	if err := validatePassword(password); err != nil {
+		return "", "", err
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", "", fmt.Errorf("hashing the password: %w", err)
+	}
+	return string(hashed), generated, nil
+}
+
+// randomPassword makes a 16 character password meeting the password policy
+func randomPassword() (string, error) {
+	const (
+		letters = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+		special = "!@#$%^&*"
+	)
+	pick := func(set string) (byte, error) {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(set))))
+		if err != nil {
+			return 0, err
+		}
+		return set[n.Int64()], nil
+	}
+
+	password := make([]byte, 0, 16)
+	for _, set := range []string{"ABCDEFGHJKLMNPQRSTUVWXYZ", "23456789", special} {
+		c, err := pick(set)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c)
+	}
+	for len(password) < cap(password) {
+		c, err := pick(letters)
+		if err != nil {
+			return "", err
+		}
+		password = append(password, c)
+	}
+	// the required characters are moved to random places
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := rand.Int(rand.Reader, big.NewInt(int64(i+1)))
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j.Int64()] = password[j.Int64()], password[i]
+	}
+	return string(password), nil
+}
+
+// importData imports a CSV file as the admin page does, -purge empties the table first and -overwrite
+// updates the existing rows
+func (cli *adminCLI) importData(args []string) error {
+	flags := cli.flags()
+	purge := flags.Bool("purge", false, "empty the table before the import")
+	overwrite := flags.Bool("overwrite", false, "update the existing rows instead of adding them")
+	rest, err := cli.parse(flags, args, 2)
+	if err != nil {
+		return err
+	}
+	target, file := rest[0], rest[1]
+
+	switch target {
+	case "learner":
+		err = cli.app.DB.ImportLearners(file, *purge, *overwrite)
+	case "course":
+		err = cli.app.DB.ImportCourses(file, *purge, *overwrite)
+	case "learnerexam":
+		err = cli.app.DB.ImportLearnerExams(file, *purge, *overwrite)
+	case "offering":
+		err = cli.app.DB.ImportOfferings(file, *purge, *overwrite)
+	default:
+		return errAdminUsage
+	}
+	if err != nil {
+		return fmt.Errorf("importing %s: %w", file, err)
+	}
+
+	cli.audit("import", target, "", fmt.Sprintf("purge=%t overwrite=%t file=%s", *purge, *overwrite, file), nil, nil)
+	fmt.Fprintf(cli.out, "Successful import for : %s\n", target)
+	return nil
+}
+
+// exportData writes a table as CSV in the import format, or the audit trail as the audit page downloads
+// it, to standard output or the -o file
+func (cli *adminCLI) exportData(args []string) error {
+	flags := cli.flags()
+	output := flags.String("o", "", "file to write, standard output when not given")
+	rest, err := cli.parse(flags, args, 1)
+	if err != nil {
+		return err
+	}
+	target := rest[0]
+
+	exports := map[string]func(w io.Writer) error{
+		"course":      cli.app.DB.ExportCourses,
+		"learner":     cli.app.DB.ExportLearners,
+		"offering":    cli.app.DB.ExportOfferings,
+		"learnerexam": cli.app.DB.ExportLearnerExams,
+		"audit": func(w io.Writer) error {
+			return cli.app.writeAuditCSV(w, database.AuditFilter{})
+		},
+	}
+	export, ok := exports[target]
+	if !ok {
+		return errAdminUsage
+	}
+
+	if *output == "" {
+		return export(cli.out)
+	}
+	file, err := os.OpenFile(*output, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return err
+	}
+	if err := export(file); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(cli.out, "Exported %s to %s\n", target, *output)
+	return nil
+}
+
+// offeringStatus opens (active) or closes an exam offering
+func (cli *adminCLI) offeringStatus(args []string, status string) error {
+	rest, err := cli.parse(cli.flags(), args, 1)
+	if err != nil {
+		return err
+	}
+	examid := rest[0]
+
+	offering, err := cli.app.DB.GetOfferingByID(examid)
+	if err != nil || offering == nil {
+		return fmt.Errorf("exam offering %s not found", examid)
+	}
+	if err := cli.app.DB.UpdateOfferingStatus(examid, status); err != nil {
+		return fmt.Errorf("updating the exam offering status: %w", err)
+	}
+	after, _ := cli.app.DB.GetOfferingByID(examid)
+	cli.audit("update", "offering", examid, "", offeringAudit(offering), offeringAudit(after))
+	fmt.Fprintf(cli.out, "Exam offering %s is %s\n", examid, status)
+	return nil
+}
+
+// attemptList lists the attempts in progress
+func (cli *adminCLI) attemptList(args []string) error {
+	flags := cli.flags()
+	examid := flags.String("examid", "", "only the attempts of the exam")
+	if _, err := cli.parse(flags, args, 0); err != nil {
+		return err
+	}
+
+	var rows [][]string
+	opts := database.ListOptions{Page: 1, PageSize: database.MaxPageSize}
+	for {
+		attempts, total, err := cli.app.DB.GetAllLearnerExams("", *examid, "active", opts)
+		if err != nil {
+			return err
+		}
+		for _, attempt := range attempts {
+			rows = append(rows, []string{attempt.StudentID.String, attempt.ExamID.String, attempt.StartTime.String})
+		}
+		if opts.Page*opts.PageSize >= total {
+			break
+		}
+		opts.Page++
+	}
+	if len(rows) == 0 {
+		fmt.Fprintln(cli.out, "No attempts in progress")
+		return nil
+	}
+	return cli.table([]string{"STUDENT ID", "EXAM ID", "STARTED"}, rows)
+}
+
+// attemptExpire ends an attempt in progress as the Assessment Tool does when the time is up
+func (cli *adminCLI) attemptExpire(args []string) error {
+	return cli.attemptChange(args, "expired", "", func(attempt *models.LearnerExam) error {
+		if attempt.Status.String != "active" {
+			return fmt.Errorf("the attempt status is %s, only an active attempt can be expired", attempt.Status.String)
+		}
+		return cli.app.DB.CloseLearnerExam(attempt.StudentID.String, attempt.ExamID.String, true)
+	})
+}
+
+// attemptReset lets the learner start an attempt again, the grade, marks and marking steps of the earlier
+// attempt are cleared. Results that have been released are kept
+func (cli *adminCLI) attemptReset(args []string) error {
+	detail := "reset to ready, the grade, marks and marking steps of the attempt were cleared"
+	return cli.attemptChange(args, "reset to ready", detail, func(attempt *models.LearnerExam) error {
+		if attempt.Status.String == "marked" {
+			return errors.New("the attempt is marked, its result has been released")
+		}
+		return cli.app.DB.ResetLearnerExam(attempt.StudentID.String, attempt.ExamID.String, sql.NullInt32{})
+	})
+}
+
+// attemptChange runs change on the attempt of the student ID and exam ID arguments and audits it, with its
+// marks in the snapshot from before the change
+func (cli *adminCLI) attemptChange(args []string, done, detail string, change func(attempt *models.LearnerExam) error) error {
+	rest, err := cli.parse(cli.flags(), args, 2)
+	if err != nil {
+		return err
+	}
+	studentid, examid := rest[0], rest[1]
+
+	attempt, err := cli.app.DB.GetLearnerExamByID(studentid, examid)
+	if err != nil {
+		return fmt.Errorf("learner exam %s/%s not found", studentid, examid)
+	}
+	before := cli.app.learnerExamMarksAudit(nil, attempt)
+	if err := change(attempt); err != nil {
+		return err
+	}
+	after, _ := cli.app.DB.GetLearnerExamByID(studentid, examid)
+	cli.audit("update", "learnerexam", studentid+"/"+examid, detail, before, learnerExamAudit(after))
+	fmt.Fprintf(cli.out, "Attempt %s/%s %s\n", studentid, examid, done)
+	return nil
+}
+
+// migrateStatus lists the migrations of MIGRATIONS_DIR and whether they have been applied
+func (cli *adminCLI) migrateStatus(args []string) error {
+	if _, err := cli.parse(cli.flags(), args, 0); err != nil {
+		return err
+	}
+	migrations, err := cli.app.DB.GetMigrations(cli.app.Config.MigrationsDir)
+	if err != nil {
+		return err
+	}
+	rows := make([][]string, len(migrations))
+	for i, migration := range migrations {
+		state := "pending"
+		if migration.Applied {
+			state = "applied"
+		}
+		rows[i] = []string{migration.File, state}
+	}
+	return cli.table([]string{"MIGRATION", "STATE"}, rows)
+}
+
+// migrateUp applies the pending migrations of MIGRATIONS_DIR oldest first, stopping at the first failure
+func (cli *adminCLI) migrateUp(args []string) error {
+	if _, err := cli.parse(cli.flags(), args, 0); err != nil {
+		return err
+	}
+	dir := cli.app.Config.MigrationsDir
+	pending, err := cli.app.DB.PendingMigrations(dir)
+	if err != nil {
+		return err
+	}
+	if len(pending) == 0 {
+		fmt.Fprintln(cli.out, "No pending migrations")
+		return nil
+	}
+	var applied []string
+	for _, migration := range pending {
+		if err = cli.app.DB.ApplyMigration(dir, migration); err != nil {
+			break
+		}
+		applied = append(applied, migration.File)
+		fmt.Fprintf(cli.out, "Applied %s\n", migration.File)
+	}
+
+	// one entry for the run, written after it as the AuditLog table may only just have been created
+	if len(applied) > 0 {
+		last := pending[len(applied)-1]
+		detail := fmt.Sprintf("%d migration(s) applied", len(applied))
+		cli.audit("update", "migration", fmt.Sprint(last.Version), detail, nil, map[string]any{"applied": applied})
+	}
+	return err
+}
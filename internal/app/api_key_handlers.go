@@ -0,0 +1,241 @@
+package app
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+/* API keys for machine clients
+   - the Assessment Tool and Assessment Marking Tool send a key in the header: Authorization: Bearer ads4_...
+   - keys are created and revoked by admins, only a hash of the key is stored and it is shown once
+   - a key only reaches the routes listed in apiKeyRoutes for its scopes, every other route is refused
+   - a key acts with Admin data rights on those routes, so a key is not limited to the offerings of a user
+*/
+
+// API key scopes
+const (
+	ScopeExamDeliver = "exam:deliver" // offering details and learner exam status for the exam delivery
+	ScopeMarksWrite  = "marks:write"  // read the learner exams, write their status and submit the first marks
+	ScopeReportsRead = "reports:read" // read only offerings, learner exams and results
+	ScopeMetricsRead = "metrics:read" // scrape the Prometheus metrics
+)
+
+// apiKeyScopes are the scopes an admin can grant
+var apiKeyScopes = []string{ScopeExamDeliver, ScopeMarksWrite, ScopeReportsRead, ScopeMetricsRead}
+
+// apiKeyRoutes maps the routes that accept an API key to the scopes allowed to use them
+var apiKeyRoutes = map[string][]string{
+	"GET /api/offering":         {ScopeExamDeliver, ScopeReportsRead},
+	"GET /api/offering/:examid": {ScopeExamDeliver, ScopeReportsRead},
+
+	"GET /api/learnerexam":                           {ScopeMarksWrite, ScopeReportsRead},
+	"GET /api/learnerexam/:studentid/:examid":        {ScopeExamDeliver, ScopeMarksWrite, ScopeReportsRead},
+	"PUT /api/learnerexam/:studentid/:examid":        {ScopeMarksWrite},
+	"PUT /api/learnerexam/:studentid/:examid/status": {ScopeExamDeliver, ScopeMarksWrite},
+
+	"GET /api/learner/:studentid/results": {ScopeReportsRead},
+
+	"GET /exammetrics":                         {ScopeReportsRead},
+	"GET /closedexams/:field/:value/:semester": {ScopeReportsRead},
+
+	// moderation and the approval of the results are left to staff
+	"GET /api/marking/:examid":                  {ScopeMarksWrite, ScopeReportsRead},
+	"GET /api/marking/:examid/:studentid":       {ScopeMarksWrite, ScopeReportsRead},
+	"PUT /api/marking/:examid/:studentid/marks": {ScopeMarksWrite},
+
+	"GET /metrics":              {ScopeMetricsRead},
+	"GET /health/ready/details": {ScopeMetricsRead},
+}
+
+const apiKeyPrefix = "ads4_"
+
+// bearerKey returns the key of an Authorization: Bearer header, or false when the header is not set
+func bearerKey(c echo.Context) (string, bool) {
+	header := c.Request().Header.Get(echo.HeaderAuthorization)
+	key, found := strings.CutPrefix(header, "Bearer ")
+	return strings.TrimSpace(key), found
+}
+
+// isAPIKeyRequest checks if the request was authenticated with an API key
+func isAPIKeyRequest(c echo.Context) bool {
+	return c.Get("apikey") != nil
+}
+
+// APIKeyAuth middleware authenticates requests with an Authorization: Bearer header. A valid key with a scope
+// for the route stands in for the login token, requests without the header go on to the login token checks
+func (a *App) APIKeyAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key, found := bearerKey(c)
+		if !found {
+			return next(c)
+		}
+
+		// a guessed key counts as a failed login for the address
+		ip := c.RealIP()
+		if wait := a.lockoutRemaining(c, ScopeIP, ip); wait > 0 {
+			return c.JSON(http.StatusTooManyRequests, map[string]string{"error": lockoutMessage(wait)})
+		}
+
+		apiKey, err := a.db(c).GetAPIKeyByHash(hashResetToken(key))
+		if err != nil {
+			if err != sql.ErrNoRows {
+				return a.handleError(c, http.StatusInternalServerError, "Error checking the API key", err)
+			}
+			a.recordLoginFailure(c, ScopeIP, ip)
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "Invalid or revoked API key"})
+		}
+
+		allowed := apiKeyRoutes[c.Request().Method+" "+c.Path()]
+		if !slices.ContainsFunc(apiKeyScopes, func(scope string) bool {
+			return slices.Contains(allowed, scope) && slices.Contains(apiKey.Scopes, scope)
+		}) {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "The API key does not have a scope for this route"})
+		}
+
+		if err := a.db(c).TouchAPIKey(apiKey.KeyID, ip); err != nil {
+			a.handleLogger(c, "Error updating API key: "+err.Error())
+		}
+
+		// the key stands in for a login token so the role checks and logging work unchanged
+		c.Set("apikey", apiKey)
+		c.Set("user", &jwt.Token{Valid: true, Claims: jwt.MapClaims{
+			"user_id":  "0",
+			"username": "apikey:" + apiKey.Name,
+			"role":     RoleAdmin,
+		}})
+		return next(c)
+	}
+}
+
+// HandleGetAPIKeys fetches a page of the API keys as JSON in the paging envelope, ?revoked=true includes revoked keys
+func (a *App) HandleGetAPIKeys(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.Redirect(http.StatusSeeOther, "/admin?error=Method not allowed")
+	}
+
+	opts := parseListOptions(c)
+
+	keys, total, err := a.db(c).GetAPIKeys(c.QueryParam("revoked") == "true", opts)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
+	}
+
+	// Return the results as JSON
+	return c.JSON(http.StatusOK, newPagedResponse(c, keys, total, opts))
+}
+
+// HandlePostAPIKey creates an API key, the key is only returned in this response
+func (a *App) HandlePostAPIKey(c echo.Context) error {
+	// Check if request is a POST request
+	if c.Request().Method != http.MethodPost {
+		return c.Redirect(http.StatusSeeOther, "/admin?error=Method not allowed")
+	}
+
+	// Create a struct to bind the JSON request body
+	type APIKeyRequest struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+
+	var req APIKeyRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid request body",
+			"redirectURL": "/admin?error=Invalid request body"})
+	}
+
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 64 {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "A name of up to 64 characters is required",
+			"redirectURL": "/admin?error=A name of up to 64 characters is required"})
+	}
+
+	var scopes []string
+	for _, scope := range apiKeyScopes {
+		if slices.Contains(req.Scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 || len(scopes) != len(req.Scopes) {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Choose one or more scopes from " + strings.Join(apiKeyScopes, ", "),
+			"redirectURL": "/admin?error=Choose one or more valid scopes"})
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error creating the API key", err)
+	}
+	key := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(b)
+	prefix := key[:len(apiKeyPrefix)+6]
+
+	userid, _ := currentUser(c)
+	keyid, err := a.db(c).CreateAPIKey(req.Name, prefix, hashResetToken(key), scopes, userid)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error creating the API key", err)
+	}
+
+	// the key itself is never recorded, only its prefix
+	auditChange(c, "apikey", strconv.Itoa(keyid), nil, func() any {
+		after, _ := a.db(c).GetAPIKeyByID(keyid)
+		return after
+	})
+
+	a.handleLogger(c, "API key "+req.Name+" ("+strings.Join(scopes, ",")+") created by "+currentUsername(c))
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"message": "API key created, copy it now as it will not be shown again",
+		"key_id":  keyid,
+		"key":     key,
+		"prefix":  prefix,
+		"scopes":  scopes,
+	})
+}
+
+// HandleDeleteAPIKey revokes an API key, the key is kept in the list as revoked
+func (a *App) HandleDeleteAPIKey(c echo.Context) error {
+	// Check if request if a DELETE request
+	if c.Request().Method != http.MethodDelete {
+		return c.Redirect(http.StatusSeeOther, "/admin?error=Method not allowed")
+	}
+
+	keyid, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid API key ID",
+			"redirectURL": "/admin?error=Invalid API key ID"})
+	}
+
+	before, _ := a.db(c).GetAPIKeyByID(keyid)
+	auditChange(c, "apikey", c.Param("id"), before, func() any {
+		after, _ := a.db(c).GetAPIKeyByID(keyid)
+		return after
+	})
+
+	err = a.db(c).RevokeAPIKey(keyid)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error":       "API key not found or already revoked",
+			"redirectURL": "/admin?error=API key not found or already revoked"})
+	}
+	if err != nil {
+		a.handleLogger(c, "Error revoking API key: "+err.Error())
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error":       "Error revoking API key",
+			"redirectURL": "/admin?error=Error revoking API key"})
+	}
+
+	a.handleLogger(c, "API key ID "+c.Param("id")+" revoked by "+currentUsername(c))
+	return c.JSON(http.StatusOK, map[string]string{
+		"message":     "API key revoked",
+		"redirectURL": "/admin?message=API key revoked"})
+}
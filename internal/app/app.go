@@ -2,48 +2,81 @@ package app
 
 import (
 	"context"
-	"log"
 	"net/http"
-	"os"
+	"time"
 
+	ads4 "ADS4"
 	"ADS4/internal/config"
 	"ADS4/internal/database"
+	"ADS4/internal/directory"
+	"ADS4/internal/mailer"
 	"ADS4/internal/utils"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/rs/zerolog"
 )
 
 // App holds the application state including database and router
 type App struct {
 	DB      *database.DB
 	Router  *echo.Echo
-	Logger  *log.Logger
+	Logger  *zerolog.Logger
 	Context context.Context
 	DataDir string
-}
+	Config  config.Config
+	Mail    *mailer.Queue
 
-const (
-	colorBlue  = "\033[1;38;2;62;93;185m"
-	colorRed   = "\033[1;38;2;213;70;70m"
-	colorBlack = "\033[0m"
-)
+	// staff directory for AUTH_PROVIDER=ldap, nil when only local passwords are used
+	Directory *directory.LDAP
+
+	// build version and time, set by main from the -ldflags of the build, and the start of the service
+	Version   string
+	BuildTime string
+	Started   time.Time
+
+	// draining and progress of the graceful shutdown
+	shutdown *shutdownState
+
+	// the running backup and the outcome of the last one
+	backups *backupRunner
+
+	// the running year-end archive or purge and the last purge report
+	archives *archiveRunner
+}
 
 // null route handler for testing
 func (a *App) handleNULL(c echo.Context) error {
-	a.Logger.Printf("Null handler called")
+	a.handleLogger(c, "Null handler called")
 	return c.Render(http.StatusOK, "index.html", nil)
 }
 
-// handleError is a method of App for handling errors
+// handleError is a method of App for handling errors, the error is logged with the request ID
 func (a *App) handleError(c echo.Context, statusCode int, message string, err error) error {
-	a.Logger.Printf(colorRed+"Error: %v"+colorBlack, err) // Use the logger in the App struct
+	a.log(c).Error().Err(err).Int("status", statusCode).Msg(message)
 	return c.JSON(statusCode, map[string]string{"error": message})
 }
 
-func (a *App) handleLogger(message string) {
+// handleLogger logs a message, with the request ID and route parameters when c is a request
+func (a *App) handleLogger(c echo.Context, message string) {
+	a.log(c).Info().Msg(message)
+}
+
+// log returns the logger of the request, or the app logger when c is nil e.g. for background work
+func (a *App) log(c echo.Context) *zerolog.Logger {
+	if c == nil {
+		return a.Logger
+	}
+	return utils.RequestLogger(c)
+}
 
-	a.Logger.Printf(colorBlue+"%s"+colorBlack, message)
+// db returns the database with its calls made in the request context, so they are logged with the request
+// ID. c is nil for background work, which uses the app database
+func (a *App) db(c echo.Context) *database.DB {
+	if c == nil {
+		return a.DB
+	}
+	return a.DB.WithContext(c.Request().Context())
 }
 
 // NewApp creates a new instance of App
@@ -51,24 +84,30 @@ func NewApp(cfg config.Config) *App {
 	// Initialize Echo
 	router := echo.New()
 
-	// Set up renderer
-	renderer, err := utils.NewTemplateRenderer()
+	// Set up renderer, the templates and static files are built into the binary unless ASSETS_DIR is set
+	assets := utils.NewAssets(ads4.Web, cfg.AssetsDir)
+	renderer, err := utils.NewTemplateRenderer(assets)
 	if err != nil {
 		// Handle the error, e.g.:
 		panic(err)
 	}
 
 	router.Renderer = renderer
-	utils.NewLogger() // new
-
-	// Serve static files
-	router.Static("/static", "static")
-
-	router.Use(middleware.RequestLogger()) // Log requests
-	router.Use(middleware.Recover())       // Recover from panics
-	router.Use(middleware.CORS())          // Enable CORS
+	// the service logs its own start up and requests
+	router.HideBanner = true
+	router.HidePort = true
+	// the client address is taken from the connection, forwarded headers could be forged to dodge the
+	// login throttling and rate limits
+	router.IPExtractor = echo.ExtractIPDirect()
+
+	// Serve static files, cached by the browsers when linked with their content hash
+	router.Match([]string{http.MethodGet, http.MethodHead}, "/static/*", assets.ServeStatic)
+
+	router.Use(middleware.RequestID())  // X-Request-Id header, taken from the client when it sends one
+	router.Use(HTTPMetrics)             // Request latency and status by route for /metrics
+	router.Use(utils.LoggingMiddleware) // Log requests, with a request logger in the request context
+	router.Use(middleware.Recover())    // Recover from panics
 	router.Use(middleware.AddTrailingSlash())
-	//router.Use(utils.LoggingMiddleware)
 
 	// Initialize Database
 	db, err := database.NewDB(cfg)
@@ -78,22 +117,58 @@ func NewApp(cfg config.Config) *App {
 
 	// Seed database
 	// Initialize database and seed data if needed
-	if err := database.SeedDatabase(db); err != nil {
+	if err := database.SeedDatabase(db, cfg); err != nil {
 		panic(err)
 	}
 
-	// Initialize Logger
-	logger := log.New(os.Stdout, colorBlue+"APP:"+colorBlack, log.LstdFlags)
+	// The app logger is set up by utils.NewLogger from the logging settings
+	logger := &utils.Logger.Logger
+	if assets.Dev() {
+		logger.Warn().Str("dir", cfg.AssetsDir).Msg("Serving the templates and static files from disk, templates reload when they change")
+	}
+
+	// Outgoing email is queued in the database and delivered in the background
+	mail, err := mailer.New(cfg)
+	if err != nil {
+		panic(err)
+	}
+	mailQueue := mailer.NewQueue(db, mail, logger)
+	mailQueue.Start()
 
 	app := &App{
 		DB:      db,
 		Router:  router,
 		Logger:  logger,
 		DataDir: cfg.DataDir,
+		Config:  cfg,
+		Mail:    mailQueue,
+		Version: "dev",
+		Started: time.Now(),
+
+		shutdown: newShutdownState(),
+		backups:  &backupRunner{},
+		archives: &archiveRunner{},
 	}
 
+	if cfg.AuthProvider == "ldap" {
+		app.Directory = directory.New(cfg)
+	}
+
+	// Cross-origin policies for the Assessment Tool and the browser UI
+	router.Use(app.corsPolicies()...)
+	router.Use(app.hsts()) // keep browsers on HTTPS when TLS is on
+
+	// Metrics that read the database and the data folder
+	app.registerMetrics()
+
 	// Initialize routes
 	app.initRoutes()
 
+	// Scheduled backups of the database and data folders
+	app.startBackupSchedule()
+
+	// Daily purge of the learners' submissions past the retention period
+	app.startRetentionSchedule()
+
 	return app
 }
@@ -0,0 +1,578 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ADS4/internal/archive"
+	"ADS4/internal/database"
+
+	"github.com/labstack/echo/v4"
+)
+
+/* Year-end archives
+   - an admin archives a closed year or semester from the Archive page. Every offering of the period must be
+     closed and nobody sitting an exam. The rows and exam files go into a signed records archive and the
+     learners' uploads into a signed submissions archive (see internal/archive), then the period is removed
+     from the live tables and the data folder. Its results stay searchable in the ArchivedResults index
+   - one archive or purge runs at a time, counted as a background job so a shutdown waits for it
+   - the retention period (RETENTION_YEARS) is applied daily and on demand: the submissions archives and the
+     live learners/<year> folders of the years past it are removed, with a report in the archive folder
+*/
+
+const (
+	retentionStartDelay = 10 * time.Minute
+	retentionInterval   = 24 * time.Hour
+)
+
+var errArchiveRunning = errors.New("an archive or purge is already running")
+
+// archiveRunner runs one archive or purge at a time and keeps the last purge report
+type archiveRunner struct {
+	mu      sync.Mutex
+	running bool
+	last    *purgeReport
+	signer  *archive.Signer
+
+	stop chan struct{}
+}
+
+// purgeReport is the outcome of applying the retention period, saved as purge-<time>.json
+type purgeReport struct {
+	Started        string          `json:"started"`
+	Finished       string          `json:"finished"`
+	By             string          `json:"by"`
+	RetentionYears int             `json:"retention_years"`
+	BeforeYear     int             `json:"before_year"` // the submissions of the years before were purged
+	Archives       []purgedArchive `json:"archives"`
+	Folders        []purgedFolder  `json:"folders"`
+	Errors         []string        `json:"errors"`
+	Report         string          `json:"report,omitempty"` // the report file, when something was purged
+}
+
+// purgedArchive is a submissions archive removed by the purge
+type purgedArchive struct {
+	ArchiveID int    `json:"archive_id"`
+	Period    string `json:"period"`
+	File      string `json:"file"`
+	Files     int    `json:"files"`
+	Bytes     int64  `json:"bytes"`
+}
+
+// purgedFolder is a live learners/<year> folder removed by the purge
+type purgedFolder struct {
+	Path  string `json:"path"`
+	Files int    `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+// claimArchive marks an archive or purge as running and counts it as a background job, until release is called
+func (a *App) claimArchive() (release func(), err error) {
+	a.archives.mu.Lock()
+	defer a.archives.mu.Unlock()
+	if a.archives.running {
+		return nil, errArchiveRunning
+	}
+	a.archives.running = true
+	done := a.startJob()
+	return func() {
+		a.archives.mu.Lock()
+		a.archives.running = false
+		a.archives.mu.Unlock()
+		done()
+	}, nil
+}
+
+// archiveSigner loads the archive key on first use
+func (a *App) archiveSigner() (*archive.Signer, error) {
+	a.archives.mu.Lock()
+	defer a.archives.mu.Unlock()
+	if a.archives.signer == nil {
+		signer, err := archive.LoadSigner(a.Config.ArchiveKey)
+		if err != nil {
+			return nil, err
+		}
+		a.archives.signer = signer
+	}
+	return a.archives.signer, nil
+}
+
+// archivePeriod writes the archives of a period checked with GetPeriodSummary, then removes the period from
+// the live tables and the data folder. The archives are removed again when the database step fails
+func (a *App) archivePeriod(period archive.Period, by string) (*database.Archive, error) {
+	signer, err := a.archiveSigner()
+	if err != nil {
+		return nil, fmt.Errorf("loading the archive key: %w", err)
+	}
+
+	tables, examIDs, err := a.DB.GetPeriodRecords(period.Year, period.Semester)
+	if err != nil {
+		return nil, fmt.Errorf("reading the records: %w", err)
+	}
+	examFiles := make([]string, len(examIDs))
+	for i, examid := range examIDs {
+		examFiles[i] = "exams/" + strings.Replace(examid, ".", "_", 1) + ".json"
+	}
+
+	records, err := archive.WriteRecords(a.Config.ArchiveDir, signer, period, a.Version, tables, a.DataDir, examFiles)
+	if err != nil {
+		return nil, fmt.Errorf("writing the records archive: %w", err)
+	}
+	submissions, err := archive.WriteSubmissions(a.Config.ArchiveDir, signer, period, a.Version, a.DataDir)
+	if err != nil {
+		archive.Remove(a.Config.ArchiveDir, records.Name)
+		return nil, fmt.Errorf("writing the submissions archive: %w", err)
+	}
+
+	row := &database.Archive{
+		Year:              period.Year,
+		Semester:          period.Semester,
+		RecordsFile:       records.Name,
+		RecordsSHA256:     records.SHA256,
+		SubmissionsFile:   submissions.Name,
+		SubmissionsSHA256: submissions.SHA256,
+		Offerings:         len(examIDs),
+		SubmissionFiles:   submissions.Files,
+		SubmissionBytes:   submissions.Bytes,
+		CreatedBy:         by,
+	}
+	if learnerexams, ok := tables["Learnerexams"].([]map[string]any); ok {
+		row.Results = len(learnerexams)
+	}
+	if err := a.DB.ArchivePeriod(row); err != nil {
+		archive.Remove(a.Config.ArchiveDir, records.Name)
+		archive.Remove(a.Config.ArchiveDir, submissions.Name)
+		return nil, fmt.Errorf("removing the period from the database: %w", err)
+	}
+
+	// the live files go once the archive is recorded, a file left behind is logged rather than failing
+	if err := os.RemoveAll(archive.SubmissionsDir(a.DataDir, period)); err != nil {
+		a.Logger.Error().Err(err).Str("period", period.String()).Msg("Error removing the archived submissions")
+	}
+	for _, file := range examFiles {
+		if err := os.Remove(filepath.Join(a.DataDir, filepath.FromSlash(file))); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			a.Logger.Error().Err(err).Str("file", file).Msg("Error removing an archived exam file")
+		}
+	}
+	return row, nil
+}
+
+// purgeSubmissions removes the submissions of the years past the retention period, claimed with
+// claimArchive. The report is saved in the archive folder when something was purged or failed
+func (a *App) purgeSubmissions(by string) *purgeReport {
+	now := time.Now()
+	report := &purgeReport{
+		Started:        now.Format("2006-01-02 15:04:05"),
+		By:             by,
+		RetentionYears: a.Config.RetentionYears,
+		BeforeYear:     now.Year() - a.Config.RetentionYears,
+		Archives:       []purgedArchive{},
+		Folders:        []purgedFolder{},
+		Errors:         []string{},
+	}
+	fail := func(format string, args ...any) {
+		report.Errors = append(report.Errors, fmt.Sprintf(format, args...))
+	}
+
+	archives, err := a.DB.GetArchivesToPurge(report.BeforeYear)
+	if err != nil {
+		fail("Reading the archives: %s", err)
+	}
+	for _, row := range archives {
+		if err := archive.Remove(a.Config.ArchiveDir, row.SubmissionsFile); err != nil {
+			fail("Removing %s: %s", row.SubmissionsFile, err)
+			continue
+		}
+		if err := a.DB.MarkSubmissionsPurged(row.ArchiveID); err != nil {
+			fail("Recording the purge of %s: %s", row.SubmissionsFile, err)
+			continue
+		}
+		report.Archives = append(report.Archives, purgedArchive{
+			ArchiveID: row.ArchiveID,
+			Period:    archive.Period{Year: row.Year, Semester: row.Semester}.String(),
+			File:      row.SubmissionsFile,
+			Files:     row.SubmissionFiles,
+			Bytes:     row.SubmissionBytes,
+		})
+	}
+
+	// years that were never archived still have their uploads in the data folder
+	learners := filepath.Join(a.DataDir, "learners")
+	entries, err := os.ReadDir(learners)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		fail("Reading the learners folder: %s", err)
+	}
+	for _, entry := range entries {
+		year, err := strconv.Atoi(entry.Name())
+		if err != nil || len(entry.Name()) != 4 || !entry.IsDir() || year >= report.BeforeYear {
+			continue
+		}
+		folder := purgedFolder{Path: filepath.Join(learners, entry.Name())}
+		filepath.WalkDir(folder.Path, func(_ string, d fs.DirEntry, err error) error {
+			if err == nil && d.Type().IsRegular() {
+				if info, err := d.Info(); err == nil {
+					folder.Files++
+					folder.Bytes += info.Size()
+				}
+			}
+			return nil
+		})
+		if err := os.RemoveAll(folder.Path); err != nil {
+			fail("Removing %s: %s", folder.Path, err)
+			continue
+		}
+		report.Folders = append(report.Folders, folder)
+	}
+
+	report.Finished = time.Now().Format("2006-01-02 15:04:05")
+	if len(report.Archives) > 0 || len(report.Folders) > 0 || len(report.Errors) > 0 {
+		name, err := archive.SaveReport(a.Config.ArchiveDir, report)
+		if err != nil {
+			a.Logger.Error().Err(err).Msg("Error saving the purge report")
+		}
+		report.Report = name
+	}
+
+	a.Logger.Info().
+		Str("by", by).
+		Int("before_year", report.BeforeYear).
+		Int("archives", len(report.Archives)).
+		Int("folders", len(report.Folders)).
+		Strs("errors", report.Errors).
+		Str("report", report.Report).
+		Msg("Retention period applied to the learners' submissions")
+	a.archives.mu.Lock()
+	a.archives.last = report
+	a.archives.mu.Unlock()
+	return report
+}
+
+// startRetentionSchedule applies the retention period daily in the background until stopRetentionSchedule
+func (a *App) startRetentionSchedule() {
+	if a.Config.RetentionYears == 0 {
+		return
+	}
+	a.archives.stop = make(chan struct{})
+
+	go func(stop chan struct{}) {
+		timer := time.NewTimer(retentionStartDelay)
+		defer timer.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-timer.C:
+			}
+			if a.Draining() {
+				return
+			}
+			if release, err := a.claimArchive(); err != nil {
+				a.Logger.Info().Msg("Scheduled purge skipped, an archive or purge is running")
+			} else {
+				a.purgeSubmissions("schedule")
+				release()
+			}
+			timer.Reset(retentionInterval)
+		}
+	}(a.archives.stop)
+}
+
+// stopRetentionSchedule ends the schedule, a purge that is running is a job the shutdown drain waits for
+func (a *App) stopRetentionSchedule() {
+	if a.archives.stop == nil {
+		return
+	}
+	close(a.archives.stop)
+	a.archives.stop = nil
+}
+
+// parsePeriod reads the year and the optional semester of a period
+func parsePeriod(yearValue, semester string) (archive.Period, bool) {
+	year, err := strconv.Atoi(yearValue)
+	if err != nil || year < 2000 || year > 9999 {
+		return archive.Period{}, false
+	}
+	switch semester {
+	case "", "S1", "S2", "S3":
+		return archive.Period{Year: year, Semester: semester}, true
+	}
+	return archive.Period{}, false
+}
+
+// GET /archive
+// HandleGetArchivePage renders the year-end archive page
+func (a *App) HandleGetArchivePage(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.Redirect(http.StatusSeeOther, "/admin?error=Method not allowed")
+	}
+
+	userid, role := currentUser(c)
+	return c.Render(http.StatusOK, "archive.html", map[string]interface{}{
+		"role":      role,
+		"user_id":   userid,
+		"username":  currentUsername(c),
+		"retention": a.Config.RetentionYears,
+	})
+}
+
+// GET /api/archive
+// HandleGetArchives lists the archives, newest first, with the retention period and the last purge report
+func (a *App) HandleGetArchives(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.JSON(http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+
+	archives, err := a.db(c).GetArchives()
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error listing the archives", err)
+	}
+
+	a.archives.mu.Lock()
+	defer a.archives.mu.Unlock()
+	return c.JSON(http.StatusOK, map[string]any{
+		"data":            archives,
+		"running":         a.archives.running,
+		"last_purge":      a.archives.last,
+		"retention_years": a.Config.RetentionYears,
+	})
+}
+
+// GET /api/archive/period?year=2025&semester=S1
+// HandleGetArchivePeriod counts the offerings and results of a period, and what stops it being archived
+func (a *App) HandleGetArchivePeriod(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.JSON(http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+
+	period, ok := parsePeriod(c.QueryParam("year"), c.QueryParam("semester"))
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "A year and an optional semester S1, S2 or S3 are required"})
+	}
+	summary, err := a.db(c).GetPeriodSummary(period.Year, period.Semester)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error reading the period", err)
+	}
+	return c.JSON(http.StatusOK, map[string]any{
+		"period":  period.String(),
+		"summary": summary,
+		"problem": periodProblem(summary),
+	})
+}
+
+// periodProblem explains why a period cannot be archived, empty when it can
+func periodProblem(summary *database.PeriodSummary) string {
+	switch {
+	case summary.Offerings == 0:
+		return "There are no offerings to archive in this period"
+	case summary.OpenOfferings > 0:
+		return fmt.Sprintf("%d offerings of this period are not closed", summary.OpenOfferings)
+	case summary.ActiveAttempts > 0:
+		return fmt.Sprintf("%d learners are sitting an exam of this period", summary.ActiveAttempts)
+	}
+	return ""
+}
+
+// POST /api/archive
+// HandlePostArchive archives a closed year or semester and removes it from the live tables
+func (a *App) HandlePostArchive(c echo.Context) error {
+	// Check if request if a POST request
+	if c.Request().Method != http.MethodPost {
+		return c.JSON(http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+
+	type ArchiveRequest struct {
+		Year     string `json:"year"`
+		Semester string `json:"semester"`
+	}
+	var req ArchiveRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	period, ok := parsePeriod(strings.TrimSpace(req.Year), req.Semester)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "A year and an optional semester S1, S2 or S3 are required"})
+	}
+	if a.Draining() {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "The service is shutting down"})
+	}
+
+	release, err := a.claimArchive()
+	if err != nil {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "An archive or purge is already running"})
+	}
+	defer release()
+
+	summary, err := a.db(c).GetPeriodSummary(period.Year, period.Semester)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error reading the period", err)
+	}
+	if problem := periodProblem(summary); problem != "" {
+		return c.JSON(http.StatusConflict, map[string]string{"error": problem})
+	}
+
+	start := time.Now()
+	row, err := a.archivePeriod(period, currentUsername(c))
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error archiving "+period.String(), err)
+	}
+
+	auditChange(c, "archive", period.String(), nil, func() any { return row })
+	auditDetail(c, "archive", fmt.Sprintf("%d offerings and %d results archived", row.Offerings, row.Results))
+	a.log(c).Info().
+		Str("period", period.String()).
+		Int("offerings", row.Offerings).
+		Int("results", row.Results).
+		Int("submissions", row.SubmissionFiles).
+		Dur("took", time.Since(start)).
+		Msg("Period archived")
+	return c.JSON(http.StatusOK, map[string]any{
+		"message": fmt.Sprintf("%s archived: %d offerings, %d results and %d submitted files", period, row.Offerings, row.Results, row.SubmissionFiles),
+		"data":    row,
+	})
+}
+
+// GET /api/archive/results?studentid=&coursecode=&year=&semester=&search=
+// HandleGetArchivedResults searches the archived results in the paging envelope
+func (a *App) HandleGetArchivedResults(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.JSON(http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+
+	filter := database.ArchivedResultFilter{
+		StudentID:  strings.TrimSpace(c.QueryParam("studentid")),
+		CourseCode: strings.TrimSpace(c.QueryParam("coursecode")),
+		Semester:   c.QueryParam("semester"),
+	}
+	if value := c.QueryParam("year"); value != "" {
+		year, err := strconv.Atoi(value)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "The year must be a number"})
+		}
+		filter.Year = year
+	}
+
+	opts := parseListOptions(c)
+	results, total, err := a.db(c).SearchArchivedResults(filter, opts)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error searching the archived results", err)
+	}
+	return c.JSON(http.StatusOK, newPagedResponse(c, results, total, opts))
+}
+
+// POST /api/archive/purge
+// HandlePostPurge applies the retention period now and returns the report
+func (a *App) HandlePostPurge(c echo.Context) error {
+	// Check if request if a POST request
+	if c.Request().Method != http.MethodPost {
+		return c.JSON(http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+
+	if a.Config.RetentionYears == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "There is no retention period, RETENTION_YEARS is 0"})
+	}
+	release, err := a.claimArchive()
+	if err != nil {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "An archive or purge is already running"})
+	}
+	defer release()
+
+	report := a.purgeSubmissions("admin " + currentUsername(c))
+	auditDetail(c, "purge", fmt.Sprintf("submissions before %d purged: %d archives and %d folders", report.BeforeYear, len(report.Archives), len(report.Folders)))
+	return c.JSON(http.StatusOK, map[string]any{
+		"message": fmt.Sprintf("%d archives and %d folders of submissions purged", len(report.Archives), len(report.Folders)),
+		"data":    report,
+	})
+}
+
+// GET /api/archive/:id/verify
+// HandleGetArchiveVerify checks the signatures and checksums of the archives of an archived period
+func (a *App) HandleGetArchiveVerify(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.JSON(http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid archive ID"})
+	}
+	row, err := a.db(c).GetArchiveByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Archive not found"})
+	}
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error reading the archive", err)
+	}
+
+	signer, err := a.archiveSigner()
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error loading the archive key", err)
+	}
+
+	check := func(name string) string {
+		if _, err := archive.Verify(a.Config.ArchiveDir, name, signer); err != nil {
+			return err.Error()
+		}
+		return "ok"
+	}
+	result := map[string]string{"records": check(row.RecordsFile)}
+	if row.SubmissionsPurgedAt != "" {
+		result["submissions"] = "purged " + row.SubmissionsPurgedAt
+	} else {
+		result["submissions"] = check(row.SubmissionsFile)
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// GET /api/archive/:id/:kind
+// HandleGetArchiveFile downloads the records or submissions archive of an archived period
+func (a *App) HandleGetArchiveFile(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.JSON(http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid archive ID"})
+	}
+	row, err := a.db(c).GetArchiveByID(id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Archive not found"})
+	}
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error reading the archive", err)
+	}
+	var name string
+	switch c.Param("kind") {
+	case archive.KindRecords:
+		name = row.RecordsFile
+	case archive.KindSubmissions:
+		name = row.SubmissionsFile
+	default:
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Unknown archive kind"})
+	}
+
+	path, err := archive.Path(a.Config.ArchiveDir, name)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if _, err := os.Stat(path); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "The archive file is not there, it may have been purged"})
+	}
+	a.handleLogger(c, "Archive "+name+" downloaded by "+currentUsername(c))
+	return c.Attachment(path, name)
+}
@@ -9,6 +9,8 @@ import (
 	"strings"
 	"time"
 
+	"ADS4/internal/metrics"
+
 	"github.com/labstack/echo/v4"
 )
 
@@ -23,7 +25,7 @@ func (a *App) HandleGetExamList(c echo.Context) error {
 
 	//retrieve the list of active exams for the current year only
 	currentyear := strconv.Itoa(time.Now().Year())
-	examOfferings, err := a.DB.GetActiveExams(currentyear)
+	examOfferings, err := a.db(c).GetActiveExams(currentyear)
 	if err != nil {
 		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
 	}
@@ -51,16 +53,17 @@ func (a *App) HandlePostExamUpload(c echo.Context) error {
 
 	//TODO do a time check to set the exam state to expired if the learner does not save within the time
 
-	pass, err := a.DB.GetExamPassword(examid, studentid)
+	pass, err := a.db(c).GetExamPassword(examid, studentid)
 	if pass == "" || pass != password || err != nil {
+		a.recordLoginFailure(c, ScopeExam, c.RealIP())
 		return c.JSON(http.StatusBadRequest, map[string]any{"Status": "Error"})
 	}
 
 	// check if the exam is still valid by checking the state and elapsed time
 	//return if the time has expired, we assume the exam was started
-	if a.DB.CheckIfTime(examid, studentid) == false {
+	if a.db(c).CheckIfTime(examid, studentid) == false {
 		//make sure the exam is recorded as expired and not closed then return
-		if a.DB.CloseLearnerExam(studentid, examid, true) != nil {
+		if a.db(c).CloseLearnerExam(studentid, examid, true) != nil {
 			return c.JSON(http.StatusBadRequest, map[string]any{"Status": "Error", "Message": "Unable to set the exam status"})
 		}
 		return c.JSON(http.StatusBadRequest, map[string]any{"Status": "Error", "Message": "Exam has expired"})
@@ -126,14 +129,17 @@ func (a *App) HandlePostExamUpload(c echo.Context) error {
 	}
 	defer dst.Close()
 
-	if _, err = io.Copy(dst, src); err != nil {
+	written, err := io.Copy(dst, src)
+	if err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]any{"Status": "Error", "Message": "Unable to write the exam file"})
 	}
+	metrics.ExamUploads.Inc()
+	metrics.ExamUploadBytes.Add(float64(written))
 
 	//close off the exam if need be
 	final := c.FormValue("final")
 	if final == "closed" {
-		a.DB.CloseLearnerExam(studentid, examid, false)
+		a.db(c).CloseLearnerExam(studentid, examid, false)
 	}
 	return c.JSON(http.StatusOK, map[string]any{"Status": "OK"})
 }
@@ -152,29 +158,36 @@ func (a *App) HandleGetStudentAuth(c echo.Context) error {
 	examid := c.Param("examid")
 	studentid := c.Param("studentid")
 
+	// every refusal gets the same answer so the student IDs and enrolments cannot be told apart
+	refuse := func(reason string) error {
+		a.recordLoginFailure(c, ScopeExam, c.RealIP())
+		a.handleLogger(c, "Exam authorisation refused for "+studentid+" "+examid+": "+reason)
+		return c.JSON(http.StatusBadRequest, map[string]any{"Status": "Error", "Message": "Unable to authorise the exam"})
+	}
+
 	//learner must exist and be an active learner in the system
-	if a.DB.IsLearnerValid(studentid) == false {
-		return c.JSON(http.StatusBadRequest, map[string]any{"Status": "Error", "Message": "Invalid or inactive student ID"})
+	if a.db(c).IsLearnerValid(studentid) == false {
+		return refuse("invalid or inactive student ID")
 	}
 
 	// chgeck if the exam is still open. A closed/expired exam cannot be authorised
-	if a.DB.CheckExamClosed(examid, studentid) {
+	if a.db(c).CheckExamClosed(examid, studentid) {
 		//make sure the exam is recorded as expired and not closed then return
-		//if a.DB.CloseLearnerExam(studentid, examid, true) != nil {
+		//if a.db(c).CloseLearnerExam(studentid, examid, true) != nil {
 		//	return c.JSON(http.StatusBadRequest, map[string]any{"Status": "Error", "Message": "Exam has expired, status set to expire"})
 		//}
-		return c.JSON(http.StatusBadRequest, map[string]any{"Status": "Error", "Message": "Exam has expired or been closed"})
+		return refuse("the exam has expired or been closed")
 	}
 
 	//check if the learner is allocated to the exam
-	password, err := a.DB.GetExamPassword(examid, studentid)
+	password, err := a.db(c).GetExamPassword(examid, studentid)
 	if password == "" || err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]any{"Status": "Error", "Message": "Unable to get an authorised password"})
+		return refuse("no authorised password")
 	}
 	//set the exam active and start time once the learner has bene authorised
-	err = a.DB.StartLearnerExam(studentid, examid)
+	err = a.db(c).StartLearnerExam(studentid, examid)
 	if err != nil {
-		return c.JSON(http.StatusBadRequest, map[string]any{"Status": "Error", "Message": "Unable to initiate the exam"})
+		return c.JSON(http.StatusBadRequest, map[string]any{"Status": "Error", "Message": "Unable to authorise the exam"})
 	}
 
 	return c.JSON(http.StatusOK, map[string]any{"Status": "OK", "examid": examid, "studentid": studentid, "password": password})
@@ -192,8 +205,9 @@ func (a *App) HandleGetStudentExam(c echo.Context) error {
 	}
 	examid := c.Param("examid")
 	password := c.Param("password")
-	isvalid := a.DB.IsExamActive(examid, password)
+	isvalid := a.db(c).IsExamActive(examid, password)
 	if isvalid == false {
+		a.recordLoginFailure(c, ScopeExam, c.RealIP())
 		return c.JSON(http.StatusBadRequest, map[string]any{"success": false, "Message": "Exam retrieval unauthorised"})
 	}
 	//read the entire exam file into memory - around 50KB of text
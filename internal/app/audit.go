@@ -0,0 +1,252 @@
+package app
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"ADS4/internal/database"
+
+	"github.com/labstack/echo/v4"
+)
+
+/* Audit trail
+   - AuditTrail records every POST, PUT and DELETE on the logged in routes, with the actor from the token,
+     the route, the response status and the client address, failed requests included
+   - handlers that change records describe the change with auditChange: the entity, its ID and a snapshot
+     from before the change. The snapshot after the change is loaded once the handler has succeeded, so
+     it shows what was stored e.g. a grade as saved for an academic board review
+   - passwords are never recorded, a password change is noted in the detail
+*/
+
+// auditRecord is the change a handler describes for the audit entry of its request
+type auditRecord struct {
+	action   string
+	entity   string
+	entityID string
+	detail   string
+	before   any
+	after    func() any // loads the state after the change, only called when the request succeeded
+}
+
+// auditChange describes the change made by the request, after may be nil e.g. for a delete
+func auditChange(c echo.Context, entity, entityID string, before any, after func() any) {
+	c.Set("audit", &auditRecord{entity: entity, entityID: entityID, before: before, after: after})
+}
+
+// auditDetail adds a note to the audit entry of the request, e.g. that a password was changed
+func auditDetail(c echo.Context, action, detail string) {
+	record, ok := c.Get("audit").(*auditRecord)
+	if !ok {
+		record = &auditRecord{}
+		c.Set("audit", record)
+	}
+	if action != "" {
+		record.action = action
+	}
+	record.detail = detail
+}
+
+// auditActions maps the request methods to the audit actions
+var auditActions = map[string]string{
+	http.MethodPost:   "create",
+	http.MethodPut:    "update",
+	http.MethodPatch:  "update",
+	http.MethodDelete: "delete",
+}
+
+// AuditTrail middleware records an audit entry for every request that changes data
+func (a *App) AuditTrail(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		action, mutating := auditActions[c.Request().Method]
+		if !mutating {
+			return next(c)
+		}
+
+		err := next(c)
+
+		status := c.Response().Status
+		if err != nil {
+			if he, ok := err.(*echo.HTTPError); ok {
+				status = he.Code
+			} else {
+				status = http.StatusInternalServerError
+			}
+		}
+
+		userid, role := currentUser(c)
+		entry := &database.AuditEntry{
+			ActorID: userid,
+			Actor:   currentUsername(c),
+			Role:    role,
+			Action:  action,
+			Method:  c.Request().Method,
+			Path:    c.Request().URL.Path,
+			Status:  status,
+			IP:      c.RealIP(),
+		}
+		entry.Entity, entry.EntityID = routeEntity(c)
+
+		if record, ok := c.Get("audit").(*auditRecord); ok {
+			if record.action != "" {
+				entry.Action = record.action
+			}
+			if record.entity != "" {
+				entry.Entity, entry.EntityID = record.entity, record.entityID
+			}
+			entry.Detail = record.detail
+			entry.Before = auditJSON(record.before)
+			if record.after != nil && status < http.StatusBadRequest {
+				entry.After = auditJSON(record.after())
+			}
+		}
+
+		if dberr := a.db(c).CreateAuditEntry(entry); dberr != nil {
+			a.handleLogger(c, "Error writing the audit entry: "+dberr.Error())
+		}
+		return err
+	}
+}
+
+// routeEntity names the entity of a request from its route e.g. /api/learner/:studentid gives learner and the student ID
+func routeEntity(c echo.Context) (string, string) {
+	var entity string
+	for _, part := range strings.Split(strings.Trim(c.Path(), "/"), "/") {
+		if part != "" && part != "api" && !strings.HasPrefix(part, ":") {
+			entity = part
+			break
+		}
+	}
+	var ids []string
+	for _, name := range c.ParamNames() {
+		ids = append(ids, c.Param(name))
+	}
+	return entity, strings.Join(ids, "/")
+}
+
+// auditJSON encodes a snapshot for the audit trail, nil gives an empty string
+func auditJSON(value any) string {
+	if value == nil {
+		return ""
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// parseAuditFilter reads the audit filters from the query string, the dates are YYYY-MM-DD
+func parseAuditFilter(c echo.Context) (database.AuditFilter, error) {
+	filter := database.AuditFilter{
+		Actor:    strings.TrimSpace(c.QueryParam("actor")),
+		Action:   c.QueryParam("action"),
+		Entity:   c.QueryParam("entity"),
+		EntityID: strings.TrimSpace(c.QueryParam("entity_id")),
+		From:     c.QueryParam("from"),
+		To:       c.QueryParam("to"),
+	}
+	for _, date := range []string{filter.From, filter.To} {
+		if date == "" {
+			continue
+		}
+		if _, err := time.Parse(time.DateOnly, date); err != nil {
+			return filter, err
+		}
+	}
+	return filter, nil
+}
+
+// HandleGetAuditPage serves the audit trail page
+func (a *App) HandleGetAuditPage(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.Redirect(http.StatusSeeOther, "/admin?error=Method not allowed")
+	}
+
+	userid, role := currentUser(c)
+	return c.Render(http.StatusOK, "audit.html", map[string]interface{}{
+		"role":     role,
+		"user_id":  userid,
+		"username": currentUsername(c),
+	})
+}
+
+// HandleGetAudit fetches a page of the audit trail as JSON in the paging envelope. Filters are
+// ?actor, ?action, ?entity, ?entity_id and the ?from and ?to dates
+func (a *App) HandleGetAudit(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.Redirect(http.StatusSeeOther, "/admin?error=Method not allowed")
+	}
+
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Dates must be given as YYYY-MM-DD"})
+	}
+	opts := parseListOptions(c)
+
+	entries, total, err := a.db(c).GetAuditEntries(filter, opts)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
+	}
+
+	// Return the results as JSON
+	return c.JSON(http.StatusOK, newPagedResponse(c, entries, total, opts))
+}
+
+// HandleGetAuditExport downloads the audit entries matching the filters as CSV
+func (a *App) HandleGetAuditExport(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.Redirect(http.StatusSeeOther, "/admin?error=Method not allowed")
+	}
+
+	filter, err := parseAuditFilter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Dates must be given as YYYY-MM-DD"})
+	}
+
+	filename := "ads4-audit-" + time.Now().Format("20060102-150405") + ".csv"
+	c.Response().Header().Set(echo.HeaderContentType, "text/csv; charset=utf-8")
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+	c.Response().WriteHeader(http.StatusOK)
+
+	if err := a.writeAuditCSV(c.Response(), filter); err != nil {
+		// the header has been sent, the error can only be logged
+		a.handleLogger(c, "Error exporting the audit trail: "+err.Error())
+	}
+	a.handleLogger(c, "Audit trail exported by "+currentUsername(c))
+	return nil
+}
+
+// writeAuditCSV writes the audit entries matching the filters as CSV, used by the download and ads admin export
+func (a *App) writeAuditCSV(out io.Writer, filter database.AuditFilter) error {
+	w := csv.NewWriter(out)
+	w.Write([]string{"AuditID", "CreatedAt", "ActorID", "Actor", "Role", "Action", "Entity", "EntityID",
+		"Detail", "Before", "After", "Method", "Path", "Status", "IP"})
+	err := a.DB.ExportAuditEntries(filter, func(entry *database.AuditEntry) error {
+		return w.Write([]string{
+			strconv.Itoa(entry.AuditID), entry.CreatedAt, strconv.Itoa(entry.ActorID), csvSafe(entry.Actor), entry.Role,
+			entry.Action, entry.Entity, csvSafe(entry.EntityID), csvSafe(entry.Detail), csvSafe(entry.Before),
+			csvSafe(entry.After), entry.Method, csvSafe(entry.Path), strconv.Itoa(entry.Status), entry.IP,
+		})
+	})
+	w.Flush()
+	if err != nil {
+		return err
+	}
+	return w.Error()
+}
+
+// csvSafe stops a spreadsheet from running a cell as a formula, e.g. a username starting with =
+func csvSafe(value string) string {
+	if value != "" && strings.ContainsRune("=+-@", rune(value[0])) {
+		return "'" + value
+	}
+	return value
+}
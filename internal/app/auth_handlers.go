@@ -1,20 +1,24 @@
 package app
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strconv"
 	"time"
 
-	"ADS4/internal/config"
 	"ADS4/internal/models"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/labstack/echo/v4"
-	"github.com/sethvargo/go-password/password"
 	"golang.org/x/crypto/bcrypt"
-	gomail "gopkg.in/mail.v2"
 )
 
 // CustomClaims represents JWT custom claims
@@ -27,7 +31,8 @@ type CustomClaims struct {
 	jwt.RegisteredClaims
 }
 
-// HandlePostForgotPassword handles the forgot password form submission
+// HandlePostForgotPassword handles the forgot password form submission. A single use reset link is emailed
+// to the account, the password itself is not changed until the link is used
 func (a *App) HandlePostForgotPassword(c echo.Context) error {
 	// Check if request if a GET request
 	if c.Request().Method != http.MethodPost {
@@ -38,47 +43,113 @@ func (a *App) HandlePostForgotPassword(c echo.Context) error {
 
 	email := c.FormValue("email")
 
-	// Check if the email exists in the database
-	user, err := a.DB.GetUserByEmail(email)
+	// the same response is given whether or not the email is registered so accounts cannot be discovered
+	message := "If the email belongs to an active account a password reset link has been sent to it"
+
+	// Check if the email exists in the database and the user account is active. Directory accounts
+	// change their password in the directory so they are not sent a link
+	user, err := a.db(c).GetUserByEmail(email)
+	if err != nil || !a.db(c).IsUserActive(user.UserID) || user.AuthSource == "ldap" {
+		return c.Redirect(http.StatusSeeOther, "/?message="+url.QueryEscape(message))
+	}
+
+	// Generate the reset token, only its hash is stored
+	token, err := newResetToken()
 	if err != nil {
-		return c.Redirect(http.StatusSeeOther, "/forgot-password?error=Email%20not%20found")
+		a.handleLogger(c, "Error generating password reset token: "+err.Error())
+		return c.Redirect(http.StatusSeeOther, "/forgot-password?error=Could%20not%20create%20a%20reset%20link")
 	}
 
-	// Check if the user account is active
-	active := a.DB.IsUserActive(user.UserID)
-	if active != true {
-		return c.Redirect(http.StatusSeeOther, "/forgot-password?error=Inactive%20user%20account")
+	if err := a.db(c).CreatePasswordReset(user.UserID, hashResetToken(token), time.Now().Add(resetTokenTTL)); err != nil {
+		a.handleLogger(c, "Error saving password reset token: "+err.Error())
+		return c.Redirect(http.StatusSeeOther, "/forgot-password?error=Could%20not%20create%20a%20reset%20link")
 	}
 
-	// Generate a new password
-	newPassword, err := password.Generate(10, 8, 2, false, false)
+	// Send the reset link to the user's email, built from the configured address and never the request
+	// headers, which the client controls
+	link := a.Config.PublicURL + "/reset-password?token=" + url.QueryEscape(token)
+	err = a.Mail.Send("password_reset", email, map[string]string{
+		"Username": user.Username,
+		"Link":     link,
+		"Expires":  resetTokenTTL.String(),
+	})
 	if err != nil {
-		return c.Redirect(http.StatusSeeOther, "/forgot-password?error=Could%20not%20generate%20password")
+		a.handleLogger(c, "Could not queue the password reset email to "+email+": "+err.Error())
 	}
 
-	// Hash the new password
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), bcrypt.DefaultCost)
-	if err != nil {
-		return err
+	return c.Redirect(http.StatusSeeOther, "/?message="+url.QueryEscape(message))
+}
+
+// HandleGetResetPassword serves the form to set a new password from a reset link
+func (a *App) HandleGetResetPassword(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.Render(http.StatusMethodNotAllowed, "index.html", map[string]interface{}{
+			"error": "Method not allowed",
+		})
 	}
 
-	// Update the user's password
-	if err := a.DB.UpdatePassword(user.UserID, string(hashedPassword)); err != nil {
-		return c.Redirect(http.StatusSeeOther, "/forgot-password?error=Could%20not%20update%20password")
+	token := c.QueryParam("token")
+	if token == "" || !a.db(c).IsPasswordResetValid(hashResetToken(token)) {
+		return c.Redirect(http.StatusSeeOther, "/forgot-password?error=The%20reset%20link%20is%20invalid%20or%20has%20expired")
 	}
 
-	emailmessage := fmt.Sprintf("Could not send email but Your new password is: %s", newPassword)
+	return c.Render(http.StatusOK, "reset_password.html", map[string]interface{}{
+		"token": token,
+	})
+}
+
+// HandlePostResetPassword sets the new password and uses up the reset token. Existing logins
+// for the account stop working as they were issued before the password change
+func (a *App) HandlePostResetPassword(c echo.Context) error {
+	// Check if request if a POST request
+	if c.Request().Method != http.MethodPost {
+		return c.Render(http.StatusMethodNotAllowed, "index.html", map[string]interface{}{
+			"error": "Method not allowed",
+		})
+	}
+
+	token := c.FormValue("token")
+	password := c.FormValue("password")
+	confirmpassword := c.FormValue("confirm-password")
+
+	// Validate password confirmation and policy
+	if password != confirmpassword {
+		return c.Render(http.StatusOK, "reset_password.html", map[string]interface{}{
+			"token": token,
+			"error": "Passwords do not match",
+		})
+	}
+	if err := validatePassword(password); err != nil {
+		return c.Render(http.StatusOK, "reset_password.html", map[string]interface{}{
+			"token": token,
+			"error": err.Error(),
+		})
+	}
 
-	a.handleLogger(emailmessage)
+	// Hash the password
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return c.Render(http.StatusOK, "reset_password.html", map[string]interface{}{
+			"token": token,
+			"error": "Could not hash password",
+		})
+	}
 
-	// Send the new password to the user's email
-	if err := sendPasswordResetEmail(email, user.Username, newPassword); err != nil {
-		return c.Redirect(http.StatusSeeOther, "/?message="+emailmessage)
+	userid, err := a.db(c).ResetPasswordWithToken(hashResetToken(token), string(hashedPassword))
+	if err == sql.ErrNoRows {
+		return c.Redirect(http.StatusSeeOther, "/forgot-password?error=The%20reset%20link%20is%20invalid%20or%20has%20expired")
+	}
+	if err != nil {
+		a.handleLogger(c, "Error resetting password: "+err.Error())
+		return c.Render(http.StatusOK, "reset_password.html", map[string]interface{}{
+			"token": token,
+			"error": "Could not update password",
+		})
 	}
-	message := fmt.Sprintf("Password reset successful. Check your %s for the new password.", email)
 
-	// Render the login page with a success message
-	return c.Redirect(http.StatusSeeOther, "/?message="+message)
+	a.handleLogger(c, "Password reset for user ID "+strconv.Itoa(userid))
+	return c.Redirect(http.StatusSeeOther, "/logout?message=Password updated. Please log in with your new password")
 }
 
 // HandlePostRegister handles the register form submission
@@ -119,6 +190,13 @@ func (a *App) HandlePostRegister(c echo.Context) error {
 
 	}
 
+	// Registration can be restricted to the organisation's email domains
+	if !a.isAllowedEmailDomain(email) {
+		return c.Render(http.StatusOK, "register.html", map[string]interface{}{
+			"error": "Registration is not open to this email domain",
+		})
+	}
+
 	// Validate password confirmation
 	if password != confirmpassword {
 		return c.Render(http.StatusOK, "register.html", map[string]interface{}{
@@ -127,15 +205,9 @@ func (a *App) HandlePostRegister(c echo.Context) error {
 	}
 
 	// Validate password
-	//TODO Change the passoword length to 12 characters and remove special character filter
-	passwordLengthRegex := regexp.MustCompile(`.{8,}`)
-	passwordDigitRegex := regexp.MustCompile(`[0-9]`)
-	passwordSpecialCharRegex := regexp.MustCompile(`[!@#$%^&*]`)
-	passwordCapitalLetterRegex := regexp.MustCompile(`[A-Z]`)
-
-	if !passwordLengthRegex.MatchString(password) || !passwordDigitRegex.MatchString(password) || !passwordSpecialCharRegex.MatchString(password) || !passwordCapitalLetterRegex.MatchString(password) {
+	if err := validatePassword(password); err != nil {
 		return c.Render(http.StatusOK, "register.html", map[string]interface{}{
-			"error": "Password must contain at least one number, one special character, one capital letter, and be at least 8 characters long",
+			"error": err.Error(),
 		})
 	}
 
@@ -148,35 +220,34 @@ func (a *App) HandlePostRegister(c echo.Context) error {
 	}
 
 	// Check if the user or email already exists and are active
-	if _, err := a.DB.GetUserByUsername(username); err == nil {
+	if _, err := a.db(c).GetUserByUsername(username); err == nil {
 		return c.Render(http.StatusOK, "register.html", map[string]interface{}{
 			"error": "Username already exists",
 		})
 	}
 
-	if _, err := a.DB.GetUserByEmail(email); err == nil {
+	if _, err := a.db(c).GetUserByEmail(email); err == nil {
 		return c.Render(http.StatusOK, "register.html", map[string]interface{}{
 			"error": "Email already exists",
 		})
 	}
 
-	// Create a new user
+	// Create a new user in the pending queue, an admin approves the account with a role
 	user := models.User{
 		Username: username,
 		Email:    email,
-		Role:     "",
 		Password: string(hashedPassword),
 	}
 
-	if err := a.DB.CreateUser(&user); err != nil {
+	if err := a.db(c).RegisterUser(&user); err != nil {
+		a.handleLogger(c, "Error registering user: "+err.Error())
 		return c.Render(http.StatusOK, "register.html", map[string]interface{}{
 			"error": "Could not create user",
 		})
 	}
 
 	// Generate a success message
-	// Maybe send a welcome email here
-	message := fmt.Sprintf("Registration successful. Please login with your username: %s", username)
+	message := fmt.Sprintf("Registration received for %s. You will be emailed once an administrator has reviewed your account", username)
 	return c.Redirect(http.StatusSeeOther, "/?message="+message)
 }
 
@@ -193,7 +264,7 @@ func (a *App) HandleGetLogin(c echo.Context) error {
 	cookie, err := c.Cookie("token")
 	if err == nil && cookie.Value != "" {
 		// Parse the JWT token
-		token, err := parseToken(cookie.Value)
+		token, err := a.parseToken(cookie.Value)
 		if err == nil && token.Valid {
 			if claims, ok := token.Claims.(*CustomClaims); ok {
 				// Put the claims data in the context
@@ -225,34 +296,96 @@ func (a *App) HandlePostLogin(c echo.Context) error {
 	username := c.FormValue("username")
 	password := c.FormValue("password")
 	remember := c.FormValue("remember")
+	ip := c.RealIP()
 
-	// Validate the user's credentials
-	user, err := a.DB.GetUserByUsername(username)
-	if err != nil || bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+	// Locked out accounts and addresses are refused before the password is checked
+	wait := max(a.lockoutRemaining(c, ScopeAccount, username), a.lockoutRemaining(c, ScopeIP, ip))
+	if wait > 0 {
+		return c.Render(http.StatusTooManyRequests, "index.html", map[string]interface{}{
+			"error": lockoutMessage(wait),
+		})
+	}
+
+	// Validate the user's credentials, unknown usernames are counted too so they cannot be told apart
+	user, err := a.authenticate(c, username, password)
+	if err != nil {
+		message := "Invalid username or password"
+		switch err {
+		case errDirectoryUnavailable:
+			message = err.Error()
+		case errNotStaffGroup:
+			message = err.Error()
+			fallthrough
+		default:
+			a.recordLoginFailure(c, ScopeAccount, username)
+			a.recordLoginFailure(c, ScopeIP, ip)
+		}
+		return c.Render(http.StatusOK, "index.html", map[string]interface{}{
+			"error": message,
+		})
+	}
+	// Self-registered accounts cannot log in until they have been approved
+	switch user.Approval {
+	case "pending":
+		return c.Render(http.StatusOK, "index.html", map[string]interface{}{
+			"error": "Your registration is awaiting approval",
+		})
+	case "rejected":
 		return c.Render(http.StatusOK, "index.html", map[string]interface{}{
-			"error": "Invalid username or password",
+			"error": "Your registration was rejected: " + user.RejectReason,
 		})
 	}
+
 	// Check if the user account is active
-	active := a.DB.IsUserActive(user.UserID)
+	active := a.db(c).IsUserActive(user.UserID)
 	if active != true {
 		return c.Render(http.StatusOK, "index.html", map[string]interface{}{
 			"error": "Inactive user account",
 		})
 	}
 
+	// Staff accounts with a second factor, or required to enrol one, finish the login on the second step
+	if user.Role != RoleLearner {
+		tf, err := a.db(c).GetTwoFactor(user.UserID)
+		if err != nil {
+			a.handleLogger(c, "Error reading second factor: "+err.Error())
+			return c.Render(http.StatusOK, "index.html", map[string]interface{}{
+				"error": "Could not load the second factor",
+			})
+		}
+		if tf.Enabled || tf.Required {
+			return a.startSecondFactor(c, user, remember == "on")
+		}
+	}
+
+	a.clearLoginFailures(c, ScopeAccount, username)
+	if err := a.setLoginCookie(c, user, remember == "on"); err != nil {
+		return c.Render(http.StatusOK, "index.html", map[string]interface{}{
+			"error": "Could not generate token",
+		})
+	}
+
+	return c.Redirect(http.StatusFound, "/dashboard")
+}
+
+// setLoginCookie starts a server side session, generates the login token for it and sets it as the token cookie
+func (a *App) setLoginCookie(c echo.Context, user *models.User, remember bool) error {
 	// Determine expiration time based on "remember" checkbox
 	expiresAt := time.Now().Add(72 * time.Hour) // Default expiration time is 3 days
-	if remember == "on" {
+	if remember {
 		expiresAt = time.Now().Add(30 * 24 * time.Hour)
 	}
 
+	// The token is only accepted while its session exists
+	sessionID, err := a.startSession(c, user, expiresAt)
+	if err != nil {
+		return err
+	}
+
 	// Generate token
-	token, err := GenerateToken(user, expiresAt)
+	token, err := a.GenerateToken(user, sessionID, expiresAt)
 	if err != nil {
-		return c.Render(http.StatusOK, "index.html", map[string]interface{}{
-			"error": "Could not generate token",
-		})
+		return err
 	}
 
 	// Set the token as a cookie
@@ -262,15 +395,13 @@ func (a *App) HandlePostLogin(c echo.Context) error {
 		Expires:  expiresAt,
 		Path:     "/",
 		HttpOnly: true,
-		Secure:   false, // Set to true if using HTTPS
+		Secure:   a.Config.TLSEnabled(), // HTTPS only cookies when TLS is on
 		SameSite: http.SameSiteStrictMode,
 	}
 	c.SetCookie(cookie)
 
 	c.Set("user", token)
-
-	// Return the token in the response as well
-	return c.Redirect(http.StatusFound, "/dashboard")
+	return nil
 }
 
 // HandleGetLogout logs the user out
@@ -284,6 +415,9 @@ func (a *App) HandleGetLogout(c echo.Context) error {
 		})
 	}
 
+	// End the server side session so the token cannot be used again
+	a.endSession(c)
+
 	// Clear JWT or session cookie
 	cookie := &http.Cookie{
 		Name:     "token",
@@ -291,7 +425,7 @@ func (a *App) HandleGetLogout(c echo.Context) error {
 		Expires:  time.Now().Add(-time.Hour),
 		Path:     "/",
 		HttpOnly: true,
-		Secure:   false, // Set to true if using HTTPS
+		Secure:   a.Config.TLSEnabled(), // HTTPS only cookies when TLS is on
 		SameSite: http.SameSiteStrictMode,
 	}
 	c.SetCookie(cookie)
@@ -305,8 +439,8 @@ func (a *App) HandleGetLogout(c echo.Context) error {
 	return c.Redirect(http.StatusSeeOther, "/?message="+message)
 }
 
-// GenerateToken generates a JWT token
-func GenerateToken(user *models.User, expiresAt time.Time) (string, error) {
+// GenerateToken generates a JWT token for the session, the session ID is the token ID (jti) claim
+func (a *App) GenerateToken(user *models.User, sessionID string, expiresAt time.Time) (string, error) {
 	claims := &CustomClaims{
 		UserID:       strconv.Itoa(user.UserID),
 		Email:        user.Email,
@@ -314,18 +448,19 @@ func GenerateToken(user *models.User, expiresAt time.Time) (string, error) {
 		Role:         user.Role,
 		DefaultAdmin: user.DefaultAdmin,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        sessionID,
 			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	secret := config.LoadConfig().JWTSecret
-	return token.SignedString([]byte(secret))
+	return token.SignedString([]byte(a.Config.JWTSecret))
 }
 
 // parseToken parses and validates the JWT token
-func parseToken(tokenString string) (*jwt.Token, error) {
-	secret := config.LoadConfig().JWTSecret
+func (a *App) parseToken(tokenString string) (*jwt.Token, error) {
+	secret := a.Config.JWTSecret
 	return jwt.ParseWithClaims(tokenString, &CustomClaims{}, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
@@ -334,25 +469,34 @@ func parseToken(tokenString string) (*jwt.Token, error) {
 	})
 }
 
-// sendPasswordResetEmail sends a password reset email
-// TODO update the from/sender email and attach to config. Fix SMTP credentials
-func sendPasswordResetEmail(email, username, newPassword string) error {
-	m := gomail.NewMessage()
-	m.Reset()
-	m.SetHeader("From", "MS_kAX6BH@trial-jpzkmgqev0vg059v.mlsender.net")
-	m.SetHeader("To", email)
-	m.SetHeader("Subject", "ADS4 PASSWORD RESET")
-	m.SetBody("text/plain", "Your Username is "+username+", Your new password is: "+newPassword)
-	m.AddAlternative("text/html", `<html><body style="font-family: Arial, sans-serif; padding: 20px;">
-		<h2 style="color: #333;">ADS4 PASSWORD RESET</h2>
-		<p style="margin-top: 20px;">Your Username is <strong>`+username+`</strong></p>
-		<p>Your new password is: <strong>`+newPassword+`</strong></p>
-	</body></html>`)
-
-	d := gomail.NewDialer("smtp.gmail.com", 587, "ADS44758@gmail.com", "dksz kwoc zjiv cqub")
-
-	fmt.Println("Sending email to", email)
-	fmt.Println("Username:", username)
-	fmt.Println("New Password:", newPassword)
-	return d.DialAndSend(m)
+// resetTokenTTL is how long a password reset link stays valid
+const resetTokenTTL = time.Hour
+
+// newResetToken returns a random URL safe reset token
+func newResetToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashResetToken returns the hex SHA-256 hash of a reset token as stored in the database
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// validatePassword applies the password policy used for registration, resets and account updates
+func validatePassword(password string) error {
+	//TODO Change the passoword length to 12 characters and remove special character filter
+	passwordLengthRegex := regexp.MustCompile(`.{8,}`)
+	passwordDigitRegex := regexp.MustCompile(`[0-9]`)
+	passwordSpecialCharRegex := regexp.MustCompile(`[!@#$%^&*]`)
+	passwordCapitalLetterRegex := regexp.MustCompile(`[A-Z]`)
+
+	if !passwordLengthRegex.MatchString(password) || !passwordDigitRegex.MatchString(password) || !passwordSpecialCharRegex.MatchString(password) || !passwordCapitalLetterRegex.MatchString(password) {
+		return errors.New("Password must contain at least one number, one special character, one capital letter, and be at least 8 characters long")
+	}
+	return nil
 }
@@ -0,0 +1,205 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"ADS4/internal/backup"
+
+	"github.com/labstack/echo/v4"
+)
+
+/* Backups
+   - made on a schedule (BACKUP_INTERVAL_HOURS) and on demand by an admin, one at a time and counted as a
+     background job so a shutdown waits for it. See internal/backup for the content of a backup
+   - the schedule picks up from the newest backup, a restart does not delay or repeat a backup
+   - admins can list and download the backups, restoring is done with the service stopped: ads restore
+   - only the SQLite database can be copied online, PostgreSQL installs use their own tooling
+*/
+
+// the first scheduled backup waits at least this long after the start
+const backupStartDelay = time.Minute
+
+var errBackupRunning = errors.New("a backup is already running")
+
+// backupRunner runs one backup at a time and keeps the outcome of the last one
+type backupRunner struct {
+	mu      sync.Mutex
+	running bool
+	last    backupResult
+
+	stop chan struct{}
+}
+
+// backupResult is the outcome of a backup
+type backupResult struct {
+	Name     string `json:"name,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	Finished string `json:"finished,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// backupsSupported reports if the database can be backed up online
+func (a *App) backupsSupported() bool {
+	return a.Config.DBtype == "sqlite"
+}
+
+// claimBackup marks a backup as running and counts it as a background job, until release is called
+func (a *App) claimBackup() (release func(), err error) {
+	a.backups.mu.Lock()
+	defer a.backups.mu.Unlock()
+	if a.backups.running {
+		return nil, errBackupRunning
+	}
+	a.backups.running = true
+	return a.startJob(), nil
+}
+
+// runBackup makes a backup claimed with claimBackup and removes the oldest ones past BACKUP_KEEP
+func (a *App) runBackup(reason string, release func()) error {
+	defer release()
+
+	start := time.Now()
+	name, err := backup.Create(a.DB.BackupTo, a.DataDir, a.Config.DBName, a.Config.BackupDir, a.Version)
+	result := backupResult{Name: name, Reason: reason, Finished: time.Now().Format("2006-01-02 15:04:05")}
+	if err != nil {
+		result.Error = err.Error()
+		a.Logger.Error().Err(err).Str("reason", reason).Msg("Backup failed")
+	} else {
+		a.Logger.Info().Str("backup", name).Str("reason", reason).Dur("took", time.Since(start)).Msg("Backup made")
+		removed, err := backup.Prune(a.Config.BackupDir, a.Config.BackupKeep)
+		if err != nil {
+			a.Logger.Error().Err(err).Msg("Error removing old backups")
+		}
+		for _, old := range removed {
+			a.Logger.Info().Str("backup", old).Msg("Old backup removed")
+		}
+	}
+
+	a.backups.mu.Lock()
+	a.backups.running = false
+	a.backups.last = result
+	a.backups.mu.Unlock()
+	return err
+}
+
+// startBackupSchedule runs the scheduled backups in the background until stopBackupSchedule
+func (a *App) startBackupSchedule() {
+	if a.Config.BackupInterval == 0 || !a.backupsSupported() {
+		return
+	}
+	a.backups.stop = make(chan struct{})
+
+	go func(stop chan struct{}) {
+		timer := time.NewTimer(a.nextBackupWait())
+		defer timer.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-timer.C:
+			}
+			if a.Draining() {
+				return
+			}
+			if release, err := a.claimBackup(); err != nil {
+				a.Logger.Info().Msg("Scheduled backup skipped, a backup is already running")
+			} else {
+				a.runBackup("schedule", release)
+			}
+			timer.Reset(a.Config.BackupInterval)
+		}
+	}(a.backups.stop)
+}
+
+// stopBackupSchedule ends the schedule, a backup that is running is a job the shutdown drain waits for
+func (a *App) stopBackupSchedule() {
+	if a.backups.stop == nil {
+		return
+	}
+	close(a.backups.stop)
+	a.backups.stop = nil
+}
+
+// nextBackupWait is the time until the next scheduled backup, counted from the newest backup
+func (a *App) nextBackupWait() time.Duration {
+	wait := a.Config.BackupInterval
+	if backups, err := backup.List(a.Config.BackupDir); err == nil && len(backups) > 0 {
+		if newest, err := time.ParseInLocation("2006-01-02 15:04:05", backups[0].Created, time.Local); err == nil {
+			wait = time.Until(newest.Add(a.Config.BackupInterval))
+		}
+	}
+	return max(wait, backupStartDelay)
+}
+
+// GET /api/backup
+// HandleGetBackups lists the backups, newest first, with the outcome of the last backup
+func (a *App) HandleGetBackups(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.JSON(http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+
+	backups, err := backup.List(a.Config.BackupDir)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error listing the backups", err)
+	}
+
+	a.backups.mu.Lock()
+	defer a.backups.mu.Unlock()
+	return c.JSON(http.StatusOK, map[string]any{
+		"data":      backups,
+		"running":   a.backups.running,
+		"last":      a.backups.last,
+		"supported": a.backupsSupported(),
+		"interval":  a.Config.BackupInterval.String(),
+		"keep":      a.Config.BackupKeep,
+	})
+}
+
+// POST /api/backup
+// HandlePostBackup starts a backup in the background, its outcome is listed by GET /api/backup
+func (a *App) HandlePostBackup(c echo.Context) error {
+	// Check if request if a POST request
+	if c.Request().Method != http.MethodPost {
+		return c.JSON(http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+
+	if !a.backupsSupported() {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Online backups need the SQLite database, back up PostgreSQL with its own tools"})
+	}
+	if a.Draining() {
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "The service is shutting down"})
+	}
+
+	// claimed before answering so a shutdown straight after waits for the backup
+	release, err := a.claimBackup()
+	if err != nil {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "A backup is already running"})
+	}
+	go a.runBackup("admin "+currentUsername(c), release)
+	auditDetail(c, "backup", "backup requested")
+	return c.JSON(http.StatusAccepted, map[string]string{"message": "The backup has started"})
+}
+
+// GET /api/backup/:name
+// HandleGetBackupFile downloads a backup
+func (a *App) HandleGetBackupFile(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.JSON(http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+
+	path, err := backup.Path(a.Config.BackupDir, c.Param("name"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if _, err := os.Stat(path); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Backup not found"})
+	}
+	a.handleLogger(c, "Backup "+c.Param("name")+" downloaded by "+currentUsername(c))
+	return c.Attachment(path, c.Param("name"))
+}
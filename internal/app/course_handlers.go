@@ -19,8 +19,8 @@ import (
    - status - active,closed
 */
 
-// HandleGetAllCourses fetches all active exams from the database with optional filtering by status code
-// and returns the results as JSON
+// HandleGetAllCourses fetches a page of courses from the database with optional filtering by course code and status code
+// and returns the results as JSON in the paging envelope
 func (a *App) HandleGetAllCourses(c echo.Context) error {
 	// Check if request if a POST request
 	if c.Request().Method != http.MethodGet {
@@ -29,13 +29,15 @@ func (a *App) HandleGetAllCourses(c echo.Context) error {
 	coursecode := c.QueryParam("coursecode")
 	statusCode := c.QueryParam("status")
 
-	examCourses, err := a.DB.GetAllCourses(coursecode, statusCode)
+	opts := parseListOptions(c)
+
+	examCourses, total, err := a.db(c).GetAllCourses(coursecode, statusCode, opts)
 	if err != nil {
 		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
 	}
 
 	// Return the results as JSON
-	return c.JSON(http.StatusOK, examCourses)
+	return c.JSON(http.StatusOK, newPagedResponse(c, examCourses, total, opts))
 }
 
 // HandleGetCourseByID fetches a single exam Course by ID from the database and returns the result as JSON
@@ -45,16 +47,20 @@ func (a *App) HandleGetCourseByID(c echo.Context) error {
 		return c.Redirect(http.StatusSeeOther, "/dashboard?error=Method not allowed")
 	}
 
-	// Get the exam ID from the URL
+	// Get the course code from the URL
 	coursecode := c.Param("coursecode")
-	//coursecode, err := strconv.Atoi(coursecodeStr)
-	if coursecode != "" {
-		return c.Redirect(http.StatusSeeOther, "Invalid exam ID")
+	if utils.IsValidCourseCode(coursecode) == false {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid course code",
+			"redirectURL": "/dashboard?error=Invalid course code",
+		})
 	}
 
-	// Fetch the exam from the database
-	Course, err := a.DB.GetCourseByID(coursecode)
-
+	// Fetch the course from the database
+	Course, err := a.db(c).GetCourseByID(coursecode)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Course not found"})
+	}
 	if err != nil {
 		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
 	}
@@ -86,17 +92,21 @@ func (a *App) HandlePostCourse(c echo.Context) error {
 	// Validate input
 	Course, err := validateCourse(coursecode, description, level, status)
 	if err != nil {
-		a.handleLogger("Error validating exam Course: " + err.Error())
+		a.handleLogger(c, "Error validating exam Course: "+err.Error())
 		// Redirect to dashboard with error message
 		return c.Redirect(http.StatusSeeOther, "/dashboard?error="+"Error validating course: "+err.Error())
 	}
 
 	// Insert new exma Course exam
-	err = a.DB.AddExamCourse(Course)
+	err = a.db(c).AddExamCourse(Course)
 	if err != nil {
-		a.handleLogger("Error adding course: " + err.Error())
+		a.handleLogger(c, "Error adding course: "+err.Error())
 		return c.Redirect(http.StatusSeeOther, "/dashboard?error="+err.Error())
 	}
+	auditChange(c, "course", coursecode, nil, func() any {
+		after, _ := a.db(c).GetCourseByID(coursecode)
+		return courseAudit(after)
+	})
 
 	// Redirect to dashboard with success message
 	return c.Redirect(http.StatusFound, "/dashboard?message=Course added successfully")
@@ -121,25 +131,32 @@ func (a *App) HandlePutCourse(c echo.Context) error {
 	// Parse form data from the request body
 	var coursedto models.CoursesDto
 	if err := c.Bind(&coursedto); err != nil {
-		a.handleLogger("Error binding course request body: " + err.Error())
+		a.handleLogger(c, "Error binding course request body: "+err.Error())
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error":       "Invalid course request body",
 			"redirectURL": "/dashboard?error=Invalid exam Course request body",
 		})
 	}
 
-	// Validate input
-	course, err := validateCourse(coursedto.CourseCode, coursedto.Description, coursedto.Level, coursedto.Status)
+	// Validate input - the course code is the primary key and is taken from the URL not the body
+	course, err := validateCourse(coursecode, coursedto.Description, coursedto.Level, coursedto.Status)
 	if err != nil {
-		a.handleLogger("Error validating Course: " + err.Error())
+		a.handleLogger(c, "Error validating Course: "+err.Error())
 		// Redirect to dashboard with error message
 		return c.Redirect(http.StatusSeeOther, "/dashboard?error="+"Error validating Course: "+err.Error())
 	}
 
+	// record the course as it was and as it is stored after the update
+	before, _ := a.db(c).GetCourseByID(coursecode)
+	auditChange(c, "course", coursecode, courseAudit(before), func() any {
+		after, _ := a.db(c).GetCourseByID(coursecode)
+		return courseAudit(after)
+	})
+
 	// Update the exam in the database
-	err = a.DB.UpdateCourse(course)
+	err = a.db(c).UpdateCourse(course)
 	if err != nil {
-		a.handleLogger("Error updating exam Course: " + err.Error())
+		a.handleLogger(c, "Error updating exam Course: "+err.Error())
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error updating exam Course: " + err.Error(),
 			"redirectURL": "/dashboard?error=" + err.Error()})
 	}
@@ -148,6 +165,18 @@ func (a *App) HandlePutCourse(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]string{"message": "exam updated successfully", "redirectURL": "/dashboard?message=exam updated successfully"})
 }
 
+// courseAudit is the snapshot of a course recorded in the audit trail
+func courseAudit(course *models.Courses) any {
+	if course == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"description": course.Description.String,
+		"level":       course.Level,
+		"status":      course.Status.String,
+	}
+}
+
 func validateCourse(coursecode, description, level, status string) (*models.Courses, error) {
 	const (
 		ErrCourseCodeRequired      string = "coursecode is required"
@@ -232,8 +261,11 @@ func (a *App) HandleDeleteCourse(c echo.Context) error {
 		})
 	}
 
+	before, _ := a.db(c).GetCourseByID(coursecode)
+	auditChange(c, "course", coursecode, courseAudit(before), nil)
+
 	// Delete the exam from the database
-	err := a.DB.DeleteCourse(coursecode)
+	err := a.db(c).DeleteCourse(coursecode)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error":       "Error deleting exam Course",
@@ -279,8 +311,15 @@ func (a *App) HandlePutCourseStatus(c echo.Context) error {
 			"redirectURL": "/dashboard?error=Invalid request body"})
 	}
 
+	// Validate status, the Courses check constraint allows these two only
+	if req.Status != "active" && req.Status != "closed" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Status must be active or closed",
+			"redirectURL": "/dashboard?error=Status must be active or closed"})
+	}
+
 	// Validate Course exists
-	Course, err := a.DB.GetCourseByID(coursecode)
+	Course, err := a.db(c).GetCourseByID(coursecode)
 	if err != nil {
 		return c.JSON(http.StatusNotFound, map[string]string{
 			"error":       "Exam Course not found",
@@ -294,15 +333,13 @@ func (a *App) HandlePutCourseStatus(c echo.Context) error {
 			"redirectURL": "/dashboard?error=Exam Course not found"})
 	}
 
-	// Validate status
-	if req.Status == "" {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error":       "Status is required",
-			"redirectURL": "/dashboard?error=Status is required"})
-	}
+	auditChange(c, "course", coursecode, courseAudit(Course), func() any {
+		after, _ := a.db(c).GetCourseByID(coursecode)
+		return courseAudit(after)
+	})
 
 	// Update the exam status in the database
-	err = a.DB.UpdateCourseStatus(coursecode, req.Status)
+	err = a.db(c).UpdateCourseStatus(coursecode, req.Status)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error":       "Failed to update exam Course status",
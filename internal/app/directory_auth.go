@@ -0,0 +1,111 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+
+	"ADS4/internal/directory"
+	"ADS4/internal/models"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+/* Password checks for the login
+   - with AUTH_PROVIDER=local every account logs in with its password in UserT
+   - with AUTH_PROVIDER=ldap staff log in with their directory password, the account is created on the
+     first login and its email and role follow the directory groups (LDAP_ADMIN_GROUPS, LDAP_FACULTY_GROUPS)
+   - the default admin always uses its local password as a break-glass account for when the directory is
+     down or misconfigured, and local Learner accounts keep their local passwords
+*/
+
+var (
+	errInvalidLogin         = errors.New("Invalid username or password")
+	errNotStaffGroup        = errors.New("Your directory account is not in an ADS4 staff group")
+	errDirectoryUnavailable = errors.New("The directory service is unavailable, try again later")
+)
+
+// authenticate checks the username and password and returns the account. errInvalidLogin and
+// errNotStaffGroup count as failed logins, errDirectoryUnavailable does not
+func (a *App) authenticate(c echo.Context, username, password string) (*models.User, error) {
+	user, err := a.db(c).GetUserByUsername(username)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if a.Directory == nil || (user != nil && usesLocalPassword(user)) {
+		if user == nil || bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)) != nil {
+			return nil, errInvalidLogin
+		}
+		return user, nil
+	}
+
+	entry, err := a.Directory.Authenticate(username, password)
+	if err == directory.ErrInvalidCredentials {
+		return nil, errInvalidLogin
+	}
+	if err != nil {
+		a.handleLogger(c, "Error authenticating with the directory: "+err.Error())
+		return nil, errDirectoryUnavailable
+	}
+	return a.provisionDirectoryUser(c, entry)
+}
+
+// usesLocalPassword checks if an account keeps its local password while the directory is in use
+func usesLocalPassword(user *models.User) bool {
+	return user.DefaultAdmin || (user.Role == RoleLearner && user.AuthSource != "ldap")
+}
+
+// directoryRole maps the directory groups of a user to a staff role, Admin taking precedence
+func (a *App) directoryRole(entry *directory.Entry) string {
+	switch {
+	case entry.InGroup(a.Config.LDAPAdminGroups):
+		return RoleAdmin
+	case entry.InGroup(a.Config.LDAPFacultyGroups):
+		return RoleFaculty
+	}
+	return ""
+}
+
+// provisionDirectoryUser creates or refreshes the account of a directory user after a successful bind
+func (a *App) provisionDirectoryUser(c echo.Context, entry *directory.Entry) (*models.User, error) {
+	role := a.directoryRole(entry)
+	if role == "" {
+		a.handleLogger(c, "Directory user "+entry.Username+" is not in an admin or faculty group")
+		return nil, errNotStaffGroup
+	}
+
+	// the email is unique and required, directory users without one get a placeholder
+	email := strings.TrimSpace(entry.Email)
+	if email == "" {
+		email = entry.Username + "@ldap.invalid"
+	}
+
+	user, err := a.db(c).GetUserByUsername(entry.Username)
+	if err == sql.ErrNoRows {
+		user, err = a.db(c).ProvisionDirectoryUser(entry.Username, email, role)
+		if err != nil {
+			return nil, err
+		}
+		a.handleLogger(c, "Directory user "+entry.Username+" provisioned as "+role)
+		return user, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the break-glass admin is never taken over by a directory account of the same name
+	if user.DefaultAdmin {
+		return nil, errInvalidLogin
+	}
+
+	if user.Email != email || user.Role != role || user.AuthSource != "ldap" {
+		if err := a.db(c).SyncDirectoryUser(user.UserID, email, role); err != nil {
+			return nil, err
+		}
+		a.handleLogger(c, "Directory user "+entry.Username+" updated as "+role)
+		return a.db(c).GetUserByID(user.UserID)
+	}
+	return user, nil
+}
@@ -23,9 +23,9 @@ func (a *App) HandleGetYearList(c echo.Context) error {
 	}
 
 	//retrieve the list of years in the database
-	examyears, err := a.DB.GetExamYears()
+	examyears, err := a.db(c).GetExamYears()
 	if err != nil {
-		a.handleLogger("Error fetching exam year data: " + err.Error())
+		a.handleLogger(c, "Error fetching exam year data: "+err.Error())
 		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
 	}
 
@@ -57,10 +57,10 @@ func (a *App) HandleExamMetrics(c echo.Context) error {
 		semester = _semester
 	}
 
-	//retrieve the list of active exam offerings with current metrics
-	metrics, err := a.DB.GetExamByYearSemester(year, semester)
+	//retrieve the list of active exam offerings with current metrics, Faculty see their own offerings
+	metrics, err := a.db(c).GetExamByYearSemester(year, semester, staffScope(c))
 	if err != nil {
-		a.handleLogger("Error fetching exam data: " + err.Error())
+		a.handleLogger(c, "Error fetching exam data: "+err.Error())
 		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
 	}
 
@@ -69,15 +69,24 @@ func (a *App) HandleExamMetrics(c echo.Context) error {
 
 }
 
-// e.g. /closedexams/:field/:value/:semester
+// e.g. /closedexams/:field/:value/:semester?year=2025
 func (a *App) HandleClosedExams(c echo.Context) error {
 	// Check if request if a GET request
 	if c.Request().Method != http.MethodGet {
 		return c.Redirect(http.StatusSeeOther, "/dashboard?error=Method not allowed")
 	}
 
-	//year := c.QueryParam("year") //passed as ?year=
-	//year := c.Param("year") //path parameter
+	//assume the current year else use the argument
+	year := strconv.Itoa(time.Now().Year())
+	if _year := c.QueryParam("year"); _year != "" {
+		if _, err := strconv.Atoi(_year); err != nil || len(_year) != 4 {
+			return c.JSON(http.StatusBadRequest, map[string]string{
+				"error": "Invalid year value",
+			})
+		}
+		year = _year
+	}
+
 	field := c.Param("field")
 	value := c.Param("value")
 	semester := c.Param("semester")
@@ -100,9 +109,9 @@ func (a *App) HandleClosedExams(c echo.Context) error {
 	}
 
 	//fmt.Printf("%s, %s, %s\n", field, value, semester)
-	metrics, err := a.DB.GetExaminations(field, value, semester)
+	metrics, err := a.db(c).GetExaminations(field, value, year, semester, staffScope(c))
 	if err != nil {
-		a.handleLogger("Error fetchign examination data: ")
+		a.handleLogger(c, "Error fetchign examination data: ")
 		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
 	}
 
@@ -0,0 +1,200 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"ADS4/internal/utils"
+
+	"github.com/labstack/echo/v4"
+)
+
+/* Health checks
+   - GET /health/live answers as long as the service is running, with the build version and uptime
+   - GET /health/ready checks what the exam room needs before the learners arrive: the service is not
+     shutting down, the database answers, no migration is pending, the data folder has free space, and
+     the learners (uploads) and exams folders are writable. It answers 503 when a check fails so a probe
+     or the operator can tell. The public answer is only the status, the checks with their details are
+     served by GET /health/ready/details to the METRICS_ALLOW addresses or an API key with metrics:read
+   - /hello stays as it is for the Assessment Tool
+*/
+
+// the database check gives up after healthTimeout
+const healthTimeout = 3 * time.Second
+
+// healthCheck is the outcome of one readiness check
+type healthCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+}
+
+// healthReport is the body of the health endpoints
+type healthReport struct {
+	Status        string        `json:"status"`
+	Version       string        `json:"version"`
+	BuildTime     string        `json:"build_time,omitempty"`
+	Started       string        `json:"started"`
+	Uptime        string        `json:"uptime"`
+	UptimeSeconds int64         `json:"uptime_seconds"`
+	Checks        []healthCheck `json:"checks,omitempty"`
+}
+
+// newHealthReport fills in the version and uptime of the service
+func (a *App) newHealthReport(status string) healthReport {
+	uptime := time.Since(a.Started)
+	return healthReport{
+		Status:        status,
+		Version:       a.Version,
+		BuildTime:     a.BuildTime,
+		Started:       a.Started.Format(time.RFC3339),
+		Uptime:        uptime.Round(time.Second).String(),
+		UptimeSeconds: int64(uptime.Seconds()),
+	}
+}
+
+// GET /health/live
+// HandleGetLive reports that the service is running
+func (a *App) HandleGetLive(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.JSON(http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+
+	return c.JSON(http.StatusOK, a.newHealthReport("ok"))
+}
+
+// GET /health/ready
+// HandleGetReady answers the status of the readiness checks only, with 503 when one of them fails
+func (a *App) HandleGetReady(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.JSON(http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+
+	report, status := a.readiness(c)
+	return c.JSON(status, map[string]string{"status": report.Status})
+}
+
+// GET /health/ready/details
+// HandleGetReadyDetails answers the readiness checks with their details, with 503 when one of them fails
+func (a *App) HandleGetReadyDetails(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.JSON(http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+
+	report, status := a.readiness(c)
+	return c.JSON(status, report)
+}
+
+// readiness runs the readiness checks and logs the failed ones
+func (a *App) readiness(c echo.Context) (healthReport, int) {
+	checks := []healthCheck{
+		a.checkShutdown(),
+		a.checkDatabase(c.Request().Context()),
+		a.checkMigrations(),
+		a.checkFreeDisk(),
+		checkWritable("learners", filepath.Join(a.DataDir, "learners")),
+		checkWritable("exams", filepath.Join(a.DataDir, "exams")),
+	}
+
+	report := a.newHealthReport("ready")
+	report.Checks = checks
+	status := http.StatusOK
+	for _, check := range checks {
+		if !check.OK {
+			report.Status = "not ready"
+			status = http.StatusServiceUnavailable
+			a.log(c).Warn().Str("check", check.Name).Str("detail", check.Detail).Msg("Readiness check failed")
+		}
+	}
+	return report, status
+}
+
+// checkShutdown fails once the service is draining for a shutdown
+func (a *App) checkShutdown() healthCheck {
+	if a.Draining() {
+		return healthCheck{Name: "service", Detail: "The service is shutting down"}
+	}
+	return healthCheck{Name: "service", OK: true, Detail: "Accepting exams"}
+}
+
+// checkDatabase pings the database and runs a query
+func (a *App) checkDatabase(ctx context.Context) healthCheck {
+	check := healthCheck{Name: "database"}
+	ctx, cancel := context.WithTimeout(ctx, healthTimeout)
+	defer cancel()
+
+	start := time.Now()
+	var one int
+	if err := a.DB.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		check.Detail = "The database is not answering: " + err.Error()
+		return check
+	}
+	check.OK = true
+	check.Detail = fmt.Sprintf("%s database answered in %s", a.Config.DBtype, time.Since(start).Round(time.Microsecond))
+	return check
+}
+
+// checkMigrations compares the migrations folder with the migrations applied to the database
+func (a *App) checkMigrations() healthCheck {
+	check := healthCheck{Name: "migrations"}
+	pending, err := a.DB.PendingMigrations(a.Config.MigrationsDir)
+	if err != nil {
+		check.Detail = err.Error()
+		return check
+	}
+	if len(pending) > 0 {
+		files := make([]string, len(pending))
+		for i, migration := range pending {
+			files[i] = migration.File
+		}
+		check.Detail = fmt.Sprintf("%d pending: %s", len(pending), strings.Join(files, ", "))
+		return check
+	}
+	check.OK = true
+	check.Detail = "All migrations are applied"
+	return check
+}
+
+// checkFreeDisk checks the free space of the disk holding the data folder
+func (a *App) checkFreeDisk() healthCheck {
+	check := healthCheck{Name: "disk"}
+	free, err := utils.FreeDiskSpace(a.DataDir)
+	if err != nil {
+		check.Detail = "Unable to read the free disk space: " + err.Error()
+		return check
+	}
+	freeMB := free / (1024 * 1024)
+	check.OK = freeMB >= uint64(a.Config.MinFreeDiskMB)
+	check.Detail = fmt.Sprintf("%d MB free, at least %d MB needed", freeMB, a.Config.MinFreeDiskMB)
+	return check
+}
+
+// checkWritable writes and removes a file in dir
+func checkWritable(name, dir string) healthCheck {
+	check := healthCheck{Name: name}
+	file, err := os.CreateTemp(dir, ".health-*")
+	if err != nil {
+		check.Detail = "The folder is not writable: " + err.Error()
+		return check
+	}
+	_, err = file.WriteString("ok")
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	os.Remove(file.Name())
+	if err != nil {
+		check.Detail = "The folder is not writable: " + err.Error()
+		return check
+	}
+	check.OK = true
+	check.Detail = dir + " is writable"
+	return check
+}
@@ -0,0 +1,60 @@
+package app
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+/* Built-in HTTPS
+   - TLS is turned on by setting TLS_CERT and TLS_KEY, e.g. to a certificate made by `ads gencert`
+   - when TLS is on the cookies are marked Secure and browsers are told to keep to HTTPS (HSTS)
+   - HTTP_REDIRECT_PORT starts a plain HTTP listener that redirects to the HTTPS port
+*/
+
+// hstsMaxAge is how long browsers keep to HTTPS after a visit, one year
+const hstsMaxAge = 31536000
+
+// hsts returns the middleware sending the Strict-Transport-Security header on HTTPS responses
+func (a *App) hsts() echo.MiddlewareFunc {
+	return middleware.SecureWithConfig(middleware.SecureConfig{
+		Skipper: func(c echo.Context) bool {
+			return !a.Config.TLSEnabled()
+		},
+		HSTSMaxAge: hstsMaxAge,
+	})
+}
+
+// TLSConfig loads the certificate and key and returns the TLS settings of the HTTPS listener
+func (a *App) TLSConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(a.Config.TLSCert, a.Config.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   a.Config.TLSMinVersion,
+	}, nil
+}
+
+// HTTPSRedirectServer returns the plain HTTP server that redirects every request to the HTTPS port
+func (a *App) HTTPSRedirectServer(httpsPort string) *http.Server {
+	return &http.Server{
+		Addr: ":" + a.Config.HTTPRedirectPort,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host, _, err := net.SplitHostPort(r.Host)
+			if err != nil {
+				host = r.Host // no port in the request
+			}
+			address := net.JoinHostPort(host, httpsPort)
+			if httpsPort == "443" {
+				address = strings.TrimSuffix(address, ":443")
+			}
+			http.Redirect(w, r, "https://"+address+r.URL.RequestURI(), http.StatusMovedPermanently)
+		}),
+	}
+}
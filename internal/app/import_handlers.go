@@ -15,13 +15,13 @@ func (a *App) TransferFile(c echo.Context, destfile string) error {
 	//retrieve the uploaded data file - this assumes the form variable datafile
 	inf, err := c.FormFile("datafile")
 	if err != nil {
-		a.handleLogger("Error accessing import file data: " + err.Error())
+		a.handleLogger(c, "Error accessing import file data: "+err.Error())
 		return err
 	}
 
 	src, err := inf.Open()
 	if err != nil {
-		a.handleLogger("Error opening srouce import file: " + err.Error())
+		a.handleLogger(c, "Error opening srouce import file: "+err.Error())
 		return err
 	}
 	defer src.Close()
@@ -29,13 +29,13 @@ func (a *App) TransferFile(c echo.Context, destfile string) error {
 	//copy and save the exam file
 	dst, err := os.Create(destfile)
 	if err != nil {
-		a.handleLogger("Error creating tagert import file: " + err.Error())
+		a.handleLogger(c, "Error creating tagert import file: "+err.Error())
 		return err
 	}
 	defer dst.Close()
 
 	if _, err = io.Copy(dst, src); err != nil {
-		a.handleLogger("Error copying source import file to target location: " + err.Error())
+		a.handleLogger(c, "Error copying source import file to target location: "+err.Error())
 		return err
 	}
 
@@ -51,32 +51,41 @@ func (a *App) ProcessImportFile(c echo.Context, target string) error {
 	datafile := a.DataDir + "/" + target + ".csv"
 	err := a.TransferFile(c, datafile)
 	if err != nil {
-		a.handleLogger("Transfer error with file import: " + err.Error())
+		a.handleLogger(c, "Transfer error with file import: "+err.Error())
 		return err
 	}
 	var ErrNotFound = errors.New("import handler not found")
 
 	switch target {
 	case "learner":
-		err = a.DB.ImportLearners(datafile, purge, overwrite)
+		err = a.db(c).ImportLearners(datafile, purge, overwrite)
 	case "course":
-		err = a.DB.ImportCourses(datafile, purge, overwrite)
+		err = a.db(c).ImportCourses(datafile, purge, overwrite)
 	case "learnerexam":
-		err = a.DB.ImportLearnerExams(datafile, purge, overwrite)
+		err = a.db(c).ImportLearnerExams(datafile, purge, overwrite)
 	case "offering":
-		err = a.DB.ImportOfferings(datafile, purge, overwrite)
+		err = a.db(c).ImportOfferings(datafile, purge, overwrite)
 	default:
 		return ErrNotFound
 	}
 
 	if err != nil {
-		a.handleLogger("Error importing data into database: " + err.Error())
+		a.handleLogger(c, "Error importing data into database: "+err.Error())
 		return err
 	}
 
 	return nil
 }
 
+// importAuditDetail describes an import for the audit trail, the options and the uploaded file name
+func importAuditDetail(c echo.Context) string {
+	detail := "purge=" + c.FormValue("purge") + " overwrite=" + c.FormValue("overwrite")
+	if inf, err := c.FormFile("datafile"); err == nil {
+		detail += " file=" + inf.Filename
+	}
+	return detail
+}
+
 func (a *App) HandlePostImport(c echo.Context) error {
 	// Check if request is a POST request
 	if c.Request().Method != http.MethodPost {
@@ -92,6 +101,7 @@ func (a *App) HandlePostImport(c echo.Context) error {
 		return c.String(http.StatusSeeOther, "Invalid import target: "+target)
 	}
 
+	auditDetail(c, "import", importAuditDetail(c))
 	err := a.ProcessImportFile(c, target)
 	if err != nil {
 		//return c.Render(http.StatusSeeOther, "admin.html", map[string]interface{}{"error": "Error processing import file: " + err.Error()})
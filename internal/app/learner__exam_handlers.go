@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"strconv"
 
+	"ADS4/internal/database"
 	"ADS4/internal/models"
 	"ADS4/internal/utils"
 
@@ -15,52 +16,44 @@ import (
 //-------------------------------------------------------------------------------------------------------------
 //The Handlers below are used for the leaner CRUD interfaces within the ADS and the Assessment Marker Tool
 
-// HandleGetAllLearnerExam fetches all active exams from the database with optional filtering by status code
-// and returns the results as JSON
+// HandleGetAllLearnerExams fetches a page of learner exams from the database with optional filtering by student ID,
+// exam ID and status code and returns the results as JSON in the paging envelope
 func (a *App) HandleGetAllLearnerExams(c echo.Context) error {
-	// Check if request if a POST request
+	// Check if request if a GET request
 	if c.Request().Method != http.MethodGet {
 		return c.Redirect(http.StatusSeeOther, "/dashboard?error=Method not allowed")
 	}
-	learnerexamid := c.QueryParam("learnerexamID")
+	studentid := c.QueryParam("studentid")
+	examid := c.QueryParam("examid")
 	statusCode := c.QueryParam("status")
 
-	// Get the LearnerExam ID from the URL
-	learnerexamID, err := strconv.Atoi(learnerexamid)
-	if err != nil {
-		a.handleLogger("Error converting leaner ID to integer: " + err.Error())
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid learner exam ID",
-			"redirectURL": "/dashboard?error=Invalid learner exam ID"})
-	}
+	opts := scopedListOptions(c)
 
-	LearnerExams, err := a.DB.GetAllLearnerExams(learnerexamID, statusCode)
+	LearnerExams, total, err := a.db(c).GetAllLearnerExams(studentid, examid, statusCode, opts)
 	if err != nil {
 		return a.handleError(c, http.StatusInternalServerError, "Error fetching leaner exam data", err)
 	}
 
 	// Return the results as JSON
-	return c.JSON(http.StatusOK, LearnerExams)
+	return c.JSON(http.StatusOK, newPagedResponse(c, LearnerExams, total, opts))
 }
 
-// HandleGetOfferingByID fetches a single exam offering by ID from the database and returns the result as JSON
+// HandleGetLearnerExamByID fetches a single learner exam by student ID and exam ID and returns the result as JSON
 func (a *App) HandleGetLearnerExamByID(c echo.Context) error {
-	// Check if request if a POST request
+	// Check if request if a GET request
 	if c.Request().Method != http.MethodGet {
 		return c.Redirect(http.StatusSeeOther, "/dashboard?error=Method not allowed")
 	}
 
-	// Get the LearnerExam ID from the URL
-	id := c.Param("learnerexamID")
-	learnerexamID, err := strconv.Atoi(id)
-	if err != nil {
-		a.handleLogger("Error converting leaner ID to integer: " + err.Error())
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid learner exam ID",
-			"redirectURL": "/dashboard?error=Invalid learner exam ID"})
-	}
-
-	// Fetch the learner from the database
-	learnerexam, err := a.DB.GetLearnerExamByID(learnerexamID)
+	// Get the learner exam key from the URL
+	studentid := c.Param("studentid")
+	examid := c.Param("examid")
 
+	// Fetch the learner exam from the database
+	learnerexam, err := a.db(c).GetLearnerExamByID(studentid, examid)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Learner exam not found"})
+	}
 	if err != nil {
 		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
 	}
@@ -70,32 +63,41 @@ func (a *App) HandleGetLearnerExamByID(c echo.Context) error {
 }
 
 func (a *App) HandlePostLearnerExam(c echo.Context) error {
-	// Check if request if a GET request
+	// Check if request if a POST request
 	if c.Request().Method != http.MethodPost {
 		return c.Render(http.StatusMethodNotAllowed, "dashboard.html", map[string]interface{}{
 			"error": "Method not allowed",
 		})
 	}
 
-	learnerexamID := c.FormValue("learnerexamID")
 	studentid := c.FormValue("studentid")
 	examid := c.FormValue("examid")
 	status := c.FormValue("status")
+	grade := c.FormValue("grade")
 
 	// Validate input
-	learnerexam, err := validateLearnerExam(learnerexamID, studentid, examid, status)
+	learnerexam, err := validateLearnerExam(studentid, examid, status, grade)
 	if err != nil {
-		a.handleLogger("Error validating leaner exam details " + err.Error())
+		a.handleLogger(c, "Error validating leaner exam details "+err.Error())
 		// Redirect to dashboard with error message
 		return c.Redirect(http.StatusSeeOther, "/dashboard?error="+"Error validating learner exam details: "+err.Error())
 	}
 
+	// Faculty can only enrol learners into their own offerings
+	if !a.canAccessOffering(c, examid) {
+		return c.Redirect(http.StatusSeeOther, "/dashboard?error=You do not have permission to enrol learners into this exam")
+	}
+
 	// Insert new exma offering LearnerExam
-	err = a.DB.AddLearnerExam(learnerexam)
+	err = a.db(c).AddLearnerExam(learnerexam)
 	if err != nil {
-		a.handleLogger("Error adding learner exam details: " + err.Error())
+		a.handleLogger(c, "Error adding learner exam details: "+err.Error())
 		return c.Redirect(http.StatusSeeOther, "/dashboard?error="+err.Error())
 	}
+	auditChange(c, "learnerexam", studentid+"/"+examid, nil, func() any {
+		after, _ := a.db(c).GetLearnerExamByID(studentid, examid)
+		return learnerExamAudit(after)
+	})
 
 	// Redirect to dashboard with success message
 	return c.Redirect(http.StatusFound, "/dashboard?message=Learner exam details added successfully")
@@ -109,37 +111,50 @@ func (a *App) HandlePutLearnerExam(c echo.Context) error {
 		})
 	}
 
-	// Get the LearnerExam ID from the URL
-	id := c.Param("learnerexamID")
-	_, err := strconv.Atoi(id)
-	if err != nil {
-		a.handleLogger("Error converting leaner ID to integer: " + err.Error())
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid learner ID",
-			"redirectURL": "/dashboard?error=Invalid learner ID"})
-	}
+	// Get the learner exam key from the URL
+	studentid := c.Param("studentid")
+	examid := c.Param("examid")
 
 	// Parse form data from the request body
 	var learnerexam models.LearnerExamDto
 	if err := c.Bind(&learnerexam); err != nil {
-		a.handleLogger("Error binding learner exam details request body: " + err.Error())
+		a.handleLogger(c, "Error binding learner exam details request body: "+err.Error())
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error":       "Invalid learner exam details request body",
 			"redirectURL": "/dashboard?error=Invalid learner exam details request body",
 		})
 	}
 
-	// Validate input
-	learnerExam, err := validateLearnerExam(learnerexam.LearnerExamID, learnerexam.StudentID, learnerexam.ExamID, learnerexam.Status)
+	// Validate input - the key is taken from the URL not the body
+	learnerExam, err := validateLearnerExam(studentid, examid, learnerexam.Status, learnerexam.Grade)
 	if err != nil {
-		a.handleLogger("Error validating learner exam details: " + err.Error())
-		// Redirect to dashboard with error message
-		return c.Redirect(http.StatusSeeOther, "/dashboard?error="+"Error validating learner exam details: "+err.Error())
+		a.handleLogger(c, "Error validating learner exam details: "+err.Error())
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Error validating learner exam details: " + err.Error(),
+			"redirectURL": "/dashboard?error=Error validating learner exam details: " + err.Error(),
+		})
 	}
 
-	// Update the LearnerExam in the database
-	err = a.DB.UpdateLearnerExam(learnerExam)
+	// results are released by the coordinator's approval of the moderated marks, and then kept, see marking_handlers.go
+	before, _ := a.db(c).GetLearnerExamByID(studentid, examid)
+	if msg := workflowConflict(before, learnerExam.Status.String, learnerExam.Grade); msg != "" {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error":       msg,
+			"redirectURL": "/dashboard?error=" + msg})
+	}
+
+	// record the attempt as it was and as it is stored after the change, grades must be traceable
+	resetting := a.auditLearnerExamChange(c, studentid, examid, before, learnerExam.Status.String)
+
+	// Update the LearnerExam in the database, an attempt put back to ready is a new attempt and the marking
+	// of the earlier one is cleared
+	if resetting {
+		err = a.db(c).ResetLearnerExam(studentid, examid, learnerExam.Grade)
+	} else {
+		err = a.db(c).UpdateLearnerExam(learnerExam)
+	}
 	if err != nil {
-		a.handleLogger("Error updating learner exam details: " + err.Error())
+		a.handleLogger(c, "Error updating learner exam details: "+err.Error())
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error updating learner exam details: " + err.Error(),
 			"redirectURL": "/dashboard?error=" + err.Error()})
 	}
@@ -148,42 +163,95 @@ func (a *App) HandlePutLearnerExam(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]string{"message": "LearnerExam updated successfully", "redirectURL": "/dashboard?message=LearnerExam updated successfully"})
 }
 
+// auditLearnerExamChange records the learner exam as it was and as it is stored after the request changes it
+// to status. It reports if the change puts the attempt back to ready, which clears its marking - the marks
+// are then kept in the snapshot from before the change
+func (a *App) auditLearnerExamChange(c echo.Context, studentid, examid string, before *models.LearnerExam, status string) bool {
+	resetting := before != nil && before.Status.String != "ready" && status == "ready"
+	snapshot := learnerExamAudit(before)
+	if resetting {
+		snapshot = a.learnerExamMarksAudit(c, before)
+	}
+	auditChange(c, "learnerexam", studentid+"/"+examid, snapshot, func() any {
+		after, _ := a.db(c).GetLearnerExamByID(studentid, examid)
+		return learnerExamAudit(after)
+	})
+	if resetting {
+		auditDetail(c, "", "reset to ready, the grade, marks and marking steps of the attempt were cleared")
+	}
+	return resetting
+}
+
+// learnerExamMarksAudit is the snapshot of a learner exam with its first and moderated marks, c is nil
+// outside a request
+func (a *App) learnerExamMarksAudit(c echo.Context, learnerexam *models.LearnerExam) any {
+	snapshot, ok := learnerExamAudit(learnerexam).(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	studentid, examid := learnerexam.StudentID.String, learnerexam.ExamID.String
+	snapshot["first_marks"], _ = a.db(c).GetMarks(studentid, examid, database.StageFirst)
+	snapshot["moderated_marks"], _ = a.db(c).GetMarks(studentid, examid, database.StageModerated)
+	return snapshot
+}
+
+// learnerExamAudit is the snapshot of a learner exam kept in the audit trail
+func learnerExamAudit(learnerexam *models.LearnerExam) any {
+	if learnerexam == nil {
+		return nil
+	}
+	snapshot := map[string]interface{}{
+		"status":    learnerexam.Status.String,
+		"starttime": learnerexam.StartTime.String,
+		"endtime":   learnerexam.EndTime.String,
+		"grade":     nil,
+	}
+	if learnerexam.Grade.Valid {
+		snapshot["grade"] = learnerexam.Grade.Int32
+	}
+	return snapshot
+}
+
+// valid learner exam states - these match the CHECK constraint on the Learnerexams table
 func validStatus(status string) bool {
 	stat := utils.StatusSet{}
 
-	stat.Add("available")
+	stat.Add("ready")
 	stat.Add("active")
-	stat.Add("expired")
+	stat.Add("expire")
 	stat.Add("closed")
 	stat.Add("marked")
 	return stat.Has(status)
 }
 
-func validateLearnerExam(learnerexamID, studentid, examid, status string) (*models.LearnerExam, error) {
+func validateLearnerExam(studentid, examid, status, grade string) (*models.LearnerExam, error) {
 	const (
-		ErrlearnerexamIDRequired string = "exam ID is required"
-		ErrStudentIDRequired     string = "student ID is required"
-		ErrStatusRequired        string = "status is required"
-		ErrlearnerexamID         string = "invalid learner exam ID"
-		ErrexamID                string = "invalid exam ID"
-		ErrStatus                string = "invalid status code "
-		ErrStudentIDTooLong      string = "student ID length exceeded"
+		ErrStudentIDRequired string = "student ID is required"
+		ErrExamIDRequired    string = "exam ID is required"
+		ErrStatusRequired    string = "status is required"
+		ErrexamID            string = "invalid exam ID"
+		ErrStatus            string = "invalid status code - ready, active, expire, closed, marked"
+		ErrStudentIDTooLong  string = "student ID length exceeded"
+		ErrGrade             string = "invalid grade - must be a number 0-100"
 	)
 
 	var learnerexam models.LearnerExam
 
-	if learnerexamID == "" {
-		return &learnerexam, errors.New(ErrlearnerexamIDRequired)
+	if studentid == "" {
+		return &learnerexam, errors.New(ErrStudentIDRequired)
 	}
 
-	// Get the LearnerExam ID from the URL
-	learnerexamid, err := strconv.Atoi(learnerexamID)
-	if err != nil {
-		return &learnerexam, errors.New(ErrlearnerexamID)
+	if len(studentid) > 8 {
+		return &learnerexam, errors.New(ErrStudentIDTooLong)
 	}
 
-	if studentid == "" {
-		return &learnerexam, errors.New(ErrStudentIDRequired)
+	if examid == "" {
+		return &learnerexam, errors.New(ErrExamIDRequired)
+	}
+
+	//[year:4][semester:2][coursecode:9]
+	if len(examid) != 15 {
+		return &learnerexam, errors.New(ErrexamID)
 	}
 
 	if status == "" {
@@ -194,17 +262,16 @@ func validateLearnerExam(learnerexamID, studentid, examid, status string) (*mode
 		return &learnerexam, errors.New(ErrStatus)
 	}
 
-	if len(studentid) > 8 {
-		return &learnerexam, errors.New(ErrStudentIDTooLong)
-	}
-
-	if utils.IsValidCourseCode(examid) == false {
-		return &learnerexam, errors.New(ErrexamID)
+	// the grade is optional
+	if grade != "" {
+		_grade, err := strconv.Atoi(grade)
+		if err != nil || _grade < 0 || _grade > 100 {
+			return &learnerexam, errors.New(ErrGrade)
+		}
+		learnerexam.Grade = sql.NullInt32{Int32: int32(_grade), Valid: true}
 	}
 
 	// Set the values of the LearnerExam model
-	// Initialize sql.NullString for optional fields
-	learnerexam.LearnerExamID = learnerexamid
 	learnerexam.StudentID = sql.NullString{String: studentid, Valid: true}
 	learnerexam.ExamID = sql.NullString{String: examid, Valid: true}
 	learnerexam.Status = sql.NullString{String: status, Valid: true}
@@ -212,26 +279,24 @@ func validateLearnerExam(learnerexamID, studentid, examid, status string) (*mode
 	return &learnerexam, nil
 }
 
-// TODO: continue here ##
 func (a *App) HandleDeleteLearnerExam(c echo.Context) error {
 	// Check if request is not a DELETE request
 	if c.Request().Method != http.MethodDelete {
 		return c.JSON(http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
 	}
 
-	// Get the LearnerExam ID from the URL
-	id := c.Param("learnerexamID")
-	learnerexamID, err := strconv.Atoi(id)
-	if err != nil {
-		a.handleLogger("Error converting leaner ID to integer: " + err.Error())
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid learner exam ID",
-			"redirectURL": "/dashboard?error=Invalid learner exam ID"})
-	}
+	// Get the learner exam key from the URL
+	studentid := c.Param("studentid")
+	examid := c.Param("examid")
+
+	// keep the deleted attempt in the audit trail
+	before, _ := a.db(c).GetLearnerExamByID(studentid, examid)
+	auditChange(c, "learnerexam", studentid+"/"+examid, learnerExamAudit(before), nil)
 
 	// Delete the LearnerExam from the database
-	err = a.DB.DeleteLearnerExam(learnerexamID)
+	err := a.db(c).DeleteLearnerExam(studentid, examid)
 	if err != nil {
-		a.handleLogger("Error deleting learner exam details: " + err.Error())
+		a.handleLogger(c, "Error deleting learner exam details: "+err.Error())
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error":       "Error deleting learner exam",
 			"redirectURL": "/dashboard?error=Error deleting learner exam " + err.Error(),
@@ -252,14 +317,9 @@ func (a *App) HandlePutLearnerExamStatus(c echo.Context) error {
 			"redirectURL": "/dashboard?error=Method not allowed"})
 	}
 
-	// Get the LearnerExam ID from the URL
-	learnerexamid := c.Param("learnerexamID")
-	learnerexamID, err := strconv.Atoi(learnerexamid)
-	if err != nil {
-		a.handleLogger("Error converting leaner ID to integer: " + err.Error())
-		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid learner exam ID",
-			"redirectURL": "/dashboard?error=Invalid learner exam ID"})
-	}
+	// Get the learner exam key from the URL
+	studentid := c.Param("studentid")
+	examid := c.Param("examid")
 
 	// Create a struct to bind the JSON request body
 	type StatusRequest struct {
@@ -274,37 +334,43 @@ func (a *App) HandlePutLearnerExamStatus(c echo.Context) error {
 			"redirectURL": "/dashboard?error=Invalid learner exam request body"})
 	}
 
-	// Validate offering exists
-	offering, err := a.DB.GetLearnerExamByID(learnerexamID)
-	if err != nil {
-		return c.JSON(http.StatusNotFound, map[string]string{
-			"error":       "Learner exam not found",
-			"redirectURL": "/dashboard?error=Learner exam not found"})
-	}
-
-	// if no LearnerExam found, return 404
-	if offering == nil {
+	// Validate the learner exam exists
+	if _, err := a.db(c).GetLearnerExamByID(studentid, examid); err != nil {
 		return c.JSON(http.StatusNotFound, map[string]string{
 			"error":       "Learner exam not found",
 			"redirectURL": "/dashboard?error=Learner exam not found"})
 	}
 
-	// Validate status exists
-	if req.Status == "" || validStatus(req.Status) == false {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error":       "Status code is required",
-			"redirectURL": "/dashboard?error=Status code is required"})
-	}
-
 	// Validate status is valid value
 	if validStatus(req.Status) == false {
 		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error":       "Status code is invalid - must be one of available, active, expired, closed, marked",
-			"redirectURL": "/dashboard?error=Status code is invalid - must be one of available, active, expired, closed, marked"})
+			"error":       "Status code is invalid - must be one of ready, active, expire, closed, marked",
+			"redirectURL": "/dashboard?error=Status code is invalid - must be one of ready, active, expire, closed, marked"})
+	}
+
+	// results are released by the coordinator's approval of the moderated marks, and then kept, see marking_handlers.go
+	before, _ := a.db(c).GetLearnerExamByID(studentid, examid)
+	var grade sql.NullInt32
+	if before != nil {
+		grade = before.Grade
+	}
+	if msg := workflowConflict(before, req.Status, grade); msg != "" {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error":       msg,
+			"redirectURL": "/dashboard?error=" + msg})
 	}
 
-	// Update the LearnerExam status in the database
-	err = a.DB.UpdateLearnerExamStatus(learnerexamID, req.Status)
+	// record the attempt as it was and as it is stored after the change, grades must be traceable
+	resetting := a.auditLearnerExamChange(c, studentid, examid, before, req.Status)
+
+	// Update the LearnerExam status in the database, an attempt put back to ready is a new attempt and the
+	// marking of the earlier one is cleared
+	var err error
+	if resetting {
+		err = a.db(c).ResetLearnerExam(studentid, examid, sql.NullInt32{})
+	} else {
+		err = a.db(c).UpdateLearnerExamStatus(studentid, examid, req.Status)
+	}
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error":       "Failed to update the learner exam status",
@@ -312,5 +378,5 @@ func (a *App) HandlePutLearnerExamStatus(c echo.Context) error {
 	}
 
 	return c.JSON(http.StatusOK, map[string]string{
-		"message": "Exam offering status updated successfully"})
+		"message": "Learner exam status updated successfully"})
 }
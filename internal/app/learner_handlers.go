@@ -0,0 +1,372 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"ADS4/internal/database"
+	"ADS4/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+/* Learners
+   - StudentID e.g 20001111
+   - Name
+   - status - active,inactive
+*/
+
+// HandleGetAllLearners fetches a page of learners from the database with optional filtering by student ID and status
+// and returns the results as JSON in the paging envelope
+func (a *App) HandleGetAllLearners(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.Redirect(http.StatusSeeOther, "/dashboard?error=Method not allowed")
+	}
+	studentid := c.QueryParam("studentid")
+	statusCode := c.QueryParam("status")
+
+	opts := scopedListOptions(c)
+
+	learners, total, err := a.db(c).GetAllLearners(studentid, statusCode, opts)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
+	}
+
+	// Return the results as JSON
+	return c.JSON(http.StatusOK, newPagedResponse(c, learners, total, opts))
+}
+
+// HandleGetLearnerByID fetches a single learner by student ID from the database and returns the result as JSON
+func (a *App) HandleGetLearnerByID(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.Redirect(http.StatusSeeOther, "/dashboard?error=Method not allowed")
+	}
+
+	// Get the student ID from the URL
+	studentid := c.Param("studentid")
+	if isValidStudentID(studentid) == false {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid student ID",
+			"redirectURL": "/dashboard?error=Invalid student ID",
+		})
+	}
+
+	// Faculty can only see the learners enrolled in their offerings
+	if staffID := staffScope(c); staffID > 0 {
+		learners, _, err := a.db(c).GetAllLearners(studentid, "", database.ListOptions{StaffID: staffID})
+		if err != nil {
+			return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
+		}
+		if len(learners) == 0 {
+			return forbidden(c)
+		}
+	}
+
+	// Fetch the learner from the database
+	learner, err := a.db(c).GetLearnerByID(studentid)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Learner not found"})
+	}
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
+	}
+
+	// Return the result as JSON
+	return c.JSON(http.StatusOK, learner)
+}
+
+// HandleGetLearnerEnrolments fetches the exams a learner is enrolled in along with the status of each attempt.
+// Faculty only see the enrolments for their own offerings
+func (a *App) HandleGetLearnerEnrolments(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.Redirect(http.StatusSeeOther, "/dashboard?error=Method not allowed")
+	}
+
+	studentid := c.Param("studentid")
+	if isValidStudentID(studentid) == false {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid student ID",
+			"redirectURL": "/dashboard?error=Invalid student ID",
+		})
+	}
+
+	enrolments, err := a.db(c).GetLearnerEnrolments(studentid, staffScope(c))
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching learner enrolments", err)
+	}
+
+	// Return the results as JSON
+	return c.JSON(http.StatusOK, enrolments)
+}
+
+// HandleGetLearnerResults fetches the marked exam results of a learner. Faculty only see the results for their own offerings
+func (a *App) HandleGetLearnerResults(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.Redirect(http.StatusSeeOther, "/dashboard?error=Method not allowed")
+	}
+
+	studentid := c.Param("studentid")
+	if isValidStudentID(studentid) == false {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid student ID",
+			"redirectURL": "/dashboard?error=Invalid student ID",
+		})
+	}
+
+	results, err := a.db(c).GetLearnerResults(studentid, staffScope(c))
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching learner results", err)
+	}
+
+	// Return the results as JSON
+	return c.JSON(http.StatusOK, results)
+}
+
+func (a *App) HandlePostLearner(c echo.Context) error {
+	// Check if request if a POST request
+	if c.Request().Method != http.MethodPost {
+		return c.Render(http.StatusMethodNotAllowed, "dashboard.html", map[string]interface{}{
+			"error": "Method not allowed",
+		})
+	}
+
+	// Parse form data
+	studentid := c.FormValue("studentid")
+	studentname := c.FormValue("studentname")
+	status := c.FormValue("status")
+
+	// Validate input
+	learner, err := validateLearner(studentid, studentname, status)
+	if err != nil {
+		a.handleLogger(c, "Error validating learner: "+err.Error())
+		// Redirect to dashboard with error message
+		return c.Redirect(http.StatusSeeOther, "/dashboard?error="+"Error validating learner: "+err.Error())
+	}
+
+	// Insert the new learner
+	err = a.db(c).AddLearner(learner)
+	if err != nil {
+		a.handleLogger(c, "Error adding learner: "+err.Error())
+		return c.Redirect(http.StatusSeeOther, "/dashboard?error="+err.Error())
+	}
+	auditChange(c, "learner", studentid, nil, func() any {
+		after, _ := a.db(c).GetLearnerByID(studentid)
+		return learnerAudit(after)
+	})
+
+	// Redirect to dashboard with success message
+	return c.Redirect(http.StatusFound, "/dashboard?message=Learner added successfully")
+}
+
+func (a *App) HandlePutLearner(c echo.Context) error {
+	// Check if request is not a PUT request
+	if c.Request().Method != http.MethodPut {
+		return c.Render(http.StatusMethodNotAllowed, "dashboard.html", map[string]interface{}{
+			"error": "Method not allowed",
+		})
+	}
+
+	studentid := c.Param("studentid")
+	if isValidStudentID(studentid) == false {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid student ID",
+			"redirectURL": "/dashboard?error=Invalid student ID",
+		})
+	}
+
+	// Parse form data from the request body
+	var learnerdto models.LearnerDto
+	if err := c.Bind(&learnerdto); err != nil {
+		a.handleLogger(c, "Error binding learner request body: "+err.Error())
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid learner request body",
+			"redirectURL": "/dashboard?error=Invalid learner request body",
+		})
+	}
+
+	// the student ID is the primary key and is taken from the URL not the body
+	learner, err := validateLearner(studentid, learnerdto.StudentName, learnerdto.Status)
+	if err != nil {
+		a.handleLogger(c, "Error validating learner: "+err.Error())
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Error validating learner: " + err.Error(),
+			"redirectURL": "/dashboard?error=Error validating learner: " + err.Error(),
+		})
+	}
+
+	// record the learner as it was and as it is stored after the update
+	before, _ := a.db(c).GetLearnerByID(studentid)
+	auditChange(c, "learner", studentid, learnerAudit(before), func() any {
+		after, _ := a.db(c).GetLearnerByID(studentid)
+		return learnerAudit(after)
+	})
+
+	// Update the learner in the database
+	err = a.db(c).UpdateLearner(learner)
+	if err != nil {
+		a.handleLogger(c, "Error updating learner: "+err.Error())
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error updating learner: " + err.Error(),
+			"redirectURL": "/dashboard?error=" + err.Error()})
+	}
+
+	// Redirect to dashboard with success message
+	return c.JSON(http.StatusOK, map[string]string{"message": "Learner updated successfully", "redirectURL": "/dashboard?message=Learner updated successfully"})
+}
+
+// learnerAudit is the snapshot of a learner recorded in the audit trail
+func learnerAudit(learner *models.Learner) any {
+	if learner == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"studentname": learner.StudentName.String,
+		"status":      learner.Status.String,
+	}
+}
+
+func (a *App) HandlePutLearnerStatus(c echo.Context) error {
+	// Check if request is not a PUT request
+	if c.Request().Method != http.MethodPut {
+		return c.JSON(http.StatusMethodNotAllowed, map[string]string{
+			"error":       "Method not allowed",
+			"redirectURL": "/dashboard?error=Method not allowed"})
+	}
+
+	// Parse the student ID from the URL parameter
+	studentid := c.Param("studentid")
+	if isValidStudentID(studentid) == false {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid student ID",
+			"redirectURL": "/dashboard?error=Invalid student ID",
+		})
+	}
+
+	// Create a struct to bind the JSON request body
+	type StatusRequest struct {
+		Status string `json:"status"`
+	}
+
+	// Bind the JSON request body to the struct
+	var req StatusRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid request body",
+			"redirectURL": "/dashboard?error=Invalid request body"})
+	}
+
+	// Validate status
+	if req.Status != "active" && req.Status != "inactive" {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Status must be active or inactive",
+			"redirectURL": "/dashboard?error=Status must be active or inactive"})
+	}
+
+	// Validate learner exists
+	before, err := a.db(c).GetLearnerByID(studentid)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error":       "Learner not found",
+			"redirectURL": "/dashboard?error=Learner not found"})
+	}
+	auditChange(c, "learner", studentid, learnerAudit(before), func() any {
+		after, _ := a.db(c).GetLearnerByID(studentid)
+		return learnerAudit(after)
+	})
+
+	// Update the learner status in the database
+	err = a.db(c).UpdateLearnerStatus(studentid, req.Status)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error":       "Failed to update learner status",
+			"redirectURL": "/dashboard?error=Failed to update learner status"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Learner status updated successfully"})
+}
+
+func (a *App) HandleDeleteLearner(c echo.Context) error {
+	// Check if request is not a DELETE request
+	if c.Request().Method != http.MethodDelete {
+		return c.JSON(http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+
+	studentid := c.Param("studentid")
+	if isValidStudentID(studentid) == false {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid student ID",
+			"redirectURL": "/dashboard?error=Invalid student ID",
+		})
+	}
+
+	before, _ := a.db(c).GetLearnerByID(studentid)
+	auditChange(c, "learner", studentid, learnerAudit(before), nil)
+
+	// Delete the learner from the database
+	err := a.db(c).DeleteLearner(studentid)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error":       "Error deleting learner",
+			"redirectURL": "/dashboard?error=Error deleting learner: " + err.Error(),
+		})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message":     "Learner deleted successfully",
+		"redirectURL": "/dashboard?message=Learner deleted successfully",
+	})
+}
+
+// student IDs are stored as VARCHAR(8)
+func isValidStudentID(studentid string) bool {
+	return studentid != "" && len(studentid) <= 8
+}
+
+func validateLearner(studentid, studentname, status string) (*models.Learner, error) {
+	const (
+		ErrStudentIDRequired   string = "student ID is required"
+		ErrStudentIDTooLong    string = "student ID is too long, maximum 8 characters"
+		ErrStudentNameRequired string = "student name is required"
+		ErrStudentNameTooLong  string = "student name is too long, maximum 255 characters"
+		ErrStatusRequired      string = "status code is required"
+		ErrStatusRange         string = "invalid status code - active/inactive"
+	)
+
+	var learner models.Learner
+
+	if studentid == "" {
+		return &learner, errors.New(ErrStudentIDRequired)
+	}
+
+	if len(studentid) > 8 {
+		return &learner, errors.New(ErrStudentIDTooLong)
+	}
+
+	if studentname == "" {
+		return &learner, errors.New(ErrStudentNameRequired)
+	}
+
+	if len(studentname) > 255 {
+		return &learner, errors.New(ErrStudentNameTooLong)
+	}
+
+	if status == "" {
+		return &learner, errors.New(ErrStatusRequired)
+	}
+
+	if status != "active" && status != "inactive" {
+		return &learner, errors.New(ErrStatusRange)
+	}
+
+	// Set the values of the learner model
+	learner.StudentID = sql.NullString{String: studentid, Valid: true}
+	learner.StudentName = sql.NullString{String: studentname, Valid: true}
+	learner.Status = sql.NullString{String: status, Valid: true}
+
+	return &learner, nil
+}
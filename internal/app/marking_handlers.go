@@ -0,0 +1,479 @@
+package app
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ADS4/internal/database"
+	"ADS4/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+/* Marking moderation
+   - the first marker, staff with access to the offering or a marks:write API key, submits per question marks
+     for a closed or expired learner exam
+   - the moderator, a Faculty user assigned to the offering by the coordinator, reviews all or a sample of the
+     scripts and can adjust the mark of any question with a comment. A moderator never moderates their own marking
+   - the course coordinator, or an Admin, approves the offering's results once every sat exam has been marked
+     and at least one has been moderated. Only then are the learner exams marked, which releases the grades
+     with the feedback from the marking comments, the moderator's comment replacing the first marker's
+   - every step is kept in MarkingSteps and returned by the marking API
+*/
+
+// the longest question label and comment accepted
+const (
+	maxQuestionLength = 32
+	maxCommentLength  = 500
+)
+
+// the learner exam changes refused outside the workflow - only the coordinator's approval marks a learner
+// exam, and a released result keeps its status and grade
+const (
+	errMarkedByApproval = "Results are marked when the coordinator approves the moderated marks of the offering"
+	errResultReleased   = "The result has been released, its status and grade cannot be changed"
+)
+
+// workflowConflict returns why a learner exam status or grade change is refused by the moderation workflow,
+// empty when the change is allowed. before is the learner exam as stored, nil when it was not found
+func workflowConflict(before *models.LearnerExam, status string, grade sql.NullInt32) string {
+	released := before != nil && before.Status.String == "marked"
+	switch {
+	case released && (status != "marked" || grade != before.Grade):
+		return errResultReleased
+	case !released && status == "marked":
+		return errMarkedByApproval
+	}
+	return ""
+}
+
+// MarkRequest is the body of a first marking or moderation, OutOf is ignored for a moderation
+type MarkRequest struct {
+	Marks   []database.Mark `json:"marks"`
+	Comment string          `json:"comment"`
+}
+
+// markingStep starts a workflow step for the logged in user or API key
+func markingStep(c echo.Context, comment string) *database.MarkingStep {
+	userid, _ := currentUser(c)
+	return &database.MarkingStep{ActorID: userid, Actor: currentUsername(c), Comment: strings.TrimSpace(comment)}
+}
+
+// isModerator checks if the logged in user is the moderator of the offering
+func (a *App) isModerator(c echo.Context, examid string) bool {
+	userid, role := currentUser(c)
+	if role != RoleFaculty || isAPIKeyRequest(c) {
+		return false
+	}
+	moderatorid, err := a.db(c).GetModerator(examid)
+	return err == nil && moderatorid != 0 && moderatorid == userid
+}
+
+// isCoordinator checks if the logged in user coordinates the offering, an Admin stands in for every coordinator
+func (a *App) isCoordinator(c echo.Context, examid string) bool {
+	if isAPIKeyRequest(c) {
+		return false
+	}
+	if isAdmin(c) {
+		return true
+	}
+	userid, _ := currentUser(c)
+	offering, err := a.db(c).GetOfferingByID(examid)
+	return err == nil && offering != nil && offering.Coordinator.String == strconv.Itoa(userid)
+}
+
+// MarkingAccess middleware for the marking routes, the moderator of an offering can reach its scripts
+// along with the staff that can access the offering
+func (a *App) MarkingAccess(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		examid := c.Param("examid")
+		if !a.canAccessOffering(c, examid) && !a.isModerator(c, examid) {
+			return forbidden(c)
+		}
+		return next(c)
+	}
+}
+
+// validateMarks checks the per question marks of a first marking, questions must be unique
+func validateMarks(marks []database.Mark) string {
+	if len(marks) == 0 {
+		return "At least one question mark is required"
+	}
+	seen := map[string]bool{}
+	for i := range marks {
+		mark := &marks[i]
+		mark.Question = strings.TrimSpace(mark.Question)
+		if mark.Question == "" || len(mark.Question) > maxQuestionLength {
+			return "Each mark needs a question of up to 32 characters"
+		}
+		if seen[mark.Question] {
+			return "Question " + mark.Question + " is marked more than once"
+		}
+		seen[mark.Question] = true
+		if mark.OutOf <= 0 || mark.Mark < 0 || mark.Mark > mark.OutOf {
+			return "The mark for question " + mark.Question + " must be between 0 and its out of value"
+		}
+		if len(mark.Comment) > maxCommentLength {
+			return "The comment for question " + mark.Question + " is too long"
+		}
+	}
+	return ""
+}
+
+// HandleGetMarking returns the moderation state of an offering - its moderator, the state of every
+// learner exam and the offering level steps
+func (a *App) HandleGetMarking(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.JSON(http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+
+	examid := c.Param("examid")
+	offering, err := a.db(c).GetOfferingByID(examid)
+	if err != nil || offering == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Exam offering not found"})
+	}
+
+	moderatorid, err := a.db(c).GetModerator(examid)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
+	}
+	moderator := ""
+	if moderatorid != 0 {
+		if user, err := a.db(c).GetUserByID(moderatorid); err == nil {
+			moderator = user.Username
+		}
+	}
+
+	scripts, err := a.db(c).GetScriptStates(examid)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
+	}
+	counts := map[string]int{
+		database.ScriptUnmarked:  0,
+		database.ScriptSubmitted: 0,
+		database.ScriptModerated: 0,
+		database.ScriptReleased:  0,
+	}
+	for _, script := range scripts {
+		counts[script.State]++
+	}
+
+	steps, err := a.db(c).GetMarkingSteps(examid, "")
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"examid":       examid,
+		"coordinator":  offering.Coordinator.String,
+		"moderator_id": moderatorid,
+		"moderator":    moderator,
+		"counts":       counts,
+		"scripts":      scripts,
+		"steps":        steps,
+	})
+}
+
+// HandleGetScriptMarking returns the marking of a learner exam - its state, the first and moderated marks,
+// the final marks and every step
+func (a *App) HandleGetScriptMarking(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.JSON(http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+
+	examid, studentid := c.Param("examid"), c.Param("studentid")
+	script, err := a.db(c).GetScriptState(studentid, examid)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Learner exam not found"})
+	}
+
+	first, err := a.db(c).GetMarks(studentid, examid, database.StageFirst)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
+	}
+	moderated, err := a.db(c).GetMarks(studentid, examid, database.StageModerated)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
+	}
+	steps, err := a.db(c).GetMarkingSteps(examid, studentid)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
+	}
+
+	// the final mark of a question is the moderated mark when there is one
+	adjusted := map[string]database.Mark{}
+	for _, mark := range moderated {
+		adjusted[mark.Question] = mark
+	}
+	final := make([]database.Mark, 0, len(first))
+	for _, mark := range first {
+		if adj, ok := adjusted[mark.Question]; ok {
+			mark = adj
+		}
+		final = append(final, mark)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"script":          script,
+		"first_marks":     first,
+		"moderated_marks": moderated,
+		"final_marks":     final,
+		"steps":           steps,
+	})
+}
+
+// HandlePutModerator assigns the moderator of an offering. Only the coordinator or an Admin can assign,
+// and the moderator must be another active Faculty user
+func (a *App) HandlePutModerator(c echo.Context) error {
+	// Check if request is a PUT request
+	if c.Request().Method != http.MethodPut {
+		return c.JSON(http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+
+	examid := c.Param("examid")
+	if !a.isCoordinator(c, examid) {
+		return forbidden(c)
+	}
+
+	var req struct {
+		ModeratorID int    `json:"moderator_id"`
+		Comment     string `json:"comment"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+
+	moderator, err := a.db(c).GetUserByID(req.ModeratorID)
+	if err != nil || !moderator.Active || moderator.Role != RoleFaculty {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "The moderator must be an active Faculty user"})
+	}
+	offering, err := a.db(c).GetOfferingByID(examid)
+	if err != nil || offering == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Exam offering not found"})
+	}
+	if offering.Coordinator.String == strconv.Itoa(moderator.UserID) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "The coordinator cannot moderate their own offering"})
+	}
+
+	moderatorAudit := func() any {
+		moderatorid, err := a.db(c).GetModerator(examid)
+		if err != nil {
+			return nil
+		}
+		return map[string]int{"moderator_id": moderatorid}
+	}
+	auditChange(c, "moderator", examid, moderatorAudit(), moderatorAudit)
+
+	step := markingStep(c, req.Comment)
+	step.Comment = strings.TrimSpace("moderator " + moderator.Username + " " + step.Comment)
+	if err := a.db(c).AssignModerator(examid, moderator.UserID, step); err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error assigning the moderator", err)
+	}
+
+	a.handleLogger(c, "Moderator "+moderator.Username+" assigned to "+examid+" by "+currentUsername(c))
+	return c.JSON(http.StatusOK, map[string]string{"message": "Moderator assigned"})
+}
+
+// HandlePutFirstMarks stores the first marker's per question marks of a closed or expired learner exam.
+// The marks can be resubmitted until the script has been moderated
+func (a *App) HandlePutFirstMarks(c echo.Context) error {
+	// Check if request is a PUT request
+	if c.Request().Method != http.MethodPut {
+		return c.JSON(http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+
+	examid, studentid := c.Param("examid"), c.Param("studentid")
+	// the moderator reaches the scripts through MarkingAccess but is not a first marker
+	if !a.canAccessOffering(c, examid) || a.isModerator(c, examid) {
+		return forbidden(c)
+	}
+
+	var req MarkRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+	if msg := validateMarks(req.Marks); msg != "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+	}
+
+	script, err := a.db(c).GetScriptState(studentid, examid)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Learner exam not found"})
+	}
+	if script.Status != "closed" && script.Status != "expire" {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Only closed or expired exams can be marked"})
+	}
+	if script.State != database.ScriptUnmarked && script.State != database.ScriptSubmitted {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "The marks cannot be changed once the script has been moderated"})
+	}
+
+	// record the first marks as they were and as stored, marks must be traceable for an academic board review
+	a.auditMarks(c, studentid, examid, database.StageFirst)
+
+	if err := a.db(c).SubmitMarks(studentid, examid, database.StageFirst, req.Marks, markingStep(c, req.Comment)); err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error saving the marks", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Marks submitted for moderation"})
+}
+
+// HandlePutModeration stores the moderator's review of a learner exam. Marks adjust the first marks of
+// the listed questions, an empty list agrees with the first marking. The review can be replaced until the
+// results are approved
+func (a *App) HandlePutModeration(c echo.Context) error {
+	// Check if request is a PUT request
+	if c.Request().Method != http.MethodPut {
+		return c.JSON(http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+
+	examid, studentid := c.Param("examid"), c.Param("studentid")
+	if !a.isModerator(c, examid) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Only the moderator of the offering can moderate its scripts"})
+	}
+
+	var req MarkRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+
+	script, err := a.db(c).GetScriptState(studentid, examid)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Learner exam not found"})
+	}
+	if script.State != database.ScriptSubmitted && script.State != database.ScriptModerated {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "Only scripts submitted by the first marker can be moderated"})
+	}
+	userid, _ := currentUser(c)
+	if script.FirstMarkerID == userid {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "A moderator cannot moderate their own marking"})
+	}
+
+	// the adjusted questions must have been marked, they keep the first marker's out of value
+	first, err := a.db(c).GetMarks(studentid, examid, database.StageFirst)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
+	}
+	outOf := map[string]float64{}
+	for _, mark := range first {
+		outOf[mark.Question] = mark.OutOf
+	}
+	for i := range req.Marks {
+		question := strings.TrimSpace(req.Marks[i].Question)
+		if _, ok := outOf[question]; !ok {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "Question " + question + " was not marked by the first marker"})
+		}
+		req.Marks[i].OutOf = outOf[question]
+		if strings.TrimSpace(req.Marks[i].Comment) == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "A comment is required for the adjusted mark of question " + question})
+		}
+	}
+	if len(req.Marks) > 0 {
+		if msg := validateMarks(req.Marks); msg != "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+		}
+	}
+
+	// record the moderator's adjusted marks as they were and as stored
+	a.auditMarks(c, studentid, examid, database.StageModerated)
+
+	if err := a.db(c).SubmitMarks(studentid, examid, database.StageModerated, req.Marks, markingStep(c, req.Comment)); err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error saving the moderation", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": "Moderation saved"})
+}
+
+// HandlePostApproveMarking releases the results of an offering. The coordinator, or an Admin, approves once
+// no exam is in progress, every closed or expired exam has been marked and at least one has been moderated
+func (a *App) HandlePostApproveMarking(c echo.Context) error {
+	// Check if request is a POST request
+	if c.Request().Method != http.MethodPost {
+		return c.JSON(http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+
+	examid := c.Param("examid")
+	if !a.isCoordinator(c, examid) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Only the coordinator of the offering can approve its results"})
+	}
+
+	var req struct {
+		Comment string `json:"comment"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request body"})
+	}
+
+	moderatorid, err := a.db(c).GetModerator(examid)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
+	}
+	if moderatorid == 0 {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "A moderator must be assigned before the results are approved"})
+	}
+
+	scripts, err := a.db(c).GetScriptStates(examid)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
+	}
+	moderated, pending := 0, map[string]bool{}
+	for _, script := range scripts {
+		switch {
+		case script.Status == "active":
+			return c.JSON(http.StatusConflict, map[string]string{"error": "Learner " + script.StudentID + " is still sitting the exam"})
+		case script.State == database.ScriptModerated:
+			moderated++
+			pending[script.StudentID] = true
+		case script.State == database.ScriptSubmitted:
+			pending[script.StudentID] = true
+		case script.State == database.ScriptUnmarked && (script.Status == "closed" || script.Status == "expire"):
+			return c.JSON(http.StatusConflict, map[string]string{"error": "The exam of learner " + script.StudentID + " has not been marked"})
+		}
+	}
+	if len(pending) == 0 {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "There are no marked results to approve"})
+	}
+	if moderated == 0 {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "At least one script must be moderated before the results are approved"})
+	}
+
+	// record the status and grade of every released learner exam before and after the approval
+	auditChange(c, "results", examid, resultsAudit(scripts, pending), func() any {
+		after, _ := a.db(c).GetScriptStates(examid)
+		return resultsAudit(after, pending)
+	})
+
+	released, err := a.db(c).ApproveResults(examid, markingStep(c, req.Comment))
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error approving the results", err)
+	}
+
+	a.handleLogger(c, "Results of "+examid+" approved by "+currentUsername(c)+", "+strconv.Itoa(released)+" released")
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":  "Results approved and released",
+		"released": released,
+	})
+}
+
+// auditMarks records the marks of a learner exam at a stage before and after the request changes them
+func (a *App) auditMarks(c echo.Context, studentid, examid, stage string) {
+	before, _ := a.db(c).GetMarks(studentid, examid, stage)
+	auditChange(c, "marks", studentid+"/"+examid+"/"+stage, before, func() any {
+		after, _ := a.db(c).GetMarks(studentid, examid, stage)
+		return after
+	})
+}
+
+// resultsAudit is the snapshot of the status and grade of the learner exams of an approval, by student ID
+func resultsAudit(scripts []database.ScriptState, studentids map[string]bool) any {
+	snapshot := map[string]interface{}{}
+	for _, script := range scripts {
+		if studentids[script.StudentID] {
+			snapshot[script.StudentID] = map[string]interface{}{"status": script.Status, "grade": script.Grade}
+		}
+	}
+	return snapshot
+}
@@ -0,0 +1,164 @@
+package app
+
+import (
+	"io/fs"
+	"net/http"
+	"net/netip"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"ADS4/internal/metrics"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+/* Prometheus metrics
+   - GET /metrics serves the metrics of internal/metrics plus the active attempts, the bytes stored under
+     DataDir/learners and DataDir/exams, and the database connection pool stats
+   - scrapers are let in by address (METRICS_ALLOW) or with an API key with the metrics:read scope
+   - the HTTP histogram is labelled with the route pattern e.g. /api/learnerexam/:studentid/:examid, never
+     the request path, so the learner and exam IDs do not become labels
+*/
+
+// the stored bytes are counted by walking the folders, at most once per storedBytesTTL
+const storedBytesTTL = time.Minute
+
+// the data folders counted in ads4_stored_bytes
+var storedAreas = []string{"learners", "exams"}
+
+// registerMetrics adds the metrics that read the database and the data folder
+func (a *App) registerMetrics() {
+	stored := &storedBytes{dataDir: a.DataDir}
+	metrics.Registry.MustRegister(
+		collectors.NewDBStatsCollector(a.DB.DB, "ads4"),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "ads4_active_attempts",
+			Help: "Learner exams in progress.",
+		}, func() float64 {
+			active, err := a.DB.CountActiveAttempts()
+			if err != nil {
+				a.handleLogger(nil, "Error counting the active attempts: "+err.Error())
+				return 0
+			}
+			return float64(active)
+		}),
+		stored,
+	)
+
+	// the failure counts start at zero rather than appearing on the first failure
+	for scope := range lockoutThresholds {
+		metrics.AuthFailures.WithLabelValues(scope)
+	}
+}
+
+// HTTPMetrics middleware observes the latency and status of each request by route
+func HTTPMetrics(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		start := time.Now()
+		err := next(c)
+
+		status := c.Response().Status
+		if err != nil {
+			// the error has not been written yet, take the status it will be written with
+			if he, ok := err.(*echo.HTTPError); ok {
+				status = he.Code
+			} else {
+				status = http.StatusInternalServerError
+			}
+		}
+		route := c.Path()
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.HTTPDuration.WithLabelValues(c.Request().Method, route, strconv.Itoa(status)).
+			Observe(time.Since(start).Seconds())
+		return err
+	}
+}
+
+// MetricsAccess middleware lets in the addresses of METRICS_ALLOW, and API keys with the metrics:read scope
+func (a *App) MetricsAccess(next echo.HandlerFunc) echo.HandlerFunc {
+	keyAuth := a.APIKeyAuth(next)
+	return func(c echo.Context) error {
+		if addr, err := netip.ParseAddr(c.RealIP()); err == nil {
+			addr = addr.Unmap()
+			if slices.ContainsFunc(a.Config.MetricsAllow, func(prefix netip.Prefix) bool {
+				return prefix.Contains(addr)
+			}) {
+				return next(c)
+			}
+		}
+		if _, found := bearerKey(c); found {
+			return keyAuth(c)
+		}
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "Metrics are not available to this client"})
+	}
+}
+
+// GET /metrics
+// HandleGetMetrics serves the metrics in the Prometheus text format
+func (a *App) HandleGetMetrics(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.JSON(http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+
+	handler := promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{})
+	handler.ServeHTTP(c.Response(), c.Request())
+	return nil
+}
+
+// storedBytes collects the bytes of the files under the data folders, cached for storedBytesTTL
+type storedBytes struct {
+	dataDir string
+
+	mu      sync.Mutex
+	counted time.Time
+	bytes   map[string]int64
+}
+
+var storedBytesDesc = prometheus.NewDesc("ads4_stored_bytes",
+	"Bytes of the files stored under the data folder by area (learners, exams).", []string{"area"}, nil)
+
+func (s *storedBytes) Describe(ch chan<- *prometheus.Desc) {
+	ch <- storedBytesDesc
+}
+
+func (s *storedBytes) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if time.Since(s.counted) > storedBytesTTL {
+		s.bytes = make(map[string]int64, len(storedAreas))
+		for _, area := range storedAreas {
+			s.bytes[area] = folderSize(filepath.Join(s.dataDir, area))
+		}
+		s.counted = time.Now()
+	}
+	for _, area := range storedAreas {
+		ch <- prometheus.MustNewConstMetric(storedBytesDesc, prometheus.GaugeValue, float64(s.bytes[area]), area)
+	}
+}
+
+// folderSize adds up the size of the files under root, skipping what cannot be read
+func folderSize(root string) int64 {
+	var total int64
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
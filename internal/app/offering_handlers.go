@@ -15,8 +15,8 @@ import (
 //-------------------------------------------------------------------------------------------------------------
 //The Handlers below are used for the leaner CRUD interfaces within the ADS and the Assessment Marker Tool
 
-// HandleGetAllOfferings fetches all active exams from the database with optional filtering by status code
-// and returns the results as JSON
+// HandleGetAllOfferings fetches a page of exam offerings from the database with optional filtering by exam ID, year,
+// semester and status code and returns the results as JSON in the paging envelope
 func (a *App) HandleGetAllOfferings(c echo.Context) error {
 	// Check if request if a GET request
 	if c.Request().Method != http.MethodGet {
@@ -27,13 +27,15 @@ func (a *App) HandleGetAllOfferings(c echo.Context) error {
 	statusCode := c.QueryParam("status")
 	semester := c.QueryParam("semester")
 
-	examOfferings, err := a.DB.GetAllOfferings(examID, year, semester, statusCode)
+	opts := scopedListOptions(c)
+
+	examOfferings, total, err := a.db(c).GetAllOfferings(examID, year, semester, statusCode, opts)
 	if err != nil {
 		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
 	}
 
 	// Return the results as JSON
-	return c.JSON(http.StatusOK, examOfferings)
+	return c.JSON(http.StatusOK, newPagedResponse(c, examOfferings, total, opts))
 }
 
 // HandleGetOfferingByID fetches a single exam offering by ID from the database and returns the result as JSON
@@ -45,13 +47,15 @@ func (a *App) HandleGetOfferingByID(c echo.Context) error {
 
 	// Get the exam ID from the URL
 	examID := c.Param("examid")
-	//examID, err := strconv.Atoi(examIDStr)
-	if examID != "" {
-		return c.Redirect(http.StatusSeeOther, "Invalid exam ID")
+	if examID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid exam ID"})
 	}
 
 	// Fetch the exam from the database
-	offering, err := a.DB.GetOfferingByID(examID)
+	offering, err := a.db(c).GetOfferingByID(examID)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "Exam offering not found"})
+	}
 	if err != nil {
 		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
 	}
@@ -88,19 +92,23 @@ func (a *App) HandlePostOffering(c echo.Context) error {
 	duration := c.FormValue("duration")
 
 	// Validate input
-	offering, err := validateOffering(examID, coursecode, password, coordinator, ownerid, status, year, semester, duration)
+	offering, err := validateOffering(examID, coursecode, year, semester, password, coordinator, ownerid, status, duration)
 	if err != nil {
-		a.handleLogger("Error validating exam offering: " + err.Error())
+		a.handleLogger(c, "Error validating exam offering: "+err.Error())
 		// Redirect to dashboard with error message
 		return c.Redirect(http.StatusSeeOther, "/dashboard?error="+"Error validating exam offering: "+err.Error())
 	}
 
 	// Insert new exma offering exam
-	err = a.DB.AddExamOffering(offering)
+	err = a.db(c).AddExamOffering(offering)
 	if err != nil {
-		a.handleLogger("Error adding exam offering: " + err.Error())
+		a.handleLogger(c, "Error adding exam offering: "+err.Error())
 		return c.Redirect(http.StatusSeeOther, "/dashboard?error="+err.Error())
 	}
+	auditChange(c, "offering", examID, nil, func() any {
+		after, _ := a.db(c).GetOfferingByID(examID)
+		return offeringAudit(after)
+	})
 
 	// Redirect to dashboard with success message
 	return c.Redirect(http.StatusFound, "/dashboard?message=Exam offering added successfully")
@@ -125,26 +133,48 @@ func (a *App) HandlePutOffering(c echo.Context) error {
 	// Parse form data from the request body
 	var offering models.OfferingsDto
 	if err := c.Bind(&offering); err != nil {
-		a.handleLogger("Error binding exam offering request body: " + err.Error())
+		a.handleLogger(c, "Error binding exam offering request body: "+err.Error())
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error":       "Invalid exam offering request body",
 			"redirectURL": "/dashboard?error=Invalid exam offering request body",
 		})
 	}
 
-	// Validate input
-	Offering, err := validateOffering(offering.ExamID, offering.CourseCode, offering.Year, offering.Semester,
+	// Only an Admin can reassign the coordinator and owner of an offering
+	if !isAdmin(c) {
+		existing, err := a.db(c).GetOfferingByID(examid)
+		if err != nil {
+			return c.JSON(http.StatusNotFound, map[string]string{
+				"error":       "Exam offering not found",
+				"redirectURL": "/dashboard?error=Exam offering not found"})
+		}
+		offering.Coordinator = existing.Coordinator.String
+		offering.OwnerID = existing.OwnerID.String
+	}
+
+	// Validate input - the exam ID is the primary key and is taken from the URL not the body
+	Offering, err := validateOffering(examid, offering.CourseCode, offering.Year, offering.Semester,
 		offering.Password, offering.Coordinator, offering.OwnerID, offering.Status, offering.Duration)
 	if err != nil {
-		a.handleLogger("Error validating exam offering: " + err.Error())
+		a.handleLogger(c, "Error validating exam offering: "+err.Error())
 		// Redirect to dashboard with error message
 		return c.Redirect(http.StatusSeeOther, "/dashboard?error="+"Error validating exam offering: "+err.Error())
 	}
 
+	// record the offering as it was and as it is stored after the update, the password is only noted
+	before, _ := a.db(c).GetOfferingByID(examid)
+	auditChange(c, "offering", examid, offeringAudit(before), func() any {
+		after, _ := a.db(c).GetOfferingByID(examid)
+		return offeringAudit(after)
+	})
+	if before != nil && before.Password.String != Offering.Password.String {
+		auditDetail(c, "", "exam password changed")
+	}
+
 	// Update the exam in the database
-	err = a.DB.UpdateOffering(Offering)
+	err = a.db(c).UpdateOffering(Offering)
 	if err != nil {
-		a.handleLogger("Error updating exam offering: " + err.Error())
+		a.handleLogger(c, "Error updating exam offering: "+err.Error())
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Error updating exam offering: " + err.Error(),
 			"redirectURL": "/dashboard?error=" + err.Error()})
 	}
@@ -153,6 +183,22 @@ func (a *App) HandlePutOffering(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]string{"message": "exam updated successfully", "redirectURL": "/dashboard?message=exam updated successfully"})
 }
 
+// offeringAudit is the snapshot of an offering kept in the audit trail, without the exam password
+func offeringAudit(offering *models.Offerings) any {
+	if offering == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"coursecode":  offering.CourseCode.String,
+		"year":        offering.Year,
+		"semester":    offering.Semester.String,
+		"status":      offering.Status.String,
+		"coordinator": offering.Coordinator.String,
+		"ownerid":     offering.OwnerID.String,
+		"duration":    offering.Duration,
+	}
+}
+
 func validateOffering(examid, coursecode, year, semester, password, coordinator, ownerid, status, duration string) (*models.Offerings, error) {
 	const (
 		ErrExamIDRequired          string = "exam ID is required"
@@ -269,8 +315,12 @@ func (a *App) HandleDeleteOffering(c echo.Context) error {
 		})
 	}
 
+	// keep the deleted offering in the audit trail
+	before, _ := a.db(c).GetOfferingByID(examid)
+	auditChange(c, "offering", examid, offeringAudit(before), nil)
+
 	// Delete the exam from the database
-	err := a.DB.DeleteOffering(examid)
+	err := a.db(c).DeleteOffering(examid)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error":       "Error deleting exam offering",
@@ -317,7 +367,7 @@ func (a *App) HandlePutOfferingStatus(c echo.Context) error {
 	}
 
 	// Validate offering exists
-	offering, err := a.DB.GetOfferingByID(examid)
+	offering, err := a.db(c).GetOfferingByID(examid)
 	if err != nil {
 		return c.JSON(http.StatusNotFound, map[string]string{
 			"error":       "Exam offering not found",
@@ -339,11 +389,16 @@ func (a *App) HandlePutOfferingStatus(c echo.Context) error {
 	}
 
 	// Log the incoming data
-	a.handleLogger("Exam ID: " + examid)
-	a.handleLogger("Status: " + req.Status)
+	a.handleLogger(c, "Exam ID: "+examid)
+	a.handleLogger(c, "Status: "+req.Status)
+
+	auditChange(c, "offering", examid, offeringAudit(offering), func() any {
+		after, _ := a.db(c).GetOfferingByID(examid)
+		return offeringAudit(after)
+	})
 
 	// Update the exam status in the database
-	err = a.DB.UpdateOfferingStatus(examid, req.Status)
+	err = a.db(c).UpdateOfferingStatus(examid, req.Status)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error":       "Failed to update exam offering status",
@@ -0,0 +1,76 @@
+package app
+
+import (
+	"strconv"
+	"strings"
+
+	"ADS4/internal/database"
+
+	"github.com/labstack/echo/v4"
+)
+
+/*
+	Common paging envelope for the list endpoints
+	e.g. /api/learner?page=2&page_size=100&sort=studentname&order=desc&search=smith
+*/
+
+// PagedResponse is the envelope returned by all the list endpoints
+type PagedResponse struct {
+	Data     any    `json:"data"`
+	Total    int    `json:"total"`
+	Page     int    `json:"page"`
+	PageSize int    `json:"page_size"`
+	Pages    int    `json:"pages"`
+	Next     string `json:"next"` // empty on the last page
+	Prev     string `json:"prev"` // empty on the first page
+}
+
+// parseListOptions reads the page, page_size, sort, order and search query parameters
+func parseListOptions(c echo.Context) database.ListOptions {
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	pageSize, _ := strconv.Atoi(c.QueryParam("page_size"))
+
+	opts := database.ListOptions{
+		Page:     page,
+		PageSize: pageSize,
+		Sort:     c.QueryParam("sort"),
+		Desc:     strings.EqualFold(c.QueryParam("order"), "desc"),
+		Search:   c.QueryParam("search"),
+	}
+	opts.Normalise()
+	return opts
+}
+
+// newPagedResponse wraps a page of results with the totals and the links to the neighbouring pages
+func newPagedResponse(c echo.Context, data any, total int, opts database.ListOptions) PagedResponse {
+	pages := (total + opts.PageSize - 1) / opts.PageSize
+
+	resp := PagedResponse{
+		Data:     data,
+		Total:    total,
+		Page:     opts.Page,
+		PageSize: opts.PageSize,
+		Pages:    pages,
+	}
+	if opts.Page < pages {
+		resp.Next = pageLink(c, opts.Page+1)
+	}
+	if opts.Page > 1 {
+		resp.Prev = pageLink(c, opts.Page-1)
+	}
+	return resp
+}
+
+// pageLink returns the current request URL with the page parameter replaced
+func pageLink(c echo.Context, page int) string {
+	u := *c.Request().URL
+	// the trailing slash middleware runs after routing, the routes have no trailing slash
+	if u.Path != "/" {
+		u.Path = strings.TrimSuffix(u.Path, "/")
+		u.RawPath = strings.TrimSuffix(u.RawPath, "/")
+	}
+	q := u.Query()
+	q.Set("page", strconv.Itoa(page))
+	u.RawQuery = q.Encode()
+	return u.RequestURI()
+}
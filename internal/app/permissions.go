@@ -0,0 +1,163 @@
+package app
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ADS4/internal/database"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+/*
+	Permission model
+	- Admin   - global rights over all users, courses, learners, offerings, enrolments and marks
+	- Faculty - can only view and change the offerings they coordinate or own (Offerings.Coordinator/OwnerID)
+	            along with the learner enrolments, exams and marks for those offerings
+	- Learner - no staff rights, the portal only shows the learner record linked to the account (UserT.StudentID)
+
+	Single offering routes are guarded by the OfferingAccess middleware, list routes are
+	restricted in the database queries through ListOptions.StaffID. Portal routes never take a
+	student ID from the request - the LearnerOnly middleware resolves it from the account
+*/
+
+const (
+	RoleAdmin   = "Admin"
+	RoleFaculty = "Faculty"
+	RoleLearner = "Learner"
+)
+
+// currentUser returns the user ID and role of the logged in user from the JWT claims
+func currentUser(c echo.Context) (int, string) {
+	token, ok := c.Get("user").(*jwt.Token)
+	if !ok {
+		return 0, ""
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, ""
+	}
+	userid, _ := claims["user_id"].(string)
+	role, _ := claims["role"].(string)
+	uid, _ := strconv.Atoi(userid)
+	return uid, role
+}
+
+// currentUsername returns the username of the logged in user from the JWT claims
+func currentUsername(c echo.Context) string {
+	token, ok := c.Get("user").(*jwt.Token)
+	if !ok {
+		return ""
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return ""
+	}
+	username, _ := claims["username"].(string)
+	return username
+}
+
+// isAdmin checks if the logged in user holds the global Admin role
+func isAdmin(c echo.Context) bool {
+	_, role := currentUser(c)
+	return role == RoleAdmin
+}
+
+// staffScope returns the user ID the list queries should be restricted to - 0 for an Admin who sees everything
+func staffScope(c echo.Context) int {
+	userid, role := currentUser(c)
+	if role == RoleAdmin {
+		return 0
+	}
+	return userid
+}
+
+// scopedListOptions parses the list options and restricts them to the offerings of the logged in user
+func scopedListOptions(c echo.Context) database.ListOptions {
+	opts := parseListOptions(c)
+	opts.StaffID = staffScope(c)
+	return opts
+}
+
+// canAccessOffering checks if the logged in user may view and change the offering identified by examid
+func (a *App) canAccessOffering(c echo.Context, examid string) bool {
+	userid, role := currentUser(c)
+	if role == RoleAdmin {
+		return true
+	}
+	if role != RoleFaculty {
+		return false
+	}
+	return a.db(c).CanAccessOffering(examid, userid)
+}
+
+// forbidden is the common response when a user tries to reach a resource outside their rights
+func forbidden(c echo.Context) error {
+	return c.JSON(http.StatusForbidden, map[string]string{
+		"error":       "You do not have permission to access this resource",
+		"redirectURL": "/dashboard?error=You do not have permission to access this resource",
+	})
+}
+
+// StaffOnly middleware where Admin and Faculty are staff. What Faculty can reach is further
+// restricted by OfferingAccess and the scoped list queries
+func (a *App) StaffOnly(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		_, role := currentUser(c)
+		if role != RoleAdmin && role != RoleFaculty {
+			return c.Redirect(http.StatusSeeOther, "/dashboard?error=You%20do%20not%20have%20permission%20to%20access%20this%20page")
+		}
+		return next(c)
+	}
+}
+
+// AdminOnly middleware for the routes that need global rights e.g. user account management, imports,
+// course and learner maintenance and creating or removing offerings
+func (a *App) AdminOnly(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !isAdmin(c) {
+			return forbidden(c)
+		}
+		return next(c)
+	}
+}
+
+// OfferingAccess middleware for the routes carrying an :examid parameter. Faculty must coordinate
+// or own the offering, Admin can access all offerings
+func (a *App) OfferingAccess(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if !a.canAccessOffering(c, c.Param("examid")) {
+			return forbidden(c)
+		}
+		return next(c)
+	}
+}
+
+// LearnerOnly middleware for the learner portal. The linked student ID is read from the account on every
+// request, so unlinking or deactivating the account takes effect immediately, and stored in the context
+func (a *App) LearnerOnly(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userid, role := currentUser(c)
+		if role != RoleLearner {
+			return c.Redirect(http.StatusSeeOther, "/dashboard?error=You%20do%20not%20have%20permission%20to%20access%20this%20page")
+		}
+		user, err := a.db(c).GetUserByID(userid)
+		if err != nil || !user.Active || user.StudentID == "" {
+			if strings.HasPrefix(c.Path(), "/api/") {
+				return forbidden(c)
+			}
+			// the portal page sends the learner back to the login page, the dashboard would redirect back here
+			return c.Redirect(http.StatusSeeOther, "/logout?message=Your account is not linked to an active learner record")
+		}
+		c.Set("studentid", user.StudentID)
+		return next(c)
+	}
+}
+
+// portalStudentID returns the student ID of the logged in learner as resolved by LearnerOnly
+func portalStudentID(c echo.Context) string {
+	studentid, _ := c.Get("studentid").(string)
+	return studentid
+}
@@ -0,0 +1,104 @@
+package app
+
+import (
+	"net/http"
+
+	"ADS4/internal/database"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+/* Learner self-service portal
+   - upcoming exams - enrolments in an active offering that have not been sat yet (ready, active)
+   - attempts       - every enrolment with the attempt times and status
+   - results        - released grades and feedback (marked exams)
+
+   All the portal routes run behind LearnerOnly and only ever use the student ID linked to the account
+*/
+
+// HandleGetPortal serves the learner portal page
+func (a *App) HandleGetPortal(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.Render(http.StatusMethodNotAllowed, "index.html", map[string]interface{}{
+			"error": "Method not allowed",
+		})
+	}
+
+	user := c.Get("user").(*jwt.Token)
+	claims := user.Claims.(jwt.MapClaims)
+
+	// the learner name is shown in the page header
+	learnername := ""
+	if learner, err := a.db(c).GetLearnerByID(portalStudentID(c)); err == nil {
+		learnername = learner.StudentName.String
+	}
+
+	return c.Render(http.StatusOK, "portal.html", map[string]interface{}{
+		"username":    claims["username"],
+		"role":        claims["role"],
+		"email":       claims["email"],
+		"user_id":     claims["user_id"],
+		"studentid":   portalStudentID(c),
+		"learnername": learnername,
+	})
+}
+
+// HandleGetPortalUpcoming returns the exams the learner is still to sit in the active offerings
+func (a *App) HandleGetPortalUpcoming(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.JSON(http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+
+	enrolments, err := a.db(c).GetLearnerEnrolments(portalStudentID(c), 0)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching upcoming exams", err)
+	}
+
+	upcoming := []database.Enrolment{}
+	for _, enrolment := range enrolments {
+		if enrolment.OfferingStatus != "active" {
+			continue
+		}
+		if enrolment.Status == "ready" || enrolment.Status == "active" {
+			upcoming = append(upcoming, enrolment)
+		}
+	}
+
+	// Return the results as JSON
+	return c.JSON(http.StatusOK, upcoming)
+}
+
+// HandleGetPortalAttempts returns the learner's full attempt history with the status of each attempt
+func (a *App) HandleGetPortalAttempts(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.JSON(http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+
+	enrolments, err := a.db(c).GetLearnerEnrolments(portalStudentID(c), 0)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching exam attempts", err)
+	}
+
+	// Return the results as JSON
+	return c.JSON(http.StatusOK, enrolments)
+}
+
+// HandleGetPortalResults returns the released grades and marker feedback of the learner
+func (a *App) HandleGetPortalResults(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.JSON(http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+
+	results, err := a.db(c).GetLearnerResults(portalStudentID(c), 0)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching exam results", err)
+	}
+
+	// Return the results as JSON
+	return c.JSON(http.StatusOK, results)
+}
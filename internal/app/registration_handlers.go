@@ -0,0 +1,216 @@
+package app
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ADS4/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+/* Self-registration approval workflow
+   - HandlePostRegister places new accounts in the pending queue (inactive)
+   - an admin approves with a role (Faculty/Learner) or rejects with a reason
+   - the user is emailed the outcome, a rejected user sees the reason when trying to log in
+   - registration can be restricted to the email domains in REGISTRATION_DOMAINS
+*/
+
+// HandleGetPendingRegistrations fetches a page of the accounts waiting for approval as JSON in the paging envelope
+func (a *App) HandleGetPendingRegistrations(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.Redirect(http.StatusSeeOther, "/admin?error=Method not allowed")
+	}
+
+	opts := parseListOptions(c)
+
+	users, total, err := a.db(c).GetPendingRegistrations(opts)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
+	}
+
+	// Return the results as JSON
+	return c.JSON(http.StatusOK, newPagedResponse(c, users, total, opts))
+}
+
+// HandlePostApproveRegistration activates a pending account with the role chosen by the admin
+func (a *App) HandlePostApproveRegistration(c echo.Context) error {
+	// Check if request is a POST request
+	if c.Request().Method != http.MethodPost {
+		return c.Redirect(http.StatusSeeOther, "/admin?error=Method not allowed")
+	}
+
+	user, err := a.pendingRegistration(c, c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error":       "Pending registration not found",
+			"redirectURL": "/admin?error=Pending registration not found"})
+	}
+
+	// Create a struct to bind the JSON request body
+	type ApproveRequest struct {
+		Role      string `json:"role"`
+		StudentID string `json:"studentid"`
+	}
+
+	var req ApproveRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid request body",
+			"redirectURL": "/admin?error=Invalid request body"})
+	}
+
+	// Registrations are approved as staff or learner accounts, admin rights are granted through user management
+	if req.Role != RoleFaculty && req.Role != RoleLearner {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Role must be Faculty or Learner",
+			"redirectURL": "/admin?error=Role must be Faculty or Learner"})
+	}
+
+	// Learner accounts are linked to the learner record shown in the portal
+	studentid, err := a.validateUserLearner(req.Role, req.StudentID, user.UserID)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       err.Error(),
+			"redirectURL": "/admin?error=" + err.Error()})
+	}
+
+	a.auditRegistration(c, user)
+	err = a.db(c).ApproveRegistration(user.UserID, req.Role, studentid)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error":       "Registration is no longer pending",
+			"redirectURL": "/admin?error=Registration is no longer pending"})
+	}
+	if err != nil {
+		a.handleLogger(c, "Error approving registration: "+err.Error())
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error":       "Error approving registration",
+			"redirectURL": "/admin?error=Error approving registration"})
+	}
+
+	a.handleLogger(c, "Registration approved for "+user.Username+" as "+req.Role)
+	a.notifyRegistration(c, user, "registration_approved", map[string]string{
+		"Username": user.Username,
+		"Role":     req.Role,
+		"Link":     a.Config.PublicURL + "/",
+	})
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message":     "Registration approved",
+		"redirectURL": "/admin?message=Registration approved"})
+}
+
+// HandlePostRejectRegistration closes a pending account with a reason that is sent to the user
+func (a *App) HandlePostRejectRegistration(c echo.Context) error {
+	// Check if request is a POST request
+	if c.Request().Method != http.MethodPost {
+		return c.Redirect(http.StatusSeeOther, "/admin?error=Method not allowed")
+	}
+
+	user, err := a.pendingRegistration(c, c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error":       "Pending registration not found",
+			"redirectURL": "/admin?error=Pending registration not found"})
+	}
+
+	// Create a struct to bind the JSON request body
+	type RejectRequest struct {
+		Reason string `json:"reason"`
+	}
+
+	var req RejectRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid request body",
+			"redirectURL": "/admin?error=Invalid request body"})
+	}
+
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" || len(req.Reason) > 255 {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "A reason of up to 255 characters is required",
+			"redirectURL": "/admin?error=A reason of up to 255 characters is required"})
+	}
+
+	a.auditRegistration(c, user)
+	auditDetail(c, "", "rejected: "+req.Reason)
+	err = a.db(c).RejectRegistration(user.UserID, req.Reason)
+	if err == sql.ErrNoRows {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error":       "Registration is no longer pending",
+			"redirectURL": "/admin?error=Registration is no longer pending"})
+	}
+	if err != nil {
+		a.handleLogger(c, "Error rejecting registration: "+err.Error())
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error":       "Error rejecting registration",
+			"redirectURL": "/admin?error=Error rejecting registration"})
+	}
+
+	a.handleLogger(c, "Registration rejected for "+user.Username)
+	a.notifyRegistration(c, user, "registration_rejected", map[string]string{
+		"Username": user.Username,
+		"Reason":   req.Reason,
+	})
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message":     "Registration rejected",
+		"redirectURL": "/admin?message=Registration rejected"})
+}
+
+// auditRegistration records the pending account and the account as it is stored after the decision
+func (a *App) auditRegistration(c echo.Context, user *models.User) {
+	auditChange(c, "registration", strconv.Itoa(user.UserID), userAudit(user), func() any {
+		after, _ := a.db(c).GetUserByID(user.UserID)
+		return userAudit(after)
+	})
+}
+
+// pendingRegistration loads the pending account for the user ID
+func (a *App) pendingRegistration(c echo.Context, id string) (*models.User, error) {
+	userid, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := a.db(c).GetUserByID(userid)
+	if err != nil {
+		return nil, err
+	}
+	if user.Approval != "pending" {
+		return nil, sql.ErrNoRows
+	}
+
+	return user, nil
+}
+
+// notifyRegistration queues the email with the outcome of a registration, failures are only logged
+func (a *App) notifyRegistration(c echo.Context, user *models.User, message string, data map[string]string) {
+	if err := a.Mail.Send(message, user.Email, data); err != nil {
+		a.handleLogger(c, "Could not queue the "+message+" email to "+user.Email+": "+err.Error())
+	}
+}
+
+// isAllowedEmailDomain checks the email against the domains allowed to self-register, any domain is allowed when none are configured
+func (a *App) isAllowedEmailDomain(email string) bool {
+	if len(a.Config.RegistrationDomains) == 0 {
+		return true
+	}
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(email[at+1:])
+	for _, allowed := range a.Config.RegistrationDomains {
+		if domain == allowed {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package app
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+/* Request policies for cross-origin and cross-site requests
+   - CORS is applied per route group: the Assessment Tool routes get their own origins and methods,
+     the browser UI (pages, dashboard and staff API) is same origin only unless CORS_UI_ORIGINS is set
+   - the CORS middleware sits on the router so preflight requests are answered for every route
+   - state-changing requests on the logged in routes need a CSRF token, sent in the X-CSRF-Token header
+     by the page scripts or in a _csrf form field. The token is rendered into the pages as the csrf-token meta tag
+*/
+
+// assessmentPrefixes are the public routes used by the Assessment Tool clients
+var assessmentPrefixes = []string{"/hello", "/examlist", "/auth/", "/exam/", "/examupload/"}
+
+// isAssessmentPath checks if the request is for an Assessment Tool route
+func isAssessmentPath(c echo.Context) bool {
+	path := c.Request().URL.Path
+	for _, prefix := range assessmentPrefixes {
+		if path == strings.TrimSuffix(prefix, "/") || strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// corsPolicies returns the CORS middleware for the Assessment Tool routes and for the browser UI
+func (a *App) corsPolicies() []echo.MiddlewareFunc {
+	policies := []echo.MiddlewareFunc{
+		middleware.CORSWithConfig(middleware.CORSConfig{
+			Skipper: func(c echo.Context) bool {
+				return !isAssessmentPath(c)
+			},
+			AllowOrigins: a.Config.AssessmentOrigins,
+			AllowMethods: a.Config.AssessmentMethods,
+		}),
+	}
+
+	// without configured origins the browser UI sends no CORS headers and browsers keep it same origin
+	if len(a.Config.UIOrigins) > 0 {
+		policies = append(policies, middleware.CORSWithConfig(middleware.CORSConfig{
+			Skipper:          isAssessmentPath,
+			AllowOrigins:     a.Config.UIOrigins,
+			AllowMethods:     a.Config.UIMethods,
+			AllowHeaders:     []string{echo.HeaderContentType, echo.HeaderXCSRFToken},
+			AllowCredentials: a.Config.UIAllowCredentials,
+		}))
+	}
+	return policies
+}
+
+// csrfProtection checks the CSRF token on state-changing requests of the logged in routes
+func (a *App) csrfProtection() echo.MiddlewareFunc {
+	var trusted []string
+	for _, origin := range a.Config.UIOrigins {
+		if origin != "*" {
+			trusted = append(trusted, origin)
+		}
+	}
+
+	return middleware.CSRFWithConfig(middleware.CSRFConfig{
+		Skipper:        isAPIKeyRequest, // API key requests carry no cookies a page could forge
+		TokenLookup:    "header:" + echo.HeaderXCSRFToken + ",form:_csrf",
+		TrustedOrigins: trusted,
+		CookieName:     "_csrf",
+		CookiePath:     "/",
+		CookieHTTPOnly: true,
+		CookieSameSite: http.SameSiteStrictMode,
+		CookieSecure:   a.Config.TLSEnabled(),
+		ErrorHandler: func(err error, c echo.Context) error {
+			a.handleLogger(c, "CSRF check failed for "+c.Request().Method+" "+c.Request().URL.Path+": "+err.Error())
+			return c.JSON(http.StatusForbidden, map[string]string{
+				"error":       "Invalid or missing CSRF token, reload the page and try again",
+				"redirectURL": "/dashboard?error=Invalid or missing CSRF token, reload the page and try again"})
+		},
+	})
+}
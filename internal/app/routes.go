@@ -2,40 +2,12 @@ package app
 
 import (
 	"net/http"
-	"os"
 
-	"ADS4/internal/config"
-
-	"github.com/golang-jwt/jwt/v5"
 	echojwt "github.com/labstack/echo-jwt/v4"
 	"github.com/labstack/echo/v4"
 )
 
-// AdminOnly middleware where Admin and Faculty are administrators
-// Admin role allows for user account management
-func (a *App) AdminOnly(next echo.HandlerFunc) echo.HandlerFunc {
-	return func(c echo.Context) error {
-		user := c.Get("user").(*jwt.Token)
-		claims := user.Claims.(jwt.MapClaims)
-		role := claims["role"]
-
-		if role != "Admin" && role != "Faculty" {
-			return c.Redirect(http.StatusUnauthorized, "/dashboard?error=You%20do%20not%20have%20permission%20to%20access%20this%20page")
-		}
-		return next(c)
-	}
-}
-
 // echo response for the keepalive/check if online route
-func (a *App) HandeGetShutdown(c echo.Context) error {
-	a.DB.Close()
-	// Log the shutdown process
-	a.handleLogger("Shutting HTTP service down")
-	os.Exit(0)
-	return nil
-}
-
-// force the server to shutdown, for testing purposes - not for production use
 func (a *App) HandeGetHello(c echo.Context) error {
 	if c.Request().Method == http.MethodGet {
 		return c.String(http.StatusOK, "OK")
@@ -46,36 +18,46 @@ func (a *App) HandeGetHello(c echo.Context) error {
 }
 
 func (a *App) initRoutes() {
-	secret := config.LoadConfig().JWTSecret
+	secret := a.Config.JWTSecret
 	// Public routes
 	//user account public routes
 	a.Router.GET("/", a.HandleGetIndex)
 	a.Router.GET("/login", a.HandleGetLogin)
 	a.Router.POST("/login", a.HandlePostLogin)
+	a.Router.GET("/login/2fa", a.HandleGetSecondFactor) // second login step for staff with a TOTP factor
+	a.Router.POST("/login/2fa", a.HandlePostSecondFactor)
 	a.Router.GET("/logout", a.HandleGetLogout)
 	a.Router.GET("/register", a.HandleGetRegister)
 	a.Router.POST("/register", a.HandlePostRegister)
 	a.Router.GET("/forgot-password", a.HandleGetForgotPassword)
 	a.Router.POST("/forgot-password", a.HandlePostForgotPassword)
+	a.Router.GET("/reset-password", a.HandleGetResetPassword)
+	a.Router.POST("/reset-password", a.HandlePostResetPassword)
 
-	//public routes for the Assesment Tool
+	//public routes for the Assesment Tool - rate limited per address, and addresses with too many
+	//failed authorisations or exam fetches are refused both for a while
 	a.Router.GET("/hello", a.HandeGetHello)
-	a.Router.GET("/examlist", a.HandleGetExamList)
-	a.Router.GET("/auth/:examid/:studentid", a.HandleGetStudentAuth)
-	a.Router.GET("/exam/:examid/:password", a.HandleGetStudentExam)
-	a.Router.POST("/examupload/:studentid/:examid/:password", a.HandlePostExamUpload)
+	a.Router.GET("/health/live", a.HandleGetLive)   // the service is running, with its version and uptime
+	a.Router.GET("/health/ready", a.HandleGetReady) // the status of the database, migrations, disk space and data folders
+	assessment := a.Router.Group("")
+	assessment.Use(assessmentRateLimiter())
+	assessment.GET("/examlist", a.HandleGetExamList)
+	assessment.GET("/auth/:examid/:studentid", a.HandleGetStudentAuth, a.RefuseWhenDraining, a.ExamLockout) // no new exams once shutting down
+	assessment.GET("/exam/:examid/:password", a.HandleGetStudentExam, a.ExamLockout)
+	assessment.POST("/examupload/:studentid/:examid/:password", a.HandlePostExamUpload, a.TrackUpload)
 
 	//public routes for the dashboard
 	a.Router.GET("/yearlist", a.HandleGetYearList) //list of available years for the offerings
-	a.Router.GET("/exammetrics", a.HandleExamMetrics)
-	a.Router.GET("/closedexams/:field/:value/:semester", a.HandleClosedExams) // /closedexams/:field/:value/:semester
 
-	//a.Router.GET("/shutdown", a.HandeGetShutdown) //admin only route to shutdown the server, for testing purposes
+	//Prometheus metrics and the readiness details for the allowed scraper addresses, or an API key with the metrics:read scope
+	a.Router.GET("/metrics", a.HandleGetMetrics, a.MetricsAccess)
+	a.Router.GET("/health/ready/details", a.HandleGetReadyDetails, a.MetricsAccess) // the readiness checks with their details
 
 	// JWT middleware
 	jwtMiddleware := echojwt.WithConfig(echojwt.Config{
 		SigningKey:  []byte(secret),
 		TokenLookup: "cookie:token",
+		Skipper:     isAPIKeyRequest, // machine clients authenticate with an API key instead
 		ErrorHandler: func(c echo.Context, err error) error {
 			return c.Redirect(http.StatusSeeOther, "/")
 		},
@@ -83,17 +65,98 @@ func (a *App) initRoutes() {
 
 	// Protected routes
 	protected := a.Router.Group("")
+	protected.Use(a.APIKeyAuth) // API keys are only accepted on the routes in apiKeyRoutes
 	protected.Use(jwtMiddleware)
+	protected.Use(a.SessionCheck)     // the token must belong to a session that has not been revoked
+	protected.Use(a.AuditTrail)       // every POST, PUT and DELETE is recorded in the audit trail, refused ones included
+	protected.Use(a.csrfProtection()) // state-changing requests must carry the page's CSRF token
 
 	protected.GET("/dashboard", a.HandleGetDashboard) //index.html
 
+	// the logged in user's own sessions
+	protected.GET("/api/session", a.HandleGetMySessions)
+	protected.DELETE("/api/session", a.HandleDeleteMyOtherSessions)
+	protected.DELETE("/api/session/:id", a.HandleDeleteMySession)
+
+	// Learner portal routes - scoped to the learner record linked to the account
+	portal := protected.Group("")
+	portal.Use(a.LearnerOnly)
+	portal.GET("/portal", a.HandleGetPortal)
+	portal.GET("/api/portal/upcoming", a.HandleGetPortalUpcoming)
+	portal.GET("/api/portal/attempts", a.HandleGetPortalAttempts)
+	portal.GET("/api/portal/results", a.HandleGetPortalResults)
+
+	// Staff routes - Admin and Faculty. Faculty are limited to the offerings they coordinate or own,
+	// single offering routes use the OfferingAccess middleware and the list queries are scoped
+	staff := protected.Group("")
+	staff.Use(a.StaffOnly)
+	staff.GET("/admin", a.HandleGetAdmin)
+
+	//self-service second factor for staff accounts
+	staff.GET("/security", a.HandleGetSecurity)
+	staff.GET("/api/2fa", a.HandleGetTwoFactor)
+	staff.POST("/api/2fa/setup", a.HandlePostTwoFactorSetup)
+	staff.POST("/api/2fa/enable", a.HandlePostTwoFactorEnable)
+	staff.POST("/api/2fa/recovery", a.HandlePostTwoFactorRecovery)
+	staff.POST("/api/2fa/disable", a.HandlePostTwoFactorDisable)
+
+	//dashboard metrics and closed exam reports, scoped to the offerings of Faculty
+	staff.GET("/exammetrics", a.HandleExamMetrics)
+	staff.GET("/closedexams/:field/:value/:semester", a.HandleClosedExams) // /closedexams/:field/:value/:semester?year=
+
+	//exam offering management routes
+	staff.GET("/api/offering", a.HandleGetAllOfferings)
+	staff.GET("/api/offering/:examid", a.HandleGetOfferingByID, a.OfferingAccess)
+	staff.PUT("/api/offering/:examid", a.HandlePutOffering, a.OfferingAccess)
+	staff.PUT("/api/offering/:examid/status", a.HandlePutOfferingStatus, a.OfferingAccess)
+
+	//learner exam management CRUD routes - the POST handler checks access to the offering in the form
+	staff.POST("/api/learnerexam", a.HandlePostLearnerExam)
+	staff.GET("/api/learnerexam", a.HandleGetAllLearnerExams)
+	staff.GET("/api/learnerexam/:studentid/:examid", a.HandleGetLearnerExamByID, a.OfferingAccess)
+	staff.PUT("/api/learnerexam/:studentid/:examid", a.HandlePutLearnerExam, a.OfferingAccess)
+	staff.PUT("/api/learnerexam/:studentid/:examid/status", a.HandlePutLearnerExamStatus, a.OfferingAccess)
+	staff.DELETE("/api/learnerexam/:studentid/:examid", a.HandleDeleteLearnerExam, a.OfferingAccess)
+
+	//marking moderation - first marks, the moderator's review and the coordinator's approval of the results
+	marking := staff.Group("/api/marking")
+	marking.Use(a.MarkingAccess)
+	marking.GET("/:examid", a.HandleGetMarking)
+	marking.PUT("/:examid/moderator", a.HandlePutModerator)
+	marking.POST("/:examid/approve", a.HandlePostApproveMarking)
+	marking.GET("/:examid/:studentid", a.HandleGetScriptMarking)
+	marking.PUT("/:examid/:studentid/marks", a.HandlePutFirstMarks)
+	marking.PUT("/:examid/:studentid/moderation", a.HandlePutModeration)
+
+	//read only course and learner lookups
+	staff.GET("/api/course", a.HandleGetAllCourses)
+	staff.GET("/api/course/:coursecode", a.HandleGetCourseByID)
+	staff.GET("/api/learner", a.HandleGetAllLearners)
+	staff.GET("/api/learner/:studentid", a.HandleGetLearnerByID)
+	staff.GET("/api/learner/:studentid/enrolments", a.HandleGetLearnerEnrolments)
+	staff.GET("/api/learner/:studentid/results", a.HandleGetLearnerResults)
+
 	// Admin-only routes
 	admin := protected.Group("")
 	admin.Use(a.AdminOnly)
-	admin.GET("/admin", a.HandleGetAdmin)
-	admin.GET("/shutdown", a.HandeGetShutdown) //admin only route to shutdown the server, for testing purposes
+	admin.GET("/api/shutdown", a.HandleGetShutdown)   // progress of a graceful shutdown
+	admin.POST("/api/shutdown", a.HandlePostShutdown) // drain and shut the service down
+	admin.GET("/api/backup", a.HandleGetBackups)
+	admin.POST("/api/backup", a.HandlePostBackup) // back up now, in the background
+	admin.GET("/api/backup/:name", a.HandleGetBackupFile)
+
+	// Year-end archives of closed years and semesters, and the retention of the learners' submissions
+	admin.GET("/archive", a.HandleGetArchivePage)
+	admin.GET("/api/archive", a.HandleGetArchives)
+	admin.POST("/api/archive", a.HandlePostArchive)
+	admin.GET("/api/archive/period", a.HandleGetArchivePeriod)    // ?year=&semester= what would be archived
+	admin.GET("/api/archive/results", a.HandleGetArchivedResults) // ?studentid=&coursecode=&year=&semester=&search=
+	admin.POST("/api/archive/purge", a.HandlePostPurge)           // apply the retention period now
+	admin.GET("/api/archive/:id/verify", a.HandleGetArchiveVerify)
+	admin.GET("/api/archive/:id/:kind", a.HandleGetArchiveFile) // kind is records or submissions
 	//admin.GET("/yearlist", a.HandleGetYearList) //list of available years for the offerings
 	//Bulk data importer router - /import/course, /import/learner, /import/offering, /import/learnerexam
+	//imports can purge whole tables so they are not offering scoped
 	admin.POST("/import/:target", a.HandlePostImport)
 
 	// User management CRUD routes
@@ -102,19 +165,44 @@ func (a *App) initRoutes() {
 	admin.GET("/api/user/:username", a.HandleGetUserByUsername)
 	admin.PUT("/api/user/:id", a.HandlePutUser)
 	admin.DELETE("/api/user/:id", a.HandleDeleteUser)
-
-	//exam offering management CRUD routes
+	admin.POST("/api/user/:id/2fa/reset", a.HandlePostResetTwoFactor)
+	admin.PUT("/api/user/:id/2fa/required", a.HandlePutTwoFactorRequired)
+	admin.DELETE("/api/user/:id/sessions", a.HandleDeleteUserSessions)
+	admin.DELETE("/api/sessions", a.HandleDeleteAllSessions)
+
+	// Self-registration approval queue
+	admin.GET("/api/registration", a.HandleGetPendingRegistrations)
+	admin.POST("/api/registration/:id/approve", a.HandlePostApproveRegistration)
+	admin.POST("/api/registration/:id/reject", a.HandlePostRejectRegistration)
+
+	// Failed login tracking and lockouts - scope is account, ip or exam
+	admin.GET("/api/lockout", a.HandleGetLockouts)
+	admin.DELETE("/api/lockout/:scope/:subject", a.HandleDeleteLockout)
+
+	// API keys for the Assessment Tool and Assessment Marking Tool
+	admin.GET("/api/apikey", a.HandleGetAPIKeys)
+	admin.POST("/api/apikey", a.HandlePostAPIKey)
+	admin.DELETE("/api/apikey/:id", a.HandleDeleteAPIKey)
+
+	// Audit trail of staff changes - /api/audit?actor=&action=&entity=&entity_id=&from=&to=
+	admin.GET("/audit", a.HandleGetAuditPage)
+	admin.GET("/api/audit", a.HandleGetAudit)
+	admin.GET("/api/audit/export", a.HandleGetAuditExport)
+
+	//exam offering creation and removal
 	admin.POST("/api/offering", a.HandlePostOffering)
-	admin.GET("/api/offering", a.HandleGetAllOfferings)
-	admin.GET("/api/offering/:examid", a.HandleGetOfferingByID)
-	admin.PUT("/api/offering/:examid", a.HandlePutOffering)
 	admin.DELETE("/api/offering/:examid", a.HandleDeleteOffering)
 
-	//learner exam management CRUD routes
-	admin.POST("/api/learnerexam", a.HandlePostLearnerExam)
-	admin.GET("/api/learnerexam", a.HandleGetAllLearnerExams)
-	admin.GET("/api/learnerexam/:studentid/:examid", a.HandleGetLearnerExamByID)
-	admin.PUT("/api/learnerexam/:studentid/:examid", a.HandlePutLearnerExam)
-	admin.DELETE("/api/learnerexam/:studentid/:examid", a.HandleDeleteLearnerExam)
+	//course management CRUD routes
+	admin.POST("/api/course", a.HandlePostCourse)
+	admin.PUT("/api/course/:coursecode", a.HandlePutCourse)
+	admin.PUT("/api/course/:coursecode/status", a.HandlePutCourseStatus)
+	admin.DELETE("/api/course/:coursecode", a.HandleDeleteCourse)
+
+	//learner management CRUD routes
+	admin.POST("/api/learner", a.HandlePostLearner)
+	admin.PUT("/api/learner/:studentid", a.HandlePutLearner)
+	admin.PUT("/api/learner/:studentid/status", a.HandlePutLearnerStatus)
+	admin.DELETE("/api/learner/:studentid", a.HandleDeleteLearner)
 
 }
@@ -0,0 +1,269 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"time"
+
+	"ADS4/internal/database"
+	"ADS4/internal/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+)
+
+/* Server side sessions
+   - every login token carries a session ID as its token ID (jti) claim and has a row in Sessions
+   - SessionCheck rejects tokens whose session is missing or expired, so deleting the rows logs a user out
+   - logout ends the current session, the database revokes all of a user's sessions when the account is
+     deactivated or deleted, the username or role changes, or the password changes
+   - users can list and end their own sessions, admins can end all sessions of a user or of everyone
+*/
+
+// startSession stores a new session for the login and returns its ID
+func (a *App) startSession(c echo.Context, user *models.User, expiresAt time.Time) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	session := &database.Session{
+		SessionID: hex.EncodeToString(b),
+		UserID:    user.UserID,
+		IP:        c.RealIP(),
+		UserAgent: c.Request().UserAgent(),
+	}
+	if err := a.db(c).CreateSession(session, expiresAt); err != nil {
+		return "", err
+	}
+	return session.SessionID, nil
+}
+
+// endSession revokes the session of the token cookie, if there is one
+func (a *App) endSession(c echo.Context) {
+	cookie, err := c.Cookie("token")
+	if err != nil || cookie.Value == "" {
+		return
+	}
+	token, err := a.parseToken(cookie.Value)
+	if err != nil {
+		return
+	}
+	claims, ok := token.Claims.(*CustomClaims)
+	if !ok || claims.ID == "" {
+		return
+	}
+	userid, _ := strconv.Atoi(claims.UserID)
+	if _, err := a.db(c).RevokeSession(userid, claims.ID); err != nil {
+		a.handleLogger(c, "Error ending session: "+err.Error())
+	}
+}
+
+// currentSessionID returns the session ID (jti) of the logged in user from the JWT claims
+func currentSessionID(c echo.Context) string {
+	token, ok := c.Get("user").(*jwt.Token)
+	if !ok {
+		return ""
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return ""
+	}
+	jti, _ := claims["jti"].(string)
+	return jti
+}
+
+// SessionCheck middleware rejects login tokens without a live session, revoked sessions are logged out
+// straight away instead of when the token expires
+func (a *App) SessionCheck(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		// API keys have no session, they are revoked on their own
+		if isAPIKeyRequest(c) {
+			return next(c)
+		}
+
+		userid, _ := currentUser(c)
+		sessionID := currentSessionID(c)
+		if sessionID == "" {
+			return c.Redirect(http.StatusSeeOther, "/logout?message=Your session has ended. Please log in again")
+		}
+
+		session, err := a.db(c).GetSession(sessionID)
+		if err != nil || session.UserID != userid {
+			return c.Redirect(http.StatusSeeOther, "/logout?message=Your session has ended. Please log in again")
+		}
+
+		if err := a.db(c).TouchSession(sessionID); err != nil {
+			a.handleLogger(c, "Error updating session: "+err.Error())
+		}
+		return next(c)
+	}
+}
+
+// sessionView is a session as listed to its owner, the full session ID is not exposed to the page
+type sessionView struct {
+	ID        string `json:"id"`
+	CreatedAt string `json:"created_at"`
+	LastSeen  string `json:"last_seen"`
+	ExpiresAt string `json:"expires_at"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	Current   bool   `json:"current"`
+}
+
+// sessionHandle is the short form of a session ID used by the sessions list
+func sessionHandle(sessionID string) string {
+	return sessionID[:min(len(sessionID), 12)]
+}
+
+// HandleGetMySessions returns the active sessions of the logged in user
+func (a *App) HandleGetMySessions(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.Redirect(http.StatusSeeOther, "/dashboard?error=Method not allowed")
+	}
+
+	userid, _ := currentUser(c)
+	sessions, err := a.db(c).GetUserSessions(userid)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
+	}
+
+	current := currentSessionID(c)
+	views := make([]sessionView, 0, len(sessions))
+	for _, session := range sessions {
+		views = append(views, sessionView{
+			ID:        sessionHandle(session.SessionID),
+			CreatedAt: session.CreatedAt,
+			LastSeen:  session.LastSeen,
+			ExpiresAt: session.ExpiresAt,
+			IP:        session.IP,
+			UserAgent: session.UserAgent,
+			Current:   session.SessionID == current,
+		})
+	}
+
+	return c.JSON(http.StatusOK, views)
+}
+
+// HandleDeleteMySession ends one of the logged in user's other sessions, identified by its listed ID
+func (a *App) HandleDeleteMySession(c echo.Context) error {
+	// Check if request if a DELETE request
+	if c.Request().Method != http.MethodDelete {
+		return c.Redirect(http.StatusSeeOther, "/dashboard?error=Method not allowed")
+	}
+
+	userid, _ := currentUser(c)
+	handle := c.Param("id")
+
+	sessions, err := a.db(c).GetUserSessions(userid)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
+	}
+	for _, session := range sessions {
+		if sessionHandle(session.SessionID) != handle {
+			continue
+		}
+		auditChange(c, "session", handle, map[string]interface{}{
+			"user_id":    userid,
+			"created_at": session.CreatedAt,
+			"ip":         session.IP,
+			"user_agent": session.UserAgent,
+		}, nil)
+		if _, err := a.db(c).RevokeSession(userid, session.SessionID); err != nil {
+			return a.handleError(c, http.StatusInternalServerError, "Error ending session", err)
+		}
+		return c.JSON(http.StatusOK, map[string]string{"message": "Session ended"})
+	}
+
+	return c.JSON(http.StatusNotFound, map[string]string{"error": "Session not found"})
+}
+
+// HandleDeleteMyOtherSessions ends every session of the logged in user except the current one
+func (a *App) HandleDeleteMyOtherSessions(c echo.Context) error {
+	// Check if request if a DELETE request
+	if c.Request().Method != http.MethodDelete {
+		return c.Redirect(http.StatusSeeOther, "/dashboard?error=Method not allowed")
+	}
+
+	userid, _ := currentUser(c)
+	a.auditSessions(c, userid)
+	count, err := a.db(c).RevokeUserSessions(userid, currentSessionID(c))
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error ending sessions", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"message": strconv.FormatInt(count, 10) + " other session(s) ended"})
+}
+
+// HandleDeleteUserSessions logs a user out everywhere
+func (a *App) HandleDeleteUserSessions(c echo.Context) error {
+	// Check if request if a DELETE request
+	if c.Request().Method != http.MethodDelete {
+		return c.Redirect(http.StatusSeeOther, "/admin?error=Method not allowed")
+	}
+
+	userid, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid user ID",
+			"redirectURL": "/admin?error=Invalid user ID"})
+	}
+
+	// the admin's own session is kept when they log themselves out elsewhere
+	a.auditSessions(c, userid)
+	count, err := a.db(c).RevokeUserSessions(userid, currentSessionID(c))
+	if err != nil {
+		a.handleLogger(c, "Error revoking sessions: "+err.Error())
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error":       "Error revoking sessions",
+			"redirectURL": "/admin?error=Error revoking sessions"})
+	}
+
+	message := strconv.FormatInt(count, 10) + " session(s) revoked"
+	a.handleLogger(c, message+" for user ID "+c.Param("id")+" by "+currentUsername(c))
+	return c.JSON(http.StatusOK, map[string]string{
+		"message":     message,
+		"redirectURL": "/admin?message=" + message})
+}
+
+// HandleDeleteAllSessions logs every user out except the admin making the request
+func (a *App) HandleDeleteAllSessions(c echo.Context) error {
+	// Check if request if a DELETE request
+	if c.Request().Method != http.MethodDelete {
+		return c.Redirect(http.StatusSeeOther, "/admin?error=Method not allowed")
+	}
+
+	a.auditSessions(c, 0)
+	count, err := a.db(c).RevokeAllSessions(currentSessionID(c))
+	if err != nil {
+		a.handleLogger(c, "Error revoking sessions: "+err.Error())
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error":       "Error revoking sessions",
+			"redirectURL": "/admin?error=Error revoking sessions"})
+	}
+
+	message := strconv.FormatInt(count, 10) + " session(s) revoked"
+	a.handleLogger(c, message+" for all users by "+currentUsername(c))
+	return c.JSON(http.StatusOK, map[string]string{
+		"message":     message,
+		"redirectURL": "/admin?message=" + message})
+}
+
+// auditSessions records the number of sessions of the user, or of every user when userid is 0, before
+// and after they are revoked
+func (a *App) auditSessions(c echo.Context, userid int) {
+	snapshot := func() any {
+		count, err := a.db(c).CountSessions(userid)
+		if err != nil {
+			return nil
+		}
+		return map[string]int{"sessions": count}
+	}
+	entityID := strconv.Itoa(userid)
+	if userid == 0 {
+		entityID = "all"
+	}
+	auditChange(c, "sessions", entityID, snapshot(), snapshot)
+}
@@ -0,0 +1,232 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+/* Graceful shutdown
+   - started by SIGINT/SIGTERM in main or by an admin from the System Admin page, main runs Shutdown
+   - draining: new exam authorisations are refused and readiness reports not ready, while the learners
+     already sitting an exam can still upload. Shutdown waits for the uploads in progress and the background
+     jobs (see startJob) until the deadline
+   - then the mail queue delivers what is due and stops, the HTTP service stops after its last requests,
+     and the database is closed last. main flushes the log files once Shutdown returns
+   - each step is logged and kept for GET /api/shutdown, the admin page polls it until the service is gone
+*/
+
+// shutdown states
+const (
+	StateRunning  = "running"
+	StateDraining = "draining"
+	StateStopping = "stopping"
+)
+
+// how often the drain reports the uploads and jobs it is waiting for
+const drainReportInterval = 5 * time.Second
+
+// shutdownState is the progress of the shutdown
+type shutdownState struct {
+	mu          sync.Mutex
+	state       string
+	requestedBy string
+	started     time.Time
+	deadline    time.Time
+	steps       []string
+
+	requested chan string // the reason of the first shutdown request
+	once      sync.Once
+
+	uploads atomic.Int64 // exam uploads in progress
+	jobs    atomic.Int64 // background jobs in progress e.g. backups and archives
+}
+
+// shutdownStatus is the body of GET /api/shutdown
+type shutdownStatus struct {
+	State       string   `json:"state"`
+	RequestedBy string   `json:"requested_by,omitempty"`
+	Started     string   `json:"started,omitempty"`
+	Deadline    string   `json:"deadline,omitempty"`
+	Uploads     int64    `json:"uploads"`
+	Jobs        int64    `json:"jobs"`
+	Steps       []string `json:"steps"`
+}
+
+func newShutdownState() *shutdownState {
+	return &shutdownState{state: StateRunning, requested: make(chan string, 1), steps: []string{}}
+}
+
+// ShutdownRequested receives the reason when an admin asks for the service to shut down
+func (a *App) ShutdownRequested() <-chan string {
+	return a.shutdown.requested
+}
+
+// RequestShutdown asks main to shut the service down, later requests are ignored
+func (a *App) RequestShutdown(reason string) bool {
+	requested := false
+	a.shutdown.once.Do(func() {
+		a.shutdown.requested <- reason
+		requested = true
+	})
+	return requested
+}
+
+// Draining reports if the service is shutting down
+func (a *App) Draining() bool {
+	a.shutdown.mu.Lock()
+	defer a.shutdown.mu.Unlock()
+	return a.shutdown.state != StateRunning
+}
+
+// startJob counts a background job until the returned func is called, so a shutdown waits for it
+func (a *App) startJob() (done func()) {
+	a.shutdown.jobs.Add(1)
+	var once sync.Once
+	return func() { once.Do(func() { a.shutdown.jobs.Add(-1) }) }
+}
+
+// shutdownStep logs a step of the shutdown and keeps it for the progress report
+func (a *App) shutdownStep(message string) {
+	a.Logger.Info().Str("shutdown", a.shutdownStatus().State).Msg(message)
+	a.shutdown.mu.Lock()
+	defer a.shutdown.mu.Unlock()
+	a.shutdown.steps = append(a.shutdown.steps, time.Now().Format("15:04:05")+" "+message)
+}
+
+func (a *App) setShutdownState(state string) {
+	a.shutdown.mu.Lock()
+	defer a.shutdown.mu.Unlock()
+	a.shutdown.state = state
+}
+
+func (a *App) shutdownStatus() shutdownStatus {
+	a.shutdown.mu.Lock()
+	defer a.shutdown.mu.Unlock()
+	status := shutdownStatus{
+		State:       a.shutdown.state,
+		RequestedBy: a.shutdown.requestedBy,
+		Uploads:     a.shutdown.uploads.Load(),
+		Jobs:        a.shutdown.jobs.Load(),
+		Steps:       append([]string{}, a.shutdown.steps...),
+	}
+	if !a.shutdown.started.IsZero() {
+		status.Started = a.shutdown.started.Format(time.RFC3339)
+		status.Deadline = a.shutdown.deadline.Format(time.RFC3339)
+	}
+	return status
+}
+
+// Shutdown drains and stops the service in order: refuse new exam authorisations, wait for the uploads
+// and jobs in progress, flush the mail queue, stop the HTTP service and close the database. The steps
+// that are still running at the deadline of ctx are cut short
+func (a *App) Shutdown(ctx context.Context, reason string) error {
+	a.shutdown.mu.Lock()
+	a.shutdown.state = StateDraining
+	a.shutdown.requestedBy = reason
+	a.shutdown.started = time.Now()
+	a.shutdown.deadline, _ = ctx.Deadline()
+	a.shutdown.mu.Unlock()
+
+	a.shutdownStep("Shutdown requested by " + reason + ", new exam authorisations are refused")
+	a.stopBackupSchedule()
+	a.stopRetentionSchedule()
+	if err := a.drain(ctx); err != nil {
+		a.shutdownStep("Deadline reached while draining: " + err.Error())
+	} else {
+		a.shutdownStep("No uploads or jobs in progress")
+	}
+
+	a.setShutdownState(StateStopping)
+	a.shutdownStep("Delivering the queued email that is due and stopping the mail queue")
+	a.Mail.Flush(ctx)
+
+	a.shutdownStep("Stopping the HTTP service")
+	err := a.Router.Shutdown(ctx)
+	if err != nil {
+		a.Logger.Error().Err(err).Msg("The HTTP service did not stop cleanly")
+	}
+
+	a.shutdownStep("Closing the database")
+	if closeErr := a.DB.Close(); closeErr != nil {
+		a.Logger.Error().Err(closeErr).Msg("Error closing the database")
+	}
+	return err
+}
+
+// drain waits until there are no uploads or jobs in progress, or the deadline
+func (a *App) drain(ctx context.Context) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	lastReport := time.Now()
+
+	for {
+		uploads, jobs := a.shutdown.uploads.Load(), a.shutdown.jobs.Load()
+		if uploads == 0 && jobs == 0 {
+			return nil
+		}
+		if time.Since(lastReport) >= drainReportInterval {
+			a.shutdownStep(fmt.Sprintf("Waiting for %d uploads and %d jobs", uploads, jobs))
+			lastReport = time.Now()
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d uploads and %d jobs still in progress", uploads, jobs)
+		case <-ticker.C:
+		}
+	}
+}
+
+// RefuseWhenDraining middleware refuses new exam authorisations once the service is shutting down, the
+// Assessment Tool is told to try again after the restart
+func (a *App) RefuseWhenDraining(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if a.Draining() {
+			c.Response().Header().Set("Retry-After", strconv.Itoa(60))
+			return c.JSON(http.StatusServiceUnavailable, map[string]any{"Status": "Error", "Message": "The service is shutting down, try again once it has restarted"})
+		}
+		return next(c)
+	}
+}
+
+// TrackUpload middleware counts the exam uploads in progress, a shutdown waits for them
+func (a *App) TrackUpload(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		a.shutdown.uploads.Add(1)
+		defer a.shutdown.uploads.Add(-1)
+		return next(c)
+	}
+}
+
+// GET /api/shutdown
+// HandleGetShutdown reports the progress of the shutdown
+func (a *App) HandleGetShutdown(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.JSON(http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+
+	return c.JSON(http.StatusOK, a.shutdownStatus())
+}
+
+// POST /api/shutdown
+// HandlePostShutdown starts a graceful shutdown, the progress is polled with GET /api/shutdown
+func (a *App) HandlePostShutdown(c echo.Context) error {
+	// Check if request if a POST request
+	if c.Request().Method != http.MethodPost {
+		return c.JSON(http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+	}
+
+	if !a.RequestShutdown("admin " + currentUsername(c)) {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "The service is already shutting down"})
+	}
+	auditDetail(c, "shutdown", "graceful shutdown requested")
+	a.handleLogger(c, "Graceful shutdown requested by "+currentUsername(c))
+	return c.JSON(http.StatusAccepted, map[string]string{"message": "The service is shutting down"})
+}
@@ -0,0 +1,206 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"ADS4/internal/metrics"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+/* Login throttling and lockout
+   - failed staff logins are counted per account (username) and per source address
+   - failed Assessment Tool authorisations and exam fetches are counted per source address in their own
+     scope, a lab behind a single NAT address should not lock the staff out of the web UI. Successes do not
+     take failures off the count, a lab shares its address so its threshold is set higher instead. The
+     lockout refuses authorisations and exam fetches, the exams in progress can still be uploaded
+   - once a count reaches its threshold every further failure locks the subject out, doubling from
+     lockoutBase up to lockoutMax. A count is forgotten after failureWindow without failures
+   - a successful staff login clears the account count, admins can list and clear lockouts
+   - the public Assessment Tool routes are also rate limited per address
+*/
+
+// lockout scopes, these match the LoginFailures.Scope check constraint
+const (
+	ScopeAccount = "account"
+	ScopeIP      = "ip"
+	ScopeExam    = "exam"
+)
+
+// failures allowed before each scope is locked out, the exam threshold is set with EXAM_LOCKOUT_THRESHOLD
+var lockoutThresholds = map[string]int{
+	ScopeAccount: 5,
+	ScopeIP:      20,
+	ScopeExam:    50,
+}
+
+// lockoutThreshold returns the failures allowed before the scope is locked out
+func (a *App) lockoutThreshold(scope string) int {
+	if scope == ScopeExam && a.Config.ExamLockoutThreshold > 0 {
+		return a.Config.ExamLockoutThreshold
+	}
+	return lockoutThresholds[scope]
+}
+
+const (
+	lockoutBase   = time.Minute
+	lockoutMax    = time.Hour
+	failureWindow = 24 * time.Hour
+)
+
+// lockoutRemaining returns how long the subject is still locked out for, zero when it is not locked
+func (a *App) lockoutRemaining(c echo.Context, scope, subject string) time.Duration {
+	failure, err := a.db(c).GetLoginFailure(scope, subject)
+	if err != nil {
+		a.handleLogger(c, "Error reading login failures: "+err.Error())
+		return 0
+	}
+
+	now := time.Now()
+	if !failure.Locked(now) {
+		return 0
+	}
+	return time.Unix(failure.LockedUntil, 0).Sub(now)
+}
+
+// recordLoginFailure counts a failed attempt and starts or extends the lockout once the threshold is reached
+func (a *App) recordLoginFailure(c echo.Context, scope, subject string) {
+	metrics.AuthFailures.WithLabelValues(scope).Inc()
+
+	failure, err := a.db(c).GetLoginFailure(scope, subject)
+	if err != nil {
+		a.handleLogger(c, "Error reading login failures: "+err.Error())
+		return
+	}
+
+	now := time.Now()
+	if now.Sub(time.Unix(failure.LastFailure, 0)) > failureWindow {
+		failure.Failures = 0
+	}
+	failure.Failures++
+	failure.LastFailure = now.Unix()
+
+	if over := failure.Failures - a.lockoutThreshold(scope); over >= 0 {
+		failure.LockedUntil = now.Add(lockoutDuration(over)).Unix()
+		a.handleLogger(c, fmt.Sprintf("Lockout of %s %s after %d failed attempts", scope, subject, failure.Failures))
+	}
+
+	if err := a.db(c).SaveLoginFailure(failure); err != nil {
+		a.handleLogger(c, "Error saving login failures: "+err.Error())
+	}
+}
+
+// clearLoginFailures forgets the failed attempts of the subject
+func (a *App) clearLoginFailures(c echo.Context, scope, subject string) {
+	if _, err := a.db(c).ClearLoginFailure(scope, subject); err != nil {
+		a.handleLogger(c, "Error clearing login failures: "+err.Error())
+	}
+}
+
+// lockoutDuration doubles the lockout for each failure past the threshold
+func lockoutDuration(over int) time.Duration {
+	if over >= 6 { // 2^6 minutes is past the maximum, avoid shifting into an overflow
+		return lockoutMax
+	}
+	return min(lockoutBase<<over, lockoutMax)
+}
+
+// lockoutMessage tells the user when to try again
+func lockoutMessage(wait time.Duration) string {
+	minutes := int(wait.Round(time.Minute) / time.Minute)
+	if minutes < 1 {
+		minutes = 1
+	}
+	return fmt.Sprintf("Too many failed attempts. Try again in %d minute(s)", minutes)
+}
+
+// assessmentRateLimiter limits each address on the public Assessment Tool routes. A burst covers a lab
+// starting an exam at the same time behind one address
+func assessmentRateLimiter() echo.MiddlewareFunc {
+	return middleware.RateLimiterWithConfig(middleware.RateLimiterConfig{
+		Store: middleware.NewRateLimiterMemoryStoreWithConfig(middleware.RateLimiterMemoryStoreConfig{
+			Rate:      5,
+			Burst:     60,
+			ExpiresIn: 3 * time.Minute,
+		}),
+		IdentifierExtractor: func(c echo.Context) (string, error) {
+			return c.RealIP(), nil
+		},
+		ErrorHandler: func(c echo.Context, err error) error {
+			return c.JSON(http.StatusForbidden, map[string]any{"Status": "Error", "Message": "Unable to identify the client"})
+		},
+		DenyHandler: func(c echo.Context, identifier string, err error) error {
+			return c.JSON(http.StatusTooManyRequests, map[string]any{"Status": "Error", "Message": "Too many requests, slow down"})
+		},
+	})
+}
+
+// ExamLockout rejects exam authorisations and fetches from an address locked out after too many failures.
+// It is not used on the upload so the learners already sitting an exam behind the address can still hand it in
+func (a *App) ExamLockout(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if wait := a.lockoutRemaining(c, ScopeExam, c.RealIP()); wait > 0 {
+			return c.JSON(http.StatusTooManyRequests, map[string]any{"Status": "Error", "Message": lockoutMessage(wait)})
+		}
+		return next(c)
+	}
+}
+
+// HandleGetLockouts fetches a page of the tracked accounts and addresses as JSON in the paging envelope.
+// ?scope= filters on account, ip or exam and ?locked=true only lists the running lockouts
+func (a *App) HandleGetLockouts(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.Redirect(http.StatusSeeOther, "/admin?error=Method not allowed")
+	}
+
+	scope := c.QueryParam("scope")
+	if _, ok := lockoutThresholds[scope]; scope != "" && !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Scope must be account, ip or exam",
+			"redirectURL": "/admin?error=Scope must be account, ip or exam"})
+	}
+
+	opts := parseListOptions(c)
+
+	failures, total, err := a.db(c).GetLoginFailures(scope, c.QueryParam("locked") == "true", opts)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
+	}
+
+	return c.JSON(http.StatusOK, newPagedResponse(c, failures, total, opts))
+}
+
+// HandleDeleteLockout clears the failed attempts and any lockout of an account or address
+func (a *App) HandleDeleteLockout(c echo.Context) error {
+	// Check if request if a DELETE request
+	if c.Request().Method != http.MethodDelete {
+		return c.Redirect(http.StatusSeeOther, "/admin?error=Method not allowed")
+	}
+
+	scope := c.Param("scope")
+	subject := c.Param("subject")
+
+	before, _ := a.db(c).GetLoginFailure(scope, subject)
+	auditChange(c, "lockout", scope+"/"+subject, before, nil)
+	cleared, err := a.db(c).ClearLoginFailure(scope, subject)
+	if err != nil {
+		a.handleLogger(c, "Error clearing lockout: "+err.Error())
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error":       "Error clearing lockout",
+			"redirectURL": "/admin?error=Error clearing lockout"})
+	}
+	if !cleared {
+		return c.JSON(http.StatusNotFound, map[string]string{
+			"error":       "Lockout not found",
+			"redirectURL": "/admin?error=Lockout not found"})
+	}
+
+	a.handleLogger(c, "Lockout cleared for "+scope+" "+subject)
+	return c.JSON(http.StatusOK, map[string]string{
+		"message":     "Lockout cleared",
+		"redirectURL": "/admin?message=Lockout cleared"})
+}
@@ -0,0 +1,734 @@
+package app
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"image/png"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"ADS4/internal/database"
+	"ADS4/internal/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/labstack/echo/v4"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+/* TOTP two-factor authentication for staff accounts
+   - staff enrol from the Security page: a secret is generated and shown as a QR code, the factor is
+     enabled once the first code is confirmed and a set of single use recovery codes is issued
+   - an admin can make the factor mandatory for an account, the user then enrols during the next login
+   - HandlePostLogin stops after the password check and hands over to the second step with a short lived
+     cookie, the login cookie is only issued once a code or recovery code is accepted
+   - an admin can reset a user's factor, e.g. a lost phone
+*/
+
+const (
+	totpIssuer         = "ADS4"
+	totpPeriod         = 30
+	totpSkew           = 1 // steps accepted either side of the current one for clock drift
+	recoveryCodeCount  = 10
+	pendingLoginCookie = "login_2fa"
+	pendingLoginTTL    = 5 * time.Minute
+)
+
+// pendingLoginClaims identify a user who passed the password check but not the second factor yet
+type pendingLoginClaims struct {
+	UserID   int  `json:"uid"`
+	Remember bool `json:"remember"`
+	jwt.RegisteredClaims
+}
+
+// pendingLoginKey derives the signing key of the second step cookie from the JWT secret. A different
+// key keeps the cookie from being accepted as a login token
+func (a *App) pendingLoginKey() []byte {
+	mac := hmac.New(sha256.New, []byte(a.Config.JWTSecret))
+	mac.Write([]byte("ads4-second-factor"))
+	return mac.Sum(nil)
+}
+
+// startSecondFactor sets the second step cookie and sends the user to the code entry or enrolment page
+func (a *App) startSecondFactor(c echo.Context, user *models.User, remember bool) error {
+	expiresAt := time.Now().Add(pendingLoginTTL)
+	claims := &pendingLoginClaims{
+		UserID:   user.UserID,
+		Remember: remember,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(a.pendingLoginKey())
+	if err != nil {
+		return c.Render(http.StatusOK, "index.html", map[string]interface{}{
+			"error": "Could not generate token",
+		})
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     pendingLoginCookie,
+		Value:    token,
+		Expires:  expiresAt,
+		Path:     "/login",
+		HttpOnly: true,
+		Secure:   a.Config.TLSEnabled(), // HTTPS only cookies when TLS is on
+		SameSite: http.SameSiteStrictMode,
+	})
+	return c.Redirect(http.StatusSeeOther, "/login/2fa")
+}
+
+// pendingLogin loads the user of the second step cookie
+func (a *App) pendingLogin(c echo.Context) (*models.User, bool, error) {
+	cookie, err := c.Cookie(pendingLoginCookie)
+	if err != nil {
+		return nil, false, err
+	}
+
+	claims := &pendingLoginClaims{}
+	_, err = jwt.ParseWithClaims(cookie.Value, claims, func(token *jwt.Token) (interface{}, error) {
+		return a.pendingLoginKey(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil {
+		return nil, false, err
+	}
+
+	user, err := a.db(c).GetUserByID(claims.UserID)
+	if err != nil {
+		return nil, false, err
+	}
+	if !user.Active {
+		return nil, false, errors.New("inactive user account")
+	}
+	return user, claims.Remember, nil
+}
+
+// clearPendingLogin removes the second step cookie
+func (a *App) clearPendingLogin(c echo.Context) {
+	c.SetCookie(&http.Cookie{
+		Name:     pendingLoginCookie,
+		Value:    "",
+		Expires:  time.Now().Add(-time.Hour),
+		Path:     "/login",
+		HttpOnly: true,
+		Secure:   a.Config.TLSEnabled(), // HTTPS only cookies when TLS is on
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// HandleGetSecondFactor serves the second login step, or the enrolment step when an admin requires a
+// factor the user has not set up yet
+func (a *App) HandleGetSecondFactor(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.Render(http.StatusMethodNotAllowed, "index.html", map[string]interface{}{
+			"error": "Method not allowed",
+		})
+	}
+
+	user, _, err := a.pendingLogin(c)
+	if err != nil {
+		return c.Redirect(http.StatusSeeOther, "/?error=Your login has expired. Please log in again")
+	}
+
+	tf, err := a.db(c).GetTwoFactor(user.UserID)
+	if err != nil {
+		a.handleLogger(c, "Error reading second factor: "+err.Error())
+		return c.Redirect(http.StatusSeeOther, "/?error=Could not load the second factor")
+	}
+	if tf.Enabled {
+		return c.Render(http.StatusOK, "two_factor.html", map[string]interface{}{})
+	}
+
+	// enrolment keeps the same secret across page reloads until it is confirmed
+	if tf.Secret == "" {
+		if tf.Secret, err = a.newTOTPSecret(c, user); err != nil {
+			a.handleLogger(c, "Error creating second factor: "+err.Error())
+			return c.Redirect(http.StatusSeeOther, "/?error=Could not create the second factor")
+		}
+	}
+	qr, err := totpQRCode(user.Username, tf.Secret)
+	if err != nil {
+		a.handleLogger(c, "Error creating second factor QR code: "+err.Error())
+	}
+
+	return c.Render(http.StatusOK, "two_factor.html", map[string]interface{}{
+		"enrol":  true,
+		"secret": tf.Secret,
+		"qr":     template.URL(qr), // generated data URI, not user input
+	})
+}
+
+// HandlePostSecondFactor checks the authenticator or recovery code and completes the login
+func (a *App) HandlePostSecondFactor(c echo.Context) error {
+	// Check if request if a POST request
+	if c.Request().Method != http.MethodPost {
+		return c.Render(http.StatusMethodNotAllowed, "index.html", map[string]interface{}{
+			"error": "Method not allowed",
+		})
+	}
+
+	user, remember, err := a.pendingLogin(c)
+	if err != nil {
+		return c.Redirect(http.StatusSeeOther, "/?error=Your login has expired. Please log in again")
+	}
+
+	// the second step counts towards the same lockout as the password
+	ip := c.RealIP()
+	wait := max(a.lockoutRemaining(c, ScopeAccount, user.Username), a.lockoutRemaining(c, ScopeIP, ip))
+	if wait > 0 {
+		a.clearPendingLogin(c)
+		return c.Redirect(http.StatusSeeOther, "/?error="+url.QueryEscape(lockoutMessage(wait)))
+	}
+
+	tf, err := a.db(c).GetTwoFactor(user.UserID)
+	if err != nil || tf.Secret == "" {
+		return c.Redirect(http.StatusSeeOther, "/?error=Could not load the second factor")
+	}
+
+	code := c.FormValue("code")
+	message := ""
+
+	if !tf.Enabled {
+		// enrolment during login, the first code confirms the authenticator
+		step, ok := checkTOTP(tf.Secret, code, tf.LastStep)
+		if !ok {
+			a.recordLoginFailure(c, ScopeAccount, user.Username)
+			a.recordLoginFailure(c, ScopeIP, ip)
+			return c.Redirect(http.StatusSeeOther, "/login/2fa?error=Invalid code")
+		}
+		codes, err := a.enableTwoFactor(c, user.UserID, step)
+		if err != nil {
+			a.handleLogger(c, "Error enabling second factor: "+err.Error())
+			return c.Redirect(http.StatusSeeOther, "/login/2fa?error=Could not enable the second factor")
+		}
+		if err := a.completeLogin(c, user, remember); err != nil {
+			return c.Redirect(http.StatusSeeOther, "/?error=Could not generate token")
+		}
+		a.handleLogger(c, "Second factor enrolled at login for "+user.Username)
+		return c.Render(http.StatusOK, "two_factor.html", map[string]interface{}{
+			"recovery_codes": codes,
+		})
+	}
+
+	ok, usedRecovery, err := a.verifySecondFactor(c, user.UserID, tf, code)
+	if err != nil {
+		a.handleLogger(c, "Error checking second factor: "+err.Error())
+		return c.Redirect(http.StatusSeeOther, "/login/2fa?error=Could not check the code")
+	}
+	if !ok {
+		a.recordLoginFailure(c, ScopeAccount, user.Username)
+		a.recordLoginFailure(c, ScopeIP, ip)
+		return c.Redirect(http.StatusSeeOther, "/login/2fa?error=Invalid code")
+	}
+	if usedRecovery {
+		left, _ := a.db(c).CountRecoveryCodes(user.UserID)
+		message = fmt.Sprintf("?message=Recovery code used, %d left. Generate new codes from the Security page", left)
+		a.handleLogger(c, "Recovery code used by "+user.Username)
+	}
+
+	if err := a.completeLogin(c, user, remember); err != nil {
+		return c.Redirect(http.StatusSeeOther, "/?error=Could not generate token")
+	}
+	return c.Redirect(http.StatusFound, "/dashboard"+message)
+}
+
+// completeLogin issues the login cookie once both factors are done
+func (a *App) completeLogin(c echo.Context, user *models.User, remember bool) error {
+	a.clearPendingLogin(c)
+	a.clearLoginFailures(c, ScopeAccount, user.Username)
+	return a.setLoginCookie(c, user, remember)
+}
+
+// verifySecondFactor accepts a current authenticator code or an unused recovery code.
+// Returns whether the code was accepted and whether it was a recovery code
+func (a *App) verifySecondFactor(c echo.Context, userid int, tf database.TwoFactor, code string) (bool, bool, error) {
+	if step, ok := checkTOTP(tf.Secret, code, tf.LastStep); ok {
+		// the step is claimed atomically so a code cannot be used twice in parallel
+		used, err := a.db(c).UseTOTPStep(userid, step)
+		return used, false, err
+	}
+
+	recovery := normaliseRecoveryCode(code)
+	if recovery == "" {
+		return false, false, nil
+	}
+	used, err := a.db(c).UseRecoveryCode(userid, hashResetToken(recovery))
+	return used, used, err
+}
+
+// enableTwoFactor turns the factor on with the step of the confirming code and returns new recovery codes
+func (a *App) enableTwoFactor(c echo.Context, userid int, step int64) ([]string, error) {
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	if err := a.db(c).EnableTwoFactor(userid, step, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// newTOTPSecret generates and stores a new secret for the user, the factor is not enabled until confirmed
+func (a *App) newTOTPSecret(c echo.Context, user *models.User) (string, error) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: user.Username,
+		Period:      totpPeriod,
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := a.db(c).SetTOTPSecret(user.UserID, key.Secret()); err != nil {
+		return "", err
+	}
+	return key.Secret(), nil
+}
+
+// checkTOTP checks a 6 digit code against the steps around the current time, steps at or before the
+// last accepted one are refused. Returns the matching step
+func checkTOTP(secret, code string, lastStep int64) (int64, bool) {
+	code = strings.TrimSpace(code)
+	if len(code) != 6 {
+		return 0, false
+	}
+
+	now := time.Now()
+	for skew := -totpSkew; skew <= totpSkew; skew++ {
+		t := now.Add(time.Duration(skew*totpPeriod) * time.Second)
+		step := t.Unix() / totpPeriod
+		if step <= lastStep {
+			continue
+		}
+		expected, err := totp.GenerateCodeCustom(secret, t, totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err == nil && subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// totpQRCode returns the provisioning URL of the secret as a PNG data URI for authenticator apps
+func totpQRCode(username, secret string) (string, error) {
+	provisioning := url.URL{
+		Scheme: "otpauth",
+		Host:   "totp",
+		Path:   "/" + totpIssuer + ":" + username,
+		RawQuery: url.Values{
+			"secret": {secret},
+			"issuer": {totpIssuer},
+			"period": {strconv.Itoa(totpPeriod)},
+		}.Encode(),
+	}
+	key, err := otp.NewKeyFromURL(provisioning.String())
+	if err != nil {
+		return "", err
+	}
+	img, err := key.Image(200, 200)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// newRecoveryCodes returns a set of random recovery codes formatted as xxxxx-xxxxx and their hashes
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+		hashes[i] = hashResetToken(code)
+	}
+	return codes, hashes, nil
+}
+
+// normaliseRecoveryCode strips the separator and spacing users may type, an empty string is returned
+// when the code cannot be a recovery code
+func normaliseRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	if len(code) != 10 {
+		return ""
+	}
+	return code
+}
+
+// HandleGetSecurity serves the Security page where staff manage their second factor
+func (a *App) HandleGetSecurity(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.Render(http.StatusMethodNotAllowed, "index.html", map[string]interface{}{
+			"error": "Method not allowed",
+		})
+	}
+
+	claims := c.Get("user").(*jwt.Token).Claims.(jwt.MapClaims)
+
+	return c.Render(http.StatusOK, "security.html", map[string]interface{}{
+		"username":      claims["username"],
+		"role":          claims["role"],
+		"email":         claims["email"],
+		"user_id":       claims["user_id"],
+		"default_admin": claims["default_admin"],
+	})
+}
+
+// HandleGetTwoFactor returns the second factor state of the current user
+func (a *App) HandleGetTwoFactor(c echo.Context) error {
+	// Check if request if a GET request
+	if c.Request().Method != http.MethodGet {
+		return c.Redirect(http.StatusSeeOther, "/dashboard?error=Method not allowed")
+	}
+
+	userid, _ := currentUser(c)
+	tf, err := a.db(c).GetTwoFactor(userid)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
+	}
+	left, err := a.db(c).CountRecoveryCodes(userid)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]any{
+		"enabled":             tf.Enabled,
+		"required":            tf.Required,
+		"recovery_codes_left": left,
+	})
+}
+
+// HandlePostTwoFactorSetup starts enrolment with a new secret and returns it with its QR code
+func (a *App) HandlePostTwoFactorSetup(c echo.Context) error {
+	// Check if request is a POST request
+	if c.Request().Method != http.MethodPost {
+		return c.Redirect(http.StatusSeeOther, "/security?error=Method not allowed")
+	}
+
+	userid, _ := currentUser(c)
+	user, err := a.db(c).GetUserByID(userid)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching user", err)
+	}
+	tf, err := a.db(c).GetTwoFactor(userid)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
+	}
+	if tf.Enabled {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error":       "Two-factor authentication is already enabled",
+			"redirectURL": "/security?error=Two-factor authentication is already enabled"})
+	}
+
+	a.auditTwoFactor(c, userid, "second factor setup started")
+	secret, err := a.newTOTPSecret(c, user)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Could not create the second factor", err)
+	}
+	qr, err := totpQRCode(user.Username, secret)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Could not create the QR code", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"secret": secret,
+		"qr":     qr,
+	})
+}
+
+// twoFactorCodeRequest is the body of the self-service second factor actions
+type twoFactorCodeRequest struct {
+	Code string `json:"code"`
+}
+
+// HandlePostTwoFactorEnable confirms enrolment with the first code and returns the recovery codes
+func (a *App) HandlePostTwoFactorEnable(c echo.Context) error {
+	// Check if request is a POST request
+	if c.Request().Method != http.MethodPost {
+		return c.Redirect(http.StatusSeeOther, "/security?error=Method not allowed")
+	}
+
+	var req twoFactorCodeRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid request body",
+			"redirectURL": "/security?error=Invalid request body"})
+	}
+
+	userid, _ := currentUser(c)
+	username := currentUsername(c)
+	tf, err := a.db(c).GetTwoFactor(userid)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
+	}
+	if tf.Enabled || tf.Secret == "" {
+		return c.JSON(http.StatusConflict, map[string]string{
+			"error":       "Start the setup before confirming a code",
+			"redirectURL": "/security?error=Start the setup before confirming a code"})
+	}
+
+	step, ok := checkTOTP(tf.Secret, req.Code, tf.LastStep)
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid code",
+			"redirectURL": "/security?error=Invalid code"})
+	}
+
+	a.auditTwoFactor(c, userid, "second factor enabled")
+	codes, err := a.enableTwoFactor(c, userid, step)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Could not enable the second factor", err)
+	}
+
+	a.handleLogger(c, "Second factor enrolled for "+username)
+	return c.JSON(http.StatusOK, map[string]any{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// HandlePostTwoFactorRecovery replaces the recovery codes, a current authenticator code is required
+func (a *App) HandlePostTwoFactorRecovery(c echo.Context) error {
+	// Check if request is a POST request
+	if c.Request().Method != http.MethodPost {
+		return c.Redirect(http.StatusSeeOther, "/security?error=Method not allowed")
+	}
+
+	var req twoFactorCodeRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid request body",
+			"redirectURL": "/security?error=Invalid request body"})
+	}
+
+	userid, _ := currentUser(c)
+	username := currentUsername(c)
+	if err := a.confirmTOTP(c, userid, req.Code); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       err.Error(),
+			"redirectURL": "/security?error=" + err.Error()})
+	}
+
+	a.auditTwoFactor(c, userid, "recovery codes replaced")
+	codes, hashes, err := newRecoveryCodes()
+	if err == nil {
+		err = a.db(c).ReplaceRecoveryCodes(userid, hashes)
+	}
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Could not create recovery codes", err)
+	}
+
+	a.handleLogger(c, "Recovery codes replaced for "+username)
+	return c.JSON(http.StatusOK, map[string]any{
+		"message":        "New recovery codes generated",
+		"recovery_codes": codes,
+	})
+}
+
+// HandlePostTwoFactorDisable removes the user's second factor unless an admin has made it mandatory
+func (a *App) HandlePostTwoFactorDisable(c echo.Context) error {
+	// Check if request is a POST request
+	if c.Request().Method != http.MethodPost {
+		return c.Redirect(http.StatusSeeOther, "/security?error=Method not allowed")
+	}
+
+	var req twoFactorCodeRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid request body",
+			"redirectURL": "/security?error=Invalid request body"})
+	}
+
+	userid, _ := currentUser(c)
+	username := currentUsername(c)
+	tf, err := a.db(c).GetTwoFactor(userid)
+	if err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
+	}
+	if tf.Required {
+		return c.JSON(http.StatusForbidden, map[string]string{
+			"error":       "Two-factor authentication is required for your account",
+			"redirectURL": "/security?error=Two-factor authentication is required for your account"})
+	}
+	if err := a.confirmTOTP(c, userid, req.Code); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       err.Error(),
+			"redirectURL": "/security?error=" + err.Error()})
+	}
+
+	a.auditTwoFactor(c, userid, "second factor disabled")
+	if err := a.db(c).ResetTwoFactor(userid); err != nil {
+		return a.handleError(c, http.StatusInternalServerError, "Could not disable the second factor", err)
+	}
+
+	a.handleLogger(c, "Second factor disabled by "+username)
+	return c.JSON(http.StatusOK, map[string]string{
+		"message":     "Two-factor authentication disabled",
+		"redirectURL": "/security?message=Two-factor authentication disabled"})
+}
+
+// auditTwoFactor records the second factor state of the account before and after the change, never the
+// secret or the recovery codes
+func (a *App) auditTwoFactor(c echo.Context, userid int, detail string) {
+	snapshot := func() any {
+		tf, err := a.db(c).GetTwoFactor(userid)
+		if err != nil {
+			return nil
+		}
+		left, _ := a.db(c).CountRecoveryCodes(userid)
+		return map[string]interface{}{
+			"enabled":             tf.Enabled,
+			"required":            tf.Required,
+			"recovery_codes_left": left,
+		}
+	}
+	auditChange(c, "twofactor", strconv.Itoa(userid), snapshot(), snapshot)
+	if detail != "" {
+		auditDetail(c, "", detail)
+	}
+}
+
+// confirmTOTP checks a current authenticator code for a self-service change
+func (a *App) confirmTOTP(c echo.Context, userid int, code string) error {
+	tf, err := a.db(c).GetTwoFactor(userid)
+	if err != nil || !tf.Enabled {
+		return errors.New("Two-factor authentication is not enabled")
+	}
+	step, ok := checkTOTP(tf.Secret, code, tf.LastStep)
+	if !ok {
+		return errors.New("Invalid code")
+	}
+	if used, err := a.db(c).UseTOTPStep(userid, step); err != nil || !used {
+		return errors.New("Invalid code")
+	}
+	return nil
+}
+
+// HandlePostResetTwoFactor removes a user's second factor and recovery codes, e.g. after a lost phone.
+// An account that is required to use a factor enrols again at the next login
+func (a *App) HandlePostResetTwoFactor(c echo.Context) error {
+	// Check if request is a POST request
+	if c.Request().Method != http.MethodPost {
+		return c.Redirect(http.StatusSeeOther, "/admin?error=Method not allowed")
+	}
+
+	user, status, err := a.twoFactorTarget(c)
+	if status == http.StatusForbidden {
+		return forbidden(c)
+	}
+	if err != nil {
+		return c.JSON(status, map[string]string{
+			"error":       err.Error(),
+			"redirectURL": "/admin?error=" + err.Error()})
+	}
+
+	a.auditTwoFactor(c, user.UserID, "second factor and recovery codes of "+user.Username+" reset")
+	if err := a.db(c).ResetTwoFactor(user.UserID); err != nil {
+		a.handleLogger(c, "Error resetting second factor: "+err.Error())
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error":       "Error resetting two-factor authentication",
+			"redirectURL": "/admin?error=Error resetting two-factor authentication"})
+	}
+
+	a.handleLogger(c, "Second factor of "+user.Username+" reset by "+currentUsername(c))
+	return c.JSON(http.StatusOK, map[string]string{
+		"message":     "Two-factor authentication reset",
+		"redirectURL": "/admin?message=Two-factor authentication reset"})
+}
+
+// HandlePutTwoFactorRequired sets whether a staff account must use a second factor
+func (a *App) HandlePutTwoFactorRequired(c echo.Context) error {
+	// Check if request is a PUT request
+	if c.Request().Method != http.MethodPut {
+		return c.Redirect(http.StatusSeeOther, "/admin?error=Method not allowed")
+	}
+
+	user, status, err := a.twoFactorTarget(c)
+	if status == http.StatusForbidden {
+		return forbidden(c)
+	}
+	if err != nil {
+		return c.JSON(status, map[string]string{
+			"error":       err.Error(),
+			"redirectURL": "/admin?error=" + err.Error()})
+	}
+
+	type RequiredRequest struct {
+		Required bool `json:"required"`
+	}
+
+	var req RequiredRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{
+			"error":       "Invalid request body",
+			"redirectURL": "/admin?error=Invalid request body"})
+	}
+
+	a.auditTwoFactor(c, user.UserID, "")
+	if err := a.db(c).SetTwoFactorRequired(user.UserID, req.Required); err != nil {
+		a.handleLogger(c, "Error updating second factor requirement: "+err.Error())
+		return c.JSON(http.StatusInternalServerError, map[string]string{
+			"error":       "Error updating two-factor requirement",
+			"redirectURL": "/admin?error=Error updating two-factor requirement"})
+	}
+
+	message := "Two-factor authentication is optional"
+	if req.Required {
+		message = "Two-factor authentication is required"
+	}
+	a.handleLogger(c, message+" for "+user.Username)
+	return c.JSON(http.StatusOK, map[string]string{
+		"message":     message,
+		"redirectURL": "/admin?message=" + message})
+}
+
+// twoFactorTarget loads the staff account named by the :id parameter, with the response status when it
+// cannot be changed. Only the default admin may change the factor of another admin
+func (a *App) twoFactorTarget(c echo.Context) (*models.User, int, error) {
+	userid, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("Invalid user ID")
+	}
+
+	user, err := a.db(c).GetUserByID(userid)
+	if err != nil {
+		return nil, http.StatusNotFound, errors.New("User not found")
+	}
+	if user.Role == RoleLearner {
+		return nil, http.StatusBadRequest, errors.New("Two-factor authentication is only used by staff accounts")
+	}
+
+	currentID, _ := currentUser(c)
+	if user.Role == RoleAdmin && user.UserID != currentID {
+		current, err := a.db(c).GetUserByID(currentID)
+		if err != nil || !current.DefaultAdmin {
+			return nil, http.StatusForbidden, errors.New("Forbidden")
+		}
+	}
+
+	return user, http.StatusOK, nil
+}
@@ -2,6 +2,7 @@ package app
 
 import (
 	"database/sql"
+	"errors"
 	"net/http"
 	"regexp"
 	"strconv"
@@ -12,20 +13,24 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
-// HandleGetAllUsers fetches all users from the database and returns the results as JSON
+// HandleGetAllUsers fetches a page of users from the database with optional filtering by role
+// and returns the results as JSON in the paging envelope
 func (a *App) HandleGetAllUsers(c echo.Context) error {
-	// Check if request if a POST request
+	// Check if request if a GET request
 	if c.Request().Method != http.MethodGet {
 		return c.Redirect(http.StatusSeeOther, "/dashboard?error=Method not allowed")
 	}
 
-	users, err := a.DB.GetAllUsers()
+	role := c.QueryParam("role")
+	opts := parseListOptions(c)
+
+	users, total, err := a.db(c).GetAllUsers(role, opts)
 	if err != nil {
 		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
 	}
 
 	// Return the results as JSON
-	return c.JSON(http.StatusOK, users)
+	return c.JSON(http.StatusOK, newPagedResponse(c, users, total, opts))
 }
 
 // HandleGetUserByUsername
@@ -37,7 +42,7 @@ func (a *App) HandleGetUserByUsername(c echo.Context) error {
 	}
 
 	username := c.Param("username")
-	user, err := a.DB.GetUserByUsername(username)
+	user, err := a.db(c).GetUserByUsername(username)
 	if err != nil {
 		return a.handleError(c, http.StatusInternalServerError, "Error fetching data", err)
 	}
@@ -59,7 +64,7 @@ func (a *App) HandlePutUser(c echo.Context) error {
 	// Parse form data from the request body
 	var user models.UserDto
 	if err := c.Bind(&user); err != nil {
-		a.handleLogger("Invalid request payload")
+		a.handleLogger(c, "Invalid request payload")
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error":       "Invalid request payload",
 			"redirectURL": "/admin?error=Invalid request payload",
@@ -68,51 +73,42 @@ func (a *App) HandlePutUser(c echo.Context) error {
 
 	user.UserID = userID
 	// Validate input
-	if user.Username == "" || user.Email == "" || user.Role == "" {
+	if err := validateUser(user.Username, user.Email, user.Role); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error":       "Username, email, and role are required",
-			"redirectURL": "/admin?error=Username, email, and role are required",
+			"error":       err.Error(),
+			"redirectURL": "/admin?error=" + err.Error(),
 		})
 	}
 
-	// Validate username
-	usernameRegex := regexp.MustCompile(`^[a-zA-Z0-9_]{6,}$`)
-	if !usernameRegex.MatchString(user.Username) {
+	// Convert the user ID to an integer
+	userIDInt, err := strconv.Atoi(userID)
+	if err != nil {
+		a.handleLogger(c, "Invalid user ID")
 		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error":       "Username must be at least 6 characters long and contain only letters, numbers, and underscores",
-			"redirectURL": "/admin?error=Username must be at least 6 characters long and contain only letters, numbers, and underscores",
+			"error":       "Invalid user ID",
+			"redirectURL": "/admin?error=Invalid user ID",
 		})
-	}
 
-	// Validate email
-	if !regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`).MatchString(user.Email) {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error":       "Invalid email address",
-			"redirectURL": "/admin?error=Invalid email address",
-		})
 	}
 
-	// Validate role
-	if user.Role != "Faculty" && user.Role != "Learner" && user.Role != "Admin" {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error":       "Invalid role",
-			"redirectURL": "/admin?error=Invalid role",
-		})
-	}
+	// record the account as it was and as it is stored after the update
+	before, _ := a.db(c).GetUserByID(userIDInt)
+	auditChange(c, "user", userID, userAudit(before), func() any {
+		after, _ := a.db(c).GetUserByID(userIDInt)
+		return userAudit(after)
+	})
 
-	// Convert the user ID to an integer
-	userIDInt, err := strconv.Atoi(userID)
+	// Learner accounts are linked to the learner record shown in the portal
+	studentid, err := a.validateUserLearner(user.Role, user.StudentID, userIDInt)
 	if err != nil {
-		a.handleLogger("Invalid user ID")
 		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error":       "Invalid user ID",
-			"redirectURL": "/admin?error=Invalid user ID",
+			"error":       err.Error(),
+			"redirectURL": "/admin?error=" + err.Error(),
 		})
-
 	}
 
 	// check if updated user.Username is unique
-	existingUser, err := a.DB.GetUserByUsername(user.Username)
+	existingUser, err := a.db(c).GetUserByUsername(user.Username)
 	if err == nil {
 		if existingUser.UserID != userIDInt {
 			return c.JSON(http.StatusOK, map[string]string{
@@ -128,7 +124,7 @@ func (a *App) HandlePutUser(c echo.Context) error {
 	}
 
 	// check if updated email is unique
-	existingUser, err = a.DB.GetUserByEmail(user.Email)
+	existingUser, err = a.db(c).GetUserByEmail(user.Email)
 	if err == nil {
 		if existingUser.UserID != userIDInt {
 			return c.JSON(http.StatusOK, map[string]string{
@@ -181,15 +177,16 @@ func (a *App) HandlePutUser(c echo.Context) error {
 			}
 
 			user := &models.User{
-				UserID:   userIDInt,
-				Username: user.Username,
-				Email:    user.Email,
-				Role:     user.Role,
-				Active:   active,
+				UserID:    userIDInt,
+				Username:  user.Username,
+				Email:     user.Email,
+				Role:      user.Role,
+				Active:    active,
+				StudentID: studentid,
 			}
 
 			// Update the user in the database
-			err = a.DB.UpdateUser(user)
+			err = a.db(c).UpdateUser(user)
 			// Check for errors
 			// iF there is an error, return an error message
 			if err != nil {
@@ -213,15 +210,10 @@ func (a *App) HandlePutUser(c echo.Context) error {
 				})
 			}
 
-			passwordLengthRegex := regexp.MustCompile(`.{8,}`)
-			passwordDigitRegex := regexp.MustCompile(`[0-9]`)
-			passwordSpecialCharRegex := regexp.MustCompile(`[!@#$%^&*]`)
-			passwordCapitalLetterRegex := regexp.MustCompile(`[A-Z]`)
-
-			if !passwordLengthRegex.MatchString(password) || !passwordDigitRegex.MatchString(password) || !passwordSpecialCharRegex.MatchString(password) || !passwordCapitalLetterRegex.MatchString(password) {
+			if err := validatePassword(password); err != nil {
 				return c.JSON(http.StatusOK, map[string]string{
-					"error":       "Password must be at least 8 characters long and contain at least one digit, special character, and capital letter",
-					"redirectURL": "/admin?error=Password must be at least 8 characters long and contain at least one digit, special character, and capital letter",
+					"error":       err.Error(),
+					"redirectURL": "/admin?error=" + err.Error(),
 				})
 			}
 
@@ -245,15 +237,18 @@ func (a *App) HandlePutUser(c echo.Context) error {
 			}
 
 			user := &models.User{
-				UserID:   userIDInt,
-				Username: user.Username,
-				Email:    user.Email,
-				Password: string(hashedPassword),
-				Role:     user.Role,
+				UserID:    userIDInt,
+				Username:  user.Username,
+				Email:     user.Email,
+				Password:  string(hashedPassword),
+				Role:      user.Role,
+				Active:    active,
+				StudentID: studentid,
 			}
 
 			// Update the user in the database
-			err = a.DB.UpdateUserWithPassword(user)
+			auditDetail(c, "", "password changed")
+			err = a.db(c).UpdateUserWithPassword(user)
 			if err != nil {
 				return c.JSON(http.StatusInternalServerError, map[string]string{
 					"error":       "Error updating user",
@@ -286,15 +281,16 @@ func (a *App) HandlePutUser(c echo.Context) error {
 		}
 
 		user := &models.User{
-			UserID:   userIDInt,
-			Username: user.Username,
-			Email:    user.Email,
-			Role:     user.Role,
-			Active:   active,
+			UserID:    userIDInt,
+			Username:  user.Username,
+			Email:     user.Email,
+			Role:      user.Role,
+			Active:    active,
+			StudentID: studentid,
 		}
 
 		// Update the user in the database
-		err = a.DB.UpdateUser(user)
+		err = a.db(c).UpdateUser(user)
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, map[string]string{
 				"error":       "Error updating user",
@@ -320,47 +316,31 @@ func (a *App) HandlePostUser(c echo.Context) error {
 	// Parse form data from the request body
 	var user models.UserDto
 	if err := c.Bind(&user); err != nil {
-		a.handleLogger("Invalid request payload")
+		a.handleLogger(c, "Invalid request payload")
 		return c.JSON(http.StatusBadRequest, map[string]string{
 			"error":       "Invalid request payload",
 			"redirectURL": "/admin?error=Invalid request payload",
 		})
 	}
 	// Validate input
-	if user.Username == "" || user.Email == "" || user.Role == "" {
+	if err := validateUser(user.Username, user.Email, user.Role); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error":       "Username, email, and role are required",
-			"redirectURL": "/admin?error=Username, email, and role are required",
+			"error":       err.Error(),
+			"redirectURL": "/admin?error=" + err.Error(),
 		})
 	}
 
-	// Validate username
-	usernameRegex := regexp.MustCompile(`^[a-zA-Z0-9_]{6,}$`)
-	if !usernameRegex.MatchString(user.Username) {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error":       "Username must be at least 6 characters long and contain only letters, numbers, and underscores",
-			"redirectURL": "/admin?error=Username must be at least 6 characters long and contain only letters, numbers, and underscores",
-		})
-	}
-
-	// Validate email
-	if !regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`).MatchString(user.Email) {
-		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error":       "Invalid email address",
-			"redirectURL": "/admin?error=Invalid email address",
-		})
-	}
-
-	// Validate role
-	if user.Role != "Faculty" && user.Role != "Learner" && user.Role != "Admin" {
+	// Learner accounts are linked to the learner record shown in the portal
+	studentid, err := a.validateUserLearner(user.Role, user.StudentID, 0)
+	if err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{
-			"error":       "Invalid role",
-			"redirectURL": "/admin?error=Invalid role",
+			"error":       err.Error(),
+			"redirectURL": "/admin?error=" + err.Error(),
 		})
 	}
 
 	// check if updated user.Username is unique
-	_, err := a.DB.GetUserByUsername(user.Username)
+	_, err = a.db(c).GetUserByUsername(user.Username)
 	if err == nil {
 		return c.JSON(http.StatusOK, map[string]string{
 			"error":       "Username already exists",
@@ -368,7 +348,7 @@ func (a *App) HandlePostUser(c echo.Context) error {
 		})
 	}
 	// check if updated email is unique
-	_, err = a.DB.GetUserByEmail(user.Email)
+	_, err = a.db(c).GetUserByEmail(user.Email)
 	if err == nil {
 		return c.JSON(http.StatusOK, map[string]string{
 			"error":       "Email already exists",
@@ -395,20 +375,25 @@ func (a *App) HandlePostUser(c echo.Context) error {
 		}*/
 
 	User := &models.User{
-		Username: user.Username,
-		Email:    user.Email,
-		Role:     user.Role,
-		Active:   false,
+		Username:  user.Username,
+		Email:     user.Email,
+		Role:      user.Role,
+		Active:    false,
+		StudentID: studentid,
 	}
 
 	// Update the user in the database
-	err = a.DB.CreateUser(User)
+	err = a.db(c).CreateUser(User)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error":       "Error creating user",
 			"redirectURL": "/admin?error=Error creating user",
 		})
 	}
+	created, _ := a.db(c).GetUserByUsername(user.Username)
+	if created != nil {
+		auditChange(c, "user", strconv.Itoa(created.UserID), nil, func() any { return userAudit(created) })
+	}
 
 	// Redirect to the admin page with a success message
 	return c.JSON(http.StatusOK, map[string]string{
@@ -450,7 +435,7 @@ func (a *App) HandleDeleteUser(c echo.Context) error {
 	}
 
 	// Get the user by ID
-	user, err := a.DB.GetUserByID(userIDInt)
+	user, err := a.db(c).GetUserByID(userIDInt)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error":       "Error fetching user",
@@ -467,7 +452,8 @@ func (a *App) HandleDeleteUser(c echo.Context) error {
 	}
 
 	// Delete the user from the database
-	err = a.DB.DeleteUser(userIDInt)
+	auditChange(c, "user", userID, userAudit(user), nil)
+	err = a.db(c).DeleteUser(userIDInt)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{
 			"error":       "Error deleting user",
@@ -490,3 +476,70 @@ func (a *App) HandleDeleteUser(c echo.Context) error {
 		"redirectURL": "/admin?message=User deleted successfully",
 	})
 }
+
+// userAudit is the snapshot of an account kept in the audit trail, the password is left out
+func userAudit(user *models.User) any {
+	if user == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"username":    user.Username,
+		"email":       user.Email,
+		"role":        user.Role,
+		"active":      user.Active,
+		"studentid":   user.StudentID,
+		"auth_source": user.AuthSource,
+		"approval":    user.Approval,
+	}
+}
+
+var (
+	usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9_]{6,}$`)
+	emailPattern    = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+)
+
+// validateUser checks the username, email and role of an account created or updated by an admin
+func validateUser(username, email, role string) error {
+	if username == "" || email == "" || role == "" {
+		return errors.New("Username, email, and role are required")
+	}
+	if !usernamePattern.MatchString(username) {
+		return errors.New("Username must be at least 6 characters long and contain only letters, numbers, and underscores")
+	}
+	if !emailPattern.MatchString(email) {
+		return errors.New("Invalid email address")
+	}
+	if role != "Faculty" && role != "Learner" && role != "Admin" {
+		return errors.New("Invalid role - Admin, Faculty or Learner")
+	}
+	return nil
+}
+
+// validateUserLearner checks the learner link of an account. Learner accounts must be linked to an existing
+// learner record that no other account uses, staff accounts are never linked. Returns the student ID to store
+func (a *App) validateUserLearner(role, studentid string, userid int) (string, error) {
+	const (
+		ErrStudentIDRequired string = "a Learner account must be linked to a student ID"
+		ErrLearnerNotFound   string = "learner not found for the student ID"
+		ErrLearnerLinked     string = "the learner is already linked to another account"
+	)
+
+	if role != "Learner" {
+		return "", nil
+	}
+
+	if !isValidStudentID(studentid) {
+		return "", errors.New(ErrStudentIDRequired)
+	}
+
+	if _, err := a.DB.GetLearnerByID(studentid); err != nil {
+		return "", errors.New(ErrLearnerNotFound)
+	}
+
+	linked, err := a.DB.GetUserIDByStudentID(studentid)
+	if err == nil && linked != userid {
+		return "", errors.New(ErrLearnerLinked)
+	}
+
+	return studentid, nil
+}
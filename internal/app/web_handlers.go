@@ -38,6 +38,15 @@ func (a *App) HandleGetDashboard(c echo.Context) error {
 	claims := user.Claims.(jwt.MapClaims)
 	//fmt.Println("User Name: ", claims["username"], "User ID: ", claims["user_id"], "User Role: ", claims["role"], "User Email: ", claims["email"])
 
+	// learners have their own portal, any message or error is passed along
+	if claims["role"] == RoleLearner {
+		target := "/portal"
+		if query := c.QueryString(); query != "" {
+			target += "?" + query
+		}
+		return c.Redirect(http.StatusSeeOther, target)
+	}
+
 	return c.Render(http.StatusOK, "dashboard.html", map[string]interface{}{
 		"username":      claims["username"],
 		"role":          claims["role"],
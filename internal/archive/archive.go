@@ -0,0 +1,199 @@
+package archive
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ADS4/internal/backup"
+)
+
+/*
+	Year-end archives
+	- a closed year or semester is packaged into two archives in the archive folder, written like the
+	  backups with a manifest of the files and their SHA-256
+	  - ads4-archive-<period>-<time>-records.tar.gz
+	      records/<table>.json  the database rows of the period's offerings, results, marks and moderation
+	      exams/...             the exam files of the offerings
+	  - ads4-archive-<period>-<time>-submissions.tar.gz
+	      learners/<year>/...   the learners' uploads of the period
+	- each archive is signed with the Ed25519 archive key, the signature is <archive>.sig beside it
+	- the submissions archive is removed once the retention period has passed, the records are kept
+*/
+
+// the kinds of archive written for a period
+const (
+	KindRecords     = "records"
+	KindSubmissions = "submissions"
+)
+
+const (
+	filePrefix   = "ads4-archive-"
+	fileSuffix   = ".tar.gz"
+	timeLayout   = "20060102-150405"
+	reportPrefix = "purge-"
+)
+
+// Period is an archived year, or a semester of it
+type Period struct {
+	Year     int
+	Semester string // S1, S2 or S3, empty for the whole year
+}
+
+// String returns the period as in the ExamIDs e.g. 2025S1, or the year alone
+func (p Period) String() string {
+	return strconv.Itoa(p.Year) + p.Semester
+}
+
+// Part is an archive written for a period
+type Part struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256"`
+	Files  int    `json:"files"`
+	Bytes  int64  `json:"bytes"` // the size of the archived files before compression
+}
+
+// SubmissionsDir returns the folder of dataDir holding the learners' uploads of the period
+func SubmissionsDir(dataDir string, period Period) string {
+	dir := filepath.Join(dataDir, "learners", strconv.Itoa(period.Year))
+	if period.Semester != "" {
+		dir = filepath.Join(dir, period.Semester)
+	}
+	return dir
+}
+
+// WriteRecords writes and signs the records archive of the period, tables holds the rows of each table
+// and examFiles the paths of the exam files relative to dataDir. Missing exam files are skipped
+func WriteRecords(dir string, signer *Signer, period Period, version string, tables map[string]any, dataDir string, examFiles []string) (Part, error) {
+	return write(dir, signer, period, KindRecords, version, func(w *backup.Writer, created time.Time) error {
+		names := make([]string, 0, len(tables))
+		for name := range tables {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			data, err := json.MarshalIndent(tables[name], "", "  ")
+			if err != nil {
+				return fmt.Errorf("encoding the %s rows: %w", name, err)
+			}
+			if err := w.AddData(path.Join("records", name+".json"), data, created); err != nil {
+				return err
+			}
+		}
+		for _, file := range examFiles {
+			source := filepath.Join(dataDir, filepath.FromSlash(file))
+			if _, err := os.Stat(source); errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err := w.AddFile(file, source); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// WriteSubmissions writes and signs the submissions archive of the period from the learners folder of dataDir
+func WriteSubmissions(dir string, signer *Signer, period Period, version, dataDir string) (Part, error) {
+	return write(dir, signer, period, KindSubmissions, version, func(w *backup.Writer, _ time.Time) error {
+		return w.AddTree(dataDir, SubmissionsDir(dataDir, period))
+	})
+}
+
+// write makes an archive under a temporary name with fill, renames it once complete and signs it
+func write(dir string, signer *Signer, period Period, kind, version string, fill func(w *backup.Writer, created time.Time) error) (Part, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return Part{}, fmt.Errorf("creating the archive folder: %w", err)
+	}
+
+	now := time.Now()
+	name := filePrefix + period.String() + "-" + now.Format(timeLayout) + "-" + kind + fileSuffix
+	target := filepath.Join(dir, name)
+	partial := target + ".partial"
+
+	w, err := backup.NewWriter(partial, backup.Manifest{Created: now.Format(time.RFC3339), Version: version, Kind: kind, Period: period.String()})
+	if err != nil {
+		return Part{}, fmt.Errorf("creating the archive file: %w", err)
+	}
+	if err := fill(w, now); err != nil {
+		w.Close()
+		os.Remove(partial)
+		return Part{}, err
+	}
+	written, err := w.Close()
+	if err != nil {
+		os.Remove(partial)
+		return Part{}, err
+	}
+	if err := os.Rename(partial, target); err != nil {
+		os.Remove(partial)
+		return Part{}, err
+	}
+
+	signature, err := signer.Sign(target)
+	if err != nil {
+		Remove(dir, name)
+		return Part{}, fmt.Errorf("signing the archive: %w", err)
+	}
+
+	part := Part{Name: name, SHA256: signature.SHA256, Files: len(written.Files)}
+	for _, file := range written.Files {
+		part.Bytes += file.Size
+	}
+	return part, nil
+}
+
+// Verify checks the signature of the archive name in dir and every file against its manifest
+func Verify(dir, name string, signer *Signer) (backup.Manifest, error) {
+	file, err := Path(dir, name)
+	if err != nil {
+		return backup.Manifest{}, err
+	}
+	if _, err := signer.Verify(file); err != nil {
+		return backup.Manifest{}, err
+	}
+	return backup.Check(file)
+}
+
+// Remove deletes the archive name in dir and its signature, an archive that is already gone is not an error
+func Remove(dir, name string) error {
+	file, err := Path(dir, name)
+	if err != nil {
+		return err
+	}
+	for _, target := range []string{file, file + signatureSuffix} {
+		if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Path returns the path of the archive name in dir, refusing names that are not archives
+func Path(dir, name string) (string, error) {
+	if !strings.HasPrefix(name, filePrefix) || !strings.HasSuffix(name, fileSuffix) || filepath.Base(name) != name {
+		return "", fmt.Errorf("%s is not an archive", name)
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// SaveReport writes a purge report as purge-<time>.json in dir and returns its name
+func SaveReport(dir string, report any) (string, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	name := reportPrefix + time.Now().Format(timeLayout) + ".json"
+	return name, os.WriteFile(filepath.Join(dir, name), data, 0o640)
+}
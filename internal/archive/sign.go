@@ -0,0 +1,176 @@
+package archive
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// signatureSuffix is added to the archive name for its signature file
+const signatureSuffix = ".sig"
+
+// Signer signs the archives with the Ed25519 archive key
+type Signer struct {
+	key ed25519.PrivateKey
+}
+
+// Signature is the content of an archive's .sig file. The signature is over signedMessage
+type Signature struct {
+	File      string `json:"file"`
+	SHA256    string `json:"sha256"`
+	Signed    string `json:"signed"`
+	PublicKey string `json:"public_key"`
+	Signature string `json:"signature"`
+}
+
+// LoadSigner reads the PEM private key at path, a new key is made when there is none. The public key
+// is written beside it as <path>.pub so the archives can be checked without the private key
+func LoadSigner(path string) (*Signer, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return newSigner(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, fmt.Errorf("%s is not a PEM private key", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("reading the archive key: %w", err)
+	}
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 key", path)
+	}
+	return &Signer{key: private}, nil
+}
+
+// newSigner makes a new archive key and saves it at path
+func newSigner(path string) (*Signer, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeNew(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0o600); err != nil {
+		return nil, fmt.Errorf("saving the archive key: %w", err)
+	}
+	if err := os.WriteFile(path+".pub", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0o644); err != nil {
+		return nil, fmt.Errorf("saving the archive public key: %w", err)
+	}
+	return &Signer{key: private}, nil
+}
+
+// PublicKey returns the public key, base64 encoded as in the signatures
+func (s *Signer) PublicKey() string {
+	return base64.StdEncoding.EncodeToString(s.key.Public().(ed25519.PublicKey))
+}
+
+// Sign signs the archive file and writes the signature beside it
+func (s *Signer) Sign(file string) (Signature, error) {
+	sum, err := fileSHA256(file)
+	if err != nil {
+		return Signature{}, err
+	}
+	name := filepath.Base(file)
+	signature := Signature{
+		File:      name,
+		SHA256:    sum,
+		Signed:    time.Now().Format(time.RFC3339),
+		PublicKey: s.PublicKey(),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, signedMessage(name, sum))),
+	}
+
+	data, err := json.MarshalIndent(signature, "", "  ")
+	if err != nil {
+		return signature, err
+	}
+	return signature, os.WriteFile(file+signatureSuffix, data, 0o640)
+}
+
+// Verify checks the archive file against its signature, which must be made with this key
+func (s *Signer) Verify(file string) (Signature, error) {
+	var signature Signature
+	data, err := os.ReadFile(file + signatureSuffix)
+	if err != nil {
+		return signature, fmt.Errorf("reading the signature: %w", err)
+	}
+	if err := json.Unmarshal(data, &signature); err != nil {
+		return signature, fmt.Errorf("reading the signature: %w", err)
+	}
+	if signature.PublicKey != s.PublicKey() {
+		return signature, errors.New("the archive was signed with another key")
+	}
+
+	sum, err := fileSHA256(file)
+	if err != nil {
+		return signature, err
+	}
+	if sum != signature.SHA256 {
+		return signature, errors.New("the archive does not match the checksum it was signed with")
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature.Signature)
+	if err != nil || !ed25519.Verify(s.key.Public().(ed25519.PublicKey), signedMessage(filepath.Base(file), sum), sig) {
+		return signature, errors.New("the signature is not valid")
+	}
+	return signature, nil
+}
+
+// signedMessage binds the signature to the archive name as well as its content
+func signedMessage(name, sum string) []byte {
+	return []byte("ads4-archive " + name + " " + sum)
+}
+
+// fileSHA256 returns the hex SHA-256 of a file
+func fileSHA256(file string) (string, error) {
+	in, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, in); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// writeNew writes a file that must not exist yet
+func writeNew(path string, data []byte, perm fs.FileMode) error {
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := out.Write(data); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,305 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+/*
+	Backups of the service state
+	- a backup is one ads4-backup-<time>.tar.gz in the backup folder holding
+	  - database/<DB_NAME>.db  a consistent copy of the SQLite database made online with VACUUM INTO
+	  - exams/... learners/... the exam files and the learners' uploads from the data folder
+	  - manifest.json          the files with their size and SHA-256, written last
+	- Restore checks every file against the manifest in a staging folder before anything is swapped in,
+	  the replaced database and folders are kept in DataDir/pre-restore-<time> and moved back when a
+	  later step fails. The service holds DataDir/ads4.lock while it runs and a restore refuses to start
+	  while it is held
+	- Prune keeps the newest backups
+*/
+
+// the data folders saved with the database
+var Areas = []string{"exams", "learners"}
+
+const (
+	filePrefix   = "ads4-backup-"
+	fileSuffix   = ".tar.gz"
+	timeLayout   = "20060102-150405"
+	manifestName = "manifest.json"
+	databaseDir  = "database"
+
+	// LockName is the lock file of the data folder, held by the service while it runs and by a restore
+	LockName = "ads4.lock"
+)
+
+// Manifest describes the content of a backup or a year-end archive
+type Manifest struct {
+	Created string `json:"created"`
+	Version string `json:"version"`
+	DBName  string `json:"db_name"`
+	Kind    string `json:"kind,omitempty"`   // the kind of year-end archive, empty for a backup
+	Period  string `json:"period,omitempty"` // the year or semester of a year-end archive
+	Files   []File `json:"files"`
+}
+
+// File is a file of the backup with its checksum
+type File struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Info is a backup in the backup folder
+type Info struct {
+	Name    string `json:"name"`
+	Size    int64  `json:"size"`
+	Created string `json:"created"`
+}
+
+// SnapshotFunc writes a consistent copy of the database to the path, e.g. with VACUUM INTO
+type SnapshotFunc func(path string) error
+
+// Create writes a backup of the database and the data folders into dir and returns its name
+func Create(snapshot SnapshotFunc, dataDir, dbName, dir, version string) (string, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return "", fmt.Errorf("creating the backup folder: %w", err)
+	}
+
+	now := time.Now()
+	name := filePrefix + now.Format(timeLayout) + fileSuffix
+	target := filepath.Join(dir, name)
+	if _, err := os.Stat(target); err == nil {
+		return "", fmt.Errorf("the backup %s already exists", name)
+	}
+
+	// the database copy is made beside the backups so it is on the same disk, and removed afterwards
+	dbCopy := filepath.Join(dir, ".snapshot-"+now.Format(timeLayout)+".db")
+	defer os.Remove(dbCopy)
+	if err := snapshot(dbCopy); err != nil {
+		return "", fmt.Errorf("copying the database: %w", err)
+	}
+
+	// the archive is written under a temporary name and renamed once complete
+	partial := target + ".partial"
+	if err := writeArchive(partial, dbCopy, dataDir, dbName, version, now); err != nil {
+		os.Remove(partial)
+		return "", err
+	}
+	if err := os.Rename(partial, target); err != nil {
+		os.Remove(partial)
+		return "", err
+	}
+	return name, nil
+}
+
+func writeArchive(target, dbCopy, dataDir, dbName, version string, created time.Time) error {
+	w, err := NewWriter(target, Manifest{Created: created.Format(time.RFC3339), Version: version, DBName: dbName})
+	if err != nil {
+		return fmt.Errorf("creating the backup file: %w", err)
+	}
+	defer w.out.Close()
+
+	if err := w.AddFile(path.Join(databaseDir, dbName+".db"), dbCopy); err != nil {
+		return err
+	}
+	for _, area := range Areas {
+		if err := w.AddTree(dataDir, filepath.Join(dataDir, area)); err != nil {
+			return fmt.Errorf("adding the %s folder: %w", area, err)
+		}
+	}
+	_, err = w.Close()
+	return err
+}
+
+// Writer writes a .tar.gz archive of files with their checksums, the manifest listing them is written
+// last by Close. Year-end archives (internal/archive) are written the same way
+type Writer struct {
+	out      *os.File
+	gz       *gzip.Writer
+	tw       *tar.Writer
+	manifest Manifest
+}
+
+// NewWriter creates the archive target, which must not exist, for the files described by manifest
+func NewWriter(target string, manifest Manifest) (*Writer, error) {
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(out)
+	return &Writer{out: out, gz: gz, tw: tar.NewWriter(gz), manifest: manifest}, nil
+}
+
+// AddFile copies the file source into the archive as name
+func (w *Writer) AddFile(name, source string) error {
+	file, err := addFile(w.tw, name, source)
+	if err != nil {
+		return fmt.Errorf("adding %s: %w", name, err)
+	}
+	w.manifest.Files = append(w.manifest.Files, file)
+	return nil
+}
+
+// AddData writes data into the archive as name
+func (w *Writer) AddData(name string, data []byte, modTime time.Time) error {
+	if err := w.tw.WriteHeader(&tar.Header{Name: name, Mode: 0o640, Size: int64(len(data)), ModTime: modTime}); err != nil {
+		return fmt.Errorf("adding %s: %w", name, err)
+	}
+	if _, err := w.tw.Write(data); err != nil {
+		return fmt.Errorf("adding %s: %w", name, err)
+	}
+	sum := sha256.Sum256(data)
+	w.manifest.Files = append(w.manifest.Files, File{Path: name, Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:])})
+	return nil
+}
+
+// AddTree adds the regular files under root, named by their path relative to base. A root that does
+// not exist adds nothing
+func (w *Writer) AddTree(base, root string) error {
+	return filepath.WalkDir(root, func(source string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && source == root {
+				return nil // nothing stored yet
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(base, source)
+		if err != nil {
+			return err
+		}
+		return w.AddFile(filepath.ToSlash(rel), source)
+	})
+}
+
+// Close writes the manifest, completes the archive and returns the manifest
+func (w *Writer) Close() (Manifest, error) {
+	defer w.out.Close()
+	data, err := json.MarshalIndent(w.manifest, "", "  ")
+	if err != nil {
+		return w.manifest, err
+	}
+	created, err := time.Parse(time.RFC3339, w.manifest.Created)
+	if err != nil {
+		created = time.Now()
+	}
+	if err := w.tw.WriteHeader(&tar.Header{Name: manifestName, Mode: 0o640, Size: int64(len(data)), ModTime: created}); err != nil {
+		return w.manifest, err
+	}
+	if _, err := w.tw.Write(data); err != nil {
+		return w.manifest, err
+	}
+
+	if err := w.tw.Close(); err != nil {
+		return w.manifest, err
+	}
+	if err := w.gz.Close(); err != nil {
+		return w.manifest, err
+	}
+	if err := w.out.Sync(); err != nil {
+		return w.manifest, err
+	}
+	return w.manifest, w.out.Close()
+}
+
+// addFile writes a file into the archive and returns its checksum
+func addFile(tw *tar.Writer, name, source string) (File, error) {
+	in, err := os.Open(source)
+	if err != nil {
+		return File{}, err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return File{}, err
+	}
+
+	if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o640, Size: info.Size(), ModTime: info.ModTime()}); err != nil {
+		return File{}, err
+	}
+	hash := sha256.New()
+	// a file that changes size while it is copied fails the tar writer rather than giving a bad backup
+	if _, err := io.Copy(tw, io.TeeReader(in, hash)); err != nil {
+		return File{}, err
+	}
+	return File{Path: name, Size: info.Size(), SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// List returns the backups in dir, newest first
+func List(dir string) ([]Info, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []Info{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	backups := []Info{}
+	for _, entry := range entries {
+		created, ok := parseName(entry.Name())
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, Info{Name: entry.Name(), Size: info.Size(), Created: created.Format("2006-01-02 15:04:05")})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Name > backups[j].Name })
+	return backups, nil
+}
+
+// Prune removes the oldest backups in dir, keeping keep of them, and returns the removed names
+func Prune(dir string, keep int) ([]string, error) {
+	backups, err := List(dir)
+	if err != nil {
+		return nil, err
+	}
+	var removed []string
+	for i := keep; i < len(backups); i++ {
+		if err := os.Remove(filepath.Join(dir, backups[i].Name)); err != nil {
+			return removed, err
+		}
+		removed = append(removed, backups[i].Name)
+	}
+	return removed, nil
+}
+
+// Path returns the path of the backup name in dir, refusing names that are not backups
+func Path(dir, name string) (string, error) {
+	if _, ok := parseName(name); !ok || filepath.Base(name) != name {
+		return "", fmt.Errorf("%s is not a backup", name)
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// parseName reads the time of a backup from its file name
+func parseName(name string) (time.Time, bool) {
+	stamp, ok := strings.CutPrefix(name, filePrefix)
+	if !ok {
+		return time.Time{}, false
+	}
+	stamp, ok = strings.CutSuffix(stamp, fileSuffix)
+	if !ok {
+		return time.Time{}, false
+	}
+	created, err := time.ParseInLocation(timeLayout, stamp, time.Local)
+	return created, err == nil
+}
@@ -0,0 +1,263 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"ADS4/internal/utils"
+)
+
+// Verify unpacks the backup into a temporary folder and checks every file against the manifest
+func Verify(archive string) (Manifest, error) {
+	stage, err := os.MkdirTemp("", "ads4-verify-")
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer os.RemoveAll(stage)
+	return extract(archive, stage)
+}
+
+// Check reads an archive written by Writer and checks every file against the manifest, without
+// unpacking it
+func Check(archive string) (Manifest, error) {
+	return read(archive, "", relativeName)
+}
+
+// Restore replaces the database and the data folders of dataDir with the backup. The backup is unpacked
+// and checked against its manifest first, nothing is replaced when a file is missing or does not match.
+// The replaced files are moved to the returned pre-restore folder, and moved back when the restore fails
+// part way. The restore refuses to run while the service holds the lock of the data folder
+func Restore(archive, dataDir, dbName string) (Manifest, string, error) {
+	lock, err := utils.LockFile(filepath.Join(dataDir, LockName))
+	if errors.Is(err, utils.ErrLocked) {
+		return Manifest{}, "", errors.New("the service is running on this data folder, stop it first")
+	}
+	if err != nil {
+		return Manifest{}, "", fmt.Errorf("locking the data folder: %w", err)
+	}
+	defer lock.Close()
+
+	stamp := time.Now().Format(timeLayout)
+	// the staging folder is in the data folder so the files are swapped in by renaming
+	stage := filepath.Join(dataDir, ".restore-"+stamp)
+	if err := os.MkdirAll(stage, 0o750); err != nil {
+		return Manifest{}, "", err
+	}
+	defer os.RemoveAll(stage)
+
+	manifest, err := extract(archive, stage)
+	if err != nil {
+		return manifest, "", err
+	}
+	if manifest.DBName != dbName {
+		return manifest, "", fmt.Errorf("the backup is of the database %s, not %s", manifest.DBName, dbName)
+	}
+
+	kept := filepath.Join(dataDir, "pre-restore-"+stamp)
+	if err := os.MkdirAll(kept, 0o750); err != nil {
+		return manifest, "", err
+	}
+
+	// the database, with its write-ahead log, then the data folders
+	dbFile := dbName + ".db"
+	dbFiles := []string{dbFile, dbFile + "-wal", dbFile + "-shm"}
+	replaced := append(slices.Clone(dbFiles), Areas...)
+	var restored []string
+	// rollback removes what was restored and moves the replaced files back from kept
+	rollback := func(err error) (Manifest, string, error) {
+		for _, name := range restored {
+			if removeErr := os.RemoveAll(filepath.Join(dataDir, name)); removeErr != nil {
+				return manifest, kept, fmt.Errorf("%w, and removing the restored %s failed: %v", err, name, removeErr)
+			}
+		}
+		for _, name := range replaced {
+			if moveErr := moveIfExists(filepath.Join(kept, name), filepath.Join(dataDir, name)); moveErr != nil {
+				return manifest, kept, fmt.Errorf("%w, and moving %s back failed: %v", err, name, moveErr)
+			}
+		}
+		os.Remove(kept)
+		return manifest, "", err
+	}
+
+	for _, name := range dbFiles {
+		if err := moveIfExists(filepath.Join(dataDir, name), filepath.Join(kept, name)); err != nil {
+			return rollback(fmt.Errorf("moving the database aside: %w", err))
+		}
+	}
+	if err := os.Rename(filepath.Join(stage, databaseDir, dbFile), filepath.Join(dataDir, dbFile)); err != nil {
+		return rollback(fmt.Errorf("restoring the database: %w", err))
+	}
+	restored = append(restored, dbFile)
+	for _, area := range Areas {
+		if err := moveIfExists(filepath.Join(dataDir, area), filepath.Join(kept, area)); err != nil {
+			return rollback(fmt.Errorf("moving the %s folder aside: %w", area, err))
+		}
+		staged := filepath.Join(stage, area)
+		if _, err := os.Stat(staged); errors.Is(err, fs.ErrNotExist) {
+			// the folder was empty when the backup was made
+			restored = append(restored, area)
+			if err := os.MkdirAll(filepath.Join(dataDir, area), 0o755); err != nil {
+				return rollback(err)
+			}
+			continue
+		}
+		if err := os.Rename(staged, filepath.Join(dataDir, area)); err != nil {
+			return rollback(fmt.Errorf("restoring the %s folder: %w", area, err))
+		}
+		restored = append(restored, area)
+	}
+	return manifest, kept, nil
+}
+
+// extract unpacks the backup into stage and checks the files against the manifest
+func extract(archive, stage string) (Manifest, error) {
+	manifest, err := read(archive, stage, validName)
+	if err != nil {
+		return manifest, err
+	}
+	if !slices.ContainsFunc(manifest.Files, func(file File) bool {
+		return file.Path == path.Join(databaseDir, manifest.DBName+".db")
+	}) {
+		return manifest, errors.New("the backup has no database")
+	}
+	return manifest, nil
+}
+
+// read checks the files of the archive against its manifest, unpacking them into stage unless it is
+// empty. Files with a name that valid refuses fail the check
+func read(archive, stage string, valid func(name string) bool) (Manifest, error) {
+	var manifest Manifest
+	in, err := os.Open(archive)
+	if err != nil {
+		return manifest, err
+	}
+	defer in.Close()
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return manifest, fmt.Errorf("reading the backup: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	found := map[string]File{}
+	var manifestData []byte
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return manifest, fmt.Errorf("reading the backup: %w", err)
+		}
+		if header.Typeflag == tar.TypeDir {
+			continue // the folders are made for their files
+		}
+		if header.Typeflag != tar.TypeReg {
+			return manifest, fmt.Errorf("unexpected entry %s in the backup", header.Name)
+		}
+		if header.Name == manifestName {
+			if manifestData, err = io.ReadAll(io.LimitReader(tr, 64<<20)); err != nil {
+				return manifest, err
+			}
+			continue
+		}
+		if !valid(header.Name) {
+			return manifest, fmt.Errorf("unexpected file %s in the backup", header.Name)
+		}
+
+		var file File
+		if stage == "" {
+			file, err = checksum(tr)
+		} else {
+			file, err = extractFile(tr, filepath.Join(stage, filepath.FromSlash(header.Name)))
+		}
+		if err != nil {
+			return manifest, fmt.Errorf("unpacking %s: %w", header.Name, err)
+		}
+		file.Path = header.Name
+		found[header.Name] = file
+	}
+
+	if manifestData == nil {
+		return manifest, errors.New("the backup has no manifest")
+	}
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return manifest, fmt.Errorf("reading the manifest: %w", err)
+	}
+
+	// every file of the manifest is present and matches, and there is nothing else
+	for _, expected := range manifest.Files {
+		file, ok := found[expected.Path]
+		if !ok {
+			return manifest, fmt.Errorf("%s is in the manifest but not in the backup", expected.Path)
+		}
+		if file.Size != expected.Size || file.SHA256 != expected.SHA256 {
+			return manifest, fmt.Errorf("%s does not match its checksum", expected.Path)
+		}
+		delete(found, expected.Path)
+	}
+	for name := range found {
+		return manifest, fmt.Errorf("%s is in the backup but not in the manifest", name)
+	}
+	return manifest, nil
+}
+
+// checksum reads a file of the archive and returns its checksum
+func checksum(r io.Reader) (File, error) {
+	hash := sha256.New()
+	size, err := io.Copy(hash, r)
+	return File{Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, err
+}
+
+// extractFile writes a file of the backup and returns its checksum
+func extractFile(r io.Reader, target string) (File, error) {
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return File{}, err
+	}
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return File{}, err
+	}
+	defer out.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(out, io.TeeReader(r, hash))
+	if err != nil {
+		return File{}, err
+	}
+	return File{Size: size, SHA256: hex.EncodeToString(hash.Sum(nil))}, out.Close()
+}
+
+// relativeName accepts the relative paths that stay inside the folder they are unpacked to
+func relativeName(name string) bool {
+	return name == path.Clean(name) && !path.IsAbs(name) && name != ".." && !strings.HasPrefix(name, "../") && !strings.Contains(name, "\\")
+}
+
+// validName accepts the relative paths under the database and data folders, nothing that could leave them
+func validName(name string) bool {
+	if !relativeName(name) {
+		return false
+	}
+	top, _, found := strings.Cut(name, "/")
+	return found && (top == databaseDir || slices.Contains(Areas, top))
+}
+
+// moveIfExists renames from to to, doing nothing when from does not exist
+func moveIfExists(from, to string) error {
+	if _, err := os.Lstat(from); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return os.Rename(from, to)
+}
@@ -1,13 +1,16 @@
 package config
 
 import (
-	"log"
-	"os"
-	"strconv"
-
-	"github.com/joho/godotenv"
+	"crypto/tls"
+	"fmt"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
 )
 
+// Config is the service configuration, read once at start up by Load
 type Config struct {
 	DBtype        string
 	DBUser        string
@@ -20,61 +23,351 @@ type Config struct {
 	AdminEmail    string
 	DataDir       string
 	ADSPORT       string
+	PublicURL     string // base of the links in the emails e.g. https://ads.example.com, never the request Host
+
+	// optional settings
+	RegistrationDomains []string // email domains allowed to self-register, empty allows any domain
+
+	// outgoing email - the file driver writes messages to MailOutbox instead of sending them
+	MailDriver   string // smtp, file
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUser     string
+	SMTPPassword string
+	SMTPFrom     string
+	SMTPTLS      string // starttls, tls, none
+	MailOutbox   string
+
+	// failed Assessment Tool authorisations from an address before it is refused new authorisations, a lab
+	// behind one NAT address shares the count
+	ExamLockoutThreshold int
+
+	// cross-origin (CORS) policies - the Assessment Tool clients and the browser UI are configured apart.
+	// No UI origins means the browser UI is only served to its own origin
+	AssessmentOrigins  []string
+	AssessmentMethods  []string
+	UIOrigins          []string
+	UIMethods          []string
+	UIAllowCredentials bool
+
+	// built-in HTTPS - TLS is on when both the certificate and key files are set. The optional redirect
+	// port runs a plain HTTP listener that sends browsers to the HTTPS address
+	TLSCert          string
+	TLSKey           string
+	TLSMinVersion    uint16
+	HTTPRedirectPort string
+
+	// staff authentication - local checks the passwords in UserT, ldap binds to a directory (LDAP/Active
+	// Directory) and maps its groups to the Admin and Faculty roles. Group lists are DNs separated by ;
+	AuthProvider      string // local, ldap
+	LDAPURL           string // ldap://host:389 or ldaps://host:636
+	LDAPStartTLS      bool
+	LDAPSkipVerify    bool
+	LDAPBindDN        string // service account used to find users, anonymous when empty
+	LDAPBindPassword  string
+	LDAPBaseDN        string
+	LDAPUserFilter    string // %s is replaced by the escaped username e.g. (sAMAccountName=%s) for AD
+	LDAPUsernameAttr  string
+	LDAPEmailAttr     string
+	LDAPGroupAttr     string
+	LDAPAdminGroups   []string
+	LDAPFacultyGroups []string
+
+	// logging - the console output is text or JSON, the log files under LogDir are JSON and rotate by size.
+	// An empty LogDir logs to the console only
+	LogLevel      string // debug, info, warn, error
+	LogFormat     string // console, json
+	LogDir        string
+	LogMaxSizeMB  int
+	LogMaxFiles   int
+	LogMaxAgeDays int
+
+	// metrics - /metrics is served to the addresses or networks in MetricsAllow, and to API keys with the
+	// metrics:read scope
+	MetricsAllow []netip.Prefix
+
+	// readiness checks - the goose migrations folder compared with the applied migrations, and the free
+	// space below which the data folder is reported as not ready for uploads
+	MigrationsDir string
+	MinFreeDiskMB int
+
+	// graceful shutdown - how long the uploads, jobs and requests in progress are waited for
+	ShutdownTimeout time.Duration
+
+	// backups of the SQLite database and the exams and learners folders, made every BackupInterval (0 for
+	// on demand only) into BackupDir, keeping the newest BackupKeep
+	BackupDir      string
+	BackupInterval time.Duration
+	BackupKeep     int
+
+	// year-end archives - written into ArchiveDir and signed with the Ed25519 key ArchiveKey, which is
+	// made on first use. The learners' submissions are purged RetentionYears after the end of their year,
+	// 0 keeps them forever
+	ArchiveDir     string
+	ArchiveKey     string
+	RetentionYears int
+
+	// development - serve the templates and static files from this folder instead of the ones built into
+	// the binary, the templates are reloaded when they change
+	AssetsDir string
+
+	// the value of each setting and the layer it came from, for String
+	values  map[string]string
+	sources map[string]string
 }
 
-func LoadConfig() Config {
-	// Try to load .env file, but don't fail if it doesn't exist
-	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found, will attempt to use environment variables")
-	}
-
-	// List of required environment variables
-	requiredEnvVars := map[string]string{
-		"DB_TYPE":        "",
-		"DB_USER":        "",
-		"DB_PASSWORD":    "",
-		"DB_NAME":        "",
-		"DB_HOST":        "",
-		"DB_PORT":        "",
-		"ADMIN_PASSWORD": "",
-		"JWT_SECRET":     "",
-		"ADMIN_EMAIL":    "",
-		"DATA_DIR":       "",
-		"ADSPORT":        "",
-	}
-
-	// Check for missing environment variables
-	var missingVars []string
-	for env := range requiredEnvVars {
-		if value := os.Getenv(env); value == "" {
-			missingVars = append(missingVars, env)
+// TLSEnabled reports if the service is served over HTTPS
+func (c Config) TLSEnabled() bool {
+	return c.TLSCert != "" && c.TLSKey != ""
+}
+
+// Load reads the configuration once at start up from the defaults, the config file, the environment and
+// the command line flags in args (without the program name), see settings.go. The arguments after the
+// flags are returned. A *ValidationError lists every setting that is missing or invalid
+func Load(args []string) (Config, []string, error) {
+	l, rest, err := newLoader(args)
+	if err != nil {
+		return Config{}, nil, err
+	}
+
+	// The database, SQLite needs only its file name in DATA_DIR
+	dbType := l.choice("DB_TYPE", "sqlite", "postgres")
+	dbPort := l.integer("DB_PORT", 1, "a port number")
+	if dbType == "postgres" {
+		l.require("for DB_TYPE=postgres", "DB_USER", "DB_PASSWORD", "DB_HOST")
+	}
+	l.require("", "ADMIN_EMAIL", "ADMIN_PASSWORD", "JWT_SECRET", "PUBLIC_URL")
+	l.integer("ADSPORT", 1, "a port number")
+	dataDir := l.get("DATA_DIR")
+
+	// The public address is the base of the emailed links, so a forged Host header cannot change them
+	publicURL := strings.TrimRight(l.get("PUBLIC_URL"), "/")
+	if publicURL != "" {
+		if u, err := url.Parse(publicURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" ||
+			u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+			l.problem("PUBLIC_URL", ErrInvalid, "an http:// or https:// address without a query e.g. https://ads.example.com")
 		}
 	}
 
-	// If any required variables are missing, log them and exit
-	if len(missingVars) > 0 {
-		log.Fatalf("Missing environment variables: %v", missingVars)
+	// Mail settings are optional, without an SMTP host the messages go to the outbox folder
+	smtpPort := l.integer("SMTP_PORT", 1, "a port number")
+	if l.get("SMTP_HOST") != "" {
+		l.fallback("MAIL_DRIVER", "smtp")
 	}
+	l.fallback("MAIL_DRIVER", "file")
+	mailDriver := l.choice("MAIL_DRIVER", "smtp", "file")
+	smtpTLS := l.choice("SMTP_TLS", "starttls", "tls", "none")
+	mailOutbox := l.fallback("MAIL_OUTBOX", dataDir+"/outbox")
 
-	// Get and validate DB_PORT
-	dbPortStr := os.Getenv("DB_PORT")
-	dbPort, err := strconv.Atoi(dbPortStr)
-	if err != nil {
-		log.Fatalf("Invalid DB_PORT value: %v", err)
+	// CORS policies, the Assessment Tool is open to any origin unless restricted
+	uiCredentials := l.boolean("CORS_UI_CREDENTIALS")
+	uiOrigins := splitList(l.get("CORS_UI_ORIGINS"))
+	if uiCredentials && slices.Contains(uiOrigins, "*") {
+		l.problem("CORS_UI_ORIGINS", ErrInvalid, "credentials cannot be allowed for any origin (*)")
+	}
+
+	// HTTPS settings, the certificate and key files are set together
+	tlsCert, tlsKey := l.get("TLS_CERT"), l.get("TLS_KEY")
+	if tlsCert != "" {
+		l.require("TLS_CERT and TLS_KEY must both be set", "TLS_KEY")
+	}
+	if tlsKey != "" {
+		l.require("TLS_CERT and TLS_KEY must both be set", "TLS_CERT")
+	}
+	var tlsMinVersion uint16 = tls.VersionTLS12
+	if l.choice("TLS_MIN_VERSION", "1.2", "1.3") == "1.3" {
+		tlsMinVersion = tls.VersionTLS13
+	}
+	redirectPort := l.get("HTTP_REDIRECT_PORT")
+	if redirectPort != "" {
+		if tlsCert == "" {
+			l.problem("HTTP_REDIRECT_PORT", ErrInvalid, "the HTTP redirect needs TLS_CERT and TLS_KEY")
+		}
+		l.integer("HTTP_REDIRECT_PORT", 1, "a port number")
+	}
+
+	// Directory authentication for staff, the local default admin always logs in with its password
+	authProvider := l.choice("AUTH_PROVIDER", "local", "ldap")
+	ldapStartTLS := l.boolean("LDAP_STARTTLS")
+	ldapSkipVerify := l.boolean("LDAP_SKIP_VERIFY")
+	ldapUserFilter := l.get("LDAP_USER_FILTER")
+	ldapAdminGroups := splitDNs(l.get("LDAP_ADMIN_GROUPS"))
+	ldapFacultyGroups := splitDNs(l.get("LDAP_FACULTY_GROUPS"))
+	if authProvider == "ldap" {
+		l.require("for AUTH_PROVIDER=ldap", "LDAP_URL", "LDAP_BASE_DN")
+		if len(ldapAdminGroups) == 0 && len(ldapFacultyGroups) == 0 {
+			l.require("LDAP_ADMIN_GROUPS or LDAP_FACULTY_GROUPS is needed for AUTH_PROVIDER=ldap", "LDAP_ADMIN_GROUPS")
+		}
+		if strings.Count(ldapUserFilter, "%s") != 1 {
+			l.problem("LDAP_USER_FILTER", ErrInvalid, "the filter needs one %s for the username")
+		}
+	}
+
+	// Logging, the rotating log files are kept under DATA_DIR/logs unless LOG_FILES=false
+	logLevel := l.choice("LOG_LEVEL", "debug", "info", "warn", "error")
+	logFormat := l.choice("LOG_FORMAT", "console", "json")
+	logDir := l.fallback("LOG_DIR", dataDir+"/logs")
+	if !l.boolean("LOG_FILES") {
+		logDir = ""
+	}
+
+	// Metrics allowlist, single addresses are taken as a /32 or /128 network
+	var metricsAllow []netip.Prefix
+	for _, item := range splitList(l.get("METRICS_ALLOW")) {
+		prefix, err := parsePrefix(item)
+		if err != nil {
+			l.problem("METRICS_ALLOW", ErrInvalid, item+" is not an IP address or CIDR network")
+			continue
+		}
+		metricsAllow = append(metricsAllow, prefix)
 	}
 
+	// Backups every BACKUP_INTERVAL_HOURS, 0 for on demand only
+	backupHours := l.integer("BACKUP_INTERVAL_HOURS", 0, "a number of hours, 0 for on demand only")
+
 	// Create and return the config
 	return Config{
-		DBtype:        os.Getenv("DB_TYPE"),
-		DBUser:        os.Getenv("DB_USER"),
-		DBPassword:    os.Getenv("DB_PASSWORD"),
-		DBName:        os.Getenv("DB_NAME"),
-		DBHost:        os.Getenv("DB_HOST"),
+		DBtype:        dbType,
+		DBUser:        l.get("DB_USER"),
+		DBPassword:    l.get("DB_PASSWORD"),
+		DBName:        l.get("DB_NAME"),
+		DBHost:        l.get("DB_HOST"),
 		DBPort:        dbPort,
-		AdminPassword: os.Getenv("ADMIN_PASSWORD"),
-		AdminEmail:    os.Getenv("ADMIN_EMAIL"),
-		JWTSecret:     os.Getenv("JWT_SECRET"),
-		DataDir:       os.Getenv("DATA_DIR"),
-		ADSPORT:       os.Getenv("ADSPORT"),
+		AdminPassword: l.get("ADMIN_PASSWORD"),
+		AdminEmail:    l.get("ADMIN_EMAIL"),
+		JWTSecret:     l.get("JWT_SECRET"),
+		DataDir:       dataDir,
+		ADSPORT:       l.get("ADSPORT"),
+		PublicURL:     publicURL,
+
+		RegistrationDomains: splitList(l.get("REGISTRATION_DOMAINS")),
+
+		MailDriver:   mailDriver,
+		SMTPHost:     l.get("SMTP_HOST"),
+		SMTPPort:     smtpPort,
+		SMTPUser:     l.get("SMTP_USER"),
+		SMTPPassword: l.get("SMTP_PASSWORD"),
+		SMTPFrom:     l.get("SMTP_FROM"),
+		SMTPTLS:      smtpTLS,
+		MailOutbox:   mailOutbox,
+
+		ExamLockoutThreshold: l.integer("EXAM_LOCKOUT_THRESHOLD", 1, "a positive number of failures"),
+
+		AssessmentOrigins:  splitList(l.get("CORS_ASSESSMENT_ORIGINS")),
+		AssessmentMethods:  methodList(l.get("CORS_ASSESSMENT_METHODS")),
+		UIOrigins:          uiOrigins,
+		UIMethods:          methodList(l.get("CORS_UI_METHODS")),
+		UIAllowCredentials: uiCredentials,
+
+		TLSCert:          tlsCert,
+		TLSKey:           tlsKey,
+		TLSMinVersion:    tlsMinVersion,
+		HTTPRedirectPort: redirectPort,
+
+		AuthProvider:      authProvider,
+		LDAPURL:           l.get("LDAP_URL"),
+		LDAPStartTLS:      ldapStartTLS,
+		LDAPSkipVerify:    ldapSkipVerify,
+		LDAPBindDN:        l.get("LDAP_BIND_DN"),
+		LDAPBindPassword:  l.get("LDAP_BIND_PASSWORD"),
+		LDAPBaseDN:        l.get("LDAP_BASE_DN"),
+		LDAPUserFilter:    ldapUserFilter,
+		LDAPUsernameAttr:  l.get("LDAP_USERNAME_ATTRIBUTE"),
+		LDAPEmailAttr:     l.get("LDAP_EMAIL_ATTRIBUTE"),
+		LDAPGroupAttr:     l.get("LDAP_GROUP_ATTRIBUTE"),
+		LDAPAdminGroups:   ldapAdminGroups,
+		LDAPFacultyGroups: ldapFacultyGroups,
+
+		LogLevel:      logLevel,
+		LogFormat:     logFormat,
+		LogDir:        logDir,
+		LogMaxSizeMB:  l.integer("LOG_MAX_SIZE_MB", 1, "a positive number"),
+		LogMaxFiles:   l.integer("LOG_MAX_FILES", 1, "a positive number"),
+		LogMaxAgeDays: l.integer("LOG_MAX_AGE_DAYS", 1, "a positive number"),
+
+		MetricsAllow: metricsAllow,
+
+		MigrationsDir: l.fallback("MIGRATIONS_DIR", dataDir+"/migrations"),
+		MinFreeDiskMB: l.integer("HEALTH_MIN_FREE_MB", 0, "a number of MB"),
+
+		ShutdownTimeout: time.Duration(l.integer("SHUTDOWN_TIMEOUT", 1, "a positive number of seconds")) * time.Second,
+
+		BackupDir:      l.fallback("BACKUP_DIR", dataDir+"/backups"),
+		BackupInterval: time.Duration(backupHours) * time.Hour,
+		BackupKeep:     l.integer("BACKUP_KEEP", 1, "a positive number"),
+
+		ArchiveDir:     l.fallback("ARCHIVE_DIR", dataDir+"/archives"),
+		ArchiveKey:     l.fallback("ARCHIVE_KEY", dataDir+"/keys/archive-ed25519.pem"),
+		RetentionYears: l.integer("RETENTION_YEARS", 0, "a number of years, 0 to keep the submissions forever"),
+
+		AssetsDir: l.get("ASSETS_DIR"),
+
+		values:  l.values,
+		sources: l.sources,
+	}, rest, l.err()
+}
+
+// String lists the settings that are set with the layer each one came from, the secrets are redacted
+func (c Config) String() string {
+	var b strings.Builder
+	for _, s := range settings {
+		value, ok := c.values[s.name]
+		if !ok {
+			continue
+		}
+		if s.secret {
+			value = redacted
+		}
+		fmt.Fprintf(&b, "%s=%s  # %s\n", s.name, value, c.sources[s.name])
+	}
+	return b.String()
+}
+
+// GoString redacts the secrets when the config is printed with %#v
+func (c Config) GoString() string {
+	return c.String()
+}
+
+// splitList splits a comma separated setting e.g. eit.ac.nz,student.eit.ac.nz, ignoring empty entries
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(strings.ToLower(item)); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// methodList splits a comma separated list of HTTP methods
+func methodList(value string) []string {
+	methods := splitList(value)
+	for i := range methods {
+		methods[i] = strings.ToUpper(methods[i])
+	}
+	return methods
+}
+
+// splitDNs splits a ; separated list of distinguished names, as DNs contain commas
+func splitDNs(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ";") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// parsePrefix parses an IP address or a CIDR network
+func parsePrefix(value string) (netip.Prefix, error) {
+	if strings.Contains(value, "/") {
+		prefix, err := netip.ParsePrefix(value)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return netip.Prefix{}, err
 	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
 }
@@ -0,0 +1,355 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/joho/godotenv"
+)
+
+/*
+	Settings
+	- every setting is named as its environment variable e.g. DB_TYPE, and read from these layers, each one
+	  overriding the ones before it
+	  - the defaults in the settings list below
+	  - the config file, KEY=value lines as in .env.example. It is given with -config or ADS_CONFIG, and
+	    is .env in the working folder when that exists
+	  - the environment variables
+	  - the command line flags, the name in lower case with dashes e.g. -db-type postgres
+	- the problems found are returned together as a ValidationError, the secrets are never shown
+*/
+
+// ConfigFileEnv names the config file when the -config flag is not given
+const ConfigFileEnv = "ADS_CONFIG"
+
+// defaultConfigFile is read when it exists and no config file is named
+const defaultConfigFile = ".env"
+
+// redacted replaces the value of a secret setting when it is shown
+const redacted = "********"
+
+// the layers a setting value comes from
+const (
+	sourceDefault = "default"
+	sourceEnv     = "env"
+	sourceFlag    = "flag"
+)
+
+// setting is a configuration value with its default, named as its environment variable
+type setting struct {
+	name   string
+	value  string
+	secret bool
+	usage  string
+}
+
+// settings lists every setting in the order of .env.example, the defaults that depend on DATA_DIR are
+// worked out when the settings are read
+var settings = []setting{
+	{name: "DB_TYPE", value: "sqlite", usage: "database: sqlite or postgres"},
+	{name: "DB_USER", usage: "PostgreSQL user"},
+	{name: "DB_PASSWORD", secret: true, usage: "PostgreSQL password"},
+	{name: "DB_NAME", value: "ADS4", usage: "PostgreSQL database, or the SQLite file name in DATA_DIR without .db"},
+	{name: "DB_HOST", usage: "PostgreSQL host"},
+	{name: "DB_PORT", value: "5432", usage: "PostgreSQL port"},
+	{name: "ADMIN_EMAIL", usage: "email of the default admin"},
+	{name: "ADMIN_PASSWORD", secret: true, usage: "password of the default admin, set when the database is seeded"},
+	{name: "JWT_SECRET", secret: true, usage: "secret signing the login tokens"},
+	{name: "DATA_DIR", value: "./data", usage: "data folder"},
+	{name: "ADSPORT", value: "8080", usage: "port the service listens on"},
+	{name: "PUBLIC_URL", usage: "address the users reach the service at, the base of the emailed links e.g. https://ads.example.com"},
+
+	{name: "REGISTRATION_DOMAINS", usage: "email domains allowed to self-register (comma separated)"},
+
+	{name: "MAIL_DRIVER", usage: "smtp or file, smtp when SMTP_HOST is set"},
+	{name: "SMTP_HOST", usage: "SMTP server"},
+	{name: "SMTP_PORT", value: "587", usage: "SMTP port"},
+	{name: "SMTP_USER", usage: "SMTP user"},
+	{name: "SMTP_PASSWORD", secret: true, usage: "SMTP password"},
+	{name: "SMTP_FROM", usage: "sender address of the emails"},
+	{name: "SMTP_TLS", value: "starttls", usage: "starttls, tls or none"},
+	{name: "MAIL_OUTBOX", usage: "folder of the file driver (default DATA_DIR/outbox)"},
+
+	{name: "EXAM_LOCKOUT_THRESHOLD", value: "50", usage: "failed exam authorisations and fetches from an address before it is locked out"},
+
+	{name: "CORS_ASSESSMENT_ORIGINS", value: "*", usage: "origins allowed to use the Assessment Tool routes (comma separated)"},
+	{name: "CORS_ASSESSMENT_METHODS", value: "GET,POST", usage: "methods allowed to the Assessment Tool origins"},
+	{name: "CORS_UI_ORIGINS", usage: "origins allowed to use the browser UI (comma separated)"},
+	{name: "CORS_UI_METHODS", value: "GET,POST,PUT,DELETE", usage: "methods allowed to the browser UI origins"},
+	{name: "CORS_UI_CREDENTIALS", value: "false", usage: "allow credentials from the browser UI origins"},
+
+	{name: "TLS_CERT", usage: "HTTPS certificate file"},
+	{name: "TLS_KEY", usage: "HTTPS private key file"},
+	{name: "TLS_MIN_VERSION", value: "1.2", usage: "1.2 or 1.3"},
+	{name: "HTTP_REDIRECT_PORT", usage: "plain HTTP port redirecting to HTTPS"},
+
+	{name: "AUTH_PROVIDER", value: "local", usage: "staff authentication: local or ldap"},
+	{name: "LDAP_URL", usage: "ldap://host:389 or ldaps://host:636"},
+	{name: "LDAP_STARTTLS", value: "false", usage: "upgrade ldap:// connections with StartTLS"},
+	{name: "LDAP_SKIP_VERIFY", value: "false", usage: "skip checking the directory certificate"},
+	{name: "LDAP_BIND_DN", usage: "service account finding the users, anonymous when not set"},
+	{name: "LDAP_BIND_PASSWORD", secret: true, usage: "password of the service account"},
+	{name: "LDAP_BASE_DN", usage: "where the users are searched"},
+	{name: "LDAP_USER_FILTER", value: "(uid=%s)", usage: "user search filter, %s is the username"},
+	{name: "LDAP_USERNAME_ATTRIBUTE", value: "uid", usage: "attribute holding the username"},
+	{name: "LDAP_EMAIL_ATTRIBUTE", value: "mail", usage: "attribute holding the email"},
+	{name: "LDAP_GROUP_ATTRIBUTE", value: "memberOf", usage: "attribute holding the groups"},
+	{name: "LDAP_ADMIN_GROUPS", usage: "groups given the Admin role (DNs separated by ;)"},
+	{name: "LDAP_FACULTY_GROUPS", usage: "groups given the Faculty role (DNs separated by ;)"},
+
+	{name: "LOG_LEVEL", value: "info", usage: "debug, info, warn or error"},
+	{name: "LOG_FORMAT", value: "console", usage: "console or json"},
+	{name: "LOG_FILES", value: "true", usage: "write the rotating log files"},
+	{name: "LOG_DIR", usage: "folder of the log files (default DATA_DIR/logs)"},
+	{name: "LOG_MAX_SIZE_MB", value: "10", usage: "size a log file is rotated at"},
+	{name: "LOG_MAX_FILES", value: "10", usage: "old log files kept"},
+	{name: "LOG_MAX_AGE_DAYS", value: "90", usage: "days the old log files are kept"},
+
+	{name: "METRICS_ALLOW", usage: "addresses or networks allowed to read /metrics and /health/ready/details (comma separated)"},
+
+	{name: "MIGRATIONS_DIR", usage: "goose migrations folder (default DATA_DIR/migrations)"},
+	{name: "HEALTH_MIN_FREE_MB", value: "500", usage: "free space below which the data folder is not ready"},
+
+	{name: "SHUTDOWN_TIMEOUT", value: "60", usage: "seconds the work in progress is waited for on shutdown"},
+
+	{name: "BACKUP_DIR", usage: "backups folder (default DATA_DIR/backups)"},
+	{name: "BACKUP_INTERVAL_HOURS", value: "24", usage: "hours between backups, 0 for on demand only"},
+	{name: "BACKUP_KEEP", value: "7", usage: "backups kept"},
+
+	{name: "ARCHIVE_DIR", usage: "year-end archives folder (default DATA_DIR/archives)"},
+	{name: "ARCHIVE_KEY", usage: "Ed25519 archive signing key (default DATA_DIR/keys/archive-ed25519.pem)"},
+	{name: "RETENTION_YEARS", value: "7", usage: "years the submissions are kept, 0 for ever"},
+
+	{name: "ASSETS_DIR", usage: "development only: serve the templates and static files from this folder"},
+}
+
+// the errors of a FieldError, to tell a missing setting from an invalid one with errors.Is
+var (
+	ErrRequired = errors.New("required")
+	ErrInvalid  = errors.New("invalid value")
+)
+
+// FieldError is a setting that is missing or has an invalid value
+type FieldError struct {
+	Name   string // the setting e.g. DB_PORT
+	Value  string // the value given, redacted for the secrets
+	Err    error  // ErrRequired or ErrInvalid
+	Detail string // what is expected
+}
+
+func (e *FieldError) Error() string {
+	message := e.Name + ": " + e.Err.Error()
+	if e.Value != "" {
+		message += " " + strconv.Quote(e.Value)
+	}
+	if e.Detail != "" {
+		message += " - " + e.Detail
+	}
+	return message
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// ValidationError holds every problem found in the settings
+type ValidationError struct {
+	Fields []*FieldError
+}
+
+func (e *ValidationError) Error() string {
+	lines := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		lines[i] = field.Error()
+	}
+	return "invalid configuration: " + strings.Join(lines, "; ")
+}
+
+// loader applies the layers of settings and collects the problems found while reading them
+type loader struct {
+	values  map[string]string // the value of each setting
+	sources map[string]string // the layer each value came from: default, the config file, env or flag
+	fields  []*FieldError
+}
+
+// newLoader reads the layers of settings, args are the command line arguments without the program name.
+// The arguments after the flags are returned e.g. a command and its own flags
+func newLoader(args []string) (*loader, []string, error) {
+	l := &loader{values: map[string]string{}, sources: map[string]string{}}
+	for _, s := range settings {
+		if s.value != "" {
+			l.set(s.name, s.value, sourceDefault)
+		}
+	}
+
+	// the flags are parsed first for -config, and applied last
+	flags := flag.NewFlagSet("ads", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv(ConfigFileEnv), "config file of KEY=value lines as in .env.example (default .env when it exists)")
+	given := map[string]string{}
+	for _, s := range settings {
+		name := s.name
+		usage := s.usage + " (" + name + ")"
+		flags.Func(flagName(name), usage, func(value string) error {
+			given[name] = value
+			return nil
+		})
+	}
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: ads [flags] [port]\n       ads gencert|restore|config|admin [flags]")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	file, required := *configFile, true
+	if file == "" {
+		file, required = defaultConfigFile, false
+	}
+	values, err := godotenv.Read(file)
+	switch {
+	case err == nil:
+		for _, s := range settings {
+			if value, ok := values[s.name]; ok {
+				l.set(s.name, value, file)
+			}
+		}
+	case required || !errors.Is(err, os.ErrNotExist):
+		return nil, nil, fmt.Errorf("reading the config file %s: %w", file, err)
+	}
+
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.name); ok {
+			l.set(s.name, value, sourceEnv)
+		}
+	}
+	for name, value := range given {
+		l.set(name, value, sourceFlag)
+	}
+	return l, flags.Args(), nil
+}
+
+// flagName returns the command line flag of a setting e.g. -db-type for DB_TYPE
+func flagName(name string) string {
+	return strings.ReplaceAll(strings.ToLower(name), "_", "-")
+}
+
+// set records the value of a setting, an empty value puts back the default as an unset one
+func (l *loader) set(name, value, source string) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		value, source = defaultValue(name), sourceDefault
+	}
+	if value == "" {
+		delete(l.values, name)
+		delete(l.sources, name)
+		return
+	}
+	l.values[name], l.sources[name] = value, source
+}
+
+// get returns the value of a setting, empty when it is not set
+func (l *loader) get(name string) string {
+	return l.values[name]
+}
+
+// fallback returns the value of a setting, or sets it to value when it is not set. For the defaults that
+// depend on another setting
+func (l *loader) fallback(name, value string) string {
+	if l.values[name] == "" && value != "" {
+		l.set(name, value, sourceDefault)
+	}
+	return l.values[name]
+}
+
+// problem records a setting that is missing or invalid
+func (l *loader) problem(name string, err error, detail string) {
+	value := l.values[name]
+	if value != "" && isSecret(name) {
+		value = redacted
+	}
+	l.fields = append(l.fields, &FieldError{Name: name, Value: value, Err: err, Detail: detail})
+}
+
+// require records the settings that are not set, detail says why they are needed
+func (l *loader) require(detail string, names ...string) {
+	for _, name := range names {
+		if l.get(name) == "" {
+			l.problem(name, ErrRequired, detail)
+		}
+	}
+}
+
+// integer returns a number setting of at least min
+func (l *loader) integer(name string, min int, detail string) int {
+	value := l.get(name)
+	if value == "" {
+		return 0
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number < min {
+		l.problem(name, ErrInvalid, detail)
+	}
+	return number
+}
+
+// boolean returns a true or false setting
+func (l *loader) boolean(name string) bool {
+	value := l.get(name)
+	if value == "" {
+		return false
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		l.problem(name, ErrInvalid, "true or false")
+	}
+	return b
+}
+
+// choice returns a setting that is one of options, in lower case
+func (l *loader) choice(name string, options ...string) string {
+	value := strings.ToLower(l.get(name))
+	for _, option := range options {
+		if value == option {
+			return value
+		}
+	}
+	expected := strings.Join(options, ", ")
+	if last := strings.LastIndex(expected, ", "); last >= 0 {
+		expected = expected[:last] + " or " + expected[last+2:]
+	}
+	l.problem(name, ErrInvalid, expected)
+	return value
+}
+
+// err returns the problems found, nil when there are none
+func (l *loader) err() error {
+	if len(l.fields) == 0 {
+		return nil
+	}
+	return &ValidationError{Fields: l.fields}
+}
+
+// lookup returns the setting name
+func lookup(name string) setting {
+	for _, s := range settings {
+		if s.name == name {
+			return s
+		}
+	}
+	return setting{name: name}
+}
+
+// defaultValue returns the default of a setting, empty when it has none
+func defaultValue(name string) string {
+	return lookup(name).value
+}
+
+// isSecret reports if the value of the setting is never shown
+func isSecret(name string) bool {
+	return lookup(name).secret
+}
@@ -0,0 +1,180 @@
+package database
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+)
+
+/*
+-- API keys for machine clients, only the SHA-256 hash of a key is stored
+CREATE TABLE "ApiKeys" (
+
+	"KeyID"       INTEGER,
+	"Name"        VARCHAR(64) NOT NULL,
+	"Prefix"      VARCHAR(16) NOT NULL,
+	"KeyHash"     VARCHAR(64) NOT NULL UNIQUE,
+	"Scopes"      VARCHAR(255) NOT NULL,
+	"CreatedBy"   INTEGER,
+	"CreatedAt"   TIMESTAMP NOT NULL,
+	"LastUsed"    TIMESTAMP,
+	"LastUsedIP"  VARCHAR(64),
+	"RevokedAt"   TIMESTAMP,
+	PRIMARY KEY("KeyID" AUTOINCREMENT),
+	FOREIGN KEY("CreatedBy") REFERENCES "UserT"("UserID") ON DELETE SET NULL
+
+);
+
+Revoked keys are kept so the list shows who had access and when it was last used.
+*/
+
+// how often the last used time of a key is written
+const apiKeyTouchInterval = time.Minute
+
+// used to hold an API key, the key itself is never stored
+type APIKey struct {
+	KeyID      int      `json:"key_id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"` // start of the key, to tell keys apart
+	Scopes     []string `json:"scopes"`
+	CreatedBy  string   `json:"created_by"` // username of the admin that created the key
+	CreatedAt  string   `json:"created_at"`
+	LastUsed   string   `json:"last_used"`
+	LastUsedIP string   `json:"last_used_ip"`
+	RevokedAt  string   `json:"revoked_at"`
+}
+
+// CreateAPIKey stores a new API key by its hash and returns the key ID
+func (db *DB) CreateAPIKey(name, prefix, keyHash string, scopes []string, createdBy int) (int, error) {
+	query := `
+		INSERT INTO ApiKeys (name, prefix, keyhash, scopes, createdby, createdat)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		`
+	result, err := db.Exec(query, name, prefix, keyHash, strings.Join(scopes, ","), createdBy, timestamp(time.Now()))
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// GetAPIKeyByHash retrieves an active key, sql.ErrNoRows is returned for an unknown or revoked key
+func (db *DB) GetAPIKeyByHash(keyHash string) (*APIKey, error) {
+	query := `
+		SELECT k.keyid, k.name, k.prefix, k.scopes, COALESCE(u.username, ''), COALESCE(k.createdat, ''),
+			COALESCE(k.lastused, ''), COALESCE(k.lastusedip, ''), ''
+		FROM ApiKeys k LEFT JOIN UserT u ON u.userid = k.createdby
+		WHERE k.keyhash = $1 AND k.revokedat IS NULL`
+	return scanAPIKey(db.QueryRow(query, keyHash))
+}
+
+// GetAPIKeyByID retrieves a key by its ID, revoked or not
+func (db *DB) GetAPIKeyByID(keyid int) (*APIKey, error) {
+	query := `
+		SELECT k.keyid, k.name, k.prefix, k.scopes, COALESCE(u.username, ''), COALESCE(k.createdat, ''),
+			COALESCE(k.lastused, ''), COALESCE(k.lastusedip, ''), COALESCE(k.revokedat, '')
+		FROM ApiKeys k LEFT JOIN UserT u ON u.userid = k.createdby
+		WHERE k.keyid = $1`
+	return scanAPIKey(db.QueryRow(query, keyid))
+}
+
+// TouchAPIKey records the use of a key, at most once every apiKeyTouchInterval
+func (db *DB) TouchAPIKey(keyid int, ip string) error {
+	now := time.Now()
+	query := `
+		UPDATE ApiKeys SET lastused = $1, lastusedip = $2
+		WHERE keyid = $3 AND (lastused IS NULL OR lastused < $4)`
+	_, err := db.Exec(query, timestamp(now), truncate(ip, 64), keyid, timestamp(now.Add(-apiKeyTouchInterval)))
+	return err
+}
+
+// GetAPIKeys retrieves a page of the API keys, newest first, with a free text search on the name and prefix.
+// Revoked keys are only included when asked for. It also returns the total number of keys
+func (db *DB) GetAPIKeys(includeRevoked bool, opts ListOptions) ([]APIKey, int, error) {
+	opts.Normalise()
+	from := `ApiKeys k LEFT JOIN UserT u ON u.userid = k.createdby`
+
+	var where whereBuilder
+	if !includeRevoked {
+		where.addRaw("k.revokedat IS NULL")
+	}
+	where.addSearch(opts.Search, "k.name", "k.prefix")
+
+	total, err := db.count(from, &where)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sortColumns := map[string]string{
+		"name":       "k.name",
+		"created_at": "k.createdat",
+		"last_used":  "k.lastused",
+	}
+	if opts.Sort == "" {
+		opts.Desc = true
+	}
+	query := `
+		SELECT k.keyid, k.name, k.prefix, k.scopes, COALESCE(u.username, ''), COALESCE(k.createdat, ''),
+			COALESCE(k.lastused, ''), COALESCE(k.lastusedip, ''), COALESCE(k.revokedat, '')
+		FROM ` + from + where.String() + where.orderAndLimit(opts, sortColumns, "k.createdat", "k.keyid")
+
+	rows, err := db.Query(query, where.args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		keys = append(keys, *key)
+	}
+
+	return keys, total, rows.Err()
+}
+
+// RevokeAPIKey stops a key from being accepted. Returns sql.ErrNoRows when there is no active key with the ID
+func (db *DB) RevokeAPIKey(keyid int) error {
+	result, err := db.Exec(`UPDATE ApiKeys SET revokedat = $1 WHERE keyid = $2 AND revokedat IS NULL`, timestamp(time.Now()), keyid)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanAPIKey reads an API key row in the column order used by the key queries
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	var key APIKey
+	var scopes string
+	err := row.Scan(
+		&key.KeyID,
+		&key.Name,
+		&key.Prefix,
+		&scopes,
+		&key.CreatedBy,
+		&key.CreatedAt,
+		&key.LastUsed,
+		&key.LastUsedIP,
+		&key.RevokedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	key.Scopes = strings.Split(scopes, ",")
+	return &key, nil
+}
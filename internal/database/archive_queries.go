@@ -0,0 +1,374 @@
+package database
+
+import (
+	"strings"
+	"time"
+)
+
+/*
+-- Year-end archives of a closed year or semester, Semester is empty for the whole year
+CREATE TABLE "Archives" (
+
+	"ArchiveID"           INTEGER,
+	"Year"                INTEGER NOT NULL,
+	"Semester"            VARCHAR(2) NOT NULL DEFAULT '',
+	"RecordsFile"         VARCHAR(255) NOT NULL,
+	"RecordsSHA256"       VARCHAR(64) NOT NULL,
+	"SubmissionsFile"     VARCHAR(255) NOT NULL,
+	"SubmissionsSHA256"   VARCHAR(64) NOT NULL,
+	"Offerings"           INTEGER NOT NULL DEFAULT 0,
+	"Results"             INTEGER NOT NULL DEFAULT 0,
+	"SubmissionFiles"     INTEGER NOT NULL DEFAULT 0,
+	"SubmissionBytes"     INTEGER NOT NULL DEFAULT 0,
+	"CreatedBy"           VARCHAR(80) NOT NULL,
+	"CreatedAt"           TIMESTAMP NOT NULL,
+	"SubmissionsPurgedAt" TIMESTAMP,
+	PRIMARY KEY("ArchiveID" AUTOINCREMENT)
+
+);
+
+-- Searchable index of the archived results
+CREATE TABLE "ArchivedResults" (
+
+	"ArchiveID"   INTEGER NOT NULL,
+	"StudentID"   VARCHAR(8) NOT NULL,
+	"Name"        VARCHAR NOT NULL DEFAULT '',
+	"ExamID"      VARCHAR(15) NOT NULL,
+	"Year"        INTEGER NOT NULL,
+	"Semester"    VARCHAR(2) NOT NULL,
+	"CourseCode"  VARCHAR(9) NOT NULL,
+	"Status"      VARCHAR(6) NOT NULL,
+	"Grade"       INTEGER DEFAULT 0,
+	"Feedback"    TEXT,
+	"StartTime"   TIME,
+	"EndTime"     TIME,
+	PRIMARY KEY("ArchiveID", "StudentID", "ExamID"),
+	FOREIGN KEY("ArchiveID") REFERENCES "Archives"("ArchiveID") ON DELETE CASCADE
+
+);
+
+Archiving copies the results of the period into ArchivedResults and removes the offerings, results,
+marks and moderation of the period from the live tables in one transaction.
+*/
+
+// used to hold a year-end archive
+type Archive struct {
+	ArchiveID           int    `json:"archive_id"`
+	Year                int    `json:"year"`
+	Semester            string `json:"semester"` // empty for the whole year
+	RecordsFile         string `json:"records_file"`
+	RecordsSHA256       string `json:"records_sha256"`
+	SubmissionsFile     string `json:"submissions_file"`
+	SubmissionsSHA256   string `json:"submissions_sha256"`
+	Offerings           int    `json:"offerings"`
+	Results             int    `json:"results"`
+	SubmissionFiles     int    `json:"submission_files"`
+	SubmissionBytes     int64  `json:"submission_bytes"`
+	CreatedBy           string `json:"created_by"`
+	CreatedAt           string `json:"created_at"`
+	SubmissionsPurgedAt string `json:"submissions_purged_at"`
+}
+
+// used to hold an archived result of the searchable index
+type ArchivedResult struct {
+	ArchiveID  int    `json:"archive_id"`
+	StudentID  string `json:"studentid"`
+	Name       string `json:"name"`
+	ExamID     string `json:"examid"`
+	Year       int    `json:"year"`
+	Semester   string `json:"semester"`
+	CourseCode string `json:"coursecode"`
+	Status     string `json:"status"`
+	Grade      int    `json:"grade"`
+	Feedback   string `json:"feedback"`
+	StartTime  string `json:"starttime"`
+	EndTime    string `json:"endtime"`
+}
+
+// ArchivedResultFilter holds the optional filters of the archived results search
+type ArchivedResultFilter struct {
+	StudentID  string
+	CourseCode string
+	Year       int
+	Semester   string
+}
+
+// PeriodSummary counts what stops a year or semester from being archived
+type PeriodSummary struct {
+	Offerings      int `json:"offerings"`
+	OpenOfferings  int `json:"open_offerings"`
+	Results        int `json:"results"`
+	ActiveAttempts int `json:"active_attempts"`
+}
+
+// the tables holding the rows of an offering, archived and removed with it
+var offeringTables = []string{"Marks", "MarkingSteps", "Moderators", "Learnerexams"}
+
+// periodOfferings is the sub-query of the ExamIDs of the period, with the year and semester as $1 and $2.
+// An empty semester matches the whole year
+const periodOfferings = `SELECT examid FROM Offerings WHERE year = $1 AND ($2 = '' OR semester = $2)`
+
+// GetPeriodSummary counts the offerings and results of a year or semester, with the offerings that are
+// not closed and the attempts in progress
+func (db *DB) GetPeriodSummary(year int, semester string) (*PeriodSummary, error) {
+	var summary PeriodSummary
+	query := `
+		SELECT COUNT(*), COALESCE(SUM(CASE WHEN status <> 'closed' THEN 1 ELSE 0 END), 0)
+		FROM Offerings WHERE year = $1 AND ($2 = '' OR semester = $2)`
+	if err := db.QueryRow(query, year, semester).Scan(&summary.Offerings, &summary.OpenOfferings); err != nil {
+		return nil, err
+	}
+
+	query = `
+		SELECT COUNT(*), COALESCE(SUM(CASE WHEN status = 'active' THEN 1 ELSE 0 END), 0)
+		FROM Learnerexams WHERE examid IN (` + periodOfferings + `)`
+	if err := db.QueryRow(query, year, semester).Scan(&summary.Results, &summary.ActiveAttempts); err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// GetPeriodRecords retrieves every row of the period's offerings and of the tables that hang off them,
+// keyed by table, for the records archive. It also returns the ExamIDs of the period
+func (db *DB) GetPeriodRecords(year int, semester string) (map[string]any, []string, error) {
+	tables := map[string]any{}
+
+	offerings, err := db.dumpRows(`SELECT * FROM Offerings WHERE year = $1 AND ($2 = '' OR semester = $2) ORDER BY examid`, year, semester)
+	if err != nil {
+		return nil, nil, err
+	}
+	tables["Offerings"] = offerings
+
+	examIDs := make([]string, 0, len(offerings))
+	for _, row := range offerings {
+		for column, value := range row {
+			if id, ok := value.(string); ok && strings.EqualFold(column, "examid") {
+				examIDs = append(examIDs, id)
+			}
+		}
+	}
+
+	for _, table := range offeringTables {
+		rows, err := db.dumpRows(`SELECT * FROM `+table+` WHERE examid IN (`+periodOfferings+`) ORDER BY examid`, year, semester)
+		if err != nil {
+			return nil, nil, err
+		}
+		tables[table] = rows
+	}
+	return tables, examIDs, nil
+}
+
+// dumpRows reads the rows of a query as column name to value maps
+func (db *DB) dumpRows(query string, args ...any) ([]map[string]any, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	result := []map[string]any{}
+	for rows.Next() {
+		values := make([]any, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]any, len(columns))
+		for i, column := range columns {
+			if data, ok := values[i].([]byte); ok {
+				values[i] = string(data)
+			}
+			row[column] = values[i]
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// ArchivePeriod records the archive, copies the results of its period into the archived results and
+// removes the period from the live tables, all in one transaction. The ArchiveID is set on archive
+func (db *DB) ArchivePeriod(archive *Archive) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	archive.CreatedAt = timestamp(time.Now())
+	query := `
+		INSERT INTO Archives (year, semester, recordsfile, recordssha256, submissionsfile, submissionssha256,
+			offerings, results, submissionfiles, submissionbytes, createdby, createdat)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		`
+	result, err := tx.Exec(query, archive.Year, archive.Semester, archive.RecordsFile, archive.RecordsSHA256,
+		archive.SubmissionsFile, archive.SubmissionsSHA256, archive.Offerings, archive.Results,
+		archive.SubmissionFiles, archive.SubmissionBytes, truncate(archive.CreatedBy, 80), archive.CreatedAt)
+	if err != nil {
+		return err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	archive.ArchiveID = int(id)
+
+	query = `
+		INSERT INTO ArchivedResults (archiveid, studentid, name, examid, year, semester, coursecode, status,
+			grade, feedback, starttime, endtime)
+		SELECT $1, l.studentid, COALESCE(s.name, ''), l.examid, o.year, o.semester, o.coursecode, l.status,
+			l.grade, l.feedback, l.starttime, l.endtime
+		FROM Learnerexams l
+		JOIN Offerings o ON o.examid = l.examid
+		LEFT JOIN Learners s ON s.studentid = l.studentid
+		WHERE o.year = $2 AND ($3 = '' OR o.semester = $3)
+		`
+	if _, err := tx.Exec(query, archive.ArchiveID, archive.Year, archive.Semester); err != nil {
+		return err
+	}
+
+	// the rows that reference the offerings go first
+	for _, table := range offeringTables {
+		if _, err := tx.Exec(`DELETE FROM `+table+` WHERE examid IN (`+periodOfferings+`)`, archive.Year, archive.Semester); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`DELETE FROM Offerings WHERE year = $1 AND ($2 = '' OR semester = $2)`, archive.Year, archive.Semester); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+const archiveColumns = `archiveid, year, semester, recordsfile, recordssha256, submissionsfile, submissionssha256,
+	offerings, results, submissionfiles, submissionbytes, createdby, COALESCE(createdat, ''), COALESCE(submissionspurgedat, '')`
+
+// GetArchives retrieves the archives, newest first
+func (db *DB) GetArchives() ([]Archive, error) {
+	return db.queryArchives(`SELECT ` + archiveColumns + ` FROM Archives ORDER BY archiveid DESC`)
+}
+
+// GetArchiveByID retrieves an archive, sql.ErrNoRows is returned for an unknown archive
+func (db *DB) GetArchiveByID(id int) (*Archive, error) {
+	return scanArchive(db.QueryRow(`SELECT `+archiveColumns+` FROM Archives WHERE archiveid = $1`, id))
+}
+
+// GetArchivesToPurge retrieves the archives of the years before beforeYear whose submissions are still kept
+func (db *DB) GetArchivesToPurge(beforeYear int) ([]Archive, error) {
+	query := `SELECT ` + archiveColumns + ` FROM Archives WHERE year < $1 AND submissionspurgedat IS NULL ORDER BY archiveid`
+	return db.queryArchives(query, beforeYear)
+}
+
+// MarkSubmissionsPurged records that the submissions archive of an archive was removed
+func (db *DB) MarkSubmissionsPurged(id int) error {
+	_, err := db.Exec(`UPDATE Archives SET submissionspurgedat = $1 WHERE archiveid = $2`, timestamp(time.Now()), id)
+	return err
+}
+
+func (db *DB) queryArchives(query string, args ...any) ([]Archive, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	archives := []Archive{}
+	for rows.Next() {
+		archive, err := scanArchive(rows)
+		if err != nil {
+			return nil, err
+		}
+		archives = append(archives, *archive)
+	}
+	return archives, rows.Err()
+}
+
+// scanArchive reads an archive row in the order of archiveColumns
+func scanArchive(row rowScanner) (*Archive, error) {
+	var archive Archive
+	err := row.Scan(
+		&archive.ArchiveID,
+		&archive.Year,
+		&archive.Semester,
+		&archive.RecordsFile,
+		&archive.RecordsSHA256,
+		&archive.SubmissionsFile,
+		&archive.SubmissionsSHA256,
+		&archive.Offerings,
+		&archive.Results,
+		&archive.SubmissionFiles,
+		&archive.SubmissionBytes,
+		&archive.CreatedBy,
+		&archive.CreatedAt,
+		&archive.SubmissionsPurgedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &archive, nil
+}
+
+// SearchArchivedResults retrieves a page of the archived results with a free text search on the
+// student ID, name, ExamID and course code. It also returns the total number of matching results
+func (db *DB) SearchArchivedResults(filter ArchivedResultFilter, opts ListOptions) ([]ArchivedResult, int, error) {
+	opts.Normalise()
+	from := `ArchivedResults`
+
+	var where whereBuilder
+	if filter.StudentID != "" {
+		where.add("studentid", filter.StudentID)
+	}
+	if filter.CourseCode != "" {
+		where.add("coursecode", filter.CourseCode)
+	}
+	if filter.Year != 0 {
+		where.add("year", filter.Year)
+	}
+	if filter.Semester != "" {
+		where.add("semester", filter.Semester)
+	}
+	where.addSearch(opts.Search, "studentid", "name", "examid", "coursecode")
+
+	total, err := db.count(from, &where)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sortColumns := map[string]string{
+		"studentid":  "studentid",
+		"name":       "name",
+		"examid":     "examid",
+		"coursecode": "coursecode",
+		"year":       "year",
+		"grade":      "grade",
+	}
+	query := `
+		SELECT archiveid, studentid, name, examid, year, semester, coursecode, status, COALESCE(grade, 0),
+			COALESCE(feedback, ''), COALESCE(starttime, ''), COALESCE(endtime, '')
+		FROM ` + from + where.String() + where.orderAndLimit(opts, sortColumns, "examid", "archiveid, studentid, examid")
+
+	rows, err := db.Query(query, where.args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	results := []ArchivedResult{}
+	for rows.Next() {
+		var result ArchivedResult
+		err := rows.Scan(&result.ArchiveID, &result.StudentID, &result.Name, &result.ExamID, &result.Year,
+			&result.Semester, &result.CourseCode, &result.Status, &result.Grade, &result.Feedback,
+			&result.StartTime, &result.EndTime)
+		if err != nil {
+			return nil, 0, err
+		}
+		results = append(results, result)
+	}
+	return results, total, rows.Err()
+}
@@ -0,0 +1,209 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+/*
+-- Audit trail of the changes made through the staff and admin routes
+CREATE TABLE "AuditLog" (
+
+	"AuditID"     INTEGER,
+	"CreatedAt"   TIMESTAMP NOT NULL,
+	"ActorID"     INTEGER NOT NULL,
+	"Actor"       VARCHAR(80) NOT NULL,
+	"Role"        VARCHAR(20) NOT NULL,
+	"Action"      VARCHAR(16) NOT NULL,
+	"Entity"      VARCHAR(32) NOT NULL,
+	"EntityID"    VARCHAR(64),
+	"Detail"      VARCHAR(255),
+	"Before"      TEXT,
+	"After"       TEXT,
+	"Method"      VARCHAR(8) NOT NULL,
+	"Path"        VARCHAR(255) NOT NULL,
+	"Status"      INTEGER NOT NULL,
+	"IP"          VARCHAR(64),
+	PRIMARY KEY("AuditID" AUTOINCREMENT)
+
+);
+
+Entries are only ever added, the actor is copied so the trail survives the removal of the account.
+*/
+
+// used to hold an audit entry, Before and After are JSON snapshots of the entity
+type AuditEntry struct {
+	AuditID   int    `json:"audit_id"`
+	CreatedAt string `json:"created_at"`
+	ActorID   int    `json:"actor_id"`
+	Actor     string `json:"actor"`
+	Role      string `json:"role"`
+	Action    string `json:"action"` // create, update, delete, import
+	Entity    string `json:"entity"` // e.g. user, learnerexam, offering
+	EntityID  string `json:"entity_id"`
+	Detail    string `json:"detail"`
+	Before    string `json:"before"`
+	After     string `json:"after"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Status    int    `json:"status"`
+	IP        string `json:"ip"`
+}
+
+// AuditFilter holds the optional filters of the audit view, From and To are dates e.g. 2026-10-19
+type AuditFilter struct {
+	Actor    string
+	Action   string
+	Entity   string
+	EntityID string
+	From     string
+	To       string
+}
+
+// CreateAuditEntry adds an entry to the audit trail
+func (db *DB) CreateAuditEntry(entry *AuditEntry) error {
+	query := `
+		INSERT INTO AuditLog (createdat, actorid, actor, role, action, entity, entityid, detail, before, after, method, path, status, ip)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		`
+	_, err := db.Exec(query,
+		timestamp(time.Now()),
+		entry.ActorID,
+		truncate(entry.Actor, 80),
+		entry.Role,
+		entry.Action,
+		entry.Entity,
+		nullString(truncate(entry.EntityID, 64)),
+		nullString(truncate(entry.Detail, 255)),
+		nullString(entry.Before),
+		nullString(entry.After),
+		entry.Method,
+		truncate(entry.Path, 255),
+		entry.Status,
+		truncate(entry.IP, 64),
+	)
+	return err
+}
+
+// auditWhere builds the WHERE clause of the audit filters
+func auditWhere(filter AuditFilter) whereBuilder {
+	var where whereBuilder
+	if filter.Actor != "" {
+		where.add("actor", filter.Actor)
+	}
+	if filter.Action != "" {
+		where.add("action", filter.Action)
+	}
+	if filter.Entity != "" {
+		where.add("entity", filter.Entity)
+	}
+	if filter.EntityID != "" {
+		where.add("entityid", filter.EntityID)
+	}
+	if filter.From != "" {
+		where.addRaw("createdat >= " + where.next(filter.From))
+	}
+	if filter.To != "" {
+		// the whole of the To day is included
+		where.addRaw("createdat < DATE(" + where.next(filter.To) + ", '+1 day')")
+	}
+	return where
+}
+
+const auditColumns = `auditid, COALESCE(createdat, ''), actorid, actor, role, action, entity, COALESCE(entityid, ''),
+	COALESCE(detail, ''), COALESCE(before, ''), COALESCE(after, ''), method, path, status, COALESCE(ip, '')`
+
+// GetAuditEntries retrieves a page of the audit trail, newest first, with a free text search on the
+// actor, entity ID and detail. It also returns the total number of matching entries
+func (db *DB) GetAuditEntries(filter AuditFilter, opts ListOptions) ([]AuditEntry, int, error) {
+	opts.Normalise()
+	from := `AuditLog`
+
+	where := auditWhere(filter)
+	where.addSearch(opts.Search, "actor", "entityid", "detail")
+
+	total, err := db.count(from, &where)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sortColumns := map[string]string{
+		"created_at": "auditid",
+		"actor":      "actor",
+		"entity":     "entity",
+		"action":     "action",
+	}
+	if opts.Sort == "" {
+		opts.Desc = true
+	}
+	query := `SELECT ` + auditColumns + ` FROM ` + from + where.String() + where.orderAndLimit(opts, sortColumns, "auditid", "auditid")
+
+	rows, err := db.Query(query, where.args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		entries = append(entries, *entry)
+	}
+
+	return entries, total, rows.Err()
+}
+
+// ExportAuditEntries passes every entry matching the filter to fn, oldest first, for the CSV export
+func (db *DB) ExportAuditEntries(filter AuditFilter, fn func(entry *AuditEntry) error) error {
+	where := auditWhere(filter)
+	rows, err := db.Query(`SELECT `+auditColumns+` FROM AuditLog`+where.String()+` ORDER BY auditid`, where.args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		entry, err := scanAuditEntry(rows)
+		if err != nil {
+			return err
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// scanAuditEntry reads an audit row in the order of auditColumns
+func scanAuditEntry(row rowScanner) (*AuditEntry, error) {
+	var entry AuditEntry
+	err := row.Scan(
+		&entry.AuditID,
+		&entry.CreatedAt,
+		&entry.ActorID,
+		&entry.Actor,
+		&entry.Role,
+		&entry.Action,
+		&entry.Entity,
+		&entry.EntityID,
+		&entry.Detail,
+		&entry.Before,
+		&entry.After,
+		&entry.Method,
+		&entry.Path,
+		&entry.Status,
+		&entry.IP,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// nullString stores an empty string as NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
@@ -0,0 +1,8 @@
+package database
+
+// BackupTo writes a consistent copy of the SQLite database to path while the service keeps running,
+// the file must not exist yet
+func (db *DB) BackupTo(path string) error {
+	_, err := db.Exec("VACUUM INTO $1", path)
+	return err
+}
@@ -6,51 +6,41 @@ import (
 	_ "database/sql"
 )
 
-/*
--- Table to store courses created by admins, with a unique course code
-CREATE TABLE IF NOT EXISTS "Courses" (
-	"CourseCode"  VARCHAR(9),
-	"Description" VARCHAR(255),
-	"Level"  	  int, -- 1-9
-    "Status"      VARCHAR(6) NOT NULL DEFAULT 'active', -- active, closed
-	PRIMARY KEY("CourseCode"),
-	UNIQUE("CourseCode"),
-	CHECK (Status IN ('active','closed'))
-	CHECK (Level IN (1,2,33,4,5,6,7,8,9)))
-);
-*/
-// GetAllCourses retrieves all exam Courses from the database, with optional filtering by exam ID or status code. If no Courses are found, it returns an empty slice.
-func (db *DB) GetAllCourses(coursecode string, statusCode string) ([]models.Courses, error) {
-	var query string
-	var args []interface{}
-	var CourseExists bool
-
-	// Check if the Course exists (if exam ID is provided)
+// GetAllCourses retrieves a page of exam Courses from the database, with optional filtering by course code and status code
+// and a free text search on the course code and description. It also returns the total number of matching Courses.
+// If no Courses are found, it returns an empty slice.
+func (db *DB) GetAllCourses(coursecode string, statusCode string, opts ListOptions) ([]models.Courses, int, error) {
+	opts.Normalise()
+	from := `Courses o`
+
+	// Add the optional filters
+	var where whereBuilder
+	if statusCode != "" {
+		where.add("o.Status", statusCode)
+	}
 	if coursecode != "" {
-		CourseExistsQuery := `
-		SELECT EXISTS (SELECT 1 FROM Courses o WHERE o.CourseCode = $1)`
-		err := db.QueryRow(CourseExistsQuery, coursecode).Scan(&CourseExists)
-		if err != nil && err == sql.ErrNoRows {
-			return nil, err
-		}
+		where.add("o.CourseCode", coursecode)
 	}
-	query = `SELECT o.CourseCode, o.Description, o.Level, o.Status
- 		     FROM Courses o
-	`
+	where.addSearch(opts.Search, "o.CourseCode", "o.Description")
 
-	// Add filtering by examid OR statuscode not both
-	if statusCode != "" {
-		query += `WHERE o.Status = $1`
-		args = append(args, statusCode)
-	} else if coursecode != "" {
-		query += `WHERE o.CourseCode = $1`
-		args = append(args, coursecode)
+	total, err := db.count(from, &where)
+	if err != nil {
+		return nil, 0, err
+	}
 
+	sortColumns := map[string]string{
+		"coursecode":  "o.CourseCode",
+		"description": "o.Description",
+		"level":       "o.Level",
+		"status":      "o.Status",
 	}
+	query := `SELECT o.CourseCode, o.Description, o.Level, o.Status FROM ` + from +
+		where.String() + where.orderAndLimit(opts, sortColumns, "o.CourseCode", "o.CourseCode")
+
 	// Prepare and execute the query
-	rows, err := db.Query(query, args...)
+	rows, err := db.Query(query, where.args...)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
 
@@ -68,7 +58,7 @@ func (db *DB) GetAllCourses(coursecode string, statusCode string) ([]models.Cour
 		)
 
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 
 		Courses = append(Courses, Course)
@@ -77,10 +67,10 @@ func (db *DB) GetAllCourses(coursecode string, statusCode string) ([]models.Cour
 	// Return empty slice if:
 	// 1. no Courses are found
 	if len(Courses) == 0 {
-		return []models.Courses{}, nil
+		return []models.Courses{}, total, nil
 	}
 
-	return Courses, nil
+	return Courses, total, nil
 }
 
 // GetCourseByID retrieves a specific exam Course from the database based on the provided exam ID. If the Course is not found, it returns nil.
@@ -100,7 +90,7 @@ func (db *DB) GetCourseByID(coursecode string) (*models.Courses, error) {
 	}
 
 	query = `SELECT o.CourseCode, o.description, o.Level, o.Status
- 		     FROM Courses o WHERE o.CourseCode = $1'`
+ 		     FROM Courses o WHERE o.CourseCode = $1`
 
 	var Course models.Courses
 	err := db.QueryRow(query, coursecode).Scan(
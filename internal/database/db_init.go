@@ -1,37 +1,82 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	"log"
 
 	"ADS4/internal/config"
 
 	_ "github.com/lib/pq" // PostgreSQL driver
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/rs/zerolog"
 )
 
 type DB struct {
 	*sql.DB
+	ctx context.Context // the context of the calls, see WithContext
 }
+
+// WithContext returns the database with its calls made in ctx, so they carry its logger and request ID.
+// The calls are not cancelled with ctx: a request whose client has gone still finishes its changes
+func (db *DB) WithContext(ctx context.Context) *DB {
+	return &DB{DB: db.DB, ctx: context.WithoutCancel(ctx)}
+}
+
+// context returns the context of the calls, the background one unless set with WithContext
+func (db *DB) context() context.Context {
+	if db.ctx == nil {
+		return context.Background()
+	}
+	return db.ctx
+}
+
+// logger returns the logger of the context of the calls, the request logger when set with WithContext
+func (db *DB) logger() *zerolog.Logger {
+	return zerolog.Ctx(db.context())
+}
+
+// Query, QueryRow, Exec, Prepare and Begin are made in the context of the database, the query methods
+// below use them so they pass it on
+func (db *DB) Query(query string, args ...any) (*sql.Rows, error) {
+	return db.DB.QueryContext(db.context(), query, args...)
+}
+
+func (db *DB) QueryRow(query string, args ...any) *sql.Row {
+	return db.DB.QueryRowContext(db.context(), query, args...)
+}
+
+func (db *DB) Exec(query string, args ...any) (sql.Result, error) {
+	return db.DB.ExecContext(db.context(), query, args...)
+}
+
+func (db *DB) Prepare(query string) (*sql.Stmt, error) {
+	return db.DB.PrepareContext(db.context(), query)
+}
+
+func (db *DB) Begin() (*sql.Tx, error) {
+	return db.DB.BeginTx(db.context(), nil)
+}
+
 //https://www.sqlite.org/pragma.html#pragma_synchronous 
 func NewDB(cfg config.Config) (*DB, error) {
-	log.Println("Connecting to database...")
+	logger := zerolog.Ctx(context.Background())
+	logger.Info().Msg("Connecting to database...")
 
 	if cfg.DBtype == "sqlite" {
-		db, err := sql.Open("sqlite3", fmt.Sprintf("file:"+cfg.DataDir+"/%s.db?cache=shared&_journal_mode=WAL", cfg.DBName)) //ADS4.db
+		db, err := sql.Open(timedDriverName("sqlite3"), fmt.Sprintf("file:"+cfg.DataDir+"/%s.db?cache=shared&_journal_mode=WAL", cfg.DBName)) //ADS4.db
 		if err != nil {
 			return nil, fmt.Errorf("🔥 failed to connect to the database: %s", err)
 		}
 
-		log.Println("SQLite database connected successfully")
-		return &DB{db}, nil
+		logger.Info().Msg("SQLite database connected successfully")
+		return &DB{DB: db}, nil
 	}
 
 	if cfg.DBtype == "postgres" {
 		connStr := fmt.Sprintf("user=%s password=%s dbname=%s host=%s port=%d sslmode=disable",
 			cfg.DBUser, cfg.DBPassword, cfg.DBName, cfg.DBHost, cfg.DBPort)
-		db, err := sql.Open("postgres", connStr)
+		db, err := sql.Open(timedDriverName("postgres"), connStr)
 		if err != nil {
 			return nil, fmt.Errorf("🔥 failed to connect to the database: %s", err)
 		}
@@ -40,9 +85,9 @@ func NewDB(cfg config.Config) (*DB, error) {
 		if err := db.Ping(); err != nil {
 			return nil, err
 		}
-		log.Println("Postgres database connected successfully")
+		logger.Info().Msg("Postgres database connected successfully")
 
-		return &DB{db}, nil
+		return &DB{DB: db}, nil
 	}
 	return nil, fmt.Errorf("unsupported database type: %s", cfg.DBtype)
 }
@@ -0,0 +1,233 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"sync"
+	"time"
+
+	"ADS4/internal/metrics"
+
+	"github.com/rs/zerolog"
+)
+
+/*
+	Database call timing
+	- the database is opened through a wrapper of the SQLite or PostgreSQL driver that times every query,
+	  statement and exec, including the ones of prepared statements and transactions
+	- the durations go to the ads4_db_query_duration_seconds histogram by operation (select, insert ...)
+	- every call is logged at the debug level by the logger of its context, so the calls of a request made
+	  through DB.WithContext carry its request ID. The calls in a transaction take the context of its Begin
+*/
+
+// the SQL operations with a label of their own, anything else is "other"
+var dbOperations = map[string]bool{
+	"select": true, "insert": true, "update": true, "delete": true,
+	"with": true, "create": true, "drop": true, "alter": true, "pragma": true,
+}
+
+var timedDrivers sync.Map
+
+// timedDriverName registers the timing wrapper of the driver once and returns its name
+func timedDriverName(name string) string {
+	timed := name + "+metrics"
+	if _, loaded := timedDrivers.LoadOrStore(timed, true); loaded {
+		return timed
+	}
+	db, err := sql.Open(name, "")
+	if err != nil {
+		// unknown driver, let sql.Open report it
+		timedDrivers.Delete(timed)
+		return name
+	}
+	sql.Register(timed, timedDriver{db.Driver()})
+	db.Close()
+	return timed
+}
+
+// operation is the label of a query, its first SQL keyword
+func operation(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return "other"
+	}
+	op := strings.ToLower(fields[0])
+	if !dbOperations[op] {
+		return "other"
+	}
+	return op
+}
+
+type timedDriver struct {
+	driver.Driver
+}
+
+func (d timedDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &timedConn{Conn: conn}, nil
+}
+
+// observeCall records the duration of a database call and logs it with the logger of ctx
+func observeCall(ctx context.Context, operation string, start time.Time, err *error) {
+	if *err == driver.ErrSkip {
+		return // database/sql makes the call again another way
+	}
+	metrics.ObserveDB(operation, start)
+	event := zerolog.Ctx(ctx).Debug()
+	if *err != nil {
+		event = event.Err(*err)
+	}
+	event.Str("operation", operation).Dur("duration", time.Since(start)).Msg("database call")
+}
+
+type timedConn struct {
+	driver.Conn
+	tx context.Context // the context of the transaction in progress on the connection, nil when there is none
+}
+
+// callContext is the context a call is logged with, the one of the transaction it is part of. database/sql
+// runs the statements of a transaction in the background context
+func (c *timedConn) callContext(ctx context.Context) context.Context {
+	if c.tx != nil {
+		return c.tx
+	}
+	return ctx
+}
+
+func (c *timedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (result driver.Result, err error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	defer observeCall(c.callContext(ctx), operation(query), time.Now(), &err)
+	return execer.ExecContext(ctx, query, args)
+}
+
+func (c *timedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (rows driver.Rows, err error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	defer observeCall(c.callContext(ctx), operation(query), time.Now(), &err)
+	return queryer.QueryContext(ctx, query, args)
+}
+
+func (c *timedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &timedStmt{stmt, operation(query), c}, nil
+}
+
+func (c *timedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	var tx driver.Tx
+	var err error
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		tx, err = beginner.BeginTx(ctx, opts)
+	} else {
+		tx, err = c.Conn.Begin() //nolint:staticcheck // drivers without BeginTx
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.tx = ctx
+	return &timedTx{tx, c}, nil
+}
+
+func (c *timedConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *timedConn) CheckNamedValue(nv *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(nv)
+	}
+	return driver.ErrSkip
+}
+
+func (c *timedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *timedConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+// timedTx ends the transaction context of its connection
+type timedTx struct {
+	driver.Tx
+	conn *timedConn
+}
+
+func (t *timedTx) Commit() error {
+	t.conn.tx = nil
+	return t.Tx.Commit()
+}
+
+func (t *timedTx) Rollback() error {
+	t.conn.tx = nil
+	return t.Tx.Rollback()
+}
+
+type timedStmt struct {
+	driver.Stmt
+	operation string
+	conn      *timedConn
+}
+
+func (s *timedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (result driver.Result, err error) {
+	defer observeCall(s.conn.callContext(ctx), s.operation, time.Now(), &err)
+	if execer, ok := s.Stmt.(driver.StmtExecContext); ok {
+		return execer.ExecContext(ctx, args)
+	}
+	values, err := namedValues(args)
+	if err != nil {
+		return nil, err
+	}
+	return s.Stmt.Exec(values) //nolint:staticcheck // drivers without ExecContext
+}
+
+func (s *timedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (rows driver.Rows, err error) {
+	defer observeCall(s.conn.callContext(ctx), s.operation, time.Now(), &err)
+	if queryer, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		return queryer.QueryContext(ctx, args)
+	}
+	values, err := namedValues(args)
+	if err != nil {
+		return nil, err
+	}
+	return s.Stmt.Query(values) //nolint:staticcheck // drivers without QueryContext
+}
+
+// namedValues converts the arguments for the drivers without context methods
+func namedValues(args []driver.NamedValue) ([]driver.Value, error) {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		if arg.Name != "" {
+			return nil, driver.ErrSkip
+		}
+		values[i] = arg.Value
+	}
+	return values, nil
+}
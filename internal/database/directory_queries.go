@@ -0,0 +1,42 @@
+package database
+
+import (
+	"ADS4/internal/models"
+)
+
+/*
+Directory (LDAP) accounts, the source of an account is on UserT
+
+	"AuthSource"    VARCHAR(8) NOT NULL DEFAULT 'local', -- local, ldap
+
+A directory account is created on the user's first login and its email and role are refreshed
+from the directory on every login. It has no local password.
+*/
+
+// directoryPassword is stored as the password of directory accounts. It is not a bcrypt hash, so no
+// local password ever matches it
+const directoryPassword = "!ldap"
+
+// ProvisionDirectoryUser creates the account of a directory user on their first login
+func (db *DB) ProvisionDirectoryUser(username, email, role string) (*models.User, error) {
+	query := `
+		INSERT INTO userT (username, password, email, role, active, approval, authsource)
+		VALUES ($1, $2, $3, $4, $5, 'approved', 'ldap')
+		`
+	if _, err := db.Exec(query, username, directoryPassword, email, role, true); err != nil {
+		return nil, err
+	}
+	return db.GetUserByUsername(username)
+}
+
+// SyncDirectoryUser refreshes the email and role of an account from the directory. A local account with
+// the same username becomes a directory account and loses its local password. The active flag is left
+// alone so admins can still block a directory user, and the sessions are revoked when the role changes
+func (db *DB) SyncDirectoryUser(userid int, email, role string) error {
+	query := `
+		UPDATE userT
+		SET email = $1, role = $2, password = $3, approval = 'approved', authsource = 'ldap'
+		WHERE userid = $4
+		`
+	return db.updateUser(userid, false, query, email, role, directoryPassword, userid)
+}
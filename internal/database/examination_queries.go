@@ -3,8 +3,6 @@ package database
 import (
 	_ "database/sql"
 	"errors"
-	"strconv"
-	"time"
 )
 
 /*
@@ -73,19 +71,22 @@ type ExamMetrics struct {
 	Closed      string `json:"closed"`
 }
 
-// query the exam offerings and metrics filtered by the offering year and semester
+// query the exam offerings and metrics filtered by the offering year and semester.
+// A staffID above 0 restricts the offerings to the ones that staff member coordinates or owns
+func (db *DB) GetExamByYearSemester(year, semester string, staffID int) ([]ExamMetrics, error) {
+	var where whereBuilder
+	where.addRaw("m.ExamID = o.ExamID")
+	where.add("m.Year", year)
+	where.add("m.Semester", semester)
+	where.addStaffScope(staffID)
 
-func (db *DB) GetExamByYearSemester(year, semester string) ([]ExamMetrics, error) {
-	var query string
-
-	query = `SELECT CourseCode,Description, Password, ExamID, Year, Semester,
-			 Ready, Active, Expired, Closed
-			 FROM examMetrics 
-			 WHERE Year=$1 AND Semester = $2
-			 ORDER BY coursecode DESC`
+	query := `SELECT m.CourseCode, m.Description, m.Password, m.ExamID, m.Year, m.Semester,
+			 m.Ready, m.Active, m.Expired, m.Closed
+			 FROM examMetrics m, Offerings o` + where.String() + `
+			 ORDER BY m.coursecode DESC`
 
 	// Prepare and execute the query
-	rows, err := db.Query(query, year, semester)
+	rows, err := db.Query(query, where.args...)
 	if err != nil {
 		return nil, err
 	}
@@ -135,37 +136,38 @@ type ExamDetails struct {
 	Grade       string `json:"grade"`
 }
 
-func (db *DB) GetExaminations(field, value, semester string) ([]ExamDetails, error) {
-	var query string
-	var args []interface{}
+// GetExaminations retrieves the closed exams of the year and semester for a student, course or offering.
+// A staffID above 0 restricts the exams to the offerings that staff member coordinates or owns
+func (db *DB) GetExaminations(field, value, year, semester string, staffID int) ([]ExamDetails, error) {
 	ErrNotFound := errors.New("query field not found")
 
-	query = `SELECT CourseCode, StudentID, Name, ExamID, Grade
-			 FROM ClosedExams `
-
-	//default to the current year and add the semester
-	year := strconv.Itoa(time.Now().Year())
-	year = "2025"
-	args = append(args, year)
-	args = append(args, semester)
-	query += `WHERE year=$1 AND semester=$2 AND `
+	var where whereBuilder
+	where.addRaw("e.ExamID = o.ExamID")
+	where.add("e.Year", year)
+	where.add("e.Semester", semester)
 
+	var order string
 	switch field {
 	case "student":
-		query += `studentid=$3 ORDER BY studentid`
-		args = append(args, value)
+		where.add("e.StudentID", value)
+		order = "e.StudentID, e.ExamID"
 	case "course":
-		query += `coursecode=$3 ORDER BY coursecode`
-		args = append(args, value)
+		where.add("e.CourseCode", value)
+		order = "e.CourseCode, e.StudentID"
 	case "examid":
-		query += `examid=$3 ORDER BY examid`
-		args = append(args, value)
+		where.add("e.ExamID", value)
+		order = "e.ExamID, e.StudentID"
 	default:
 		return nil, ErrNotFound
 	}
+	where.addStaffScope(staffID)
+
+	query := `SELECT e.CourseCode, e.StudentID, e.Name, e.ExamID, e.Grade
+			 FROM ClosedExams e, Offerings o` + where.String() + `
+			 ORDER BY ` + order
 
 	// Prepare and execute the query
-	rows, err := db.Query(query, args...)
+	rows, err := db.Query(query, where.args...)
 	if err != nil {
 		return nil, err
 	}
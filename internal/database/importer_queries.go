@@ -3,6 +3,7 @@ package database
 import (
 	"ADS4/internal/models"
 	_ "database/sql"
+	"io"
 	"os"
 	"strconv"
 	"strings"
@@ -212,3 +213,96 @@ func (db *DB) ImportLearnerExams(datafile string, purge, overwrite bool) error {
 
 	return nil
 }
+
+/*
+	exports - the rows are written as CSV in the import format, so an export can be imported again
+*/
+
+// ExportCourses writes the courses as CSV
+func (db *DB) ExportCourses(w io.Writer) error {
+	rows, err := db.Query(`SELECT CourseCode, COALESCE(Description, ''), COALESCE(Level, 0), Status FROM Courses ORDER BY CourseCode`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	courses := []*models.CoursesCSV{}
+	for rows.Next() {
+		var course models.CoursesCSV
+		if err := rows.Scan(&course.CourseCode, &course.Description, &course.Level, &course.Status); err != nil {
+			return err
+		}
+		courses = append(courses, &course)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return gocsv.Marshal(courses, w)
+}
+
+// ExportLearners writes the learners as CSV
+func (db *DB) ExportLearners(w io.Writer) error {
+	rows, err := db.Query(`SELECT StudentID, Name, Status FROM Learners ORDER BY StudentID`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	learners := []*models.LearnerCSV{}
+	for rows.Next() {
+		var learner models.LearnerCSV
+		if err := rows.Scan(&learner.StudentID, &learner.StudentName, &learner.Status); err != nil {
+			return err
+		}
+		learners = append(learners, &learner)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return gocsv.Marshal(learners, w)
+}
+
+// ExportOfferings writes the offerings as CSV, with their exam passwords as the import needs them
+func (db *DB) ExportOfferings(w io.Writer) error {
+	rows, err := db.Query(`SELECT CourseCode, Year, Semester, COALESCE(Password, ''), Status, Duration FROM Offerings ORDER BY ExamID`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	offerings := []*models.OfferingsCSV{}
+	for rows.Next() {
+		var offering models.OfferingsCSV
+		if err := rows.Scan(&offering.CourseCode, &offering.Year, &offering.Semester, &offering.Password, &offering.Status, &offering.Duration); err != nil {
+			return err
+		}
+		offerings = append(offerings, &offering)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return gocsv.Marshal(offerings, w)
+}
+
+// ExportLearnerExams writes the learner exams as CSV, with the times and grades the import leaves out
+func (db *DB) ExportLearnerExams(w io.Writer) error {
+	rows, err := db.Query(`SELECT StudentID, ExamID, COALESCE(StartTime, ''), COALESCE(EndTime, ''), Status, COALESCE(Grade, 0)
+						   FROM Learnerexams ORDER BY ExamID, StudentID`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	learnerexams := []*models.LearnerExamCSV{}
+	for rows.Next() {
+		var learnerexam models.LearnerExamCSV
+		if err := rows.Scan(&learnerexam.StudentID, &learnerexam.ExamID, &learnerexam.StartTime, &learnerexam.EndTime, &learnerexam.Status, &learnerexam.Grade); err != nil {
+			return err
+		}
+		learnerexams = append(learnerexams, &learnerexam)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return gocsv.Marshal(learnerexams, w)
+}
@@ -11,17 +11,16 @@ import (
 
 CREATE TABLE "Learnerexams" (
 
-	"LearnerExamID" INTEGER,
 	"StudentID"     VARCHAR(8) NOT NULL,
 	"ExamID"        VARCHAR(15) NOT NULL,
 	"StartTime"     TIME,
 	"EndTime"       TIME,
 	"Status"        VARCHAR(6) NOT NULL DEFAULT 'ready',
-	"Grade"         INTEGER,
-	PRIMARY KEY("LearnerExamID" AUTOINCREMENT),
+	"Grade"         INTEGER DEFAULT 0,
+	PRIMARY KEY("StudentID","ExamID"),
 	FOREIGN KEY("ExamID") REFERENCES "Offerings"("ExamID"),
+	FOREIGN KEY("StudentID") REFERENCES "Learners"("StudentID"),
 	CHECK (Status IN ('ready', 'active', 'expire', 'closed', 'marked'))
-	FOREIGN KEY("StudentID") REFERENCES "Learners"("StudentID")
 
 );
 */
@@ -137,51 +136,63 @@ func (db *DB) GetExamPassword(examid, studentid string) (string, error) {
 // returns the exam if the learner is authorised
 
 func (db *DB) IsExamActive(examid, password string) bool {
-	var isExamAuth string
+	var isExamAuth bool
 
-	// Check if the offering exists (if exam ID is provided)
-	if password != "" && examid != "" {
-		Query := `SELECT EXISTS (SELECT 1 FROM Offerings
-				  WHERE examid=$1 AND password=$2 AND status = 'active')`
-		err := db.QueryRow(Query, examid, password).Scan(&isExamAuth)
-		if err != nil || err == sql.ErrNoRows {
-			return false
-		}
+	// the offering must be active and the password must match
+	if password == "" || examid == "" {
+		return false
+	}
+	Query := `SELECT EXISTS (SELECT 1 FROM Offerings
+			  WHERE examid=$1 AND password=$2 AND status = 'active')`
+	err := db.QueryRow(Query, examid, password).Scan(&isExamAuth)
+	if err != nil {
+		return false
 	}
 
-	return true
+	return isExamAuth
 }
 
-func (db *DB) GetAllLearnerExams(learnerexamid int, statusCode string) ([]models.LearnerExam, error) {
-	var query string
-	//var args []interface{}
-	var args []any
-	var LearnerexamExists bool
+// GetAllLearnerExams retrieves a page of learner exams from the database, with optional filtering by student ID, exam ID
+// and status code and a free text search on the student ID, student name and exam ID. It also returns the total number
+// of matching learner exams. If no learner exams are found, it returns an empty slice.
+func (db *DB) GetAllLearnerExams(studentid, examid, statusCode string, opts ListOptions) ([]models.LearnerExam, int, error) {
+	opts.Normalise()
+	from := `Learnerexams l LEFT JOIN Learners s ON l.StudentID = s.StudentID
+			 LEFT JOIN Offerings o ON l.ExamID = o.ExamID`
 
-	// Check if the offering exists (if exam ID is provided)
-	if learnerexamid > 0 {
-		LearnerexamExistsQuery := `
-		SELECT EXISTS (SELECT 1 FROM Learnerexams WHERE learnerexamid = $1)`
-		err := db.QueryRow(LearnerexamExistsQuery, learnerexamid).Scan(&LearnerexamExists)
-		if err != nil {
-			return nil, err
-		}
+	// Add the optional filters - these can be combined
+	var where whereBuilder
+	if statusCode != "" {
+		where.add("l.Status", statusCode)
+	}
+	if studentid != "" {
+		where.add("l.StudentID", studentid)
 	}
-	query = `SELECT l.learnerexamid, l.studentid, l.examid, l.starttime, l.endtime, l.status, l.grade FROM Learnerexams l `
+	if examid != "" {
+		where.add("l.ExamID", examid)
+	}
+	where.addSearch(opts.Search, "l.StudentID", "s.Name", "l.ExamID")
+	where.addStaffScope(opts.StaffID)
 
-	// Add filtering by learnerexamid OR statuscode not both
-	if statusCode != "" {
-		query += `WHERE o.status = $1`
-		args = append(args, statusCode)
-	} else if learnerexamid > 0 {
-		query += `WHERE o.learnerexamid = $1`
-		args = append(args, learnerexamid)
+	total, err := db.count(from, &where)
+	if err != nil {
+		return nil, 0, err
+	}
 
+	sortColumns := map[string]string{
+		"studentid":   "l.StudentID",
+		"studentname": "s.Name",
+		"examid":      "l.ExamID",
+		"status":      "l.Status",
+		"grade":       "l.Grade",
 	}
+	query := `SELECT l.StudentID, l.ExamID, l.StartTime, l.EndTime, l.Status, l.Grade FROM ` + from +
+		where.String() + where.orderAndLimit(opts, sortColumns, "l.ExamID", "l.StudentID, l.ExamID")
+
 	// Prepare and execute the query
-	rows, err := db.Query(query, args...)
+	rows, err := db.Query(query, where.args...)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
 
@@ -192,17 +203,16 @@ func (db *DB) GetAllLearnerExams(learnerexamid int, statusCode string) ([]models
 	for rows.Next() {
 		var Learnerexam models.LearnerExam
 		err := rows.Scan(
-			&Learnerexam.LearnerExamID,
 			&Learnerexam.StudentID,
 			&Learnerexam.ExamID,
 			&Learnerexam.StartTime,
 			&Learnerexam.EndTime,
-			&Learnerexam.Grade,
 			&Learnerexam.Status,
+			&Learnerexam.Grade,
 		)
 
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 
 		Learnerexams = append(Learnerexams, Learnerexam)
@@ -211,36 +221,24 @@ func (db *DB) GetAllLearnerExams(learnerexamid int, statusCode string) ([]models
 	// Return empty slice if:
 	// 1. no Learnerexams are found
 	if len(Learnerexams) == 0 {
-		return []models.LearnerExam{}, nil
+		return []models.LearnerExam{}, total, nil
 	}
 
-	return Learnerexams, nil
+	return Learnerexams, total, nil
 }
 
-func (db *DB) GetLearnerExamByID(learnerexamid int) (*models.LearnerExam, error) {
-	var query string
-	var LearnerexamExists bool
-
-	// Check if the offering exists (if offering ID is provided)
-	if learnerexamid > 0 {
-		LearnerexamExistsQuery := `
-		SELECT EXISTS (SELECT 1 FROM Learnerexams WHERE learnerexamid = $1)`
-		err := db.QueryRow(LearnerexamExistsQuery, learnerexamid).Scan(&LearnerexamExists)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	query = `SELECT l.learnerexamid, l.studentid, l.examid, l.starttime, l.endtime, l.status, l.grade FROM Learnerexams l WHERE l.learnerexamid = $1`
+// GetLearnerExamByID retrieves a learner exam by the student ID and exam ID which together form the primary key
+func (db *DB) GetLearnerExamByID(studentid, examid string) (*models.LearnerExam, error) {
+	query := `SELECT l.studentid, l.examid, l.starttime, l.endtime, l.status, l.grade
+			  FROM Learnerexams l WHERE l.studentid = $1 AND l.examid = $2`
 	var Learnerexam models.LearnerExam
-	err := db.QueryRow(query, learnerexamid).Scan(
-		&Learnerexam.LearnerExamID,
+	err := db.QueryRow(query, studentid, examid).Scan(
 		&Learnerexam.StudentID,
 		&Learnerexam.ExamID,
 		&Learnerexam.StartTime,
 		&Learnerexam.EndTime,
-		&Learnerexam.Grade,
 		&Learnerexam.Status,
+		&Learnerexam.Grade,
 	)
 
 	if err != nil {
@@ -250,10 +248,10 @@ func (db *DB) GetLearnerExamByID(learnerexamid int) (*models.LearnerExam, error)
 	return &Learnerexam, nil
 }
 
+// AddLearnerExam enrols a learner into an exam offering. The start and end times are set by the Assessment Tool
 func (db *DB) AddLearnerExam(Learnerexam *models.LearnerExam) error {
-	//query = `SELECT l.learnerexamid, l.studentid, l.examid, l.starttime, l.endtime, l.status, l.grade FROM Learnerexams l `
-	query := `INSERT INTO Learnerexams (learnerexamid, studentid, examid, starttime, endtime, status, grade) 
-			  VALUES ($1, $2, $3,$4, $5, $6, $7)`
+	query := `INSERT INTO Learnerexams (studentid, examid, status, grade)
+			  VALUES ($1, $2, $3, $4)`
 	insertStmt, err := db.Prepare(query)
 	if err != nil {
 		return err
@@ -262,13 +260,10 @@ func (db *DB) AddLearnerExam(Learnerexam *models.LearnerExam) error {
 	defer insertStmt.Close()
 
 	_, err = insertStmt.Exec(
-		Learnerexam.LearnerExamID,
 		Learnerexam.StudentID,
 		Learnerexam.ExamID,
-		Learnerexam.StartTime,
-		Learnerexam.EndTime,
-		Learnerexam.Grade,
 		Learnerexam.Status,
+		Learnerexam.Grade,
 	)
 
 	if err != nil {
@@ -278,8 +273,9 @@ func (db *DB) AddLearnerExam(Learnerexam *models.LearnerExam) error {
 	return nil
 }
 
+// UpdateLearnerExam updates the status and grade of a learner exam. The start and end times are set by the Assessment Tool
 func (db *DB) UpdateLearnerExam(Learnerexam *models.LearnerExam) error {
-	query := `UPDATE Learnerexams SET studentid=$2, examid=$3, starttime=$4, endtime=$5, status=$6, grade=$7 WHERE learnerexamid=$1`
+	query := `UPDATE Learnerexams SET status=$1, grade=$2 WHERE studentid=$3 AND examid=$4`
 	updateStmt, err := db.Prepare(query)
 	if err != nil {
 		return err
@@ -288,13 +284,10 @@ func (db *DB) UpdateLearnerExam(Learnerexam *models.LearnerExam) error {
 	defer updateStmt.Close()
 
 	_, err = updateStmt.Exec(
-		Learnerexam.LearnerExamID,
+		Learnerexam.Status,
+		Learnerexam.Grade,
 		Learnerexam.StudentID,
 		Learnerexam.ExamID,
-		Learnerexam.StartTime,
-		Learnerexam.EndTime,
-		Learnerexam.Grade,
-		Learnerexam.Status,
 	)
 	if err != nil {
 		return err
@@ -303,8 +296,8 @@ func (db *DB) UpdateLearnerExam(Learnerexam *models.LearnerExam) error {
 	return nil
 }
 
-func (db *DB) UpdateLearnerExamStatus(learnerexamid int, statusCode string) error {
-	query := "UPDATE Learnerexams SET Status=$1 WHERE learnerexamid=$2"
+func (db *DB) UpdateLearnerExamStatus(studentid, examid string, statusCode string) error {
+	query := "UPDATE Learnerexams SET Status=$1 WHERE studentid=$2 AND examid=$3"
 	updateStmt, err := db.Prepare(query)
 	if err != nil {
 		return err
@@ -312,7 +305,7 @@ func (db *DB) UpdateLearnerExamStatus(learnerexamid int, statusCode string) erro
 
 	defer updateStmt.Close()
 
-	_, err = updateStmt.Exec(statusCode, learnerexamid)
+	_, err = updateStmt.Exec(statusCode, studentid, examid)
 
 	if err != nil {
 		return err
@@ -321,9 +314,9 @@ func (db *DB) UpdateLearnerExamStatus(learnerexamid int, statusCode string) erro
 	return nil
 }
 
-func (db *DB) UpdateLearnerExamGrade(learnerexamid int, grade int) error {
+func (db *DB) UpdateLearnerExamGrade(studentid, examid string, grade int) error {
 
-	query := "UPDATE Learnerexams SET grade=$1 WHERE learnerexamid=$2"
+	query := "UPDATE Learnerexams SET grade=$1 WHERE studentid=$2 AND examid=$3"
 	updateStmt, err := db.Prepare(query)
 	if err != nil {
 		return err
@@ -331,7 +324,7 @@ func (db *DB) UpdateLearnerExamGrade(learnerexamid int, grade int) error {
 
 	defer updateStmt.Close()
 
-	_, err = updateStmt.Exec(grade, learnerexamid)
+	_, err = updateStmt.Exec(grade, studentid, examid)
 
 	if err != nil {
 		return err
@@ -340,8 +333,8 @@ func (db *DB) UpdateLearnerExamGrade(learnerexamid int, grade int) error {
 	return nil
 }
 
-func (db *DB) DeleteLearnerExam(learnerexamid int) error {
-	query := "DELETE FROM Learnerexams WHERE learnerexamid = $1"
+func (db *DB) DeleteLearnerExam(studentid, examid string) error {
+	query := "DELETE FROM Learnerexams WHERE studentid = $1 AND examid = $2"
 	deleteStmt, err := db.Prepare(query)
 	if err != nil {
 		return err
@@ -349,7 +342,7 @@ func (db *DB) DeleteLearnerExam(learnerexamid int) error {
 
 	defer deleteStmt.Close()
 
-	_, err = deleteStmt.Exec(learnerexamid)
+	_, err = deleteStmt.Exec(studentid, examid)
 
 	if err != nil {
 		return err
@@ -357,3 +350,37 @@ func (db *DB) DeleteLearnerExam(learnerexamid int) error {
 
 	return nil
 }
+
+// ResetLearnerExam puts an attempt back to ready with its start and end times cleared, so the learner can
+// start it again. The marks and the marking steps of the earlier attempt are removed and its grade replaced
+// with grade, NULL for none, in the same transaction, so the new attempt starts unmarked and an approval
+// cannot release the old marks
+func (db *DB) ResetLearnerExam(studentid, examid string, grade sql.NullInt32) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE Learnerexams SET Status='ready', StartTime=NULL, EndTime=NULL, Grade=$1 WHERE studentid=$2 AND examid=$3"
+	if _, err := tx.Exec(query, grade, studentid, examid); err != nil {
+		return err
+	}
+	queries := []string{
+		"DELETE FROM Marks WHERE studentid=$1 AND examid=$2",
+		"DELETE FROM MarkingSteps WHERE studentid=$1 AND examid=$2",
+	}
+	for _, query := range queries {
+		if _, err := tx.Exec(query, studentid, examid); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// CountActiveAttempts counts the learner exams in progress
+func (db *DB) CountActiveAttempts() (int, error) {
+	var total int
+	err := db.QueryRow("SELECT COUNT(*) FROM Learnerexams WHERE status = 'active'").Scan(&total)
+	return total, err
+}
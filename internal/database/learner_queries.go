@@ -36,38 +36,47 @@ func (db *DB) IsLearnerValid(studentid string) bool {
 	return learnerExists
 }
 
-func (db *DB) GetAllLearners(studentid string, statusCode string) ([]models.Learner, error) {
-	var query string
-	//var args []interface{}
-	var args []any
-	var learnerExists bool
-
-	// Check if the offering exists (if exam ID is provided)
-	if studentid == "" {
-		return []models.Learner{}, nil
+// GetAllLearners retrieves a page of learners from the database, with optional filtering by student ID and status code
+// and a free text search on the student ID and name. It also returns the total number of matching learners.
+// If no learners are found, it returns an empty slice.
+func (db *DB) GetAllLearners(studentid string, statusCode string, opts ListOptions) ([]models.Learner, int, error) {
+	opts.Normalise()
+	from := `Learners l`
+
+	// Add the optional filters
+	var where whereBuilder
+	if statusCode != "" {
+		where.add("l.Status", statusCode)
 	}
-
-	learnerExistsQuery := `
-		SELECT EXISTS (SELECT 1 FROM Learners WHERE studentid = $1)`
-	err := db.QueryRow(learnerExistsQuery, studentid).Scan(&learnerExists)
-	if err != nil && err == sql.ErrNoRows {
-		return nil, err
+	if studentid != "" {
+		where.add("l.StudentID", studentid)
+	}
+	where.addSearch(opts.Search, "l.StudentID", "l.Name")
+	if opts.StaffID > 0 {
+		// Faculty only see the learners enrolled in their offerings
+		where.addRaw(`EXISTS (SELECT 1 FROM Learnerexams le, Offerings o
+					  WHERE le.ExamID = o.ExamID AND le.StudentID = l.StudentID
+					  AND (o.Coordinator = ` + where.next(opts.StaffID) + ` OR o.OwnerID = ` + where.next(opts.StaffID) + `))`)
 	}
-	query = `SELECT l.studentid, l.Name, l.Status FROM Learners l `
 
-	// Add filtering by studentid OR statuscode not both
-	if statusCode != "" {
-		query += `WHERE o.status = $1`
-		args = append(args, statusCode)
-	} else if studentid != "" {
-		query += `WHERE o.studentid = $1`
-		args = append(args, studentid)
+	total, err := db.count(from, &where)
+	if err != nil {
+		return nil, 0, err
+	}
 
+	sortColumns := map[string]string{
+		"studentid":   "l.StudentID",
+		"studentname": "l.Name",
+		"name":        "l.Name",
+		"status":      "l.Status",
 	}
+	query := `SELECT l.StudentID, l.Name, l.Status FROM ` + from +
+		where.String() + where.orderAndLimit(opts, sortColumns, "l.StudentID", "l.StudentID")
+
 	// Prepare and execute the query
-	rows, err := db.Query(query, args...)
+	rows, err := db.Query(query, where.args...)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
 
@@ -84,7 +93,7 @@ func (db *DB) GetAllLearners(studentid string, statusCode string) ([]models.Lear
 		)
 
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 
 		learners = append(learners, learner)
@@ -93,10 +102,10 @@ func (db *DB) GetAllLearners(studentid string, statusCode string) ([]models.Lear
 	// Return empty slice if:
 	// 1. no Learners are found
 	if len(learners) == 0 {
-		return []models.Learner{}, nil
+		return []models.Learner{}, total, nil
 	}
 
-	return learners, nil
+	return learners, total, nil
 }
 
 func (db *DB) GetLearnerByID(studentid string) (*models.Learner, error) {
@@ -113,7 +122,7 @@ func (db *DB) GetLearnerByID(studentid string) (*models.Learner, error) {
 		}
 	}
 
-	query = `SELECT l.studentid, l.Name, l.Status FROM Learners l WHERE l.studentid = $1'`
+	query = `SELECT l.studentid, l.Name, l.Status FROM Learners l WHERE l.studentid = $1`
 
 	var learner models.Learner
 	err := db.QueryRow(query, studentid).Scan(
@@ -210,3 +219,137 @@ func (db *DB) DeleteLearner(studentid string) error {
 
 	return nil
 }
+
+// used to hold a learner's exam enrolment - the learnerexam joined with the offering and course
+type Enrolment struct {
+	StudentID      string         `json:"studentid"`
+	ExamID         string         `json:"examid"` //[year:4][semester:2][coursecode:*]
+	CourseCode     string         `json:"coursecode"`
+	Description    string         `json:"description"`
+	Year           string         `json:"year"`
+	Semester       string         `json:"semester"`
+	Duration       sql.NullInt32  `json:"duration"`       // exam duration in minutes
+	OfferingStatus string         `json:"offeringstatus"` // status of the offering - active, closed
+	StartTime      sql.NullString `json:"starttime"`
+	EndTime        sql.NullString `json:"endtime"`
+	Status         string         `json:"status"` // ready, active, expired, closed, marked
+}
+
+// GetLearnerEnrolments retrieves all the exams a learner is enrolled in, most recent offerings first.
+// A staffID above 0 restricts the enrolments to the offerings that staff member coordinates or owns.
+// If the learner has no enrolments, it returns an empty slice.
+func (db *DB) GetLearnerEnrolments(studentid string, staffID int) ([]Enrolment, error) {
+	var where whereBuilder
+	where.addRaw("l.ExamID = o.ExamID")
+	where.addRaw("o.CourseCode = c.CourseCode")
+	where.add("l.StudentID", studentid)
+	where.addStaffScope(staffID)
+
+	query := `SELECT l.StudentID, l.ExamID, o.CourseCode, c.Description, o.Year, o.Semester,
+			  o.Duration, o.Status, l.StartTime, l.EndTime, l.Status
+			  FROM Learnerexams l, Offerings o, Courses c` + where.String() + `
+			  ORDER BY o.Year DESC, o.Semester DESC, o.CourseCode`
+
+	rows, err := db.Query(query, where.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Define the result slice
+	var enrolments []Enrolment
+
+	// Scan the results
+	for rows.Next() {
+		var enrolment Enrolment
+		err := rows.Scan(
+			&enrolment.StudentID,
+			&enrolment.ExamID,
+			&enrolment.CourseCode,
+			&enrolment.Description,
+			&enrolment.Year,
+			&enrolment.Semester,
+			&enrolment.Duration,
+			&enrolment.OfferingStatus,
+			&enrolment.StartTime,
+			&enrolment.EndTime,
+			&enrolment.Status,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		enrolments = append(enrolments, enrolment)
+	}
+
+	// Return empty slice if no enrolments are found
+	if len(enrolments) == 0 {
+		return []Enrolment{}, nil
+	}
+
+	return enrolments, nil
+}
+
+// used to hold a learner's marked exam result from the MarkedExams view
+type LearnerResult struct {
+	StudentID   string         `json:"studentid"`
+	LearnerName string         `json:"learnername"`
+	ExamID      string         `json:"examid"`
+	CourseCode  string         `json:"coursecode"`
+	Year        string         `json:"year"`
+	Semester    string         `json:"semester"`
+	Grade       sql.NullInt32  `json:"grade"`
+	Feedback    sql.NullString `json:"feedback"` // marker feedback released with the grade
+}
+
+// GetLearnerResults retrieves the marked exam results of a learner, most recent offerings first.
+// A staffID above 0 restricts the results to the offerings that staff member coordinates or owns.
+// If the learner has no marked exams, it returns an empty slice.
+func (db *DB) GetLearnerResults(studentid string, staffID int) ([]LearnerResult, error) {
+	var where whereBuilder
+	where.addRaw("m.ExamID = o.ExamID")
+	where.add("m.StudentID", studentid)
+	where.addStaffScope(staffID)
+
+	query := `SELECT m.StudentID, m.Name, m.ExamID, m.CourseCode, m.Year, m.Semester, m.Grade, m.Feedback
+			  FROM MarkedExams m, Offerings o` + where.String() + `
+			  ORDER BY m.Year DESC, m.Semester DESC, m.CourseCode`
+
+	rows, err := db.Query(query, where.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// Define the result slice
+	var results []LearnerResult
+
+	// Scan the results
+	for rows.Next() {
+		var result LearnerResult
+		err := rows.Scan(
+			&result.StudentID,
+			&result.LearnerName,
+			&result.ExamID,
+			&result.CourseCode,
+			&result.Year,
+			&result.Semester,
+			&result.Grade,
+			&result.Feedback,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		results = append(results, result)
+	}
+
+	// Return empty slice if no results are found
+	if len(results) == 0 {
+		return []LearnerResult{}, nil
+	}
+
+	return results, nil
+}
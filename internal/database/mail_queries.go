@@ -0,0 +1,103 @@
+package database
+
+import (
+	"time"
+)
+
+/*
+-- Outgoing email waits here until the mailer has delivered it, failed sends are retried with a backoff
+CREATE TABLE "MailQueue" (
+
+	"MailID"      INTEGER,
+	"Recipient"   VARCHAR(255) NOT NULL,
+	"Subject"     VARCHAR(255) NOT NULL,
+	"TextBody"    TEXT,
+	"HTMLBody"    TEXT,
+	"Status"      VARCHAR(7) NOT NULL DEFAULT 'pending',  -- pending, sent, failed
+	"Attempts"    INTEGER NOT NULL DEFAULT 0,
+	"NextAttempt" TIMESTAMP NOT NULL,
+	"LastError"   TEXT,
+	"CreatedAt"   TIMESTAMP NOT NULL,
+	"SentAt"      TIMESTAMP,
+	PRIMARY KEY("MailID" AUTOINCREMENT),
+	CHECK (Status IN ('pending','sent','failed'))
+
+);
+*/
+
+// used to hold a queued email
+type QueuedMail struct {
+	MailID    int
+	Recipient string
+	Subject   string
+	TextBody  string
+	HTMLBody  string
+	Attempts  int
+}
+
+// EnqueueMail adds an email to the send queue, it is due for delivery straight away
+func (db *DB) EnqueueMail(recipient, subject, textBody, htmlBody string) error {
+	query := `
+		INSERT INTO MailQueue (recipient, subject, textbody, htmlbody, nextattempt, createdat)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		`
+	insertStmt, err := db.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	defer insertStmt.Close()
+
+	now := timestamp(time.Now())
+	_, err = insertStmt.Exec(recipient, subject, textBody, htmlBody, now, now)
+
+	return err
+}
+
+// GetDueMail retrieves up to limit pending emails whose next attempt is due, oldest first
+func (db *DB) GetDueMail(limit int) ([]QueuedMail, error) {
+	query := `
+		SELECT mailid, recipient, subject, COALESCE(textbody, ''), COALESCE(htmlbody, ''), attempts
+		FROM MailQueue
+		WHERE status = 'pending' AND nextattempt <= $1
+		ORDER BY nextattempt, mailid
+		LIMIT $2
+		`
+	rows, err := db.Query(query, timestamp(time.Now()), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	mails := []QueuedMail{}
+	for rows.Next() {
+		var mail QueuedMail
+		if err := rows.Scan(&mail.MailID, &mail.Recipient, &mail.Subject, &mail.TextBody, &mail.HTMLBody, &mail.Attempts); err != nil {
+			return nil, err
+		}
+		mails = append(mails, mail)
+	}
+
+	return mails, rows.Err()
+}
+
+// MarkMailSent records a successful delivery
+func (db *DB) MarkMailSent(mailid int) error {
+	query := `UPDATE MailQueue SET status = 'sent', attempts = attempts + 1, sentat = $1, lasterror = NULL WHERE mailid = $2`
+	_, err := db.Exec(query, timestamp(time.Now()), mailid)
+	return err
+}
+
+// MarkMailRetry records a failed delivery that will be tried again at next
+func (db *DB) MarkMailRetry(mailid int, next time.Time, sendErr string) error {
+	query := `UPDATE MailQueue SET attempts = attempts + 1, nextattempt = $1, lasterror = $2 WHERE mailid = $3`
+	_, err := db.Exec(query, timestamp(next), sendErr, mailid)
+	return err
+}
+
+// MarkMailFailed gives up on an email after the last retry
+func (db *DB) MarkMailFailed(mailid int, sendErr string) error {
+	query := `UPDATE MailQueue SET status = 'failed', attempts = attempts + 1, lasterror = $1 WHERE mailid = $2`
+	_, err := db.Exec(query, sendErr, mailid)
+	return err
+}
@@ -0,0 +1,427 @@
+package database
+
+import (
+	"database/sql"
+	"math"
+	"strings"
+	"time"
+)
+
+/*
+-- Second marker of an exam offering, assigned by the course coordinator or an Admin
+CREATE TABLE "Moderators" (
+
+	"ExamID"      VARCHAR(15) NOT NULL,
+	"ModeratorID" INTEGER NOT NULL,
+	"AssignedBy"  INTEGER NOT NULL,
+	"AssignedAt"  TIMESTAMP NOT NULL,
+	PRIMARY KEY("ExamID")
+
+);
+
+-- Per question marks of a learner exam, Stage is first or moderated
+CREATE TABLE "Marks" (
+
+	"StudentID"   VARCHAR NOT NULL,
+	"ExamID"      VARCHAR(15) NOT NULL,
+	"Stage"       VARCHAR(10) NOT NULL,
+	"Question"    VARCHAR(32) NOT NULL,
+	"Mark"        REAL NOT NULL,
+	"OutOf"       REAL NOT NULL,
+	"Comment"     VARCHAR(500),
+	"MarkerID"    INTEGER NOT NULL,
+	"MarkedAt"    TIMESTAMP NOT NULL,
+	PRIMARY KEY("StudentID", "ExamID", "Stage", "Question")
+
+);
+
+-- Every step of the moderation workflow, StudentID is NULL for the offering level steps
+CREATE TABLE "MarkingSteps" (
+
+	"StepID"      INTEGER,
+	"ExamID"      VARCHAR(15) NOT NULL,
+	"StudentID"   VARCHAR,
+	"Step"        VARCHAR(10) NOT NULL, -- assigned, submitted, moderated, approved
+	"ActorID"     INTEGER NOT NULL,
+	"Actor"       VARCHAR(80) NOT NULL,
+	"Comment"     VARCHAR(500),
+	"CreatedAt"   TIMESTAMP NOT NULL,
+	PRIMARY KEY("StepID" AUTOINCREMENT)
+
+);
+
+The state of a learner exam in the workflow follows from its steps: unmarked, submitted by the first
+marker, moderated, and released once the coordinator has approved the offering's results and the
+learner exam is marked.
+*/
+
+// marking stages and workflow steps
+const (
+	StageFirst     = "first"
+	StageModerated = "moderated"
+
+	StepAssigned  = "assigned"
+	StepSubmitted = "submitted"
+	StepModerated = "moderated"
+	StepApproved  = "approved"
+)
+
+// script states in the moderation workflow
+const (
+	ScriptUnmarked  = "unmarked"
+	ScriptSubmitted = "submitted"
+	ScriptModerated = "moderated"
+	ScriptReleased  = "released"
+)
+
+// used to hold the mark of one question
+type Mark struct {
+	Question string  `json:"question"`
+	Mark     float64 `json:"mark"`
+	OutOf    float64 `json:"out_of"`
+	Comment  string  `json:"comment"`
+	MarkerID int     `json:"marker_id"`
+	MarkedAt string  `json:"marked_at"`
+}
+
+// used to hold a step of the moderation workflow
+type MarkingStep struct {
+	StepID    int    `json:"step_id"`
+	ExamID    string `json:"examid"`
+	StudentID string `json:"studentid"` // empty for the offering level steps
+	Step      string `json:"step"`
+	ActorID   int    `json:"actor_id"`
+	Actor     string `json:"actor"`
+	Comment   string `json:"comment"`
+	CreatedAt string `json:"created_at"`
+}
+
+// used to hold the workflow state of a learner exam
+type ScriptState struct {
+	StudentID     string `json:"studentid"`
+	ExamID        string `json:"examid"`
+	Status        string `json:"status"` // the learner exam status
+	Grade         *int   `json:"grade"`
+	State         string `json:"state"` // unmarked, submitted, moderated, released
+	FirstMarkerID int    `json:"first_marker_id"`
+}
+
+// scriptStateColumns selects a ScriptState from Learnerexams l
+const scriptStateColumns = `l.StudentID, l.ExamID, l.Status, l.Grade,
+	CASE
+		WHEN l.Status = 'marked' THEN 'released'
+		WHEN EXISTS (SELECT 1 FROM MarkingSteps s WHERE s.ExamID = l.ExamID AND s.StudentID = l.StudentID AND s.Step = 'moderated') THEN 'moderated'
+		WHEN EXISTS (SELECT 1 FROM MarkingSteps s WHERE s.ExamID = l.ExamID AND s.StudentID = l.StudentID AND s.Step = 'submitted') THEN 'submitted'
+		ELSE 'unmarked'
+	END,
+	COALESCE((SELECT s.ActorID FROM MarkingSteps s WHERE s.ExamID = l.ExamID AND s.StudentID = l.StudentID AND s.Step = 'submitted'
+		ORDER BY s.StepID DESC LIMIT 1), 0)`
+
+// GetModerator returns the user ID of the moderator of the offering, 0 when none is assigned
+func (db *DB) GetModerator(examid string) (int, error) {
+	var moderatorid int
+	err := db.QueryRow(`SELECT moderatorid FROM Moderators WHERE examid = $1`, examid).Scan(&moderatorid)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return moderatorid, err
+}
+
+// AssignModerator sets the moderator of the offering, replacing any earlier one
+func (db *DB) AssignModerator(examid string, moderatorid int, step *MarkingStep) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO Moderators (examid, moderatorid, assignedby, assignedat) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (examid) DO UPDATE SET moderatorid = excluded.moderatorid, assignedby = excluded.assignedby, assignedat = excluded.assignedat
+		`
+	if _, err := tx.Exec(query, examid, moderatorid, step.ActorID, timestamp(time.Now())); err != nil {
+		return err
+	}
+	step.ExamID, step.Step = examid, StepAssigned
+	if err := addMarkingStep(tx, step); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SubmitMarks replaces the marks of a learner exam at a stage and records the workflow step.
+// The first marker submits at StageFirst, the moderator's adjustments are stored at StageModerated
+func (db *DB) SubmitMarks(studentid, examid, stage string, marks []Mark, step *MarkingStep) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `DELETE FROM Marks WHERE studentid = $1 AND examid = $2 AND stage = $3`
+	if _, err := tx.Exec(query, studentid, examid, stage); err != nil {
+		return err
+	}
+
+	now := timestamp(time.Now())
+	query = `
+		INSERT INTO Marks (studentid, examid, stage, question, mark, outof, comment, markerid, markedat)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		`
+	for _, mark := range marks {
+		_, err := tx.Exec(query, studentid, examid, stage, mark.Question, mark.Mark, mark.OutOf,
+			nullString(truncate(mark.Comment, 500)), step.ActorID, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	step.ExamID, step.StudentID = examid, studentid
+	step.Step = StepSubmitted
+	if stage == StageModerated {
+		step.Step = StepModerated
+	}
+	if err := addMarkingStep(tx, step); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetMarks retrieves the marks of a learner exam at a stage in question order
+func (db *DB) GetMarks(studentid, examid, stage string) ([]Mark, error) {
+	query := `
+		SELECT question, mark, outof, COALESCE(comment, ''), markerid, COALESCE(markedat, '')
+		FROM Marks WHERE studentid = $1 AND examid = $2 AND stage = $3
+		ORDER BY question
+		`
+	rows, err := db.Query(query, studentid, examid, stage)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	marks := []Mark{}
+	for rows.Next() {
+		var mark Mark
+		if err := rows.Scan(&mark.Question, &mark.Mark, &mark.OutOf, &mark.Comment, &mark.MarkerID, &mark.MarkedAt); err != nil {
+			return nil, err
+		}
+		marks = append(marks, mark)
+	}
+	return marks, rows.Err()
+}
+
+// GetMarkingSteps retrieves the workflow steps of a learner exam, or the offering level steps when
+// studentid is empty, oldest first
+func (db *DB) GetMarkingSteps(examid, studentid string) ([]MarkingStep, error) {
+	query := `
+		SELECT stepid, examid, COALESCE(studentid, ''), step, actorid, actor, COALESCE(comment, ''), COALESCE(createdat, '')
+		FROM MarkingSteps WHERE examid = $1 AND COALESCE(studentid, '') = $2
+		ORDER BY stepid
+		`
+	rows, err := db.Query(query, examid, studentid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	steps := []MarkingStep{}
+	for rows.Next() {
+		var step MarkingStep
+		err := rows.Scan(&step.StepID, &step.ExamID, &step.StudentID, &step.Step, &step.ActorID, &step.Actor,
+			&step.Comment, &step.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	return steps, rows.Err()
+}
+
+// GetScriptState retrieves the workflow state of a learner exam
+func (db *DB) GetScriptState(studentid, examid string) (*ScriptState, error) {
+	query := `SELECT ` + scriptStateColumns + ` FROM Learnerexams l WHERE l.StudentID = $1 AND l.ExamID = $2`
+	return scanScriptState(db.QueryRow(query, studentid, examid))
+}
+
+// GetScriptStates retrieves the workflow state of every learner exam of the offering
+func (db *DB) GetScriptStates(examid string) ([]ScriptState, error) {
+	query := `SELECT ` + scriptStateColumns + ` FROM Learnerexams l WHERE l.ExamID = $1 ORDER BY l.StudentID`
+	rows, err := db.Query(query, examid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	scripts := []ScriptState{}
+	for rows.Next() {
+		script, err := scanScriptState(rows)
+		if err != nil {
+			return nil, err
+		}
+		scripts = append(scripts, *script)
+	}
+	return scripts, rows.Err()
+}
+
+// ApproveResults releases the moderated results of the offering. Every submitted or moderated learner
+// exam is marked with its final grade, the percentage of the final marks where a moderated mark replaces
+// the first mark of the question, and its feedback from the marking comments. Returns the number of
+// learner exams released
+func (db *DB) ApproveResults(examid string, step *MarkingStep) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `
+		SELECT f.StudentID, SUM(COALESCE(m.Mark, f.Mark)), SUM(f.OutOf)
+		FROM Marks f
+		JOIN Learnerexams l ON l.StudentID = f.StudentID AND l.ExamID = f.ExamID AND l.Status <> 'marked'
+		LEFT JOIN Marks m ON m.StudentID = f.StudentID AND m.ExamID = f.ExamID AND m.Question = f.Question AND m.Stage = 'moderated'
+		WHERE f.ExamID = $1 AND f.Stage = 'first'
+		GROUP BY f.StudentID
+		`
+	rows, err := tx.Query(query, examid)
+	if err != nil {
+		return 0, err
+	}
+	grades := map[string]int{}
+	for rows.Next() {
+		var studentid string
+		var total, outOf float64
+		if err := rows.Scan(&studentid, &total, &outOf); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		grades[studentid] = finalGrade(total, outOf)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	feedback, err := releaseFeedback(tx, examid)
+	if err != nil {
+		return 0, err
+	}
+
+	query = `UPDATE Learnerexams SET status = 'marked', grade = $1, feedback = $2 WHERE studentid = $3 AND examid = $4`
+	for studentid, grade := range grades {
+		if _, err := tx.Exec(query, grade, nullString(feedback[studentid]), studentid, examid); err != nil {
+			return 0, err
+		}
+	}
+
+	step.ExamID, step.Step = examid, StepApproved
+	if err := addMarkingStep(tx, step); err != nil {
+		return 0, err
+	}
+
+	return len(grades), tx.Commit()
+}
+
+// releaseFeedback builds the feedback released with the grades of the offering, by student ID: the first
+// marker's comment on the script followed by the comment on each question, where the moderator's comment
+// replaces the first marker's for an adjusted question
+func releaseFeedback(tx *sql.Tx, examid string) (map[string]string, error) {
+	lines := map[string][]string{}
+
+	// the comment of the latest first marking of each script
+	query := `
+		SELECT studentid, comment FROM MarkingSteps
+		WHERE examid = $1 AND step = 'submitted' AND studentid IS NOT NULL
+		ORDER BY stepid
+		`
+	rows, err := tx.Query(query, examid)
+	if err != nil {
+		return nil, err
+	}
+	overall := map[string]string{}
+	for rows.Next() {
+		var studentid string
+		var comment sql.NullString
+		if err := rows.Scan(&studentid, &comment); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		overall[studentid] = comment.String
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for studentid, comment := range overall {
+		if comment != "" {
+			lines[studentid] = append(lines[studentid], comment)
+		}
+	}
+
+	query = `
+		SELECT f.StudentID, f.Question, COALESCE(m.Comment, f.Comment, '')
+		FROM Marks f
+		LEFT JOIN Marks m ON m.StudentID = f.StudentID AND m.ExamID = f.ExamID AND m.Question = f.Question AND m.Stage = 'moderated'
+		WHERE f.ExamID = $1 AND f.Stage = 'first'
+		ORDER BY f.StudentID, f.Question
+		`
+	rows, err = tx.Query(query, examid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var studentid, question, comment string
+		if err := rows.Scan(&studentid, &question, &comment); err != nil {
+			return nil, err
+		}
+		if comment != "" {
+			lines[studentid] = append(lines[studentid], question+": "+comment)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	feedback := map[string]string{}
+	for studentid, text := range lines {
+		feedback[studentid] = strings.Join(text, "\n")
+	}
+	return feedback, nil
+}
+
+// finalGrade is the total as a whole percentage of the marks available
+func finalGrade(total, outOf float64) int {
+	if outOf <= 0 {
+		return 0
+	}
+	return int(math.Round(total / outOf * 100))
+}
+
+// addMarkingStep records a workflow step within the transaction of the change it describes
+func addMarkingStep(tx execer, step *MarkingStep) error {
+	query := `
+		INSERT INTO MarkingSteps (examid, studentid, step, actorid, actor, comment, createdat)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`
+	_, err := tx.Exec(query, step.ExamID, nullString(step.StudentID), step.Step, step.ActorID,
+		truncate(step.Actor, 80), nullString(truncate(step.Comment, 500)), timestamp(time.Now()))
+	return err
+}
+
+// scanScriptState reads a row in the order of scriptStateColumns
+func scanScriptState(row rowScanner) (*ScriptState, error) {
+	var script ScriptState
+	var grade sql.NullInt32
+	err := row.Scan(&script.StudentID, &script.ExamID, &script.Status, &grade, &script.State, &script.FirstMarkerID)
+	if err != nil {
+		return nil, err
+	}
+	if grade.Valid {
+		g := int(grade.Int32)
+		script.Grade = &g
+	}
+	return &script, nil
+}
@@ -0,0 +1,144 @@
+package database
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/*
+-- goose keeps the applied migrations in goose_db_version, the migrations are the SQL files in the
+-- migrations folder named <version>_<name>.sql e.g. 20260420000000_moderation.sql
+
+CREATE TABLE goose_db_version (
+	id          INTEGER PRIMARY KEY AUTOINCREMENT,
+	version_id  INTEGER NOT NULL,
+	is_applied  INTEGER NOT NULL,
+	tstamp      TIMESTAMP DEFAULT (datetime('now'))
+);
+*/
+
+// Migration is a migration file and whether it has been applied
+type Migration struct {
+	Version int64  `json:"version"`
+	File    string `json:"file"`
+	Applied bool   `json:"applied"`
+}
+
+var migrationFile = regexp.MustCompile(`^(\d+)_\w+\.sql$`)
+
+// GetMigrations lists the migration files of dir, oldest first, with whether goose has applied them
+func (db *DB) GetMigrations(dir string) ([]Migration, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading the migrations folder: %w", err)
+	}
+
+	applied, err := db.appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []Migration
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		migrations = append(migrations, Migration{Version: version, File: entry.Name(), Applied: applied[version]})
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// PendingMigrations lists the migration files of dir that have not been applied
+func (db *DB) PendingMigrations(dir string) ([]Migration, error) {
+	migrations, err := db.GetMigrations(dir)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, migration := range migrations {
+		if !migration.Applied {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// ApplyMigration runs the Up section of a migration file of dir and records it as applied, in one
+// transaction as goose does
+func (db *DB) ApplyMigration(dir string, migration Migration) error {
+	data, err := os.ReadFile(filepath.Join(dir, migration.File))
+	if err != nil {
+		return fmt.Errorf("reading the migration %s: %w", migration.File, err)
+	}
+	up, err := migrationUp(string(data))
+	if err != nil {
+		return fmt.Errorf("migration %s: %w", migration.File, err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(up); err != nil {
+		return fmt.Errorf("running the migration %s: %w", migration.File, err)
+	}
+	if _, err := tx.Exec("INSERT INTO goose_db_version (version_id, is_applied) VALUES ($1, $2)", migration.Version, true); err != nil {
+		return fmt.Errorf("recording the migration %s: %w", migration.File, err)
+	}
+	return tx.Commit()
+}
+
+// migrationUp returns the statements between the -- +goose Up and -- +goose Down annotations
+func migrationUp(sql string) (string, error) {
+	var up []string
+	inUp := false
+	for _, line := range strings.Split(sql, "\n") {
+		switch annotation := strings.TrimSpace(line); {
+		case strings.HasPrefix(annotation, "-- +goose Up"):
+			inUp = true
+		case strings.HasPrefix(annotation, "-- +goose Down"):
+			inUp = false
+		case strings.HasPrefix(annotation, "-- +goose NO TRANSACTION"):
+			return "", fmt.Errorf("migrations outside a transaction must be run with goose")
+		case inUp && !strings.HasPrefix(annotation, "-- +goose"):
+			up = append(up, line)
+		}
+	}
+	if len(up) == 0 {
+		return "", fmt.Errorf("no -- +goose Up section")
+	}
+	return strings.Join(up, "\n"), nil
+}
+
+// appliedMigrations returns the versions goose has applied, the last entry of a version wins
+func (db *DB) appliedMigrations() (map[int64]bool, error) {
+	rows, err := db.Query("SELECT version_id, is_applied FROM goose_db_version ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("reading the applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]bool)
+	for rows.Next() {
+		var version int64
+		var isApplied bool
+		if err := rows.Scan(&version, &isApplied); err != nil {
+			return nil, err
+		}
+		applied[version] = isApplied
+	}
+	return applied, rows.Err()
+}
@@ -92,49 +92,50 @@ func (db *DB) GetActiveExams(filteryear string) ([]Exam, error) {
 	return exams, nil
 }
 
-// GetAllOfferings retrieves all exam offerings from the database, with optional filtering by exam ID or status code. If no offerings are found, it returns an empty slice.
-func (db *DB) GetAllOfferings(examID, year, semester, statusCode string) ([]models.Offerings, error) {
-	var query string
-	//var args []interface{}
-	var args []any
-	var offeringExists bool
-
-	// Check if the offering exists (if exam ID is provided)
+// GetAllOfferings retrieves a page of exam offerings from the database, with optional filtering by exam ID, year, semester
+// and status code and a free text search on the exam ID, course code and course description. It also returns the total
+// number of matching offerings. If no offerings are found, it returns an empty slice.
+func (db *DB) GetAllOfferings(examID, year, semester, statusCode string, opts ListOptions) ([]models.Offerings, int, error) {
+	opts.Normalise()
+	from := `Offerings o LEFT JOIN Courses c ON o.CourseCode = c.CourseCode`
+
+	// Add the optional filters - these can be combined
+	var where whereBuilder
+	if statusCode != "" {
+		where.add("o.Status", statusCode)
+	}
 	if examID != "" {
-		offeringExistsQuery := `
-		SELECT EXISTS (SELECT 1 FROM Offerings o WHERE o.examid = $1)`
-		err := db.QueryRow(offeringExistsQuery, examID).Scan(&offeringExists)
-		if err != nil {
-			return nil, err
-		}
+		where.add("o.ExamID", examID)
 	}
-	query = `SELECT o.examid, o.coursecode, o.year, o.semester, o.password, o.status, o.coordinator, o.ownerid,o.duration
- 		     FROM Offerings o
-	`
-	//prepare for possible filter
-	//if statusCode != "" || examID != "" || semester != "" || year != "" {
-	//	query += `WHERE `
-	//}
-
-	// Add filtering for one of four poossible fields NOT together
-	if statusCode != "" {
-		query += `WHERE o.status = $1`
-		args = append(args, statusCode)
-	} else if examID != "" {
-		query += `WHERE o.examid = $1`
-		args = append(args, examID)
-	} else if year != "" {
-		query += `WHERE o.year = $1`
-		args = append(args, examID)
-	} else if semester != "" {
-		query += `WHERE o.semester = $1`
-		args = append(args, examID)
+	if year != "" {
+		where.add("o.Year", year)
+	}
+	if semester != "" {
+		where.add("o.Semester", semester)
+	}
+	where.addSearch(opts.Search, "o.ExamID", "o.CourseCode", "c.Description")
+	where.addStaffScope(opts.StaffID)
+
+	total, err := db.count(from, &where)
+	if err != nil {
+		return nil, 0, err
 	}
 
+	sortColumns := map[string]string{
+		"examid":     "o.ExamID",
+		"coursecode": "o.CourseCode",
+		"year":       "o.Year",
+		"semester":   "o.Semester",
+		"status":     "o.Status",
+		"duration":   "o.Duration",
+	}
+	query := `SELECT o.ExamID, o.CourseCode, o.Year, o.Semester, o.Password, o.Status, o.Coordinator, o.OwnerID, o.Duration
+			  FROM ` + from + where.String() + where.orderAndLimit(opts, sortColumns, "o.ExamID", "o.ExamID")
+
 	// Prepare and execute the query
-	rows, err := db.Query(query, args...)
+	rows, err := db.Query(query, where.args...)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
 
@@ -146,9 +147,9 @@ func (db *DB) GetAllOfferings(examID, year, semester, statusCode string) ([]mode
 		var offering models.Offerings
 		err := rows.Scan(
 			&offering.ExamID,
+			&offering.CourseCode,
 			&offering.Year,
 			&offering.Semester,
-			&offering.CourseCode,
 			&offering.Password,
 			&offering.Status,
 			&offering.Coordinator,
@@ -157,7 +158,7 @@ func (db *DB) GetAllOfferings(examID, year, semester, statusCode string) ([]mode
 		)
 
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 
 		offerings = append(offerings, offering)
@@ -166,10 +167,10 @@ func (db *DB) GetAllOfferings(examID, year, semester, statusCode string) ([]mode
 	// Return empty slice if:
 	// 1. no offerings are found
 	if len(offerings) == 0 {
-		return []models.Offerings{}, nil
+		return []models.Offerings{}, total, nil
 	}
 
-	return offerings, nil
+	return offerings, total, nil
 }
 
 // GetOfferingByID retrieves a specific exam offering from the database based on the provided exam ID. If the offering is not found, it returns nil.
@@ -189,14 +190,14 @@ func (db *DB) GetOfferingByID(examID string) (*models.Offerings, error) {
 	}
 
 	query = `SELECT o.examid, o.coursecode, o.year, o.semester, o.password, o.status, o.coordinator, o.ownerid,o.duration
- 		     FROM Offerings o WHERE o.examid = $1'`
+ 		     FROM Offerings o WHERE o.examid = $1`
 
 	var offering models.Offerings
 	err := db.QueryRow(query, examID).Scan(
 		&offering.ExamID,
+		&offering.CourseCode,
 		&offering.Year,
 		&offering.Semester,
-		&offering.CourseCode,
 		&offering.Password,
 		&offering.Status,
 		&offering.Coordinator,
@@ -214,7 +215,7 @@ func (db *DB) GetOfferingByID(examID string) (*models.Offerings, error) {
 // AddOffering adds a new exam offering to the database. It takes an Offerings struct as input and returns an error if the operation fails.
 func (db *DB) AddExamOffering(offering *models.Offerings) error {
 	query := `INSERT INTO Offerings (examID, coursecode, year, semester,  Password, Status, Coordinator, OwnerID, Duration ) 
-			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`
 	insertStmt, err := db.Prepare(query)
 	if err != nil {
 		return err
@@ -244,7 +245,7 @@ func (db *DB) AddExamOffering(offering *models.Offerings) error {
 // UpdateOffering updates an existing exam offering in the database based on the provided Offerings struct. It returns an error if the operation fails.
 func (db *DB) UpdateOffering(offering *models.Offerings) error {
 	//dont update the examid as it is the primary key and should not be changed
-	query := "UPDATE Offerings SET CourseCode=$2, Year=$3, Semester=$4, Password=$5, Status=$6, Coordinator=$7, OwnerID=$8 WHERE examID=$1"
+	query := "UPDATE Offerings SET CourseCode=$1, Year=$2, Semester=$3, Password=$4, Status=$5, Coordinator=$6, OwnerID=$7, Duration=$8 WHERE examID=$9"
 	updateStmt, err := db.Prepare(query)
 	if err != nil {
 		return err
@@ -253,7 +254,6 @@ func (db *DB) UpdateOffering(offering *models.Offerings) error {
 	defer updateStmt.Close()
 
 	_, err = updateStmt.Exec(
-		offering.ExamID,
 		offering.CourseCode,
 		offering.Year,
 		offering.Semester,
@@ -262,6 +262,7 @@ func (db *DB) UpdateOffering(offering *models.Offerings) error {
 		offering.Coordinator,
 		offering.OwnerID,
 		offering.Duration,
+		offering.ExamID,
 	)
 
 	if err != nil {
@@ -308,3 +309,20 @@ func (db *DB) DeleteOffering(examid string) error {
 
 	return nil
 }
+
+// CanAccessOffering checks if the staff member coordinates or owns the exam offering
+func (db *DB) CanAccessOffering(examid string, userid int) bool {
+	var canAccess bool
+
+	if examid == "" || userid <= 0 {
+		return false
+	}
+	query := `SELECT EXISTS (SELECT 1 FROM Offerings
+			  WHERE examid = $1 AND (Coordinator = $2 OR OwnerID = $3))`
+	err := db.QueryRow(query, examid, userid, userid).Scan(&canAccess)
+	if err != nil {
+		return false
+	}
+
+	return canAccess
+}
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"strconv"
+	"strings"
+)
+
+/*
+	Pagination, sorting and free-text search shared by the list queries
+	used by:
+	- GetAllUsers, GetAllCourses, GetAllLearners, GetAllOfferings, GetAllLearnerExams
+
+	StaffID carries the Faculty permission scope into the offering based lists.
+
+	Sort keys are never placed into the SQL directly - each list query maps the
+	public sort key onto a column name and unknown keys fall back to the default.
+*/
+
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+// ListOptions holds the paging, sorting and search parameters for a list query
+type ListOptions struct {
+	Page     int    // 1 based page number
+	PageSize int    // number of rows per page
+	Sort     string // public sort key e.g. studentid
+	Desc     bool   // sort in descending order
+	Search   string // free text search across the searchable columns of the list
+	StaffID  int    // restrict to the offerings coordinated or owned by this user, 0 for no restriction
+}
+
+// Normalise clamps the paging values into range, page 1 and the default page size are assumed when not set
+func (o *ListOptions) Normalise() {
+	if o.Page < 1 {
+		o.Page = 1
+	}
+	if o.PageSize < 1 {
+		o.PageSize = DefaultPageSize
+	}
+	if o.PageSize > MaxPageSize {
+		o.PageSize = MaxPageSize
+	}
+	o.Search = strings.TrimSpace(o.Search)
+}
+
+// Offset returns the number of rows to skip for the current page
+func (o ListOptions) Offset() int {
+	return (o.Page - 1) * o.PageSize
+}
+
+// whereBuilder collects the WHERE conditions and arguments of a list query.
+// Placeholders are numbered in the order they are added so the same query works for SQLite and Postgres
+type whereBuilder struct {
+	conds []string
+	args  []any
+}
+
+// next returns the placeholder for a new argument
+func (w *whereBuilder) next(arg any) string {
+	w.args = append(w.args, arg)
+	return "$" + strconv.Itoa(len(w.args))
+}
+
+// add appends an equality condition on column
+func (w *whereBuilder) add(column string, arg any) {
+	w.conds = append(w.conds, column+" = "+w.next(arg))
+}
+
+// addRaw appends a condition that does not take an argument
+func (w *whereBuilder) addRaw(cond string) {
+	w.conds = append(w.conds, cond)
+}
+
+// addSearch appends a case insensitive LIKE match of the search term across the columns
+func (w *whereBuilder) addSearch(search string, columns ...string) {
+	if search == "" || len(columns) == 0 {
+		return
+	}
+	term := "%" + strings.ToLower(search) + "%"
+	var likes []string
+	for _, column := range columns {
+		likes = append(likes, "LOWER("+column+") LIKE "+w.next(term))
+	}
+	w.conds = append(w.conds, "("+strings.Join(likes, " OR ")+")")
+}
+
+// addStaffScope restricts the rows to the offerings (alias o) the staff member coordinates or owns
+func (w *whereBuilder) addStaffScope(staffID int) {
+	if staffID <= 0 {
+		return
+	}
+	w.conds = append(w.conds, "(o.Coordinator = "+w.next(staffID)+" OR o.OwnerID = "+w.next(staffID)+")")
+}
+
+// String returns the WHERE clause or an empty string when there are no conditions
+func (w *whereBuilder) String() string {
+	if len(w.conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(w.conds, " AND ")
+}
+
+// orderAndLimit builds the ORDER BY and LIMIT/OFFSET clauses. The sort key is looked up in
+// sortColumns and defaultSort is used when the key is missing or unknown. The primary key columns
+// in tiebreak come last so rows sharing a sort value keep their order from one page to the next
+func (w *whereBuilder) orderAndLimit(opts ListOptions, sortColumns map[string]string, defaultSort, tiebreak string) string {
+	column, ok := sortColumns[strings.ToLower(opts.Sort)]
+	if !ok {
+		column = defaultSort
+	}
+	direction := " ASC"
+	if opts.Desc {
+		direction = " DESC"
+	}
+	clause := " ORDER BY " + column + direction + ", " + tiebreak
+	clause += " LIMIT " + w.next(opts.PageSize)
+	clause += " OFFSET " + w.next(opts.Offset())
+	return clause
+}
+
+// count returns the total number of rows matching the WHERE clause for the given FROM clause
+func (db *DB) count(from string, where *whereBuilder) (int, error) {
+	var total int
+	err := db.QueryRow("SELECT COUNT(*) FROM "+from+where.String(), where.args...).Scan(&total)
+	return total, err
+}
@@ -0,0 +1,105 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+/*
+-- Single use password reset tokens, only the SHA-256 hash of the token is stored
+CREATE TABLE "PasswordResets" (
+
+	"TokenHash"   VARCHAR(64) NOT NULL,
+	"UserID"      INTEGER NOT NULL,
+	"CreatedAt"   TIMESTAMP NOT NULL,
+	"ExpiresAt"   TIMESTAMP NOT NULL,
+	"UsedAt"      TIMESTAMP,
+	PRIMARY KEY("TokenHash"),
+	FOREIGN KEY("UserID") REFERENCES "UserT"("UserID") ON DELETE CASCADE
+
+);
+*/
+
+// timestamps are stored as UTC text so they compare correctly in SQLite and Postgres
+func timestamp(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+// CreatePasswordReset stores a new reset token for the user, replacing any earlier unused tokens
+func (db *DB) CreatePasswordReset(userid int, tokenHash string, expiresAt time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM PasswordResets WHERE userid = $1`, userid); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO PasswordResets (tokenhash, userid, createdat, expiresat)
+		VALUES ($1, $2, $3, $4)
+		`
+	if _, err := tx.Exec(query, tokenHash, userid, timestamp(time.Now()), timestamp(expiresAt)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// IsPasswordResetValid checks if the token exists, has not been used and has not expired
+func (db *DB) IsPasswordResetValid(tokenHash string) bool {
+	var valid bool
+	query := `
+	SELECT EXISTS (SELECT 1 FROM PasswordResets WHERE tokenhash = $1 AND usedat IS NULL AND expiresat > $2)`
+	if err := db.QueryRow(query, tokenHash, timestamp(time.Now())).Scan(&valid); err != nil {
+		return false
+	}
+	return valid
+}
+
+// ResetPasswordWithToken marks the token as used and sets the new password hash in one transaction.
+// The sessions of the account are revoked so earlier logins are no longer accepted.
+// Returns the user ID, or sql.ErrNoRows when the token is unknown, used or expired
+func (db *DB) ResetPasswordWithToken(tokenHash, password string) (int, error) {
+	now := time.Now()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var userid int
+	query := `SELECT userid FROM PasswordResets WHERE tokenhash = $1 AND usedat IS NULL AND expiresat > $2`
+	if err := tx.QueryRow(query, tokenHash, timestamp(now)).Scan(&userid); err != nil {
+		return 0, err
+	}
+
+	// the used at check makes the token single use even with concurrent requests
+	result, err := tx.Exec(`UPDATE PasswordResets SET usedat = $1 WHERE tokenhash = $2 AND usedat IS NULL`, timestamp(now), tokenHash)
+	if err != nil {
+		return 0, err
+	}
+	if affected, err := result.RowsAffected(); err != nil || affected != 1 {
+		return 0, sql.ErrNoRows
+	}
+
+	query = `UPDATE userT SET password = $1 WHERE userid = $2`
+	if _, err := tx.Exec(query, password, userid); err != nil {
+		return 0, err
+	}
+
+	// any other outstanding tokens for the account are dropped
+	if _, err := tx.Exec(`DELETE FROM PasswordResets WHERE userid = $1 AND usedat IS NULL`, userid); err != nil {
+		return 0, err
+	}
+
+	// logins made with the old password are revoked
+	if err := revokeSessions(tx, userid); err != nil {
+		return 0, err
+	}
+
+	return userid, tx.Commit()
+}
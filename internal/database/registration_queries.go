@@ -0,0 +1,137 @@
+package database
+
+import (
+	"ADS4/internal/models"
+	"database/sql"
+	"time"
+)
+
+/*
+Self-registration approval queue, the approval columns are on UserT
+
+	"Approval"      VARCHAR(8) NOT NULL DEFAULT 'approved', -- pending, approved, rejected
+	"RejectReason"  VARCHAR(255),
+	"RegisteredAt"  TIMESTAMP,
+
+A pending account is inactive and cannot log in until an admin approves it with a role.
+*/
+
+// RegisterUser inserts a self-registered account into the pending queue. The account stays inactive
+// and the role is only a placeholder until the registration is approved
+func (db *DB) RegisterUser(user *models.User) error {
+	query := `
+		INSERT INTO userT (username, password, email, role, active, approval, registeredat)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`
+	insertStmt, err := db.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	defer insertStmt.Close()
+
+	_, err = insertStmt.Exec(user.Username, user.Password, user.Email, "Learner", false, "pending", timestamp(time.Now()))
+
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetPendingRegistrations retrieves a page of the registrations waiting for approval, oldest first,
+// with a free text search on the username and email. It also returns the total number of pending registrations
+func (db *DB) GetPendingRegistrations(opts ListOptions) ([]models.User, int, error) {
+	opts.Normalise()
+	from := `userT`
+
+	var where whereBuilder
+	where.add("approval", "pending")
+	where.addSearch(opts.Search, "username", "email")
+
+	total, err := db.count(from, &where)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sortColumns := map[string]string{
+		"username":      "username",
+		"email":         "email",
+		"registered_at": "registeredat",
+	}
+	query := `SELECT userid, username, email, approval, COALESCE(registeredat, '') FROM ` + from +
+		where.String() + where.orderAndLimit(opts, sortColumns, "registeredat", "userid")
+
+	rows, err := db.Query(query, where.args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	users := []models.User{}
+
+	for rows.Next() {
+		var user models.User
+		err := rows.Scan(
+			&user.UserID,
+			&user.Username,
+			&user.Email,
+			&user.Approval,
+			&user.RegisteredAt,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		users = append(users, user)
+	}
+
+	return users, total, nil
+}
+
+// ApproveRegistration activates a pending account with the role chosen by the admin. Learner accounts
+// are linked to their learner record. Returns sql.ErrNoRows when the account is not pending
+func (db *DB) ApproveRegistration(userid int, role, studentid string) error {
+	query := `
+		UPDATE userT
+		SET role = $1, studentid = $2, active = $3, approval = 'approved', rejectreason = NULL
+		WHERE userid = $4 AND approval = 'pending'
+		`
+	return db.execPending(query, role, studentIDValue(studentid), true, userid)
+}
+
+// RejectRegistration closes a pending account with the reason given to the user.
+// Returns sql.ErrNoRows when the account is not pending
+func (db *DB) RejectRegistration(userid int, reason string) error {
+	query := `
+		UPDATE userT
+		SET active = $1, approval = 'rejected', rejectreason = $2
+		WHERE userid = $3 AND approval = 'pending'
+		`
+	return db.execPending(query, false, reason, userid)
+}
+
+// execPending runs an approval update and reports sql.ErrNoRows when no pending account was changed
+func (db *DB) execPending(query string, args ...any) error {
+	updateStmt, err := db.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	defer updateStmt.Close()
+
+	result, err := updateStmt.Exec(args...)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -2,7 +2,6 @@ package database
 
 import (
 	"fmt"
-	"log"
 	"os"
 	"path/filepath"
 
@@ -20,9 +19,8 @@ type SeedStatus struct {
 	EnvVarName   string
 }
 
-// NewSeedStatus creates a new SeedStatus instance
-func NewSeedStatus() *SeedStatus {
-	datadir := config.LoadConfig().DataDir
+// NewSeedStatus creates a new SeedStatus instance for the data folder
+func NewSeedStatus(datadir string) *SeedStatus {
 	return &SeedStatus{
 		TempFilePath: filepath.Join(datadir, "seed_complete"),
 		EnvVarName:   "DATA_SEEDED",
@@ -74,30 +72,33 @@ func (s *SeedStatus) MarkDataAsSeeded() error {
 	return nil
 }
 
-func SeedDatabase(db *DB) error {
-	seedStatus := NewSeedStatus()
+func SeedDatabase(db *DB, cfg config.Config) error {
+	seedStatus := NewSeedStatus(cfg.DataDir)
+	logger := db.logger()
 
 	if !seedStatus.IsDataSeeded() {
-		log.Println("Seeding database...")
-		SeedData(db) // Corrected here to remove error handling
+		logger.Info().Msg("Seeding database...")
+		SeedData(db, cfg) // Corrected here to remove error handling
 		if err := seedStatus.MarkDataAsSeeded(); err != nil {
 			return fmt.Errorf("failed to mark data as seeded: %v", err)
 		}
-		log.Println("Database seeding completed successfully")
+		logger.Info().Msg("Database seeding completed successfully")
 	} else {
-		log.Println("Database already seeded")
+		logger.Info().Msg("Database already seeded")
 	}
 
 	return nil
 }
 
-func SeedData(db *DB) {
-	// Get admin password from .env
-	adminPassword := config.LoadConfig().AdminPassword
-	datadir := config.LoadConfig().DataDir
+func SeedData(db *DB, cfg config.Config) {
+	logger := db.logger()
+
+	// Get admin password from the configuration
+	adminPassword := cfg.AdminPassword
+	datadir := cfg.DataDir
 
 	if adminPassword == "" {
-		log.Fatal("ADMIN_PASSWORD not set in .env file")
+		logger.Fatal().Msg("ADMIN_PASSWORD not set in the configuration")
 	}
 
 	userPassword := "Pa$$w0rd" //default password for seeded user
@@ -105,68 +106,66 @@ func SeedData(db *DB) {
 	// Generate hash for password
 	adminHash, err := bcrypt.GenerateFromPassword([]byte(adminPassword), bcrypt.DefaultCost)
 	if err != nil {
-		fmt.Println("Error generating hash for admin password")
-		log.Fatal(err)
+		logger.Fatal().Err(err).Msg("Error generating hash for admin password")
 	}
 
 	userHash, err := bcrypt.GenerateFromPassword([]byte(userPassword), bcrypt.DefaultCost)
 
 	if err != nil {
-		fmt.Println("Error generating hash for user password")
-		log.Fatal(err)
+		logger.Fatal().Err(err).Msg("Error generating hash for user password")
 	}
 
-	log.Println("Seeding data...")
+	logger.Info().Msg("Seeding data...")
 	// Insert Users - user names must be at least 6 characters
 	_, err = db.Exec(`
 		INSERT INTO UserT (username, password, role, email, defaultadmin, active)
 		VALUES ('adminx', $1, 'Admin', 'admin@email.com', 1, 1)`, adminHash)
 	if err != nil {
-		log.Printf("- adminx user exists, skipping admin user creation: %s", err.Error())
+		logger.Info().Err(err).Msg("- adminx user exists, skipping admin user creation")
 	}
 
 	_, err = db.Exec(`
 		INSERT INTO UserT (username, password, role, email, defaultadmin, active)
 		VALUES ('bobbyx', $1, 'Faculty', 'bobbyx@email.com', 0, 1)`, userHash)
 	if err != nil {
-		log.Printf("- bobbyx user exists, skipping faculty user creation")
+		logger.Info().Msg("- bobbyx user exists, skipping faculty user creation")
 	}
 
 	// purge and import new data
-	log.Println("Importing course & offerings data.")
-	log.Printf("- Reading %v/courses.csv", datadir)
+	logger.Info().Msg("Importing course & offerings data.")
+	logger.Info().Msgf("- Reading %v/courses.csv", datadir)
 	err = db.ImportCourses(datadir+"/seed/courses.csv", true, false)
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatal().Err(err).Msg("Error seeding the database")
 	}
 
-	log.Printf("- Reading %v/learners.csv", datadir)
+	logger.Info().Msgf("- Reading %v/learners.csv", datadir)
 	err = db.ImportLearners(datadir+"/seed/learners.csv", true, false)
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatal().Err(err).Msg("Error seeding the database")
 	}
 
-	log.Printf("- Reading %v/offerings.csv", datadir)
+	logger.Info().Msgf("- Reading %v/offerings.csv", datadir)
 	err = db.ImportOfferings(datadir+"/seed/offerings.csv", true, false)
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatal().Err(err).Msg("Error seeding the database")
 	}
 
-	log.Printf("- Reading %v/learnerexams.csv", datadir)
+	logger.Info().Msgf("- Reading %v/learnerexams.csv", datadir)
 	err = db.ImportLearnerExams(datadir+"/seed/learnerexams.csv", true, false)
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatal().Err(err).Msg("Error seeding the database")
 	}
 
 	// Create a temp file in data/ directory
 	tempFile, err := os.Create(datadir + "/seed_complete")
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatal().Err(err).Msg("Error seeding the database")
 	}
 	tempFile.Close()
 
 	// Set environment variable to indicate that data has been seeded
 	os.Setenv("DATA_SEEDED", "true")
 
-	log.Println("Seeding complete.")
+	logger.Info().Msg("Seeding complete.")
 }
@@ -0,0 +1,184 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+/*
+-- Server side login sessions, keyed by the token ID (jti) claim of the login JWT
+CREATE TABLE "Sessions" (
+
+	"SessionID"   VARCHAR(64) NOT NULL,
+	"UserID"      INTEGER NOT NULL,
+	"CreatedAt"   TIMESTAMP NOT NULL,
+	"LastSeen"    TIMESTAMP NOT NULL,
+	"ExpiresAt"   TIMESTAMP NOT NULL,
+	"IP"          VARCHAR(64),
+	"UserAgent"   VARCHAR(255),
+	PRIMARY KEY("SessionID"),
+	FOREIGN KEY("UserID") REFERENCES "UserT"("UserID") ON DELETE CASCADE
+
+);
+
+A login token is only accepted while its session row exists, so deleting the rows of a user
+logs them out everywhere. Sessions are revoked by logout, deactivation, role or username
+changes and password changes.
+*/
+
+// how often the last seen time of a session is written
+const sessionTouchInterval = time.Minute
+
+// used to hold a login session
+type Session struct {
+	SessionID string `json:"session_id"`
+	UserID    int    `json:"user_id"`
+	CreatedAt string `json:"created_at"`
+	LastSeen  string `json:"last_seen"`
+	ExpiresAt string `json:"expires_at"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx so revocation can join the caller's transaction
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// CreateSession stores a new login session and drops the expired sessions of the same user
+func (db *DB) CreateSession(session *Session, expiresAt time.Time) error {
+	now := timestamp(time.Now())
+	session.ExpiresAt = timestamp(expiresAt)
+
+	if _, err := db.Exec(`DELETE FROM Sessions WHERE userid = $1 AND expiresat <= $2`, session.UserID, now); err != nil {
+		return err
+	}
+
+	query := `
+		INSERT INTO Sessions (sessionid, userid, createdat, lastseen, expiresat, ip, useragent)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		`
+	insertStmt, err := db.Prepare(query)
+	if err != nil {
+		return err
+	}
+
+	defer insertStmt.Close()
+
+	_, err = insertStmt.Exec(session.SessionID, session.UserID, now, now, session.ExpiresAt, session.IP, truncate(session.UserAgent, 255))
+	return err
+}
+
+// GetSession retrieves an unexpired session, sql.ErrNoRows is returned for a revoked or expired session
+func (db *DB) GetSession(sessionid string) (*Session, error) {
+	var session Session
+	query := `
+		SELECT sessionid, userid, createdat, lastseen, expiresat, COALESCE(ip, ''), COALESCE(useragent, '')
+		FROM Sessions WHERE sessionid = $1 AND expiresat > $2`
+	err := db.QueryRow(query, sessionid, timestamp(time.Now())).Scan(
+		&session.SessionID,
+		&session.UserID,
+		&session.CreatedAt,
+		&session.LastSeen,
+		&session.ExpiresAt,
+		&session.IP,
+		&session.UserAgent,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// TouchSession updates the last seen time of a session, at most once every sessionTouchInterval
+func (db *DB) TouchSession(sessionid string) error {
+	now := time.Now()
+	query := `UPDATE Sessions SET lastseen = $1 WHERE sessionid = $2 AND lastseen < $3`
+	_, err := db.Exec(query, timestamp(now), sessionid, timestamp(now.Add(-sessionTouchInterval)))
+	return err
+}
+
+// GetUserSessions retrieves the unexpired sessions of the user, most recently used first
+func (db *DB) GetUserSessions(userid int) ([]Session, error) {
+	query := `
+		SELECT sessionid, userid, createdat, lastseen, expiresat, COALESCE(ip, ''), COALESCE(useragent, '')
+		FROM Sessions WHERE userid = $1 AND expiresat > $2
+		ORDER BY lastseen DESC`
+	rows, err := db.Query(query, userid, timestamp(time.Now()))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []Session{}
+	for rows.Next() {
+		var session Session
+		err := rows.Scan(
+			&session.SessionID,
+			&session.UserID,
+			&session.CreatedAt,
+			&session.LastSeen,
+			&session.ExpiresAt,
+			&session.IP,
+			&session.UserAgent,
+		)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
+
+// CountSessions returns the number of unexpired sessions of the user, or of every user when userid is 0
+func (db *DB) CountSessions(userid int) (int, error) {
+	var count int
+	query := `SELECT COUNT(*) FROM Sessions WHERE ($1 = 0 OR userid = $1) AND expiresat > $2`
+	err := db.QueryRow(query, userid, timestamp(time.Now())).Scan(&count)
+	return count, err
+}
+
+// RevokeSession deletes one session of the user. Returns false when the user has no such session
+func (db *DB) RevokeSession(userid int, sessionid string) (bool, error) {
+	result, err := db.Exec(`DELETE FROM Sessions WHERE sessionid = $1 AND userid = $2`, sessionid, userid)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// RevokeUserSessions deletes every session of the user except the one given, which may be empty.
+// Returns the number of sessions revoked
+func (db *DB) RevokeUserSessions(userid int, exceptID string) (int64, error) {
+	result, err := db.Exec(`DELETE FROM Sessions WHERE userid = $1 AND sessionid <> $2`, userid, exceptID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// RevokeAllSessions deletes the sessions of every user except the one given, which may be empty.
+// Returns the number of sessions revoked
+func (db *DB) RevokeAllSessions(exceptID string) (int64, error) {
+	result, err := db.Exec(`DELETE FROM Sessions WHERE sessionid <> $1`, exceptID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// revokeSessions deletes every session of the user as part of an account change
+func revokeSessions(exec execer, userid int) error {
+	_, err := exec.Exec(`DELETE FROM Sessions WHERE userid = $1`, userid)
+	return err
+}
+
+// truncate shortens a string to the column size
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
@@ -0,0 +1,120 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+/*
+-- Failed login tracking for the lockout policy, times are unix seconds
+CREATE TABLE "LoginFailures" (
+
+	"Scope"       VARCHAR(8) NOT NULL,    -- account, ip, exam
+	"Subject"     VARCHAR(255) NOT NULL,  -- username or IP address
+	"Failures"    INTEGER NOT NULL DEFAULT 0,
+	"LastFailure" INTEGER NOT NULL DEFAULT 0,
+	"LockedUntil" INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY("Scope","Subject"),
+	CHECK (Scope IN ('account','ip','exam'))
+
+);
+*/
+
+// used to hold the failed login count of an account or address
+type LoginFailure struct {
+	Scope       string `json:"scope"`
+	Subject     string `json:"subject"`
+	Failures    int    `json:"failures"`
+	LastFailure int64  `json:"last_failure"` // unix time
+	LockedUntil int64  `json:"locked_until"` // unix time, 0 when not locked
+}
+
+// Locked checks if the lockout is still running
+func (f LoginFailure) Locked(now time.Time) bool {
+	return f.LockedUntil > now.Unix()
+}
+
+// GetLoginFailure retrieves the failure count of an account or address, a zero count is returned when there is none
+func (db *DB) GetLoginFailure(scope, subject string) (LoginFailure, error) {
+	failure := LoginFailure{Scope: scope, Subject: subject}
+	query := `SELECT failures, lastfailure, lockeduntil FROM LoginFailures WHERE scope = $1 AND subject = $2`
+	err := db.QueryRow(query, scope, subject).Scan(&failure.Failures, &failure.LastFailure, &failure.LockedUntil)
+	if err != nil && err != sql.ErrNoRows {
+		return failure, err
+	}
+	return failure, nil
+}
+
+// SaveLoginFailure stores the failure count and lockout of an account or address
+func (db *DB) SaveLoginFailure(failure LoginFailure) error {
+	query := `
+		INSERT INTO LoginFailures (scope, subject, failures, lastfailure, lockeduntil)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (scope, subject) DO UPDATE
+		SET failures = excluded.failures, lastfailure = excluded.lastfailure, lockeduntil = excluded.lockeduntil
+		`
+	_, err := db.Exec(query, failure.Scope, failure.Subject, failure.Failures, failure.LastFailure, failure.LockedUntil)
+	return err
+}
+
+// ClearLoginFailure removes the failure count and any lockout of an account or address.
+// Returns false when there was nothing to clear
+func (db *DB) ClearLoginFailure(scope, subject string) (bool, error) {
+	result, err := db.Exec(`DELETE FROM LoginFailures WHERE scope = $1 AND subject = $2`, scope, subject)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// GetLoginFailures retrieves a page of the tracked accounts and addresses, most recent failure first.
+// lockedOnly restricts the list to the lockouts still running. It also returns the total number of matching rows
+func (db *DB) GetLoginFailures(scope string, lockedOnly bool, opts ListOptions) ([]LoginFailure, int, error) {
+	opts.Normalise()
+	from := `LoginFailures`
+
+	var where whereBuilder
+	if scope != "" {
+		where.add("scope", scope)
+	}
+	if lockedOnly {
+		where.addRaw("lockeduntil > " + where.next(time.Now().Unix()))
+	}
+	where.addSearch(opts.Search, "subject")
+
+	total, err := db.count(from, &where)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	sortColumns := map[string]string{
+		"scope":        "scope",
+		"subject":      "subject",
+		"failures":     "failures",
+		"last_failure": "lastfailure",
+		"locked_until": "lockeduntil",
+	}
+	if opts.Sort == "" {
+		opts.Desc = true
+	}
+	query := `SELECT scope, subject, failures, lastfailure, lockeduntil FROM ` + from +
+		where.String() + where.orderAndLimit(opts, sortColumns, "lastfailure", "scope, subject")
+
+	rows, err := db.Query(query, where.args...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	failures := []LoginFailure{}
+	for rows.Next() {
+		var failure LoginFailure
+		if err := rows.Scan(&failure.Scope, &failure.Subject, &failure.Failures, &failure.LastFailure, &failure.LockedUntil); err != nil {
+			return nil, 0, err
+		}
+		failures = append(failures, failure)
+	}
+
+	return failures, total, rows.Err()
+}
@@ -0,0 +1,166 @@
+package database
+
+import (
+	"database/sql"
+	"time"
+)
+
+/*
+TOTP second factor, the factor columns are on UserT
+
+	"TOTPSecret"    VARCHAR(64),                    -- base32 secret, set when enrolment starts
+	"TOTPEnabled"   BOOLEAN NOT NULL DEFAULT FALSE, -- set once the first code is confirmed
+	"TOTPRequired"  BOOLEAN NOT NULL DEFAULT FALSE, -- set by an admin to force enrolment
+	"TOTPLastStep"  INTEGER NOT NULL DEFAULT 0,     -- last accepted time step, stops replays
+
+CREATE TABLE "RecoveryCodes" (
+
+	"CodeHash"  VARCHAR(64) PRIMARY KEY,
+	"UserID"    INTEGER NOT NULL,
+	"UsedAt"    TIMESTAMP,
+	FOREIGN KEY("UserID") REFERENCES "UserT"("UserID") ON DELETE CASCADE
+
+);
+*/
+
+// used to hold the second factor state of an account
+type TwoFactor struct {
+	Secret   string
+	Enabled  bool
+	Required bool
+	LastStep int64
+}
+
+// GetTwoFactor retrieves the second factor state of the user
+func (db *DB) GetTwoFactor(userid int) (TwoFactor, error) {
+	var tf TwoFactor
+	query := `SELECT COALESCE(totpsecret, ''), totpenabled, totprequired, totplaststep FROM userT WHERE userid = $1`
+	err := db.QueryRow(query, userid).Scan(&tf.Secret, &tf.Enabled, &tf.Required, &tf.LastStep)
+	return tf, err
+}
+
+// SetTOTPSecret stores a new secret for enrolment, the factor stays disabled until a code is confirmed
+func (db *DB) SetTOTPSecret(userid int, secret string) error {
+	query := `UPDATE userT SET totpsecret = $1, totpenabled = $2, totplaststep = 0 WHERE userid = $3`
+	_, err := db.Exec(query, secret, false, userid)
+	return err
+}
+
+// EnableTwoFactor turns the factor on after the first code has been confirmed and replaces the recovery codes
+func (db *DB) EnableTwoFactor(userid int, step int64, codeHashes []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE userT SET totpenabled = $1, totplaststep = $2 WHERE userid = $3 AND totpsecret IS NOT NULL`
+	if _, err := tx.Exec(query, true, step, userid); err != nil {
+		return err
+	}
+	if err := replaceRecoveryCodes(tx, userid, codeHashes); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPStep records the time step of an accepted code. Returns false when the step, or a later one,
+// has already been used so the same code cannot log in twice
+func (db *DB) UseTOTPStep(userid int, step int64) (bool, error) {
+	result, err := db.Exec(`UPDATE userT SET totplaststep = $1 WHERE userid = $2 AND totplaststep < $3`, step, userid, step)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// UseRecoveryCode marks an unused recovery code of the user as used. Returns false when there is no such code
+func (db *DB) UseRecoveryCode(userid int, codeHash string) (bool, error) {
+	query := `UPDATE RecoveryCodes SET usedat = $1 WHERE codehash = $2 AND userid = $3 AND usedat IS NULL`
+	result, err := db.Exec(query, timestamp(time.Now()), codeHash, userid)
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	return affected > 0, err
+}
+
+// ReplaceRecoveryCodes discards the recovery codes of the user and stores a new set
+func (db *DB) ReplaceRecoveryCodes(userid int, codeHashes []string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := replaceRecoveryCodes(tx, userid, codeHashes); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CountRecoveryCodes returns the number of unused recovery codes of the user
+func (db *DB) CountRecoveryCodes(userid int) (int, error) {
+	var count int
+	err := db.QueryRow(`SELECT COUNT(*) FROM RecoveryCodes WHERE userid = $1 AND usedat IS NULL`, userid).Scan(&count)
+	return count, err
+}
+
+// ResetTwoFactor removes the second factor and recovery codes of the user, the required flag is kept
+// so an enforced account enrols again at the next login
+func (db *DB) ResetTwoFactor(userid int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE userT SET totpsecret = NULL, totpenabled = $1, totplaststep = 0 WHERE userid = $2`
+	if _, err := tx.Exec(query, false, userid); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM RecoveryCodes WHERE userid = $1`, userid); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetTwoFactorRequired sets whether the user must use a second factor to log in
+func (db *DB) SetTwoFactorRequired(userid int, required bool) error {
+	result, err := db.Exec(`UPDATE userT SET totprequired = $1 WHERE userid = $2`, required, userid)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// replaceRecoveryCodes swaps the recovery codes of the user inside a transaction
+func replaceRecoveryCodes(tx *sql.Tx, userid int, codeHashes []string) error {
+	if _, err := tx.Exec(`DELETE FROM RecoveryCodes WHERE userid = $1`, userid); err != nil {
+		return err
+	}
+
+	insertStmt, err := tx.Prepare(`INSERT INTO RecoveryCodes (codehash, userid) VALUES ($1, $2)`)
+	if err != nil {
+		return err
+	}
+	defer insertStmt.Close()
+
+	for _, hash := range codeHashes {
+		if _, err := insertStmt.Exec(hash, userid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,19 +2,47 @@ package database
 
 import (
 	"ADS4/internal/models"
-	_ "database/sql"
+	"database/sql"
 )
 
-// GetAllUsers function
-func (db *DB) GetAllUsers() ([]models.User, error) {
-	query := `SELECT userid, username, email, role, defaultadmin, active FROM userT`
-	rows, err := db.Query(query)
+// learner accounts are linked to a learner record, staff accounts store NULL
+func studentIDValue(studentid string) sql.NullString {
+	return sql.NullString{String: studentid, Valid: studentid != ""}
+}
+
+// GetAllUsers retrieves a page of users, with optional filtering by role and a free text search on the username and email.
+// It also returns the total number of matching users
+func (db *DB) GetAllUsers(role string, opts ListOptions) ([]models.User, int, error) {
+	opts.Normalise()
+	from := `userT`
+
+	var where whereBuilder
+	if role != "" {
+		where.add("role", role)
+	}
+	where.addSearch(opts.Search, "username", "email")
+
+	total, err := db.count(from, &where)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
+	}
+
+	sortColumns := map[string]string{
+		"username": "username",
+		"email":    "email",
+		"role":     "role",
+		"active":   "active",
+	}
+	query := `SELECT userid, username, email, role, defaultadmin, active, COALESCE(studentid, ''), totpenabled, totprequired, authsource FROM ` + from +
+		where.String() + where.orderAndLimit(opts, sortColumns, "username", "userid")
+
+	rows, err := db.Query(query, where.args...)
+	if err != nil {
+		return nil, 0, err
 	}
 	defer rows.Close()
 
-	var users []models.User
+	users := []models.User{}
 
 	for rows.Next() {
 		var user models.User
@@ -25,22 +53,26 @@ func (db *DB) GetAllUsers() ([]models.User, error) {
 			&user.Role,
 			&user.DefaultAdmin,
 			&user.Active,
+			&user.StudentID,
+			&user.TwoFactor,
+			&user.TwoFactorRequired,
+			&user.AuthSource,
 		)
 		if err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 
 		users = append(users, user)
 	}
 
-	return users, nil
+	return users, total, nil
 }
 
 // Create user function
 func (db *DB) CreateUser(user *models.User) error {
 	query := `
-		INSERT INTO userT (username, password, email, role)
-		VALUES ($1, $2, $3, $4)
+		INSERT INTO userT (username, password, email, role, studentid)
+		VALUES ($1, $2, $3, $4, $5)
 		`
 	insertStmt, err := db.Prepare(query)
 	if err != nil {
@@ -49,7 +81,7 @@ func (db *DB) CreateUser(user *models.User) error {
 
 	defer insertStmt.Close()
 
-	_, err = insertStmt.Exec(user.Username, user.Password, user.Email, user.Role)
+	_, err = insertStmt.Exec(user.Username, user.Password, user.Email, user.Role, studentIDValue(user.StudentID))
 
 	if err != nil {
 		return err
@@ -58,62 +90,71 @@ func (db *DB) CreateUser(user *models.User) error {
 	return nil
 }
 
-// Update user function
+// Update user function, the user's sessions are revoked as the password has changed
 func (db *DB) UpdateUserWithPassword(user *models.User) error {
 	query := `
         UPDATE userT
-        SET username = $1, email = $2, role = $3, password = $4, active = $5
-        WHERE userid = $6
+        SET username = $1, email = $2, role = $3, password = $4, active = $5, studentid = $6
+        WHERE userid = $7
         `
-	args := []interface{}{user.Username, user.Email, user.Role, user.Password, user.Active, user.UserID}
+	args := []interface{}{user.Username, user.Email, user.Role, user.Password, user.Active, studentIDValue(user.StudentID), user.UserID}
 
-	updateStmt, err := db.Prepare(query)
-	if err != nil {
-		return err
-	}
-
-	defer updateStmt.Close()
-
-	_, err = updateStmt.Exec(args...)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return db.updateUser(user.UserID, true, query, args...)
 }
 
-// Update user function
+// Update user function, the user's sessions are revoked when the change makes their login token
+// out of date - a new username or role, or a deactivated account
 func (db *DB) UpdateUser(user *models.User) error {
 	query := `
 		UPDATE userT
-		SET username = $1, email = $2, role = $3, active = $4
-		WHERE userid = $5
+		SET username = $1, email = $2, role = $3, active = $4, studentid = $5
+		WHERE userid = $6
 		`
 
-	args := []interface{}{user.Username, user.Email, user.Role, user.Active, user.UserID}
+	args := []interface{}{user.Username, user.Email, user.Role, user.Active, studentIDValue(user.StudentID), user.UserID}
 
-	updateStmt, err := db.Prepare(query)
+	return db.updateUser(user.UserID, false, query, args...)
+}
 
+// updateUser runs an account update and revokes the sessions in the same transaction when the
+// password, username or role changed or the account was deactivated
+func (db *DB) updateUser(userid int, passwordChanged bool, query string, args ...any) error {
+	tx, err := db.Begin()
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	defer updateStmt.Close()
-
-	_, err = updateStmt.Exec(args...)
+	var before, after struct {
+		username, role string
+		active         bool
+	}
+	selectQuery := `SELECT username, role, active FROM userT WHERE userid = $1`
+	if err := tx.QueryRow(selectQuery, userid).Scan(&before.username, &before.role, &before.active); err != nil {
+		return err
+	}
 
-	if err != nil {
+	if _, err := tx.Exec(query, args...); err != nil {
 		return err
 	}
 
-	return nil
+	if err := tx.QueryRow(selectQuery, userid).Scan(&after.username, &after.role, &after.active); err != nil {
+		return err
+	}
+	if passwordChanged || before.username != after.username || before.role != after.role || !after.active {
+		if err := revokeSessions(tx, userid); err != nil {
+			return err
+		}
+	}
 
+	return tx.Commit()
 }
 
 // Get user by username function
 func (db *DB) GetUserByUsername(username string) (*models.User, error) {
 	query := `
-		SELECT userid, username, password, email, role, defaultadmin, active
+		SELECT userid, username, password, email, role, defaultadmin, active, COALESCE(studentid, ''),
+		       approval, COALESCE(rejectreason, ''), authsource
 		FROM userT
 		WHERE username = $1
 		`
@@ -126,6 +167,10 @@ func (db *DB) GetUserByUsername(username string) (*models.User, error) {
 		&user.Role,
 		&user.DefaultAdmin,
 		&user.Active,
+		&user.StudentID,
+		&user.Approval,
+		&user.RejectReason,
+		&user.AuthSource,
 	)
 
 	if err != nil {
@@ -138,13 +183,14 @@ func (db *DB) GetUserByUsername(username string) (*models.User, error) {
 // Get user by ID function
 func (db *DB) GetUserByID(userid int) (*models.User, error) {
 	query := `
-		SELECT userid, username, password, email, role, defaultadmin, active
+		SELECT userid, username, password, email, role, defaultadmin, active, COALESCE(studentid, ''),
+		       approval, COALESCE(rejectreason, ''), authsource
 		FROM userT
 		WHERE userid = $1
 		`
 
 	var user models.User
-	err := db.QueryRow(query).Scan(
+	err := db.QueryRow(query, userid).Scan(
 		&user.UserID,
 		&user.Username,
 		&user.Password,
@@ -152,6 +198,10 @@ func (db *DB) GetUserByID(userid int) (*models.User, error) {
 		&user.Role,
 		&user.DefaultAdmin,
 		&user.Active,
+		&user.StudentID,
+		&user.Approval,
+		&user.RejectReason,
+		&user.AuthSource,
 	)
 
 	if err != nil {
@@ -177,7 +227,8 @@ func (db *DB) DeleteUser(userid int) error {
 		return err
 	}
 
-	return nil
+	// the foreign key cascade depends on the database settings, the sessions are removed explicitly
+	return revokeSessions(db, userid)
 }
 
 // Update password function
@@ -200,10 +251,11 @@ func (db *DB) UpdatePassword(userid int, password string) error {
 		return err
 	}
 
-	return nil
+	// logins made with the old password are revoked
+	return revokeSessions(db, userid)
 }
 
-// Update password function
+// Update active function
 func (db *DB) UpdateActive(userid int, active bool) error {
 	query := `
 		UPDATE userT
@@ -223,13 +275,17 @@ func (db *DB) UpdateActive(userid int, active bool) error {
 		return err
 	}
 
+	// a deactivated account is logged out everywhere
+	if !active {
+		return revokeSessions(db, userid)
+	}
 	return nil
 }
 
 // Get user by email function
 func (db *DB) GetUserByEmail(email string) (*models.User, error) {
 	query := `
-		SELECT userid, username, password, email, role,active
+		SELECT userid, username, password, email, role, active, COALESCE(studentid, ''), authsource
 		FROM userT
 		WHERE email = $1
 		`
@@ -241,6 +297,8 @@ func (db *DB) GetUserByEmail(email string) (*models.User, error) {
 		&user.Email,
 		&user.Role,
 		&user.Active,
+		&user.StudentID,
+		&user.AuthSource,
 	)
 
 	if err != nil {
@@ -268,3 +326,19 @@ func (db *DB) IsUserActive(userid int) bool {
 
 	return true
 }
+
+// GetUserIDByStudentID returns the ID of the account linked to the learner record
+func (db *DB) GetUserIDByStudentID(studentid string) (int, error) {
+	query := `
+		SELECT userid
+		FROM userT
+		WHERE studentid = $1
+		`
+	var userid int
+	err := db.QueryRow(query, studentid).Scan(&userid)
+	if err != nil {
+		return 0, err
+	}
+
+	return userid, nil
+}
@@ -0,0 +1,135 @@
+package directory
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"ADS4/internal/config"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+/*
+	Staff authentication against an LDAP directory or Active Directory
+	- the user is found with the service account (LDAP_BIND_DN) or an anonymous bind, using LDAP_USER_FILTER
+	  under LDAP_BASE_DN, then the password is checked by binding as the user
+	- the groups come from the LDAP_GROUP_ATTRIBUTE of the user entry (memberOf in AD and OpenLDAP with the
+	  memberof overlay), the app maps them to the Admin and Faculty roles
+*/
+
+// ErrInvalidCredentials is returned for an unknown user or a wrong password
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// how long to wait for the directory server
+const timeout = 10 * time.Second
+
+// Entry is the directory account of an authenticated user
+type Entry struct {
+	DN       string
+	Username string
+	Email    string
+	Groups   []string
+}
+
+// LDAP authenticates users against the configured directory
+type LDAP struct {
+	cfg config.Config
+}
+
+// New creates the directory authenticator
+func New(cfg config.Config) *LDAP {
+	return &LDAP{cfg: cfg}
+}
+
+// Authenticate checks the username and password against the directory and returns the user's entry
+func (l *LDAP) Authenticate(username, password string) (*Entry, error) {
+	// an empty password would be an unauthenticated bind, which servers accept without checking anything
+	if strings.TrimSpace(username) == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := l.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if l.cfg.LDAPBindDN != "" {
+		err = conn.Bind(l.cfg.LDAPBindDN, l.cfg.LDAPBindPassword)
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("directory service bind: %w", err)
+	}
+
+	search := ldap.NewSearchRequest(
+		l.cfg.LDAPBaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(timeout.Seconds()), false,
+		fmt.Sprintf(l.cfg.LDAPUserFilter, ldap.EscapeFilter(username)),
+		[]string{l.cfg.LDAPUsernameAttr, l.cfg.LDAPEmailAttr, l.cfg.LDAPGroupAttr},
+		nil,
+	)
+	result, err := conn.Search(search)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, fmt.Errorf("directory search: %w", err)
+	}
+	if result == nil || len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	found := result.Entries[0]
+
+	if err := conn.Bind(found.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("directory user bind: %w", err)
+	}
+
+	entry := &Entry{
+		DN:       found.DN,
+		Username: found.GetAttributeValue(l.cfg.LDAPUsernameAttr),
+		Email:    found.GetAttributeValue(l.cfg.LDAPEmailAttr),
+		Groups:   found.GetAttributeValues(l.cfg.LDAPGroupAttr),
+	}
+	if entry.Username == "" {
+		entry.Username = username
+	}
+	return entry, nil
+}
+
+// dial connects to the directory, upgrading to TLS when LDAP_STARTTLS is set
+func (l *LDAP) dial() (*ldap.Conn, error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: l.cfg.LDAPSkipVerify}
+	conn, err := ldap.DialURL(l.cfg.LDAPURL,
+		ldap.DialWithTLSConfig(tlsConfig),
+		ldap.DialWithDialer(&net.Dialer{Timeout: timeout}))
+	if err != nil {
+		return nil, fmt.Errorf("directory service connect: %w", err)
+	}
+	conn.SetTimeout(timeout)
+
+	if l.cfg.LDAPStartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("directory service StartTLS: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// InGroup checks if one of the user's groups is in the list, DNs are compared without case
+func (e *Entry) InGroup(groups []string) bool {
+	for _, group := range e.Groups {
+		for _, want := range groups {
+			if strings.EqualFold(strings.ReplaceAll(group, " ", ""), strings.ReplaceAll(want, " ", "")) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,39 @@
+package mailer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// FileMailer writes each message as an .eml file into the outbox folder instead of sending it
+type FileMailer struct {
+	dir  string
+	from string
+	seq  atomic.Int64
+}
+
+// NewFileMailer creates the outbox folder if needed
+func NewFileMailer(dir, from string) (*FileMailer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create the mail outbox %s: %w", dir, err)
+	}
+	return &FileMailer{dir: dir, from: from}, nil
+}
+
+// Send writes the message to the outbox, the file name sorts in the order the messages were sent
+func (m *FileMailer) Send(msg Message) error {
+	name := fmt.Sprintf("%s-%04d.eml", time.Now().Format("20060102-150405.000"), m.seq.Add(1)%10000)
+	f, err := os.Create(filepath.Join(m.dir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := newMessage(m.from, msg).WriteTo(f); err != nil {
+		return err
+	}
+	return f.Close()
+}
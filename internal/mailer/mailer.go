@@ -0,0 +1,63 @@
+package mailer
+
+import (
+	"fmt"
+
+	"ADS4/internal/config"
+
+	gomail "gopkg.in/mail.v2"
+)
+
+/*
+	Outgoing email
+	- Mailer is the delivery driver selected by MAIL_DRIVER
+	  - smtp - sends through SMTP_HOST:SMTP_PORT with SMTP_TLS (starttls, tls, none)
+	  - file - writes each message as an .eml file into MAIL_OUTBOX for offline installs and testing
+	- each message type has a text and an HTML template in templates/ e.g. password_reset.txt/.html
+	- the Queue stores messages in the database and delivers them in the background with retries
+*/
+
+// Message is a rendered email ready for delivery
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Mailer delivers a single message
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// New creates the mailer for the configured driver
+func New(cfg config.Config) (Mailer, error) {
+	from := cfg.SMTPFrom
+	if from == "" {
+		from = "ads4@localhost"
+	}
+
+	switch cfg.MailDriver {
+	case "smtp":
+		if cfg.SMTPHost == "" {
+			return nil, fmt.Errorf("SMTP_HOST is required for the smtp mail driver")
+		}
+		return NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUser, cfg.SMTPPassword, from, cfg.SMTPTLS), nil
+	case "file":
+		return NewFileMailer(cfg.MailOutbox, from)
+	}
+	return nil, fmt.Errorf("unsupported mail driver: %s", cfg.MailDriver)
+}
+
+// newMessage builds the MIME message with a plain text body and an HTML alternative
+func newMessage(from string, msg Message) *gomail.Message {
+	m := gomail.NewMessage()
+	m.SetHeader("From", from)
+	m.SetHeader("To", msg.To)
+	m.SetHeader("Subject", msg.Subject)
+	m.SetBody("text/plain", msg.Text)
+	if msg.HTML != "" {
+		m.AddAlternative("text/html", msg.HTML)
+	}
+	return m
+}
@@ -0,0 +1,131 @@
+package mailer
+
+import (
+	"context"
+	"time"
+
+	"ADS4/internal/database"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	MaxAttempts   = 8                // attempts before a message is marked as failed
+	pollInterval  = 30 * time.Second // how often the queue looks for retries that are due
+	deliveryBatch = 20               // messages delivered per pass
+)
+
+// Queue stores outgoing messages in the database and delivers them in the background, so a slow or
+// unavailable mail server never fails the request that sent the message
+type Queue struct {
+	db     *database.DB
+	mailer Mailer
+	logger *zerolog.Logger
+	wake   chan struct{}
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewQueue creates the send queue for the mailer
+func NewQueue(db *database.DB, mailer Mailer, logger *zerolog.Logger) *Queue {
+	return &Queue{
+		db:     db,
+		mailer: mailer,
+		logger: logger,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Send renders the message type for the recipient and queues it for delivery
+func (q *Queue) Send(name, to string, data any) error {
+	msg, err := Render(name, to, data)
+	if err != nil {
+		return err
+	}
+	if err := q.db.EnqueueMail(msg.To, msg.Subject, msg.Text, msg.HTML); err != nil {
+		return err
+	}
+
+	// deliver straight away rather than waiting for the next poll
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Start runs the delivery loop in the background until Stop is called
+func (q *Queue) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	q.cancel = cancel
+	q.done = make(chan struct{})
+
+	go func() {
+		defer close(q.done)
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+
+		for {
+			q.deliver(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-q.wake:
+			}
+		}
+	}()
+}
+
+// Stop ends the delivery loop after the message being sent, anything left stays queued for the next start
+func (q *Queue) Stop() {
+	if q.cancel == nil {
+		return
+	}
+	q.cancel()
+	<-q.done
+}
+
+// Flush stops the delivery loop and delivers the messages that are due once more, until ctx is done
+func (q *Queue) Flush(ctx context.Context) {
+	q.Stop()
+	q.deliver(ctx)
+}
+
+// deliver sends the messages that are due, failures are retried with an exponential backoff
+func (q *Queue) deliver(ctx context.Context) {
+	mails, err := q.db.GetDueMail(deliveryBatch)
+	if err != nil {
+		q.logger.Error().Err(err).Msg("Error reading the mail queue")
+		return
+	}
+
+	for _, mail := range mails {
+		if ctx.Err() != nil {
+			return
+		}
+
+		err := q.mailer.Send(Message{To: mail.Recipient, Subject: mail.Subject, Text: mail.TextBody, HTML: mail.HTMLBody})
+		if err == nil {
+			err = q.db.MarkMailSent(mail.MailID)
+		} else if mail.Attempts+1 >= MaxAttempts {
+			q.logger.Error().Err(err).Str("recipient", mail.Recipient).Int("attempts", mail.Attempts+1).Msg("Giving up on the email")
+			err = q.db.MarkMailFailed(mail.MailID, err.Error())
+		} else {
+			q.logger.Warn().Err(err).Str("recipient", mail.Recipient).Msg("Could not send the email, will retry")
+			err = q.db.MarkMailRetry(mail.MailID, time.Now().Add(backoff(mail.Attempts)), err.Error())
+		}
+		if err != nil {
+			q.logger.Error().Err(err).Msg("Error updating the mail queue")
+		}
+	}
+}
+
+// backoff returns the wait before the next attempt - 1, 2, 4 ... minutes capped at an hour
+func backoff(attempts int) time.Duration {
+	wait := time.Minute << attempts
+	if wait > time.Hour || wait <= 0 {
+		return time.Hour
+	}
+	return wait
+}
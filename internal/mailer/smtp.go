@@ -0,0 +1,31 @@
+package mailer
+
+import (
+	gomail "gopkg.in/mail.v2"
+)
+
+// SMTPMailer sends messages through an SMTP server
+type SMTPMailer struct {
+	dialer *gomail.Dialer
+	from   string
+}
+
+// NewSMTPMailer creates an SMTP mailer. tlsMode is starttls (required STARTTLS), tls (implicit TLS, usually port 465)
+// or none (plain connection for a local relay)
+func NewSMTPMailer(host string, port int, username, password, from, tlsMode string) *SMTPMailer {
+	d := gomail.NewDialer(host, port, username, password)
+	switch tlsMode {
+	case "tls":
+		d.SSL = true
+	case "none":
+		d.StartTLSPolicy = gomail.NoStartTLS
+	default:
+		d.StartTLSPolicy = gomail.MandatoryStartTLS
+	}
+	return &SMTPMailer{dialer: d, from: from}
+}
+
+// Send delivers the message to the SMTP server
+func (m *SMTPMailer) Send(msg Message) error {
+	return m.dialer.DialAndSend(newMessage(m.from, msg))
+}
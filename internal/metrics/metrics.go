@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+/*
+	Prometheus metrics served on /metrics
+	- ads4_http_request_duration_seconds  latency per method, route and status, its _count gives the requests
+	- ads4_db_query_duration_seconds      duration of the database calls per operation (select, insert ...)
+	- ads4_exam_uploads_total             exam uploads saved, uploads per minute is rate(ads4_exam_uploads_total[5m])*60
+	- ads4_exam_upload_bytes_total        bytes of the saved exam uploads
+	- ads4_auth_failures_total            failed logins, API keys and exam authorisations per scope
+	- the app adds the active attempts, the bytes stored under the data folder and the database pool stats
+	  when it starts, as they need the database and the configuration
+*/
+
+// Registry holds the ADS4 metrics along with the Go runtime and process metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	HTTPDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ads4_http_request_duration_seconds",
+		Help:    "HTTP request latency by method, route and status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	DBDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "ads4_db_query_duration_seconds",
+		Help:    "Database call duration by operation.",
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation"})
+
+	ExamUploads = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ads4_exam_uploads_total",
+		Help: "Exam uploads saved from the Assessment Tool.",
+	})
+
+	ExamUploadBytes = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "ads4_exam_upload_bytes_total",
+		Help: "Bytes of the exam uploads saved from the Assessment Tool.",
+	})
+
+	AuthFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "ads4_auth_failures_total",
+		Help: "Failed authentication attempts by scope (account, ip, exam).",
+	}, []string{"scope"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		HTTPDuration,
+		DBDuration,
+		ExamUploads,
+		ExamUploadBytes,
+		AuthFailures,
+	)
+}
+
+// ObserveDB records the duration of a database call started at start
+func ObserveDB(operation string, start time.Time) {
+	DBDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
@@ -32,9 +32,9 @@ CREATE TABLE "Learnerexams" (
 type LearnerExam struct {
 	LearnerExamID int            `json:"learnerexamid"`
 	StudentID     sql.NullString `json:"studentid"`
-	ExamID        sql.NullString `json:"examid"` // [year:4][semester:2][coursecode:9]
-	StartTime     sql.NullTime   `json:"starttime"`
-	EndTime       sql.NullTime   `json:"endtime"`
+	ExamID        sql.NullString `json:"examid"`    // [year:4][semester:2][coursecode:9]
+	StartTime     sql.NullString `json:"starttime"` // TIME columns are returned as text e.g. 10:04:31
+	EndTime       sql.NullString `json:"endtime"`
 	Status        sql.NullString `json:"status"` // ready, active, expired, closed, marked
 	Grade         sql.NullInt32  `json:"grade"`
 }
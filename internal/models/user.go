@@ -1,14 +1,21 @@
 package models
 
 type User struct {
-	UserID        int    `json:"user_id"`
-	Username      string `json:"username"`
-	Password      string `json:"password"`
-	Email         string `json:"email"`
-	Role          string `json:"role"` // Admin, Faculty, Learner
-	DefaultAdmin  bool   `json:"default_admin"`
-	CurrentUserID int    `json:"current_user_id"`
-	Active        bool   `json:"active"`
+	UserID            int    `json:"user_id"`
+	Username          string `json:"username"`
+	Password          string `json:"password"`
+	Email             string `json:"email"`
+	Role              string `json:"role"` // Admin, Faculty, Learner
+	DefaultAdmin      bool   `json:"default_admin"`
+	CurrentUserID     int    `json:"current_user_id"`
+	Active            bool   `json:"active"`
+	StudentID         string `json:"studentid"` // Learner accounts only - the learner record shown in the portal
+	Approval          string `json:"approval"`  // pending, approved, rejected - self-registered accounts start pending
+	RejectReason      string `json:"reject_reason"`
+	RegisteredAt      string `json:"registered_at"`
+	TwoFactor         bool   `json:"twofactor"`          // a TOTP second factor is enrolled
+	TwoFactorRequired bool   `json:"twofactor_required"` // an admin has made the second factor mandatory
+	AuthSource        string `json:"auth_source"`        // local, ldap - ldap accounts log in with their directory password
 }
 
 type UserDto struct {
@@ -21,4 +28,5 @@ type UserDto struct {
 	ConfirmPassword string `json:"confirm_password"`
 	DefaultAdmin    string `json:"default_admin"`
 	Active          string `json:"active"`
+	StudentID       string `json:"studentid"`
 }
@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+/*
+	Templates and static files
+	- built into the binary (see web.go) so the service does not depend on the folder it is started from
+	- ASSETS_DIR serves them from a folder on disk instead, for development: the templates are parsed
+	  again when one of them changes and the static files are read on every request
+	- the pages link the static files with {{asset "/static/..."}}, which adds ?v=<content hash>. A request
+	  with the current hash is cached for a year, other requests revalidate with the ETag
+*/
+
+// the content hash is shortened to hashLength hex characters in the URLs
+const hashLength = 12
+
+// Assets serves the templates and static folders of fsys
+type Assets struct {
+	fsys fs.FS
+	dev  bool
+
+	mu     sync.Mutex
+	hashes map[string]assetHash
+}
+
+// assetHash is the content hash of a static file, recomputed in development when the file changes
+type assetHash struct {
+	modTime time.Time
+	size    int64
+	hash    string
+}
+
+// NewAssets serves the embedded templates and static files, or the ones in dir when it is set
+func NewAssets(embedded fs.FS, dir string) *Assets {
+	assets := &Assets{fsys: embedded, hashes: map[string]assetHash{}}
+	if dir != "" {
+		assets.fsys = os.DirFS(dir)
+		assets.dev = true
+	}
+	return assets
+}
+
+// Dev reports if the assets are served from disk
+func (a *Assets) Dev() bool {
+	return a.dev
+}
+
+// URL returns the link of a static file with its content hash e.g. /static/main/main.js?v=0123456789ab,
+// a file that is missing is linked as it is
+func (a *Assets) URL(name string) string {
+	hash, err := a.hash(strings.TrimPrefix(name, "/"))
+	if err != nil {
+		return name
+	}
+	return name + "?v=" + hash
+}
+
+// hash returns the content hash of the file name of fsys
+func (a *Assets) hash(name string) (string, error) {
+	info, err := fs.Stat(a.fsys, name)
+	if err != nil {
+		return "", err
+	}
+
+	a.mu.Lock()
+	cached, ok := a.hashes[name]
+	a.mu.Unlock()
+	if ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.hash, nil
+	}
+
+	file, err := a.fsys.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	sum := sha256.New()
+	if _, err := io.Copy(sum, file); err != nil {
+		return "", err
+	}
+	hash := hex.EncodeToString(sum.Sum(nil))[:hashLength]
+
+	a.mu.Lock()
+	a.hashes[name] = assetHash{modTime: info.ModTime(), size: info.Size(), hash: hash}
+	a.mu.Unlock()
+	return hash, nil
+}
+
+// walkTemplates passes the name of each template to fn, when it is set, and returns a stamp of the number
+// of templates and the newest change
+func (a *Assets) walkTemplates(fn func(name string) error) (string, error) {
+	var newest time.Time
+	count := 0
+	err := fs.WalkDir(a.fsys, "templates", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || path.Ext(name) != ".html" {
+			return nil
+		}
+		count++
+		if info, err := d.Info(); err == nil && info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+		if fn != nil {
+			return fn(name)
+		}
+		return nil
+	})
+	return fmt.Sprintf("%d %d", count, newest.UnixNano()), err
+}
+
+// ServeStatic serves GET /static/* with an ETag of the content hash. Links with the current hash are
+// cached for a year, the others are revalidated on every use
+func (a *Assets) ServeStatic(c echo.Context) error {
+	name := path.Join("static", strings.TrimSuffix(c.Param("*"), "/"))
+	if !fs.ValidPath(name) {
+		return echo.ErrNotFound
+	}
+	file, err := a.fsys.Open(name)
+	if err != nil {
+		return echo.ErrNotFound
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		return echo.ErrNotFound
+	}
+
+	header := c.Response().Header()
+	if hash, err := a.hash(name); err == nil {
+		header.Set("ETag", `"`+hash+`"`)
+		if c.QueryParam("v") == hash {
+			header.Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			header.Set("Cache-Control", "no-cache")
+		}
+	}
+
+	content, ok := file.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return err
+		}
+		content = bytes.NewReader(data)
+	}
+	// embedded files have no modification time, ServeContent then answers on the ETag alone
+	http.ServeContent(c.Response(), c.Request(), info.Name(), info.ModTime(), content)
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !unix && !windows
+
+package utils
+
+import "errors"
+
+// FreeDiskSpace is not available on this platform
+func FreeDiskSpace(path string) (uint64, error) {
+	return 0, errors.New("free disk space is not available on this platform")
+}
@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"errors"
+	"os"
+)
+
+// ErrLocked is returned by LockFile when another process holds the lock
+var ErrLocked = errors.New("the lock is held by another process")
+
+// LockFile opens or creates path and takes an exclusive lock on it without waiting. The lock is held until
+// the file is closed or the process exits, so a process that crashed does not leave it behind
+func LockFile(path string) (*os.File, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
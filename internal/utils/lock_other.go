@@ -0,0 +1,10 @@
+//go:build !unix && !windows
+
+package utils
+
+import "os"
+
+// lockFile does nothing, file locks are not available on this platform
+func lockFile(file *os.File) error {
+	return nil
+}
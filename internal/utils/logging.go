@@ -2,22 +2,111 @@ package utils
 
 import (
 	"fmt"
+	"io"
+	"log"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/rs/zerolog"
+	"gopkg.in/natefinch/lumberjack.v2"
 )
 
+/*
+	Application logging
+	- one zerolog logger for the app, the database, the mail queue and the HTTP requests
+	- the console output is human readable or JSON (LOG_FORMAT), log files are always JSON and rotate
+	  by size under DataDir/logs, keeping LOG_MAX_FILES old files for up to LOG_MAX_AGE_DAYS
+	- each request gets a logger with its request ID in the request context, see LoggingMiddleware
+*/
+
 type MyLogger struct {
 	zerolog.Logger
 }
 
-var Logger MyLogger
+// Logger is the application logger, it writes info messages to the console until NewLogger has been called
+var Logger = MyLogger{zerolog.New(consoleWriter(os.Stderr)).Level(zerolog.InfoLevel).With().Timestamp().Logger()}
+
+// code without a request of its own, e.g. the database calls of the command line, logs to the app logger
+func init() {
+	zerolog.DefaultContextLogger = &Logger.Logger
+}
+
+// LogOptions are the logging settings
+type LogOptions struct {
+	Level      string // debug, info, warn, error
+	Format     string // console, json
+	Dir        string // folder of the log files, empty for console only
+	MaxSizeMB  int
+	MaxFiles   int
+	MaxAgeDays int
+}
+
+// the open log file, closed by CloseLogger
+var logFile *lumberjack.Logger
+
+// NewLogger sets up the application logger from the options
+func NewLogger(opts LogOptions) (MyLogger, error) {
+	level, err := zerolog.ParseLevel(opts.Level)
+	if err != nil || opts.Level == "" {
+		level = zerolog.InfoLevel
+	}
+
+	var console io.Writer = os.Stdout
+	if opts.Format != "json" {
+		console = consoleWriter(os.Stdout)
+	}
+
+	writers := []io.Writer{console}
+	if opts.Dir != "" {
+		if err := os.MkdirAll(opts.Dir, 0o750); err != nil {
+			return Logger, fmt.Errorf("creating the log folder: %w", err)
+		}
+		logFile = &lumberjack.Logger{
+			Filename:   filepath.Join(opts.Dir, "ads4.log"),
+			MaxSize:    opts.MaxSizeMB,
+			MaxBackups: opts.MaxFiles,
+			MaxAge:     opts.MaxAgeDays,
+			LocalTime:  true,
+		}
+		writers = append(writers, logFile)
+	}
 
-func NewLogger() MyLogger {
+	zerolog.TimeFieldFormat = time.RFC3339
+	logger := zerolog.New(zerolog.MultiLevelWriter(writers...)).Level(level).With().Timestamp().Logger()
+	Logger = MyLogger{logger}
+
+	// requests without a logger of their own, and code without a request, log to the app logger
+	zerolog.DefaultContextLogger = &Logger.Logger
+
+	// the standard library log, used by the configuration, the database seeding and the command line,
+	// is written to the app logger at the info level
+	log.SetFlags(0)
+	log.SetOutput(stdLogWriter{})
+	return Logger, nil
+}
+
+// stdLogWriter writes the lines of the standard library log as info messages
+type stdLogWriter struct{}
+
+func (stdLogWriter) Write(p []byte) (int, error) {
+	Logger.Info().Msg(strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
+
+// CloseLogger flushes and closes the log file
+func CloseLogger() error {
+	if logFile == nil {
+		return nil
+	}
+	return logFile.Close()
+}
+
+// consoleWriter is the human readable console output
+func consoleWriter(out io.Writer) zerolog.ConsoleWriter {
 	// create output configuration
-	output := zerolog.ConsoleWriter{Out: os.Stdout, TimeFormat: time.RFC3339}
+	output := zerolog.ConsoleWriter{Out: out, TimeFormat: time.RFC3339}
 
 	// Format level: fatal, error, debug, info, warn
 	output.FormatLevel = func(i interface{}) string {
@@ -34,10 +123,7 @@ func NewLogger() MyLogger {
 	output.FormatErrFieldName = func(i interface{}) string {
 		return fmt.Sprintf("%s: ", i)
 	}
-
-	zerolog := zerolog.New(output).With().Caller().Timestamp().Logger()
-	Logger = MyLogger{zerolog}
-	return Logger
+	return output
 }
 
 func (l *MyLogger) LogInfo() *zerolog.Event {
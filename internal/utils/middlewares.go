@@ -1,27 +1,75 @@
 package utils
 
 import (
+	"net/http"
+	"strings"
+	"time"
+
 	"github.com/labstack/echo/v4"
+	"github.com/rs/zerolog"
 )
 
+// route parameters that are never logged, e.g. the exam password in the Assessment Tool routes
+var secretParams = map[string]bool{"password": true}
+
+// LoggingMiddleware logs each request once it is done. The request gets a logger carrying its request ID
+// (set by the RequestID middleware) and route parameters, e.g. the student and exam IDs of the Assessment
+// Tool requests, so the handlers' messages can be matched to the request
 func LoggingMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		// log the request
-		Logger.LogInfo().Fields(map[string]interface{}{
-			"method": c.Request().Method,
-			"uri":    c.Request().URL.Path,
-			"query":  c.Request().URL.RawQuery,
-		}).Msg("Request")
+		start := time.Now()
+		req := c.Request()
+
+		fields := Logger.With().Str("request_id", c.Response().Header().Get(echo.HeaderXRequestID))
+		// a URI with a secret is rebuilt from the route with the secret masked
+		uri, masked, secret := req.URL.Path, c.Path(), false
+		for _, name := range c.ParamNames() {
+			if secretParams[name] {
+				masked = strings.Replace(masked, ":"+name, "***", 1)
+				secret = true
+				continue
+			}
+			fields = fields.Str(name, c.Param(name))
+			masked = strings.Replace(masked, ":"+name, c.Param(name), 1)
+		}
+		if secret {
+			uri = masked
+		}
+		logger := fields.Logger()
+		c.SetRequest(req.WithContext(logger.WithContext(req.Context())))
 
 		// call the next middleware/handler
 		err := next(c)
 		if err != nil {
-			Logger.LogError().Fields(map[string]interface{}{
-				"error": err.Error(),
-			}).Msg("Response")
-			return err
+			c.Error(err)
 		}
 
+		status := c.Response().Status
+		event := logger.Info()
+		switch {
+		case status >= http.StatusInternalServerError:
+			event = logger.Error()
+		case status >= http.StatusBadRequest:
+			event = logger.Warn()
+		}
+		if err != nil {
+			event = event.Err(err)
+		}
+		event.Str("method", req.Method).
+			Str("uri", uri).
+			Str("route", c.Path()).
+			Int("status", status).
+			Int64("bytes", c.Response().Size).
+			Dur("latency", time.Since(start)).
+			Str("ip", c.RealIP()).
+			Msg("request")
+
+		// the error has been handled
 		return nil
 	}
 }
+
+// RequestLogger returns the logger of the request, with its request ID and route parameters
+func RequestLogger(c echo.Context) *zerolog.Logger {
+	return zerolog.Ctx(c.Request().Context())
+}
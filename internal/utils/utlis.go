@@ -4,9 +4,8 @@ import (
 	"html/template"
 	"io"
 	"net"
-	"os"
-	"path/filepath"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -38,42 +37,77 @@ type Client struct {
 
 // TemplateRenderer implements echo.Renderer
 type TemplateRenderer struct {
+	assets *Assets
+
+	mu        sync.RWMutex
 	templates *template.Template
+	stamp     string // the number of templates and the newest change, to reload them in development
 }
 
-// NewTemplateRenderer creates a new TemplateRenderer
-func NewTemplateRenderer() (*TemplateRenderer, error) {
-	rootDir, err := os.Getwd()
-	if err != nil {
+// NewTemplateRenderer creates a new TemplateRenderer for the templates of the assets, the pages link the
+// static files with the asset function
+func NewTemplateRenderer(assets *Assets) (*TemplateRenderer, error) {
+	tr := &TemplateRenderer{assets: assets}
+	if err := tr.parse(); err != nil {
 		return nil, err
 	}
+	return tr, nil
+}
 
-	templateDir := filepath.Join(rootDir, "templates")
-	t := template.New("")
-
-	err = filepath.Walk(templateDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() && filepath.Ext(path) == ".html" {
-			_, err = t.ParseFiles(path)
-			if err != nil {
-				return err
-			}
-		}
-		return nil
+// parse reads every .html file under templates, each template is named by its file name
+func (tr *TemplateRenderer) parse() error {
+	t := template.New("").Funcs(template.FuncMap{"asset": tr.assets.URL})
+	stamp, err := tr.assets.walkTemplates(func(name string) error {
+		_, err := t.ParseFS(tr.assets.fsys, name)
+		return err
 	})
-
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	return &TemplateRenderer{templates: t}, nil
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	tr.templates, tr.stamp = t, stamp
+	return nil
 }
 
-// Render implements echo.Renderer
+// reload parses the templates again when one of them was added, removed or changed
+func (tr *TemplateRenderer) reload() error {
+	stamp, err := tr.assets.walkTemplates(nil)
+	if err != nil {
+		return err
+	}
+	tr.mu.RLock()
+	changed := stamp != tr.stamp
+	tr.mu.RUnlock()
+	if !changed {
+		return nil
+	}
+	return tr.parse()
+}
+
+// Render implements echo.Renderer. The CSRF token of the request is added to map data as csrf
+// so the pages can pass it on to their scripts
 func (tr *TemplateRenderer) Render(w io.Writer, name string, data interface{}, c echo.Context) error {
-	return tr.templates.ExecuteTemplate(w, name, data)
+	if token, ok := c.Get("csrf").(string); ok {
+		switch values := data.(type) {
+		case nil:
+			data = map[string]interface{}{"csrf": token}
+		case map[string]interface{}:
+			if _, set := values["csrf"]; !set {
+				values["csrf"] = token
+			}
+		}
+	}
+	if tr.assets.dev {
+		if err := tr.reload(); err != nil {
+			return err
+		}
+	}
+	tr.mu.RLock()
+	templates := tr.templates
+	tr.mu.RUnlock()
+	return templates.ExecuteTemplate(w, name, data)
 }
 
 // used to auto detect the active local IP address - not used yet
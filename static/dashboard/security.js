@@ -0,0 +1,160 @@
+// security.js
+// Self-service two-factor authentication and active sessions for staff accounts
+
+loadStatus();
+loadSessions();
+
+document.getElementById("sessions-revoke-others").addEventListener("click", () => {
+    endSessions("/api/session");
+});
+
+document.getElementById("tfa-setup-button").addEventListener("click", () => {
+    postTwoFactor("/api/2fa/setup", {}).then((data) => {
+        document.getElementById("tfa-qr").src = data.qr;
+        document.getElementById("tfa-secret").textContent = data.secret;
+        show("tfa-enrol", true);
+        show("tfa-setup-button", false);
+    });
+});
+
+document.getElementById("tfa-enable-form").addEventListener("submit", (event) => {
+    event.preventDefault();
+    const code = event.target.elements.code.value;
+    postTwoFactor("/api/2fa/enable", { code: code }).then((data) => {
+        showToast(data.message, false);
+        showRecoveryCodes(data.recovery_codes);
+        show("tfa-enrol", false);
+        loadStatus();
+    });
+});
+
+document.getElementById("tfa-manage-form").addEventListener("submit", (event) => {
+    event.preventDefault();
+    const code = event.target.elements.code.value;
+    const action = event.submitter.dataset.action;
+    if (action === "disable" && !confirm("Turn off two-factor authentication?")) {
+        return;
+    }
+    postTwoFactor(`/api/2fa/${action}`, { code: code }).then((data) => {
+        event.target.reset();
+        showToast(data.message, false);
+        if (data.recovery_codes) {
+            showRecoveryCodes(data.recovery_codes);
+        } else {
+            show("tfa-codes", false);
+        }
+        loadStatus();
+    });
+});
+
+// show the current second factor state
+function loadStatus() {
+    fetch("/api/2fa")
+        .then((response) => response.json())
+        .then((data) => {
+            document.getElementById("tfa-status").textContent = data.enabled
+                ? "Enabled"
+                : "Not enabled";
+            document.getElementById("tfa-recovery-left").textContent = data.enabled
+                ? `${data.recovery_codes_left} recovery code(s) left`
+                : "";
+            show("tfa-required", data.required);
+            show("tfa-setup-button", !data.enabled);
+            show("tfa-manage", data.enabled);
+            show("tfa-disable-button", !data.required);
+        })
+        .catch((error) => console.error("Fetch error:", error));
+}
+
+// list the logged in user's sessions, times are UTC
+function loadSessions() {
+    const tbody = document.querySelector("#sessions-table tbody");
+    fetch("/api/session")
+        .then((response) => response.json())
+        .then((sessions) => {
+            tbody.innerHTML = sessions
+                .map(
+                    (session) => `
+<tr>
+    <td data-label="Signed in">${escapeHtml(session.created_at)}</td>
+    <td data-label="Last seen">${escapeHtml(session.last_seen)}</td>
+    <td data-label="Address">${escapeHtml(session.ip)}</td>
+    <td data-label="Browser" class="text-break">${escapeHtml(session.user_agent)}</td>
+    <td>${
+        session.current
+            ? '<span class="badge bg-success">This session</span>'
+            : `<button class="btn btn-sm btn-outline-danger" data-session="${escapeHtml(session.id)}">Log out</button>`
+    }</td>
+</tr>`
+                )
+                .join("");
+            tbody.querySelectorAll("button[data-session]").forEach((button) => {
+                button.addEventListener("click", () =>
+                    endSessions(`/api/session/${button.dataset.session}`)
+                );
+            });
+        })
+        .catch((error) => console.error("Fetch error:", error));
+}
+
+function endSessions(url) {
+    fetch(url, { method: "DELETE", headers: csrfHeaders() })
+        .then((response) => response.json())
+        .then((data) => {
+            showToast(data.message || data.error, !!data.error);
+            loadSessions();
+        })
+        .catch((error) => console.error("Fetch error:", error));
+}
+
+// the browser string is sent by the client
+function escapeHtml(value) {
+    return String(value ?? "")
+        .replace(/&/g, "&amp;")
+        .replace(/</g, "&lt;")
+        .replace(/>/g, "&gt;")
+        .replace(/"/g, "&quot;");
+}
+
+// post a second factor action, errors are shown as a toast and stop the chain
+function postTwoFactor(url, body) {
+    return fetch(url, {
+        method: "POST",
+        headers: csrfHeaders({
+            "Content-Type": "application/json",
+        }),
+        body: JSON.stringify(body),
+    })
+        .then((response) => response.json())
+        .then((data) => {
+            if (data.error) {
+                showToast(data.error, true);
+                throw new Error(data.error);
+            }
+            return data;
+        });
+}
+
+function showRecoveryCodes(codes) {
+    document.getElementById("tfa-code-list").innerHTML = codes
+        .map((code) => `<li>${code}</li>`)
+        .join("");
+    show("tfa-codes", true);
+}
+
+function show(id, visible) {
+    document.getElementById(id).classList.toggle("d-none", !visible);
+}
+
+function showToast(text, error) {
+    Toastify({
+        text: text,
+        duration: 6000,
+        close: true,
+        gravity: "top",
+        position: "center",
+        backgroundColor: error
+            ? "linear-gradient(to right, #ff5f6d, #ffc371)"
+            : "linear-gradient(to right, #008000, #008000)",
+    }).showToast();
+}
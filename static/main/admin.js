@@ -1,7 +1,6 @@
 // admin.js
-// Fetch users from the server
-fetch("/api/user")
-    .then((response) => response.json())
+// Fetch users from the server - every page of the paging envelope
+fetchAllPages("/api/user?page_size=500")
     .then((users) => {
         // Convert current_user_id to a number
         const currentUserIdNumber = parseInt(current_user_id, 10);
@@ -27,13 +26,18 @@ fetch("/api/user")
             // Determine whether to hide action buttons based on conditions
             const hideActions = !current_default_admin && isAdmin;
             var isActive = JSON.parse(user.active)?"Active":"Inactive"
+            // second factor state, learners do not use one
+            var twoFactor = user.role === "Learner" ? "" :
+                (user.twofactor ? "Enabled" : "Off") + (user.twofactor_required ? " (required)" : "")
             // Generate the row HTML
             return `
 <tr${user.user_id === currentUserIdNumber ? ' class="table-primary"' : ""}>
-    <td data-label="Username">${user.username}</td>
+    <td data-label="Username">${user.username}${user.auth_source === "ldap" ? ' <span class="badge bg-info" title="Logs in with the directory password">Directory</span>' : ""}</td>
     <td data-label="Email">${user.email}</td>
     <td data-label="Role">${user.role}</td>
+    <td data-label="StudentID">${user.studentid}</td>
     <td data-label="Active">${isActive}</td>
+    <td data-label="TwoFactor">${twoFactor}</td>
     <td>
     <div class="btn-group">
     ${
@@ -50,6 +54,22 @@ fetch("/api/user")
                     <path d="m15 5 4 4"/>
                 </svg>
             </button>
+            ${
+                user.role === "Learner"
+                    ? ""
+                    : `<button class="btn btn-secondary p-2" onclick="setTwoFactorRequired(${user.user_id}, ${!user.twofactor_required})"
+                            title="${user.twofactor_required ? "Make two-factor authentication optional" : "Require two-factor authentication"}">
+                        <i class="fas ${user.twofactor_required ? "fa-lock-open" : "fa-lock"}"></i>
+                    </button>
+                    <button class="btn btn-secondary p-2" onclick="resetTwoFactor(${user.user_id}, '${user.username}')"
+                            title="Reset two-factor authentication">
+                        <i class="fas fa-mobile-screen"></i>
+                    </button>`
+            }
+            <button class="btn btn-secondary p-2" onclick="revokeSessions(${user.user_id}, '${user.username}')"
+                    title="Log out everywhere">
+                <i class="fas fa-right-from-bracket"></i>
+            </button>
             ${
                 hideDelete
                     ? ""
@@ -77,7 +97,8 @@ fetch("/api/user")
 
         // Add the rows to the users table
         $("#users-table tbody").html(userRows.join(""));
-    });
+    })
+    .catch((error) => console.error("Fetch error:", error));
 
 export async function editUser(userId) {
     const id = userId;
@@ -88,6 +109,7 @@ export async function editUser(userId) {
     const username = row.find("td[data-label=Username]").text();
     const email = row.find("td[data-label=Email]").text();
     const role = row.find("td[data-label=Role]").text();
+    const studentid = row.find("td[data-label=StudentID]").text();
     const active = row.find("td[data-label=Active]").text();
 
     const default_admin = await fetch(`/api/user/${username}`)
@@ -104,6 +126,8 @@ export async function editUser(userId) {
     $("#editUserForm input[name=username]").val(username);
     $("#editUserForm input[name=email]").val(email);
     $("#editUserForm select[name=role]").val(role);
+    // learner accounts are linked to a learner record for the portal
+    $("#editUserForm input[name=studentid]").val(studentid);
     $("#editUserForm input[name=default_admin]").val(default_admin);
     var isActive = active=="Active"?true:false
     document.getElementById("updActive").checked = isActive
@@ -145,9 +169,9 @@ export async function editUser(userId) {
             //alert(JSON.stringify(jsonData))
             fetch(`/api/user/${document.getElementById("editUserID").value}`, {
                 method: "PUT",
-                headers: {
+                headers: csrfHeaders({
                     "Content-Type": "application/json",
-                },
+                }),
                 body: JSON.stringify(jsonData),
             })
                 .then((response) => response.json())
@@ -170,6 +194,54 @@ export async function editUser(userId) {
     });
 }
 
+// an admin can require a second factor for a staff account
+export function setTwoFactorRequired(userId, required) {
+    sendUserAction(`/api/user/${userId}/2fa/required`, "PUT", { required: required });
+}
+
+// removes a lost second factor, the user can enrol again
+export function resetTwoFactor(userId, username) {
+    if (!confirm(`Reset two-factor authentication for ${username}?`)) {
+        return;
+    }
+    sendUserAction(`/api/user/${userId}/2fa/reset`, "POST", {});
+}
+
+// logs a user out of every session
+export function revokeSessions(userId, username) {
+    if (!confirm(`Log ${username} out everywhere?`)) {
+        return;
+    }
+    sendUserAction(`/api/user/${userId}/sessions`, "DELETE", {});
+}
+
+// logs every other user out, e.g. after a security incident
+export function revokeAllSessions() {
+    if (!confirm("Log out every user except yourself?")) {
+        return;
+    }
+    sendUserAction("/api/sessions", "DELETE", {});
+}
+
+function sendUserAction(url, method, body) {
+    fetch(url, {
+        method: method,
+        headers: csrfHeaders({
+            "Content-Type": "application/json",
+        }),
+        body: JSON.stringify(body),
+    })
+        .then((response) => response.json())
+        .then((data) => {
+            if (data.redirectURL) {
+                window.location.href = data.redirectURL;
+            } else {
+                console.error("Unexpected response:", data);
+            }
+        })
+        .catch((error) => console.error("Fetch error:", error));
+}
+
 export function AddUser() {
     // Clear the form before showing it
     document.getElementById("addUserForm").reset();
@@ -206,9 +278,9 @@ export function AddUser() {
                 console.log("Sending",JSON.stringify(jsonData));
                 fetch(`/api/user`, {
                     method: "POST",
-                    headers: {
+                    headers: csrfHeaders({
                         "Content-Type": "application/json",
-                    },
+                    }),
                     body: JSON.stringify(jsonData),
                 })
                     .then((response) => response.json())
@@ -239,4 +311,8 @@ export function AddUser() {
 // Make functions available globally
 window.editUser = editUser;
 window.AddUser = AddUser;
+window.setTwoFactorRequired = setTwoFactorRequired;
+window.resetTwoFactor = resetTwoFactor;
+window.revokeSessions = revokeSessions;
+window.revokeAllSessions = revokeAllSessions;
 
@@ -0,0 +1,102 @@
+// apikeys.js
+// API keys for machine clients - the list endpoint returns a paging envelope
+loadAPIKeys();
+
+document.getElementById("apikeys-show-revoked").addEventListener("change", loadAPIKeys);
+
+document.getElementById("apikey-form").addEventListener("submit", (event) => {
+    event.preventDefault();
+    const form = event.target;
+    const scopes = [...form.querySelectorAll("input[name=scopes]:checked")].map((input) => input.value);
+    fetch("/api/apikey", {
+        method: "POST",
+        headers: csrfHeaders({
+            "Content-Type": "application/json",
+        }),
+        body: JSON.stringify({ name: form.elements.name.value, scopes: scopes }),
+    })
+        .then((response) => response.json())
+        .then((data) => {
+            if (data.error) {
+                showToast(data.error, true);
+                return;
+            }
+            form.reset();
+            document.getElementById("apikey-new-value").textContent = data.key;
+            document.getElementById("apikey-new").classList.remove("d-none");
+            showToast(data.message, false);
+            loadAPIKeys();
+        })
+        .catch((error) => console.error("Fetch error:", error));
+});
+
+function loadAPIKeys() {
+    const revoked = document.getElementById("apikeys-show-revoked").checked;
+    fetchAllPages(`/api/apikey?page_size=500&revoked=${revoked}`)
+        .then((keys) => {
+            if (keys.length === 0) {
+                $("#apikeys-table tbody").html(
+                    `<tr><td colspan="6" class="text-muted">No API keys</td></tr>`
+                );
+                return;
+            }
+
+            const rows = keys.map(
+                (key) => `
+<tr>
+    <td data-label="Name">${escapeHtml(key.name)}</td>
+    <td data-label="Key"><code>${escapeHtml(key.prefix)}…</code></td>
+    <td data-label="Scopes">${key.scopes.map((scope) => `<span class="badge bg-secondary me-1">${escapeHtml(scope)}</span>`).join("")}</td>
+    <td data-label="Created">${escapeHtml(key.created_at)} ${escapeHtml(key.created_by)}</td>
+    <td data-label="Last used">${escapeHtml(key.last_used || "Never")} ${escapeHtml(key.last_used_ip)}</td>
+    <td>${
+        key.revoked_at
+            ? `<span class="badge bg-danger">Revoked ${escapeHtml(key.revoked_at)}</span>`
+            : `<button class="btn btn-danger p-2" data-id="${key.key_id}" data-name="${escapeHtml(key.name)}" onclick="revokeAPIKey(this)" title="Revoke">
+                <i class="fas fa-ban"></i>
+            </button>`
+    }</td>
+</tr>`
+            );
+            $("#apikeys-table tbody").html(rows.join(""));
+        })
+        .catch((error) => console.error("Fetch error:", error));
+}
+
+// the key name is read from the button so it is never placed into script
+export function revokeAPIKey(button) {
+    if (!confirm(`Revoke the API key ${button.dataset.name}? Clients using it will stop working`)) {
+        return;
+    }
+    fetch(`/api/apikey/${button.dataset.id}`, { method: "DELETE", headers: csrfHeaders() })
+        .then((response) => response.json())
+        .then((data) => {
+            showToast(data.message || data.error, !!data.error);
+            loadAPIKeys();
+        })
+        .catch((error) => console.error("Fetch error:", error));
+}
+
+function escapeHtml(value) {
+    return String(value ?? "")
+        .replace(/&/g, "&amp;")
+        .replace(/</g, "&lt;")
+        .replace(/>/g, "&gt;")
+        .replace(/"/g, "&quot;");
+}
+
+function showToast(text, error) {
+    Toastify({
+        text: text,
+        duration: 6000,
+        close: true,
+        gravity: "top",
+        position: "center",
+        backgroundColor: error
+            ? "linear-gradient(to right, #ff5f6d, #ffc371)"
+            : "linear-gradient(to right, #008000, #008000)",
+    }).showToast();
+}
+
+// Make functions available globally
+window.revokeAPIKey = revokeAPIKey;
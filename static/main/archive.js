@@ -0,0 +1,259 @@
+// archive.js
+// Year-end archives - archive a closed year or semester, check the archives, apply the retention period
+// and search the archived results
+
+let resultsPage = {};
+
+const archiveForm = document.getElementById("archive-form");
+const resultsForm = document.getElementById("results-filter");
+
+archiveForm.addEventListener("submit", (event) => {
+    event.preventDefault();
+    const period = periodQuery();
+    const label = period.get("year") + (period.get("semester") || " (whole year)");
+    if (!confirm(`Archive ${label}? Its offerings, results and submissions are removed from the live tables.`)) {
+        return;
+    }
+    const button = document.getElementById("archive-button");
+    button.disabled = true;
+    document.getElementById("archive-status").textContent = `Archiving ${label}…`;
+    fetch("/api/archive", {
+        method: "POST",
+        headers: csrfHeaders({
+            "Content-Type": "application/json",
+        }),
+        body: JSON.stringify({ year: period.get("year"), semester: period.get("semester") || "" }),
+    })
+        .then((response) => response.json())
+        .then((data) => {
+            showToast(data.message || data.error, !!data.error);
+            document.getElementById("archive-status").textContent = data.message || data.error;
+            loadArchives();
+            loadResults(`/api/archive/results?${resultsQuery()}`);
+        })
+        .catch((error) => console.error("Fetch error:", error))
+        .finally(() => (button.disabled = false));
+});
+
+document.getElementById("archive-check").addEventListener("click", () => {
+    if (!archiveForm.reportValidity()) {
+        return;
+    }
+    fetch(`/api/archive/period?${periodQuery()}`)
+        .then((response) => response.json())
+        .then((data) => {
+            if (data.error) {
+                showToast(data.error, true);
+                return;
+            }
+            const summary = data.summary;
+            let status = `${data.period}: ${summary.offerings} offerings and ${summary.results} results. `;
+            status += data.problem ? `Not ready - ${data.problem}.` : "Ready to archive.";
+            document.getElementById("archive-status").textContent = status;
+        })
+        .catch((error) => console.error("Fetch error:", error));
+});
+
+document.getElementById("purge-button").addEventListener("click", () => {
+    if (!confirm("Purge the learners' submissions that are past the retention period? This cannot be undone.")) {
+        return;
+    }
+    fetch("/api/archive/purge", { method: "POST", headers: csrfHeaders() })
+        .then((response) => response.json())
+        .then((data) => {
+            showToast(data.message || data.error, !!data.error);
+            if (data.data) {
+                showReport(data.data);
+            }
+            loadArchives();
+        })
+        .catch((error) => console.error("Fetch error:", error));
+});
+
+resultsForm.addEventListener("submit", (event) => {
+    event.preventDefault();
+    loadResults(`/api/archive/results?${resultsQuery()}`);
+});
+
+document.getElementById("results-prev").addEventListener("click", () => loadResults(resultsPage.prev));
+document.getElementById("results-next").addEventListener("click", () => loadResults(resultsPage.next));
+
+loadArchives();
+loadResults(`/api/archive/results?${resultsQuery()}`);
+
+function periodQuery() {
+    const params = new URLSearchParams();
+    params.set("year", document.getElementById("archive-year").value);
+    const semester = document.getElementById("archive-semester").value;
+    if (semester) {
+        params.set("semester", semester);
+    }
+    return params;
+}
+
+// the non-empty filters of the results search as a query string
+function resultsQuery() {
+    const params = new URLSearchParams();
+    new FormData(resultsForm).forEach((value, key) => {
+        if (value) {
+            params.set(key, value);
+        }
+    });
+    return params.toString();
+}
+
+function loadArchives() {
+    fetch("/api/archive")
+        .then((response) => response.json())
+        .then((list) => {
+            document.getElementById("archive-button").disabled = list.running;
+            if (list.last_purge) {
+                showReport(list.last_purge);
+            }
+
+            const archives = list.data || [];
+            if (archives.length === 0) {
+                $("#archives-table tbody").html(`<tr><td colspan="8" class="text-muted">No archives</td></tr>`);
+                return;
+            }
+            const rows = archives.map(
+                (archive) => `
+<tr>
+    <td data-label="Period">${archive.year} ${escapeHtml(archive.semester || "whole year")}</td>
+    <td data-label="Archived" class="text-nowrap">${escapeHtml(archive.created_at)}</td>
+    <td data-label="By">${escapeHtml(archive.created_by)}</td>
+    <td data-label="Offerings">${archive.offerings}</td>
+    <td data-label="Results">${archive.results}</td>
+    <td data-label="Submissions">${
+        archive.submissions_purged_at
+            ? `<span class="text-muted">purged ${escapeHtml(archive.submissions_purged_at)}</span>`
+            : `${archive.submission_files} files, ${formatSize(archive.submission_bytes)}`
+    }</td>
+    <td data-label="Checks" id="archive-checks-${archive.archive_id}"></td>
+    <td class="text-nowrap">
+        <button class="btn btn-secondary p-2" title="Verify the signatures" onclick="verifyArchive(${archive.archive_id})">
+            <i class="fas fa-check-double"></i>
+        </button>
+        <a class="btn btn-secondary p-2" href="/api/archive/${archive.archive_id}/records" title="Download the records">
+            <i class="fas fa-download"></i>
+        </a>
+        ${
+            archive.submissions_purged_at
+                ? ""
+                : `<a class="btn btn-secondary p-2" href="/api/archive/${archive.archive_id}/submissions" title="Download the submissions">
+            <i class="fas fa-file-zipper"></i>
+        </a>`
+        }
+    </td>
+</tr>`
+            );
+            $("#archives-table tbody").html(rows.join(""));
+        })
+        .catch((error) => console.error("Fetch error:", error));
+}
+
+function verifyArchive(id) {
+    const cell = document.getElementById(`archive-checks-${id}`);
+    cell.textContent = "Checking…";
+    fetch(`/api/archive/${id}/verify`)
+        .then((response) => response.json())
+        .then((data) => {
+            if (data.error) {
+                cell.textContent = data.error;
+                return;
+            }
+            const badge = (label, result) =>
+                result === "ok"
+                    ? `<span class="badge bg-success">${label} ok</span>`
+                    : `<span class="badge ${result.startsWith("purged") ? "bg-secondary" : "bg-danger"}" title="${escapeHtml(result)}">${label} ${result.startsWith("purged") ? "purged" : "failed"}</span>`;
+            cell.innerHTML = `${badge("records", data.records)} ${badge("submissions", data.submissions)}`;
+        })
+        .catch((error) => console.error("Fetch error:", error));
+}
+window.verifyArchive = verifyArchive;
+
+function showReport(report) {
+    const lines = [
+        `Last purge ${report.finished} by ${report.by}, submissions of the years before ${report.before_year}`,
+        ...report.archives.map((archive) => `  archive ${archive.period}: ${archive.files} files, ${formatSize(archive.bytes)}`),
+        ...report.folders.map((folder) => `  folder ${folder.path}: ${folder.files} files, ${formatSize(folder.bytes)}`),
+        ...report.errors.map((error) => `  error: ${error}`),
+    ];
+    if (report.archives.length === 0 && report.folders.length === 0) {
+        lines.push("  nothing was past the retention period");
+    }
+    if (report.report) {
+        lines.push(`Report saved as ${report.report}`);
+    }
+    const pre = document.getElementById("purge-report");
+    pre.textContent = lines.join("\n");
+    pre.classList.remove("d-none");
+}
+
+function loadResults(url) {
+    if (!url) {
+        return;
+    }
+    fetch(url)
+        .then((response) => response.json())
+        .then((page) => {
+            if (page.error) {
+                showToast(page.error, true);
+                return;
+            }
+            resultsPage = page;
+            document.querySelector("#results-table tbody").innerHTML = page.data
+                .map(
+                    (result) => `
+<tr>
+    <td data-label="Student ID">${escapeHtml(result.studentid)}</td>
+    <td data-label="Name">${escapeHtml(result.name)}</td>
+    <td data-label="Exam ID">${escapeHtml(result.examid)}</td>
+    <td data-label="Course">${escapeHtml(result.coursecode)}</td>
+    <td data-label="Status">${escapeHtml(result.status)}</td>
+    <td data-label="Grade">${result.grade}</td>
+    <td data-label="Start" class="text-nowrap">${escapeHtml(result.starttime)}</td>
+    <td data-label="End" class="text-nowrap">${escapeHtml(result.endtime)}</td>
+</tr>`
+                )
+                .join("");
+            document.getElementById("results-total").textContent =
+                `${page.total} results, page ${page.page} of ${Math.max(page.pages, 1)}`;
+            document.getElementById("results-prev").disabled = !page.prev;
+            document.getElementById("results-next").disabled = !page.next;
+        })
+        .catch((error) => console.error("Fetch error:", error));
+}
+
+function formatSize(bytes) {
+    const units = ["B", "KB", "MB", "GB"];
+    let size = bytes;
+    let unit = 0;
+    while (size >= 1024 && unit < units.length - 1) {
+        size /= 1024;
+        unit++;
+    }
+    return `${size.toFixed(unit === 0 ? 0 : 1)} ${units[unit]}`;
+}
+
+// the values come from the database e.g. learner names and feedback
+function escapeHtml(value) {
+    return String(value ?? "")
+        .replace(/&/g, "&amp;")
+        .replace(/</g, "&lt;")
+        .replace(/>/g, "&gt;")
+        .replace(/"/g, "&quot;");
+}
+
+function showToast(text, error) {
+    Toastify({
+        text: text,
+        duration: 6000,
+        close: true,
+        gravity: "top",
+        position: "center",
+        backgroundColor: error
+            ? "linear-gradient(to right, #ff5f6d, #ffc371)"
+            : "linear-gradient(to right, #008000, #008000)",
+    }).showToast();
+}
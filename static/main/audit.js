@@ -0,0 +1,90 @@
+// audit.js
+// Audit trail of staff changes, filtered and paged, with a CSV export of the filtered entries
+
+let auditPage = {};
+
+const filterForm = document.getElementById("audit-filter");
+
+filterForm.addEventListener("submit", (event) => {
+    event.preventDefault();
+    loadAudit(`/api/audit?${filterQuery()}`);
+});
+
+document.getElementById("audit-prev").addEventListener("click", () => loadAudit(auditPage.prev));
+document.getElementById("audit-next").addEventListener("click", () => loadAudit(auditPage.next));
+
+loadAudit(`/api/audit?${filterQuery()}`);
+
+// the non-empty filters as a query string, also used for the export link
+function filterQuery() {
+    const params = new URLSearchParams();
+    new FormData(filterForm).forEach((value, key) => {
+        if (value) {
+            params.set(key, value);
+        }
+    });
+    document.getElementById("audit-export").href = `/api/audit/export?${params}`;
+    return params.toString();
+}
+
+function loadAudit(url) {
+    if (!url) {
+        return;
+    }
+    fetch(url)
+        .then((response) => response.json())
+        .then((page) => {
+            if (page.error) {
+                showToast(page.error, true);
+                return;
+            }
+            auditPage = page;
+            document.querySelector("#audit-table tbody").innerHTML = page.data
+                .map(
+                    (entry) => `
+<tr>
+    <td data-label="Time" class="text-nowrap">${escapeHtml(entry.created_at)}</td>
+    <td data-label="Actor">${escapeHtml(entry.actor)} <span class="text-muted small">${escapeHtml(entry.role)}</span></td>
+    <td data-label="Action">${escapeHtml(entry.action)}</td>
+    <td data-label="Entity">${escapeHtml(entry.entity)} <span class="font-monospace small">${escapeHtml(entry.entity_id)}</span></td>
+    <td data-label="Detail">${escapeHtml(entry.detail)}</td>
+    <td data-label="Before" class="font-monospace small text-break">${escapeHtml(entry.before)}</td>
+    <td data-label="After" class="font-monospace small text-break">${escapeHtml(entry.after)}</td>
+    <td data-label="Status">${
+        entry.status < 400
+            ? `<span class="badge bg-success">${entry.status}</span>`
+            : `<span class="badge bg-danger">${entry.status}</span>`
+    }</td>
+    <td data-label="Address">${escapeHtml(entry.ip)}</td>
+</tr>`
+                )
+                .join("");
+            document.getElementById("audit-total").textContent =
+                `${page.total} entries, page ${page.page} of ${Math.max(page.pages, 1)}`;
+            document.getElementById("audit-prev").disabled = !page.prev;
+            document.getElementById("audit-next").disabled = !page.next;
+        })
+        .catch((error) => console.error("Fetch error:", error));
+}
+
+// the values come from user input e.g. usernames and import file names
+function escapeHtml(value) {
+    return String(value ?? "")
+        .replace(/&/g, "&amp;")
+        .replace(/</g, "&lt;")
+        .replace(/>/g, "&gt;")
+        .replace(/"/g, "&quot;");
+}
+
+function showToast(text, error) {
+    Toastify({
+        text: text,
+        duration: 6000,
+        close: true,
+        gravity: "top",
+        position: "center",
+        backgroundColor: error
+            ? "linear-gradient(to right, #ff5f6d, #ffc371)"
+            : "linear-gradient(to right, #008000, #008000)",
+    }).showToast();
+}
@@ -0,0 +1,92 @@
+// backups.js
+// Backups of the database and data folders - a backup runs in the background so the list is polled until it is done
+const backupPoll = 2000;
+
+loadBackups();
+
+document.getElementById("backup-button").addEventListener("click", () => {
+    fetch("/api/backup", { method: "POST", headers: csrfHeaders() })
+        .then((response) => response.json())
+        .then((data) => {
+            showToast(data.message || data.error, !!data.error);
+            loadBackups();
+        })
+        .catch((error) => console.error("Fetch error:", error));
+});
+
+function loadBackups() {
+    fetch("/api/backup")
+        .then((response) => response.json())
+        .then((list) => {
+            const button = document.getElementById("backup-button");
+            button.disabled = list.running || !list.supported;
+
+            let status = list.supported
+                ? `Keeping the newest ${list.keep} backups` +
+                  (list.interval === "0s" ? ", made on demand only." : `, made every ${list.interval}.`)
+                : "Online backups need the SQLite database.";
+            if (list.running) {
+                status += " A backup is running…";
+            } else if (list.last && list.last.error) {
+                status += ` The last backup failed ${list.last.finished}: ${list.last.error}`;
+            }
+            document.getElementById("backup-status").textContent = status;
+
+            const backups = list.data || [];
+            if (backups.length === 0) {
+                $("#backups-table tbody").html(`<tr><td colspan="4" class="text-muted">No backups</td></tr>`);
+            } else {
+                const rows = backups.map(
+                    (backup) => `
+<tr>
+    <td data-label="Backup"><code>${escapeHtml(backup.name)}</code></td>
+    <td data-label="Made">${escapeHtml(backup.created)}</td>
+    <td data-label="Size">${formatSize(backup.size)}</td>
+    <td>
+        <a class="btn btn-secondary p-2" href="/api/backup/${encodeURIComponent(backup.name)}" title="Download">
+            <i class="fas fa-download"></i>
+        </a>
+    </td>
+</tr>`
+                );
+                $("#backups-table tbody").html(rows.join(""));
+            }
+
+            if (list.running) {
+                setTimeout(loadBackups, backupPoll);
+            }
+        })
+        .catch((error) => console.error("Fetch error:", error));
+}
+
+function formatSize(bytes) {
+    const units = ["B", "KB", "MB", "GB"];
+    let size = bytes;
+    let unit = 0;
+    while (size >= 1024 && unit < units.length - 1) {
+        size /= 1024;
+        unit++;
+    }
+    return `${size.toFixed(unit === 0 ? 0 : 1)} ${units[unit]}`;
+}
+
+function escapeHtml(value) {
+    return String(value ?? "")
+        .replace(/&/g, "&amp;")
+        .replace(/</g, "&lt;")
+        .replace(/>/g, "&gt;")
+        .replace(/"/g, "&quot;");
+}
+
+function showToast(text, error) {
+    Toastify({
+        text: text,
+        duration: 6000,
+        close: true,
+        gravity: "top",
+        position: "center",
+        backgroundColor: error
+            ? "linear-gradient(to right, #ff5f6d, #ffc371)"
+            : "linear-gradient(to right, #008000, #008000)",
+    }).showToast();
+}
@@ -2,6 +2,30 @@ export function logout() {
     window.location.href = "/logout";
 }
 
+// adds the page's CSRF token to the headers of a state-changing request
+export function csrfHeaders(headers = {}) {
+    const meta = document.querySelector('meta[name="csrf-token"]');
+    if (meta) {
+        headers["X-CSRF-Token"] = meta.content;
+    }
+    return headers;
+}
+
+// fetches every page of a list endpoint, following the next links of the paging envelope
+export async function fetchAllPages(url) {
+    const items = [];
+    while (url) {
+        const response = await fetch(url);
+        const page = await response.json();
+        if (page.error) {
+            throw new Error(page.error);
+        }
+        items.push(...(page.data || []));
+        url = page.next;
+    }
+    return items;
+}
+
 function formatEntityType(entityType) {
     return entityType
         .split("-")
@@ -98,10 +122,9 @@ document
 
         fetch(this.action, {
             method: "DELETE",
-            headers: {
+            headers: csrfHeaders({
                 "Content-Type": "application/json",
-                // Add any other necessary headers here
-            },
+            }),
         })
             .then((response) => response.json())
             .then((data) => {
@@ -176,6 +199,8 @@ if (savedTheme) {
 
 // Make functions available to the browser
 window.logout = logout;
+window.csrfHeaders = csrfHeaders;
+window.fetchAllPages = fetchAllPages;
 window.showDeleteModal = showDeleteModal;
 window.toggleDarkMode = toggleDarkMode;
 window.populateDropdown = populateDropdown;
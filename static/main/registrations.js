@@ -0,0 +1,91 @@
+// registrations.js
+// Self-registration approval queue - every page of the paging envelope
+loadRegistrations();
+
+function loadRegistrations() {
+    fetchAllPages("/api/registration?page_size=500")
+        .then((users) => {
+            if (users.length === 0) {
+                $("#registrations-table tbody").html(
+                    `<tr><td colspan="6" class="text-muted">No registrations waiting for approval</td></tr>`
+                );
+                return;
+            }
+
+            const rows = users.map(
+                (user) => `
+<tr data-id="${user.user_id}">
+    <td data-label="Username">${escapeHtml(user.username)}</td>
+    <td data-label="Email">${escapeHtml(user.email)}</td>
+    <td data-label="Registered">${escapeHtml(user.registered_at)}</td>
+    <td>
+        <select class="form-select form-select-sm" name="role">
+            <option value="Faculty">Faculty</option>
+            <option value="Learner" selected>Learner</option>
+        </select>
+    </td>
+    <td><input class="form-control form-control-sm" name="studentid" maxlength="8" placeholder="Learners only" /></td>
+    <td>
+        <div class="btn-group">
+            <button class="btn btn-success p-2" onclick="approveRegistration(${user.user_id})" title="Approve">
+                <i class="fas fa-check"></i>
+            </button>
+            <button class="btn btn-danger p-2" onclick="rejectRegistration(${user.user_id})" title="Reject">
+                <i class="fas fa-xmark"></i>
+            </button>
+        </div>
+    </td>
+</tr>`
+            );
+            $("#registrations-table tbody").html(rows.join(""));
+        })
+        .catch((error) => console.error("Fetch error:", error));
+}
+
+export function approveRegistration(userId) {
+    const row = $(`#registrations-table tr[data-id=${userId}]`);
+    postRegistration(`/api/registration/${userId}/approve`, {
+        role: row.find("select[name=role]").val(),
+        studentid: row.find("input[name=studentid]").val(),
+    });
+}
+
+export function rejectRegistration(userId) {
+    const reason = prompt("Reason for rejecting the registration");
+    if (reason === null) {
+        return;
+    }
+    postRegistration(`/api/registration/${userId}/reject`, { reason: reason });
+}
+
+function postRegistration(url, body) {
+    fetch(url, {
+        method: "POST",
+        headers: csrfHeaders({
+            "Content-Type": "application/json",
+        }),
+        body: JSON.stringify(body),
+    })
+        .then((response) => response.json())
+        .then((data) => {
+            if (data.redirectURL) {
+                window.location.href = data.redirectURL;
+            } else {
+                console.error("Unexpected response:", data);
+            }
+        })
+        .catch((error) => console.error("Fetch error:", error));
+}
+
+// registration details are entered by the public
+function escapeHtml(value) {
+    return String(value ?? "")
+        .replace(/&/g, "&amp;")
+        .replace(/</g, "&lt;")
+        .replace(/>/g, "&gt;")
+        .replace(/"/g, "&quot;");
+}
+
+// Make functions available globally
+window.approveRegistration = approveRegistration;
+window.rejectRegistration = rejectRegistration;
@@ -0,0 +1,63 @@
+// service.js
+// Graceful shutdown - the progress is polled until the service has stopped answering
+const shutdownPoll = 1000;
+
+loadShutdown();
+
+document.getElementById("shutdown-button").addEventListener("click", () => {
+    if (!confirm("Shut the service down? New exams will be refused and it will need to be started again")) {
+        return;
+    }
+    fetch("/api/shutdown", { method: "POST", headers: csrfHeaders() })
+        .then((response) => response.json())
+        .then((data) => {
+            showToast(data.message || data.error, !!data.error);
+            loadShutdown();
+        })
+        .catch((error) => console.error("Fetch error:", error));
+});
+
+function loadShutdown() {
+    fetch("/api/shutdown")
+        .then((response) => response.json())
+        .then((status) => {
+            if (status.state === "running") {
+                return;
+            }
+            document.getElementById("shutdown-button").disabled = true;
+            document.getElementById("shutdown-progress").classList.remove("d-none");
+            document.getElementById("shutdown-state").textContent = `Shutdown ${status.state}, requested by ${status.requested_by}`;
+            document.getElementById("shutdown-counts").textContent =
+                `${status.uploads} uploads and ${status.jobs} jobs in progress, deadline ${status.deadline}`;
+            $("#shutdown-steps").html(status.steps.map((step) => `<li>${escapeHtml(step)}</li>`).join(""));
+            setTimeout(loadShutdown, shutdownPoll);
+        })
+        .catch(() => {
+            // the service has stopped once it no longer answers
+            if (!document.getElementById("shutdown-progress").classList.contains("d-none")) {
+                document.getElementById("shutdown-state").textContent = "The service has stopped";
+                document.getElementById("shutdown-counts").textContent = "";
+            }
+        });
+}
+
+function escapeHtml(value) {
+    return String(value ?? "")
+        .replace(/&/g, "&amp;")
+        .replace(/</g, "&lt;")
+        .replace(/>/g, "&gt;")
+        .replace(/"/g, "&quot;");
+}
+
+function showToast(text, error) {
+    Toastify({
+        text: text,
+        duration: 6000,
+        close: true,
+        gravity: "top",
+        position: "center",
+        backgroundColor: error
+            ? "linear-gradient(to right, #ff5f6d, #ffc371)"
+            : "linear-gradient(to right, #008000, #008000)",
+    }).showToast();
+}
@@ -42,6 +42,7 @@ export function btnImport() {
   fetch("/import/"+route, {
     signal: controller.signal,
     method: 'POST',
+    headers: csrfHeaders(),
     body: fileData
     //body: JSON.stringify(jsonData) //for JSON data
     
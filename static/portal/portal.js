@@ -0,0 +1,61 @@
+// portal.js
+// Learner self-service portal - the portal API only returns the records of the logged in learner
+
+loadPortalTable("/api/portal/upcoming", "upcoming-table", 6, "No upcoming exams", (exam) => `
+<tr>
+    <td data-label="Course">${escapeHtml(exam.coursecode)}</td>
+    <td data-label="Description">${escapeHtml(exam.description)}</td>
+    <td data-label="Year">${escapeHtml(exam.year)}</td>
+    <td data-label="Semester">${escapeHtml(exam.semester)}</td>
+    <td data-label="Duration">${exam.duration.Valid ? exam.duration.Int32 + " min" : ""}</td>
+    <td data-label="Status">${escapeHtml(exam.status)}</td>
+</tr>`);
+
+loadPortalTable("/api/portal/attempts", "attempts-table", 6, "No exam attempts", (exam) => `
+<tr>
+    <td data-label="Course">${escapeHtml(exam.coursecode)}</td>
+    <td data-label="Year">${escapeHtml(exam.year)}</td>
+    <td data-label="Semester">${escapeHtml(exam.semester)}</td>
+    <td data-label="Started">${exam.starttime.Valid ? escapeHtml(exam.starttime.String) : ""}</td>
+    <td data-label="Finished">${exam.endtime.Valid ? escapeHtml(exam.endtime.String) : ""}</td>
+    <td data-label="Status">${escapeHtml(exam.status)}</td>
+</tr>`);
+
+loadPortalTable("/api/portal/results", "results-table", 5, "No results have been released", (result) => `
+<tr>
+    <td data-label="Course">${escapeHtml(result.coursecode)}</td>
+    <td data-label="Year">${escapeHtml(result.year)}</td>
+    <td data-label="Semester">${escapeHtml(result.semester)}</td>
+    <td data-label="Grade">${result.grade.Valid ? result.grade.Int32 : ""}</td>
+    <td data-label="Feedback" style="white-space: pre-line">${result.feedback.Valid ? escapeHtml(result.feedback.String) : ""}</td>
+</tr>`);
+
+// fetch a portal list and render it into the table body
+function loadPortalTable(url, tableId, columns, emptyText, renderRow) {
+    const tbody = document.querySelector(`#${tableId} tbody`);
+    fetch(url)
+        .then((response) => response.json())
+        .then((data) => {
+            if (!Array.isArray(data)) {
+                throw new Error(data.error || "Unexpected response");
+            }
+            if (data.length === 0) {
+                tbody.innerHTML = `<tr><td colspan="${columns}" class="text-muted">${emptyText}</td></tr>`;
+                return;
+            }
+            tbody.innerHTML = data.map(renderRow).join("");
+        })
+        .catch((error) => {
+            console.error("Fetch error:", error);
+            tbody.innerHTML = `<tr><td colspan="${columns}" class="text-danger">Unable to load data</td></tr>`;
+        });
+}
+
+// feedback is free text entered by the markers, one comment per line
+function escapeHtml(value) {
+    return String(value ?? "")
+        .replace(/&/g, "&amp;")
+        .replace(/</g, "&lt;")
+        .replace(/>/g, "&gt;")
+        .replace(/"/g, "&quot;");
+}
@@ -0,0 +1,10 @@
+// Package ads4 embeds the web templates and static files so the service runs as a single binary from
+// any folder. Set ASSETS_DIR to serve them from disk while working on them
+package ads4
+
+import "embed"
+
+// Web holds the templates and static folders
+//
+//go:embed templates static
+var Web embed.FS